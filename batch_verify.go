@@ -0,0 +1,108 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// batchVerifyThreshold is the minimum number of proofs to verify in batch,
+// smaller proof sets are verified one by one in place.
+var batchVerifyThreshold = 8
+
+// batchVerify verifies signatures of proofs enclosed in a message in batch,
+// and returns the result of each verified signature, to be consumed by
+// verifyMessageBatch while validating proofs one by one in order, so the
+// first offending proof and it's error remain the same as sequential
+// verification.
+//
+// As ECDSA has no algebraic batch verification, signatures are verified
+// concurrently, all goroutines have exited when batchVerify returns. Proofs
+// from unknown participants are not verified, as they're rejected before
+// signature verification.
+func (c *Consensus) batchVerify(proofs []*SignedProto) map[*SignedProto]bool {
+	if len(proofs) < batchVerifyThreshold {
+		return nil
+	}
+
+	ids := make(map[Identity]bool, len(c.participants))
+	for _, id := range c.participants {
+		ids[id] = true
+	}
+
+	var candidates []*SignedProto
+	for _, proof := range proofs {
+		if proof != nil && ids[c.pubKeyToIdentity(proof.PublicKey(c.curve))] && proof.IsLowS(c.curve) {
+			candidates = append(candidates, proof)
+		}
+	}
+
+	results := make([]bool, len(candidates))
+	durations := make([]time.Duration, len(candidates))
+	workers := runtime.NumCPU()
+	if workers > len(candidates) {
+		workers = len(candidates)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(candidates); i += workers {
+				start := time.Now()
+				results[i] = candidates[i].VerifyWithHasher(c.curve, c.hasher)
+				durations[i] = time.Since(start)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	batch := make(map[*SignedProto]bool, len(candidates))
+	for i := range candidates {
+		batch[candidates[i]] = results[i]
+		c.numSignaturesVerified++
+		c.signatureVerifyDuration += durations[i]
+	}
+	return batch
+}
+
+// verifySignature verifies the signature of a message in place, and
+// accounts the verification in metrics.
+func (c *Consensus) verifySignature(signed *SignedProto) bool {
+	start := time.Now()
+	ok := signed.VerifyWithHasher(c.curve, c.hasher)
+	c.numSignaturesVerified++
+	c.signatureVerifyDuration += time.Since(start)
+	return ok
+}
@@ -0,0 +1,77 @@
+package bdls
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withBatchVerifyThreshold runs f with batch verification threshold set
+func withBatchVerifyThreshold(threshold int, f func()) {
+	old := batchVerifyThreshold
+	batchVerifyThreshold = threshold
+	defer func() { batchVerifyThreshold = old }()
+	f()
+}
+
+func TestBatchVerifyIdenticalErrors(t *testing.T) {
+	m, sp, privateKey, proofKeys := createDecideMessage(t, 64, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+
+	batch := consensus.batchVerify(m.Proof)
+	assert.Equal(t, len(m.Proof), len(batch))
+
+	// valid
+	for _, threshold := range []int{0, math.MaxInt32} {
+		withBatchVerifyThreshold(threshold, func() {
+			assert.Nil(t, consensus.verifyDecideMessage(m, sp))
+		})
+	}
+
+	// tamper a signature, and append a proof from unknown participant
+	m.Proof[5].R[0] ^= 0xFF
+	_, unknown, _ := createCommitMessage(t, 10, 10, m.State)
+	m.Proof = append(m.Proof, unknown)
+	batch = consensus.batchVerify(m.Proof)
+	assert.Equal(t, len(m.Proof)-1, len(batch))
+	assert.False(t, batch[m.Proof[5]])
+
+	for _, threshold := range []int{0, math.MaxInt32} {
+		withBatchVerifyThreshold(threshold, func() {
+			assert.Equal(t, ErrMessageSignature, consensus.verifyDecideMessage(m, sp))
+		})
+	}
+
+	// fix the signature, then unknown participant is reported
+	m.Proof[5].R[0] ^= 0xFF
+	for _, threshold := range []int{0, math.MaxInt32} {
+		withBatchVerifyThreshold(threshold, func() {
+			assert.Equal(t, ErrDecideProofUnknownParticipant, consensus.verifyDecideMessage(m, sp))
+		})
+	}
+}
+
+func benchmarkVerifyDecideMessage(b *testing.B, threshold int) {
+	m, sp, privateKey, proofKeys := createDecideMessage(b, 64, 10, 10, 10, 10)
+	consensus := createConsensus(b, 9, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+
+	withBatchVerifyThreshold(threshold, func() {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := consensus.verifyDecideMessage(m, sp); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkVerifyDecideMessage64Sequential(b *testing.B) {
+	benchmarkVerifyDecideMessage(b, math.MaxInt32)
+}
+
+func BenchmarkVerifyDecideMessage64Batch(b *testing.B) {
+	benchmarkVerifyDecideMessage(b, batchVerifyThreshold)
+}
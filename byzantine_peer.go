@@ -0,0 +1,182 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"crypto/ecdsa"
+	rand "math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Sperax/bdls/timer"
+)
+
+// ByzantinePeer wraps the peer of a link from a Byzantine participant, the
+// messages sent to the peer are tampered by behaviors enabled via setters,
+// such as duplication, delay, corruption and conflicting proposals, to test
+// that honest participants still reach safe consensus. All behaviors are
+// disabled by default.
+type ByzantinePeer struct {
+	peer      PeerInterface
+	signer    Signer          // signs conflicting proposals, nil to disable
+	scheduler timer.Scheduler // scheduler for delayed messages
+
+	rng         *rand.Rand
+	duplication float64       // probability of a message to be sent twice
+	corruption  float64       // probability of a message to have one byte corrupted
+	maxDelay    time.Duration // messages are delayed uniformly in [0, maxDelay)
+	conflicting State         // state to replace the proposals in <roundchange>
+	sync.Mutex
+}
+
+// NewByzantinePeer creates a ByzantinePeer sending messages to peer, the
+// signer of the Byzantine participant is used for re-signing conflicting
+// proposals, it can be nil if conflicting proposals are not needed.
+func NewByzantinePeer(peer PeerInterface, signer Signer) *ByzantinePeer {
+	p := new(ByzantinePeer)
+	p.peer = peer
+	p.signer = signer
+	p.scheduler = timer.SystemTimedSched
+	p.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return p
+}
+
+// SetScheduler sets the scheduler for delayed messages, a virtual time
+// scheduler makes the delays deterministic.
+func (p *ByzantinePeer) SetScheduler(scheduler timer.Scheduler) {
+	p.Lock()
+	defer p.Unlock()
+	p.scheduler = scheduler
+}
+
+// SetDuplication sets the probability in [0, 1] of a message to be sent twice
+func (p *ByzantinePeer) SetDuplication(rate float64) {
+	p.Lock()
+	defer p.Unlock()
+	p.duplication = rate
+}
+
+// SetCorruption sets the probability in [0, 1] of a message to have one
+// random byte corrupted.
+func (p *ByzantinePeer) SetCorruption(rate float64) {
+	p.Lock()
+	defer p.Unlock()
+	p.corruption = rate
+}
+
+// SetDelay sets the maximum delay of messages, each message is delayed
+// uniformly in [0, maxDelay), messages may be reordered as a result,
+// maxDelay <= 0 sends messages immediately.
+func (p *ByzantinePeer) SetDelay(maxDelay time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+	p.maxDelay = maxDelay
+}
+
+// SetConflictingProposal replaces the state proposed in <roundchange>
+// messages with s and re-signs them, wrapping the links to different peers
+// with different states makes the Byzantine participant equivocate.
+// Messages compressed by Config.Compressor are not replaced, nil disables.
+func (p *ByzantinePeer) SetConflictingProposal(s State) {
+	p.Lock()
+	defer p.Unlock()
+	p.conflicting = s
+}
+
+// GetPublicKey implements PeerInterface.GetPublicKey
+func (p *ByzantinePeer) GetPublicKey() *ecdsa.PublicKey { return p.peer.GetPublicKey() }
+
+// RemoteAddr implements PeerInterface.RemoteAddr
+func (p *ByzantinePeer) RemoteAddr() net.Addr { return p.peer.RemoteAddr() }
+
+// Send implements PeerInterface.Send, msg is tampered before sending
+func (p *ByzantinePeer) Send(msg []byte) error {
+	p.Lock()
+	msg = p.conflict(msg)
+	if p.rng.Float64() < p.corruption && len(msg) > 0 {
+		corrupted := make([]byte, len(msg))
+		copy(corrupted, msg)
+		corrupted[p.rng.Intn(len(corrupted))] ^= byte(1 + p.rng.Intn(255))
+		msg = corrupted
+	}
+
+	count := 1
+	if p.rng.Float64() < p.duplication {
+		count = 2
+	}
+
+	delays := make([]time.Duration, count)
+	if p.maxDelay > 0 {
+		for k := range delays {
+			delays[k] = time.Duration(p.rng.Int63n(int64(p.maxDelay)))
+		}
+	}
+	scheduler := p.scheduler
+	p.Unlock()
+
+	for _, delay := range delays {
+		if delay == 0 {
+			_ = p.peer.Send(msg)
+			continue
+		}
+		scheduler.Put(func() { _ = p.peer.Send(msg) }, scheduler.Now().Add(delay))
+	}
+	return nil
+}
+
+// conflict replaces the proposal in a <roundchange> message with the
+// conflicting state, messages of other types are returned as is
+func (p *ByzantinePeer) conflict(msg []byte) []byte {
+	if p.conflicting == nil || p.signer == nil {
+		return msg
+	}
+
+	signed, err := DecodeSignedMessage(msg)
+	if err != nil {
+		return msg
+	}
+	m, err := DecodeMessage(signed.Message)
+	if err != nil || m.Type != MessageType_RoundChange || m.State == nil {
+		return msg
+	}
+
+	m.State = p.conflicting
+	sp := new(SignedProto)
+	if err := sp.SignWith(m, p.signer); err != nil {
+		return msg
+	}
+	out, err := sp.Marshal()
+	if err != nil {
+		return msg
+	}
+	return out
+}
@@ -0,0 +1,154 @@
+package bdls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Sperax/bdls/timer"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordPeer records messages sent to it
+type recordPeer struct {
+	msgs [][]byte
+}
+
+func (p *recordPeer) GetPublicKey() *ecdsa.PublicKey { return nil }
+func (p *recordPeer) RemoteAddr() net.Addr           { return fakeAddress("record") }
+func (p *recordPeer) Send(msg []byte) error {
+	p.msgs = append(p.msgs, msg)
+	return nil
+}
+
+func TestByzantinePeerBehaviors(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+	_, sp, _ := createRoundChangeMessageSigner(t, 1, 0, State("honest"), privateKey)
+	msg, err := sp.Marshal()
+	assert.Nil(t, err)
+
+	scheduler := timer.NewManualScheduler(time.Now())
+	record := new(recordPeer)
+	p := NewByzantinePeer(record, privateKeySigner{privateKey})
+	p.SetScheduler(scheduler)
+
+	// honest by default
+	assert.Nil(t, p.Send(msg))
+	assert.Equal(t, [][]byte{msg}, record.msgs)
+
+	// duplication
+	record.msgs = nil
+	p.SetDuplication(1)
+	assert.Nil(t, p.Send(msg))
+	assert.Equal(t, [][]byte{msg, msg}, record.msgs)
+	p.SetDuplication(0)
+
+	// corruption
+	record.msgs = nil
+	p.SetCorruption(1)
+	assert.Nil(t, p.Send(msg))
+	assert.Equal(t, 1, len(record.msgs))
+	assert.Equal(t, len(msg), len(record.msgs[0]))
+	assert.False(t, bytes.Equal(msg, record.msgs[0]))
+	p.SetCorruption(0)
+
+	// delay
+	record.msgs = nil
+	p.SetDelay(time.Second)
+	assert.Nil(t, p.Send(msg))
+	assert.Nil(t, record.msgs)
+	scheduler.Advance(time.Second)
+	assert.Equal(t, [][]byte{msg}, record.msgs)
+	p.SetDelay(0)
+
+	// conflicting proposal with a valid signature
+	record.msgs = nil
+	p.SetConflictingProposal(State("conflicting"))
+	assert.Nil(t, p.Send(msg))
+	assert.Equal(t, 1, len(record.msgs))
+	signed, err := DecodeSignedMessage(record.msgs[0])
+	assert.Nil(t, err)
+	assert.True(t, signed.Verify(S256Curve))
+	m, err := DecodeMessage(signed.Message)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("conflicting"), m.State)
+}
+
+func TestByzantineMinority(t *testing.T) {
+	const byzantine = 4
+	configs := createIPCNetworkConfigs(t, 5)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	for _, config := range configs {
+		config.Scheduler = scheduler
+	}
+
+	var peers []*IPCPeer
+	for _, config := range configs {
+		consensus, err := NewConsensus(config)
+		assert.Nil(t, err)
+		consensus.SetLatency(100 * time.Millisecond)
+		peers = append(peers, NewIPCPeer(consensus, 100*time.Millisecond))
+	}
+	defer func() {
+		for _, p := range peers {
+			p.Close()
+		}
+	}()
+
+	// the Byzantine participant misbehaves on all its links, and proposes
+	// different states to different peers
+	for i := range peers {
+		for j := range peers {
+			if i == j {
+				continue
+			}
+			if i != byzantine {
+				peers[i].c.Join(peers[j])
+				continue
+			}
+			p := NewByzantinePeer(peers[j], privateKeySigner{configs[i].PrivateKey})
+			p.SetScheduler(scheduler)
+			p.SetDuplication(0.5)
+			p.SetCorruption(0.2)
+			p.SetDelay(time.Second)
+			p.SetConflictingProposal(State(fmt.Sprintf("conflicting %d", j)))
+			peers[i].c.Join(p)
+		}
+	}
+
+	for _, p := range peers {
+		data := make([]byte, 1024)
+		_, err := io.ReadFull(rand.Reader, data)
+		assert.Nil(t, err)
+		p.Propose(data)
+		p.Update()
+	}
+
+	honest := peers[:byzantine]
+	for i := 0; i < 60000; i++ {
+		decided := true
+		for _, p := range honest {
+			if height, _, _ := p.GetLatestState(); height < 1 {
+				decided = false
+			}
+		}
+		if decided {
+			break
+		}
+		scheduler.Advance(10 * time.Millisecond)
+	}
+
+	// honest participants agree on the same state
+	_, _, expected := honest[0].GetLatestState()
+	for _, p := range honest {
+		height, _, state := p.GetLatestState()
+		assert.Equal(t, uint64(1), height)
+		assert.Equal(t, expected, state)
+	}
+}
@@ -0,0 +1,76 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import "fmt"
+
+// StateCodec converts states between the application's representation and
+// the encoding embedded in messages on wire, all participants must agree on
+// the codec. NULL states are neither encoded nor decoded.
+type StateCodec interface {
+	// Encode returns the encoding of s to embed in messages
+	Encode(s State) []byte
+	// Decode reverts the encoding extracted from messages
+	Decode(data []byte) (State, error)
+}
+
+// RawStateCodec is the default StateCodec, states are embedded as raw bytes
+type RawStateCodec struct{}
+
+// Encode implements StateCodec
+func (RawStateCodec) Encode(s State) []byte { return s }
+
+// Decode implements StateCodec
+func (RawStateCodec) Decode(data []byte) (State, error) { return data, nil }
+
+// encodeState returns a copy of m with the state encoded for signing,
+// m is returned as is if there is no state.
+func (c *Consensus) encodeState(m *Message) *Message {
+	if m.State == nil {
+		return m
+	}
+	out := *m
+	out.State = c.stateCodec.Encode(m.State)
+	return &out
+}
+
+// decodeState decodes the state embedded in the received message in place
+func (c *Consensus) decodeState(m *Message) error {
+	if m.State == nil {
+		return nil
+	}
+	s, err := c.stateCodec.Decode(m.State)
+	if err != nil {
+		return fmt.Errorf("decoding state: %w", err)
+	}
+	m.State = s
+	return nil
+}
@@ -0,0 +1,110 @@
+package bdls
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sperax/bdls/timer"
+	"github.com/stretchr/testify/assert"
+)
+
+var errCodecHeader = errors.New("missing codec header")
+
+// prefixCodec embeds states with a header
+type prefixCodec struct {
+	header []byte
+}
+
+func (p prefixCodec) Encode(s State) []byte {
+	return append(append([]byte{}, p.header...), s...)
+}
+
+func (p prefixCodec) Decode(data []byte) (State, error) {
+	if !bytes.HasPrefix(data, p.header) {
+		return nil, errCodecHeader
+	}
+	return data[len(p.header):], nil
+}
+
+func TestRawStateCodec(t *testing.T) {
+	var codec RawStateCodec
+	s, err := codec.Decode(codec.Encode(State("data")))
+	assert.Nil(t, err)
+	assert.Equal(t, State("data"), s)
+}
+
+func TestStateCodecConsensus(t *testing.T) {
+	codec := prefixCodec{header: []byte("v1:")}
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+
+	// all states on wire must be encoded
+	var mu sync.Mutex
+	sent := make(map[MessageType]int)
+	for _, config := range configs {
+		config.Scheduler = scheduler
+		config.StateCodec = codec
+		config.MessageOutCallback = func(m *Message, signed *SignedProto) {
+			embedded, err := DecodeMessage(signed.Message)
+			assert.Nil(t, err)
+			if m.State != nil {
+				assert.Equal(t, codec.Encode(m.State), embedded.State)
+			}
+			mu.Lock()
+			sent[m.Type]++
+			mu.Unlock()
+		}
+	}
+
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond)
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	data := make([]byte, 1024)
+	_, err = io.ReadFull(rand.Reader, data)
+	assert.Nil(t, err)
+	for _, p := range network.Peers() {
+		p.Propose(data)
+	}
+
+	for i := 0; i < 60000; i++ {
+		decided := true
+		for _, p := range network.Peers() {
+			if height, _, _ := p.GetLatestState(); height < 1 {
+				decided = false
+			}
+		}
+		if decided {
+			break
+		}
+		scheduler.Advance(10 * time.Millisecond)
+	}
+
+	// states are decoded back to the application's representation
+	for _, p := range network.Peers() {
+		height, _, state := p.GetLatestState()
+		assert.Equal(t, uint64(1), height)
+		assert.Equal(t, State(data), state)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, typ := range []MessageType{MessageType_RoundChange, MessageType_Lock, MessageType_Commit, MessageType_Decide} {
+		assert.True(t, sent[typ] > 0, "no %v sent", typ)
+	}
+}
+
+func TestStateCodecDecodeError(t *testing.T) {
+	consensus := createConsensus(t, 0, 0, nil)
+	consensus.stateCodec = prefixCodec{header: []byte("v1:")}
+
+	_, signed, privateKey := createRoundChangeMessage(t, 1, 0)
+	consensus.AddParticipant(&privateKey.PublicKey)
+	_, err := consensus.verifyMessage(signed)
+	assert.True(t, errors.Is(err, errCodecHeader))
+}
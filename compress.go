@@ -0,0 +1,163 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// DefaultCompressThreshold is the default size of messages in bytes,
+	// below which messages are sent uncompressed to avoid the overhead
+	DefaultCompressThreshold = 1024
+
+	// DefaultMaxDecompressedSize is the default limit of the size of a
+	// decompressed message for GzipCompressor
+	DefaultMaxDecompressedSize = 16 * 1024 * 1024
+)
+
+// flag byte prefixed to messages on wire when a compressor is set
+// |Flag(1byte)| Message ... |
+const (
+	frameRaw        byte = 0
+	frameCompressed byte = 1
+)
+
+// Compressor compresses the messages at the transport boundary, messages
+// are prefixed with a flag byte indicating whether it's compressed, so
+// all participants must agree on the compressor.
+type Compressor interface {
+	// Compress returns the compressed form of data
+	Compress(data []byte) ([]byte, error)
+	// Decompress reverts the data compressed by Compress
+	Decompress(data []byte) ([]byte, error)
+}
+
+// GzipCompressor is a Compressor based on compress/gzip
+type GzipCompressor struct {
+	// Level is the gzip compression level, 0 means gzip.DefaultCompression
+	Level int
+	// MaxSize limits the size of decompressed data to defend against
+	// decompression bombs, 0 means DefaultMaxDecompressedSize
+	MaxSize int
+}
+
+// Compress implements Compressor
+func (g *GzipCompressor) Compress(data []byte) ([]byte, error) {
+	level := g.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress implements Compressor
+func (g *GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	maxSize := g.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxDecompressedSize
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// read one more byte to detect oversized data
+	out, err := ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxSize {
+		return nil, ErrDecompressedSizeExceeded
+	}
+	return out, nil
+}
+
+// encodeFrame prepares the marshalled message for transmission, messages are
+// returned unchanged if compressor has not set.
+func (c *Consensus) encodeFrame(bts []byte) []byte {
+	if c.compressor == nil {
+		return bts
+	}
+
+	if len(bts) >= c.compressThreshold {
+		compressed, err := c.compressor.Compress(bts)
+		if err == nil && len(compressed) < len(bts) {
+			return append([]byte{frameCompressed}, compressed...)
+		}
+		if err != nil {
+			c.logger.Warnf("compressing message: %v", err)
+		}
+	}
+	return append([]byte{frameRaw}, bts...)
+}
+
+// decodeFrame reverts encodeFrame on received messages
+func (c *Consensus) decodeFrame(bts []byte) ([]byte, error) {
+	if c.compressor == nil {
+		return bts, nil
+	}
+
+	if len(bts) == 0 {
+		return nil, ErrMessageIsEmpty
+	}
+
+	switch bts[0] {
+	case frameRaw:
+		return bts[1:], nil
+	case frameCompressed:
+		out, err := c.compressor.Decompress(bts[1:])
+		if err != nil {
+			return nil, fmt.Errorf("decompressing message: %w", err)
+		}
+		return out, nil
+	default:
+		return nil, ErrMessageFrameFlag
+	}
+}
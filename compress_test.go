@@ -0,0 +1,168 @@
+package bdls
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Sperax/bdls/timer"
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGzipCompressor(t *testing.T) {
+	compressor := new(GzipCompressor)
+	for _, size := range []int{0, 1, 1024, 65536} {
+		data := bytes.Repeat([]byte("bdls"), size)
+		compressed, err := compressor.Compress(data)
+		assert.Nil(t, err)
+		decompressed, err := compressor.Decompress(compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, len(data), len(decompressed))
+		assert.True(t, bytes.Equal(data, decompressed))
+	}
+
+	// random data
+	data := make([]byte, 4096)
+	_, err := io.ReadFull(rand.Reader, data)
+	assert.Nil(t, err)
+	compressed, err := compressor.Compress(data)
+	assert.Nil(t, err)
+	decompressed, err := compressor.Decompress(compressed)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(data, decompressed))
+
+	// corrupted data
+	_, err = compressor.Decompress([]byte("not gzipped"))
+	assert.NotNil(t, err)
+}
+
+func TestGzipCompressorMaxSize(t *testing.T) {
+	compressor := &GzipCompressor{MaxSize: 1024}
+	compressed, err := compressor.Compress(make([]byte, 1024))
+	assert.Nil(t, err)
+	_, err = compressor.Decompress(compressed)
+	assert.Nil(t, err)
+
+	compressed, err = compressor.Compress(make([]byte, 1025))
+	assert.Nil(t, err)
+	_, err = compressor.Decompress(compressed)
+	assert.Equal(t, ErrDecompressedSizeExceeded, err)
+}
+
+func TestMessageFrame(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	configs[0].Compressor = new(GzipCompressor)
+	consensus, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+
+	// small messages skip compression
+	small := []byte("small message")
+	frame := consensus.encodeFrame(small)
+	assert.Equal(t, frameRaw, frame[0])
+	decoded, err := consensus.decodeFrame(frame)
+	assert.Nil(t, err)
+	assert.Equal(t, small, decoded)
+
+	// large messages are compressed
+	large := bytes.Repeat([]byte("bdls"), DefaultCompressThreshold)
+	frame = consensus.encodeFrame(large)
+	assert.Equal(t, frameCompressed, frame[0])
+	assert.True(t, len(frame) < len(large))
+	decoded, err = consensus.decodeFrame(frame)
+	assert.Nil(t, err)
+	assert.Equal(t, large, decoded)
+
+	// incompressible messages are sent raw
+	random := make([]byte, 2*DefaultCompressThreshold)
+	_, err = io.ReadFull(rand.Reader, random)
+	assert.Nil(t, err)
+	frame = consensus.encodeFrame(random)
+	assert.Equal(t, frameRaw, frame[0])
+	decoded, err = consensus.decodeFrame(frame)
+	assert.Nil(t, err)
+	assert.Equal(t, random, decoded)
+
+	// malformed frames
+	_, err = consensus.decodeFrame(nil)
+	assert.Equal(t, ErrMessageIsEmpty, err)
+	_, err = consensus.decodeFrame([]byte{0xff, 0x00})
+	assert.Equal(t, ErrMessageFrameFlag, err)
+	_, err = consensus.decodeFrame([]byte{frameCompressed, 0x00})
+	assert.NotNil(t, err)
+
+	// messages are unchanged without compressor
+	consensus, err = NewConsensus(configs[1])
+	assert.Nil(t, err)
+	assert.Equal(t, large, consensus.encodeFrame(large))
+	decoded, err = consensus.decodeFrame(large)
+	assert.Nil(t, err)
+	assert.Equal(t, large, decoded)
+}
+
+func TestReceiveCompressedMessage(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	configs[0].Compressor = new(GzipCompressor)
+	configs[0].CompressThreshold = 1
+	consensus, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+
+	_, signed, _ := createRoundChangeMessageSigner(t, 1, 1, bytes.Repeat([]byte("bdls"), 1024), configs[1].PrivateKey)
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	frame := consensus.encodeFrame(bts)
+	assert.Equal(t, frameCompressed, frame[0])
+
+	assert.Nil(t, consensus.ValidateMessage(frame))
+	assert.Nil(t, consensus.ReceiveMessage(frame, time.Now()))
+
+	// uncompressed messages without flag are rejected
+	_, signed, _ = createRoundChangeMessageSigner(t, 1, 2, State("state"), configs[1].PrivateKey)
+	bts, err = proto.Marshal(signed)
+	assert.Nil(t, err)
+	err = consensus.ReceiveMessage(bts, time.Now())
+	assert.NotNil(t, err)
+	assert.True(t, errors.Is(consensus.ValidateMessage(bts), err))
+}
+
+func TestIPCNetworkCompression(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	for _, config := range configs {
+		config.Scheduler = scheduler
+		config.Compressor = new(GzipCompressor)
+		config.CompressThreshold = 1
+	}
+
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond)
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	// compressible states
+	for i, p := range network.Peers() {
+		p.Propose(bytes.Repeat([]byte{byte(i)}, 4096))
+	}
+
+	decided := func() bool {
+		for _, p := range network.Peers() {
+			if height, _, _ := p.GetLatestState(); height < 1 {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; i < 60000 && !decided(); i++ {
+		scheduler.Advance(10 * time.Millisecond)
+	}
+	assert.True(t, decided())
+
+	// messages on wire are counted by type after decompression
+	for _, p := range network.Peers() {
+		p.Lock()
+		assert.True(t, p.msgTypeCount[MessageType_RoundChange] > 0)
+		p.Unlock()
+	}
+}
@@ -31,12 +31,20 @@
 package bdls
 
 import (
+	"bytes"
 	"crypto/ecdsa"
+	"errors"
+	fmt "fmt"
+	"hash"
+	"strings"
 	"time"
+
+	"github.com/Sperax/bdls/timer"
 )
 
 const (
-	// ConfigMinimumParticipants is the minimum number of participant allow in consensus protocol
+	// ConfigMinimumParticipants is the minimum number of participant allow in consensus protocol,
+	// the smallest committee n = 3t+1 tolerating t = 1 faulty participant, with a quorum of 3.
 	ConfigMinimumParticipants = 4
 )
 
@@ -48,9 +56,24 @@ type Config struct {
 	// CurrentHeight
 	// 进行这次共识前的最新区块高度
 	CurrentHeight uint64
-	// PrivateKey
+	// GenesisHeight is the height of the genesis state, the first state of
+	// the chain, CurrentHeight must not be lower than it.
+	// (optional). Default to 0
+	GenesisHeight uint64
+	// CurrentState is the state decided at CurrentHeight, which is the
+	// genesis state if CurrentHeight is GenesisHeight. It's checked by
+	// StateValidateAt with round 0, or StateValidate on creation, and
+	// returned by Consensus.CurrentState until the next height is decided.
+	// (optional). Default to nil, no state is validated.
+	CurrentState State
+	// PrivateKey, not required for observers or if Signer has set, see NewObserver
 	PrivateKey *ecdsa.PrivateKey
-	// Consensus Group
+	// Signer signs messages with a private key kept outside of the process,
+	// such as in a KMS or HSM, it's an alternative to PrivateKey.
+	// (optional). Default to an in-memory signer of PrivateKey
+	Signer Signer
+	// Consensus Group, the order decides the leader rotation, and must be
+	// the same on all participants, see SortParticipants for a canonical order.
 	// 共识参与者，在 SperaxChain 项目中，每一次共识参与者由质押spa数量和伪随机数排序获得的列表
 	Participants []Identity
 	// EnableCommitUnicast sets to true to enable <commit> message to be delivered via unicast
@@ -63,12 +86,35 @@ type Config struct {
 	// The result will be 0 if a==b, -1 if a < b, and +1 if a > b.
 	// Usually this will lead to block header comparsion in blockchain, or replication log in database,
 	// users should check fields in block header to make comparison.
+	//
+	// The comparison MUST be a strict total order, i.e. distinct states never
+	// compare equal, otherwise participants may disagree on the maximal state,
+	// ties must be broken deterministically, like comparing the bytes, or by
+	// ProposalTieBreak.
+	// (optional). Default to DefaultStateCompare
 	StateCompare func(a State, b State) int
 
+	// ProposalTieBreak compares two distinct proposals equal under
+	// StateCompare, as StateCompare does, the proposal with the greater result
+	// is selected. Only PublicKey and State of the proposals are set, the
+	// proposer of a state is the lowest identity among the <roundchange>
+	// proofs of the <select> or <lock> message carrying it, or the leader
+	// signing the message for its own proposals, <select> and <lock>
+	// messages not following it are rejected. It MUST be deterministic and
+	// agreed by all participants.
+	// (optional). Default to nil, the proposal with the lower proposer
+	// identity is selected, then the one greater in bytes.
+	ProposalTieBreak func(a ProposalInfo, b ProposalInfo) int
+
 	// StateValidate is a function from user to validate the integrity of
 	// state data.
 	StateValidate func(State) bool
 
+	// StateValidateAt is a function from user to validate the integrity of
+	// state data with the height and round of the message carrying it.
+	// (optional). If set, it takes precedence over StateValidate.
+	StateValidateAt func(height uint64, round uint64, s State) bool
+
 	// MessageValidator is an external validator to be called when a message inputs into ReceiveMessage
 	MessageValidator func(c *Consensus, m *Message, signed *SignedProto) bool
 
@@ -79,29 +125,397 @@ type Config struct {
 	// Identity derviation from ecdsa.PublicKey
 	// (optional). Default to DefaultPubKeyToIdentity
 	PubKeyToIdentity func(pubkey *ecdsa.PublicKey) (ret Identity)
+
+	// RoundChangeBaseTimeout is the timeout of <roundchange> stage at round 0,
+	// the timeout doubles on each consecutive failed round, as base * 2^round,
+	// and resets to base when a height is decided.
+	// (optional). Default to 2 * latency
+	RoundChangeBaseTimeout time.Duration
+
+	// RoundChangeMaxTimeout caps the timeout of <roundchange> stage.
+	// (optional). Default to MaxConsensusLatency
+	RoundChangeMaxTimeout time.Duration
+
+	// LatencyEstimator adapts the timeout of <roundchange> stage at round 0
+	// to observed latencies, overriding RoundChangeBaseTimeout once it has
+	// samples to suggest, see NewEWMALatencyEstimator.
+	// (optional). Default to nil, timeouts are static.
+	LatencyEstimator LatencyEstimator
+
+	// ParticipantWeights assigns voting weights to participants for stake-based
+	// quorums, every participant must have a positive weight. Quorum is reached
+	// with more than 2/3 of the total weight, i.e. floor(2W/3)+1 of the total
+	// weight W after dividing weights by their greatest common divisor. Equal
+	// weights are the same as counting participants, with the quorum of 2t+1.
+	// (optional). Default to nil, all participants are equal.
+	ParticipantWeights map[Identity]uint64
+
+	// DedupCacheSize is the number of processed messages remembered, to
+	// short-circuit duplicated messages before signature verification.
+	// (optional). Default to DefaultDedupCacheSize, negative value disables.
+	DedupCacheSize int
+
+	// MaxMessageSize is the ceiling of the size of a message received,
+	// oversized messages are rejected with ErrMessageTooLarge before any
+	// decoding. Transports with their own frame limits adopt it if smaller.
+	// (optional). Default to DefaultMaxMessageSize, negative value disables.
+	MaxMessageSize int
+
+	// MaxFutureHeight is the window of heights beyond the height in consensus,
+	// messages with heights beyond the window will be dropped, except <decide>.
+	// (optional). Default to DefaultMaxFutureHeight
+	MaxFutureHeight uint64
+
+	// PipelineDepth is the number of heights in flight, with a depth larger
+	// than 1, <roundchange> messages of following heights are exchanged while
+	// the next height is committing, states for them are proposed by
+	// ProposeAt. A height is never decided before its preceding height, the
+	// depth cannot exceed MaxFutureHeight+1.
+	// (optional). Default to 1, heights are decided one at a time.
+	PipelineDepth int
+
+	// ReplayWindow is the number of heights, counting back from the height
+	// being decided, in which accepted messages are remembered, exact replays of
+	// them are rejected with ErrMessageReplay. Messages relayed more than
+	// once in gossip networks will be rejected too.
+	// (optional). Default to 0, replays are short-circuited by dedup cache only.
+	ReplayWindow uint64
+
+	// PerParticipantRateLimit is the number of messages per second accepted
+	// from each participant, messages exceeding the limit are rejected with
+	// ErrMessageRateLimited after signature verification, duplicates and
+	// messages from myself are not counted.
+	// (optional). Default to 0, messages are not rate limited.
+	PerParticipantRateLimit float64
+
+	// PerParticipantRateBurst is the number of messages accepted at once
+	// from each participant, to accommodate bursts such as in round changes.
+	// (optional). Default to DefaultRateLimitBurst
+	PerParticipantRateBurst int
+
+	// ReceiveQueueSize is the maximum number of messages queued by
+	// ReceiveMessage, to be verified in Update by priority, <decide> first,
+	// then <commit>, messages with proofs and <roundchange>, so critical
+	// messages won't be starved by floods. When full, a message of the
+	// lowest priority is dropped, the caller must call Update frequently.
+	// (optional). Default to 0, messages are verified inline in ReceiveMessage.
+	ReceiveQueueSize int
+
+	// PauseBufferSize is the maximum number of messages buffered by
+	// ReceiveMessage while paused, messages beyond are rejected with
+	// ErrPauseBufferFull, see Consensus.Pause.
+	// (optional). Default to DefaultPauseBufferSize, negative value buffers none.
+	PauseBufferSize int
+
+	// MaxProofsPerMessage is the maximum number of proofs in a <lock>, <select>
+	// or <decide> message, messages with more proofs are rejected with
+	// ErrProofSetTooLarge before any proof is verified, to prevent a single
+	// message from forcing verification of arbitrary number of signatures.
+	// (optional). Default to the number of participants, as a participant
+	// contributes at most one proof, negative value disables.
+	MaxProofsPerMessage int
+
+	// OnDecide is called when a height is decided with the number of rounds
+	// taken and the duration since the height began, measured with the time
+	// fed to consensus, so it works under virtual time. Hooks of OnDecide,
+	// OnStateFinalized and OnBecomeLeader share one queue, and are called one
+	// by one in the order raised on the caller's goroutine, just before the
+	// method of consensus raising them returns, so heights are reported in
+	// order. The state is committed before the call, so CurrentState returns
+	// at least height from within the hook, and hooks may call back into
+	// consensus, but not through a wrapper holding its lock around the call
+	// being returned from, such as the methods of IPCPeer.
+	// (optional). Default to nil
+	OnDecide func(height uint64, rounds uint64, duration time.Duration)
+
+	// OnStateFinalized is called when a height is decided with the decided
+	// state and the signatures of the <commit> messages forming the quorum,
+	// taken from the <decide> message, to be embedded in block headers for
+	// example. It's called once for each height decided, after the state has
+	// been committed, and queued after OnDecide of the same height, see
+	// OnDecide. Heights synced by Reset are not reported.
+	// (optional). Default to nil
+	OnStateFinalized func(height uint64, round uint64, s State, sigs []SignerSignature)
+
+	// OnBeforeDecide is called before a decided state is finalized locally,
+	// for application policies to hold a state which has reached quorum, it's
+	// not a protocol safety check. A non-nil error vetoes the state, the
+	// <decide> message is rejected with ErrDecideVetoed and the height is not
+	// advanced, a leader doesn't broadcast the <decide> message it vetoed. The
+	// <decide> message is not relayed either, to avoid relaying it back and
+	// forth among vetoing participants, it will be accepted if received again
+	// once the hook allows. It's called synchronously, so it must not call
+	// back into consensus.
+	// (optional). Default to nil
+	OnBeforeDecide func(height uint64, round uint64, s State) error
+
+	// OnBecomeLeader is called when consensus switches to a round led by
+	// myself, with the height being decided and the round, so the proposal
+	// can be assembled lazily and proposed from the hook. It's called once
+	// for each height and round, in order, from the queue of OnDecide, and
+	// may call Propose directly.
+	// (optional). Default to nil
+	OnBecomeLeader func(height uint64, round uint64)
+
+	// QCHistoryDepth is the number of latest decided heights to keep quorum
+	// certificates for, which can be retrieved by QuorumCertificate.
+	// (optional). Default to 0, no quorum certificates are kept.
+	QCHistoryDepth int
+
+	// EventHistoryDepth is the number of latest events of round changes,
+	// decides, rejections and equivocations to keep, which can be retrieved
+	// by RecentEvents for post-hoc debugging.
+	// (optional). Default to 0, no events are kept.
+	EventHistoryDepth int
+
+	// StateCodec encodes states embedded in messages, and decodes states
+	// extracted from received messages, all participants must agree on the
+	// codec.
+	// (optional). Default to RawStateCodec
+	StateCodec StateCodec
+
+	// Hasher creates the hash function of messages for signing and dedup,
+	// for interop with systems hashing by SHA-256 or Keccak, it changes the
+	// signatures on wire, so all participants must agree on the hasher.
+	// (optional). Default to nil, messages are hashed by blake2b-256.
+	Hasher func() hash.Hash
+
+	// Compressor compresses messages sent to peers, and decompresses messages
+	// received, all participants must agree on whether to set a compressor.
+	// (optional). Default to nil, messages are sent uncompressed.
+	Compressor Compressor
+
+	// CompressThreshold is the size of messages in bytes below which messages
+	// are sent uncompressed.
+	// (optional). Default to DefaultCompressThreshold
+	CompressThreshold int
+
+	// WAL persists accepted messages, and will be replayed when creating
+	// consensus to rebuild the states after restarting.
+	// (optional). Default to nil, messages are not persisted.
+	WAL WAL
+
+	// Tracer to start spans around message verification, proof checking and
+	// state transitions, for profiling.
+	// (optional). Default to nil, no spans are started.
+	Tracer Tracer
+
+	// Logger to receive diagnostics of consensus, such as rejected messages
+	// (optional). Default to a logger which discards everything
+	Logger Logger
+
+	// ProtocolVersion is the version to sign messages with, messages with
+	// other versions are rejected with ErrMessageVersion, and a participant
+	// consistently sending them is reported to SubscribeVersionMismatch, so
+	// incompatible nodes can be detected during rolling upgrades.
+	// (optional). Default to ProtocolVersion
+	ProtocolVersion uint32
+
+	// SendRetry is the policy to retry Peer.Send when it returns a temporary
+	// error, i.e. one implementing Temporary() bool and returning true, or
+	// ErrTCPSendQueueFull. Retries are sent from Update once due, so
+	// consensus is never blocked, and a SendFailureEvent is delivered to
+	// SubscribeSendFailure if all attempts failed or the error is not
+	// temporary, such as a closed peer.
+	// (optional). Default to nil, a failed message is reported without retry.
+	SendRetry *SendRetry
+
+	// Scheduler to execute delayed functions for peers and simulations, along
+	// with the clock, a timer.ManualScheduler can be used for virtual time.
+	// (optional). Default to timer.SystemTimedSched
+	Scheduler timer.Scheduler
 }
 
-// VerifyConfig verifies the integrity of this config when creating new consensus object
-func VerifyConfig(c *Config) error {
+// VerifyConfig verifies the integrity of this config when creating new consensus object,
+// if there's more than one defect, all of them are returned joined, and each can be
+// tested with errors.Is.
+func VerifyConfig(c *Config) error { return verifyConfig(c, false) }
+
+// verifyConfig verifies the config, the private key is not required for observers,
+// all defects found are reported at once, see configErrors.
+func verifyConfig(c *Config, observer bool) error {
+	var errs configErrors
 	if c.Epoch.IsZero() {
-		return ErrConfigEpoch
+		errs = append(errs, ErrConfigEpoch)
+	}
+
+	if c.StateCompare != nil {
+		if err := checkStateCompare(c.StateCompare); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	if c.StateCompare == nil {
-		return ErrConfigStateCompare
+	if c.StateValidate == nil && c.StateValidateAt == nil {
+		errs = append(errs, ErrConfigStateValidate)
+	} else if c.CurrentState != nil {
+		var valid bool
+		if c.StateValidateAt != nil {
+			valid = c.StateValidateAt(c.CurrentHeight, 0, c.CurrentState)
+		} else {
+			valid = c.StateValidate(c.CurrentState)
+		}
+		if !valid {
+			errs = append(errs, fmt.Errorf("%w: state at height %d", ErrConfigCurrentState, c.CurrentHeight))
+		}
 	}
 
-	if c.StateValidate == nil {
-		return ErrConfigStateValidate
+	if c.CurrentHeight < c.GenesisHeight {
+		errs = append(errs, fmt.Errorf("%w: height %d is below genesis height %d", ErrConfigGenesisHeight, c.CurrentHeight, c.GenesisHeight))
 	}
 
-	if c.PrivateKey == nil {
-		return ErrConfigPrivateKey
+	if c.Signer != nil {
+		if c.PrivateKey != nil || c.Signer.Public() == nil {
+			errs = append(errs, ErrConfigSigner)
+		}
+	} else if c.PrivateKey == nil && !observer {
+		errs = append(errs, ErrConfigPrivateKey)
 	}
 
 	if len(c.Participants) < ConfigMinimumParticipants {
-		return ErrConfigParticipants
+		errs = append(errs, ErrConfigParticipants)
+	} else if c.ParticipantWeights != nil {
+		if err := verifyWeights(c.Participants, c.ParticipantWeights); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
+	if c.PerParticipantRateLimit < 0 || c.PerParticipantRateBurst < 0 {
+		errs = append(errs, ErrConfigRateLimit)
+	}
+
+	maxFutureHeight := c.MaxFutureHeight
+	if maxFutureHeight == 0 {
+		maxFutureHeight = DefaultMaxFutureHeight
+	}
+	if c.PipelineDepth < 0 || uint64(c.PipelineDepth) > maxFutureHeight+1 {
+		errs = append(errs, ErrConfigPipelineDepth)
+	}
+
+	if c.SendRetry != nil && (c.SendRetry.MaxAttempts < 1 || c.SendRetry.Backoff < 0 || c.SendRetry.MaxBackoff < 0) {
+		errs = append(errs, ErrConfigSendRetry)
+	}
+
+	if c.RoundChangeBaseTimeout < 0 || c.RoundChangeMaxTimeout < 0 ||
+		(c.RoundChangeMaxTimeout != 0 && c.RoundChangeMaxTimeout < c.RoundChangeBaseTimeout) {
+		errs = append(errs, ErrConfigRoundChangeTimeout)
+	}
+
+	return errs.err()
+}
+
+// configErrors joins all defects found in a config as errors.Join does, so
+// every defect can be fixed in one pass, and each of them can be tested
+// with errors.Is and errors.As on the joined error.
+type configErrors []error
+
+// err returns nil if there's no defect, the defect itself if there's only
+// one, or the joined defects.
+func (errs configErrors) err() error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return errs
+}
+
+// Error implements error, with one defect per line
+func (errs configErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns all defects, for errors.Is and errors.As since go1.20
+func (errs configErrors) Unwrap() []error { return errs }
+
+// Is reports whether any defect matches target
+func (errs configErrors) Is(target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first defect that matches target
+func (errs configErrors) As(target interface{}) bool {
+	for _, err := range errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultStateCompare compares states byte-wise lexicographically,
+// it's used when Config.StateCompare is not set.
+func DefaultStateCompare(a State, b State) int { return bytes.Compare(a, b) }
+
+// stateCompareSamples are distinct states to self-check Config.StateCompare
+var stateCompareSamples = []State{
+	{},
+	{0x00},
+	{0x00, 0x00},
+	{0x01},
+	{0x01, 0x00},
+	{0x7f, 0xff},
+	{0x80},
+	{0xff},
+	{0xff, 0xff, 0xff, 0xff},
+	State("state"),
+	State("states"),
+}
+
+// checkStateCompare checks the comparator to be a strict total order on the
+// samples, to catch comparators reporting distinct states as equal, or
+// inconsistent results when swapping the arguments.
+func checkStateCompare(compare func(a State, b State) int) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: panic on comparing samples: %v", ErrConfigStateCompareOrder, r)
+		}
+	}()
+
+	sign := func(x int) int {
+		switch {
+		case x < 0:
+			return -1
+		case x > 0:
+			return 1
+		}
+		return 0
+	}
+
+	for i, a := range stateCompareSamples {
+		if compare(a, a) != 0 {
+			return fmt.Errorf("%w: compare(%x, %x) != 0", ErrConfigStateCompareOrder, a, a)
+		}
+
+		for j, b := range stateCompareSamples {
+			if i == j {
+				continue
+			}
+
+			ab := sign(compare(a, b))
+			if ab == 0 {
+				return fmt.Errorf("%w: compare(%x, %x) == 0 for distinct states", ErrConfigStateCompareOrder, a, b)
+			}
+			if ab != -sign(compare(b, a)) {
+				return fmt.Errorf("%w: compare(%x, %x) is not the opposite of compare(%x, %x)", ErrConfigStateCompareOrder, a, b, b, a)
+			}
+
+			// transitivity
+			for _, c := range stateCompareSamples {
+				if ab == sign(compare(b, c)) && sign(compare(a, c)) != ab {
+					return fmt.Errorf("%w: compare(%x, %x) is not transitive via %x", ErrConfigStateCompareOrder, a, c, b)
+				}
+			}
+		}
+	}
 	return nil
 }
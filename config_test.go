@@ -1,8 +1,11 @@
 package bdls
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/rand"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,19 +16,32 @@ func TestVerifyConfig(t *testing.T) {
 	config := new(Config)
 
 	err := VerifyConfig(config)
-	assert.Equal(t, ErrConfigEpoch, err)
+	assert.True(t, errors.Is(err, ErrConfigEpoch))
 
 	config.Epoch = time.Now()
 	err = VerifyConfig(config)
-	assert.Equal(t, ErrConfigStateCompare, err)
+	assert.False(t, errors.Is(err, ErrConfigEpoch))
+	assert.True(t, errors.Is(err, ErrConfigStateValidate))
 
 	config.StateCompare = func(State, State) int { return 0 }
 	err = VerifyConfig(config)
-	assert.Equal(t, ErrConfigStateValidate, err)
+	assert.True(t, errors.Is(err, ErrConfigStateCompareOrder))
 
+	config.StateCompare = DefaultStateCompare
+	err = VerifyConfig(config)
+	assert.False(t, errors.Is(err, ErrConfigStateCompareOrder))
+	assert.True(t, errors.Is(err, ErrConfigStateValidate))
+
+	config.StateValidateAt = func(uint64, uint64, State) bool { return true }
+	err = VerifyConfig(config)
+	assert.False(t, errors.Is(err, ErrConfigStateValidate))
+	assert.True(t, errors.Is(err, ErrConfigPrivateKey))
+
+	config.StateValidateAt = nil
 	config.StateValidate = func(State) bool { return true }
 	err = VerifyConfig(config)
-	assert.Equal(t, ErrConfigPrivateKey, err)
+	assert.False(t, errors.Is(err, ErrConfigStateValidate))
+	assert.True(t, errors.Is(err, ErrConfigPrivateKey))
 
 	randKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
 	assert.Nil(t, err)
@@ -42,4 +58,147 @@ func TestVerifyConfig(t *testing.T) {
 
 	err = VerifyConfig(config)
 	assert.Nil(t, err)
+
+	config.RoundChangeBaseTimeout = time.Second
+	config.RoundChangeMaxTimeout = time.Millisecond
+	err = VerifyConfig(config)
+	assert.Equal(t, ErrConfigRoundChangeTimeout, err)
+
+	config.RoundChangeMaxTimeout = 0
+	err = VerifyConfig(config)
+	assert.Nil(t, err)
+}
+
+func TestVerifyConfigAllErrors(t *testing.T) {
+	config := new(Config)
+	config.StateValidate = func(State) bool { return true }
+	config.PerParticipantRateLimit = -1
+
+	err := VerifyConfig(config)
+	assert.True(t, errors.Is(err, ErrConfigEpoch))
+	assert.True(t, errors.Is(err, ErrConfigPrivateKey))
+	assert.True(t, errors.Is(err, ErrConfigParticipants))
+	assert.True(t, errors.Is(err, ErrConfigRateLimit))
+	assert.False(t, errors.Is(err, ErrConfigStateValidate))
+	assert.Equal(t, 4, strings.Count(err.Error(), "\n")+1)
+
+	// wrapped defects are reported along
+	config.StateCompare = func(State, State) int { return 0 }
+	_, err = NewConsensus(config)
+	assert.True(t, errors.Is(err, ErrConfigStateCompareOrder))
+	assert.True(t, errors.Is(err, ErrConfigEpoch))
+	assert.Contains(t, err.Error(), "for distinct states")
+}
+
+func TestQuorumSize(t *testing.T) {
+	for n := 0; n < ConfigMinimumParticipants; n++ {
+		assert.Equal(t, 0, QuorumSize(n))
+		assert.Equal(t, 0, MaxFaulty(n))
+	}
+
+	var tests = []struct {
+		n      int
+		faulty int
+		quorum int
+	}{
+		{4, 1, 3}, {5, 1, 3}, {6, 1, 3}, {7, 2, 5}, {10, 3, 7},
+		{20, 6, 13}, {21, 6, 13}, {22, 7, 15}, {50, 16, 33}, {100, 33, 67},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.faulty, MaxFaulty(tt.n), "n=%d", tt.n)
+		assert.Equal(t, tt.quorum, QuorumSize(tt.n), "n=%d", tt.n)
+	}
+
+	for n := ConfigMinimumParticipants; n <= 100; n++ {
+		f := MaxFaulty(n)
+		// n >= 3f+1 and the quorum is 2f+1
+		assert.True(t, n >= 3*f+1 && n < 3*(f+1)+1, "n=%d", n)
+		assert.Equal(t, 2*f+1, QuorumSize(n), "n=%d", n)
+	}
+}
+
+func TestDefaultStateCompare(t *testing.T) {
+	assert.Equal(t, 0, DefaultStateCompare(State("a"), State("a")))
+	assert.Equal(t, -1, DefaultStateCompare(State("a"), State("b")))
+	assert.Equal(t, 1, DefaultStateCompare(State("b"), State("a")))
+	assert.Equal(t, -1, DefaultStateCompare(State("a"), State("ab")))
+	assert.Equal(t, 0, DefaultStateCompare(nil, State{}))
+	assert.Nil(t, checkStateCompare(DefaultStateCompare))
+}
+
+func TestCheckStateCompare(t *testing.T) {
+	// reversed order is still a total order
+	reversed := func(a State, b State) int { return bytes.Compare(b, a) }
+	assert.Nil(t, checkStateCompare(reversed))
+
+	// comparing the length only has ties
+	length := func(a State, b State) int { return len(a) - len(b) }
+	err := checkStateCompare(length)
+	assert.True(t, errors.Is(err, ErrConfigStateCompareOrder))
+	assert.Contains(t, err.Error(), "distinct states")
+
+	// asymmetric results
+	asymmetric := func(a State, b State) int {
+		if bytes.Equal(a, b) {
+			return 0
+		}
+		return 1
+	}
+	err = checkStateCompare(asymmetric)
+	assert.True(t, errors.Is(err, ErrConfigStateCompareOrder))
+	assert.Contains(t, err.Error(), "opposite")
+
+	// panics are reported as error
+	panics := func(a State, b State) int { return int(a[0]) - int(b[0]) }
+	err = checkStateCompare(panics)
+	assert.True(t, errors.Is(err, ErrConfigStateCompareOrder))
+	assert.Contains(t, err.Error(), "panic")
+}
+
+func TestNewConsensusDefaultStateCompare(t *testing.T) {
+	config := createIPCNetworkConfigs(t, ConfigMinimumParticipants)[0]
+	config.StateCompare = nil
+	consensus, err := NewConsensus(config)
+	assert.Nil(t, err)
+	assert.Equal(t, -1, consensus.stateCompare(State("a"), State("b")))
+}
+
+func TestVerifyConfigGenesis(t *testing.T) {
+	config := createIPCNetworkConfigs(t, 4)[0]
+	config.CurrentHeight = 10
+	config.GenesisHeight = 10
+	config.CurrentState = State("genesis")
+	consensus, err := NewConsensus(config)
+	assert.Nil(t, err)
+	height, _, state := consensus.CurrentState()
+	assert.Equal(t, uint64(10), height)
+	assert.Equal(t, State("genesis"), state)
+
+	// below genesis
+	config.CurrentHeight = 9
+	_, err = NewConsensus(config)
+	assert.True(t, errors.Is(err, ErrConfigGenesisHeight))
+	assert.Contains(t, err.Error(), "height 9 is below genesis height 10")
+	config.CurrentHeight = 10
+
+	// invalid genesis state
+	config.StateValidate = func(s State) bool { return string(s) != "genesis" }
+	_, err = NewConsensus(config)
+	assert.True(t, errors.Is(err, ErrConfigCurrentState))
+	assert.Contains(t, err.Error(), "state at height 10")
+
+	// validated with the height
+	var validated []uint64
+	config.StateValidateAt = func(height uint64, round uint64, s State) bool {
+		validated = append(validated, height)
+		return false
+	}
+	_, err = NewConsensus(config)
+	assert.True(t, errors.Is(err, ErrConfigCurrentState))
+	assert.Equal(t, []uint64{10}, validated)
+
+	// not validated if unset
+	config.CurrentState = nil
+	_, err = NewConsensus(config)
+	assert.Nil(t, err)
 }
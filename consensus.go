@@ -33,13 +33,19 @@ package bdls
 import (
 	"bytes"
 	"container/list"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	fmt "fmt"
+	"hash"
+	"math/big"
 	"net"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/Sperax/bdls/crypto/blake2b"
+	"github.com/Sperax/bdls/timer"
 	proto "github.com/gogo/protobuf/proto"
 )
 
@@ -54,6 +60,14 @@ const (
 
 	// MaxConsensusLatency is the ceiling of latencies
 	MaxConsensusLatency = 10 * time.Second
+
+	// DefaultMaxFutureHeight is the default window of heights beyond the
+	// height in consensus, messages beyond the window will be dropped
+	DefaultMaxFutureHeight = 10
+
+	// DefaultMaxMessageSize is the default ceiling of the size of a message
+	// received (32MB)
+	DefaultMaxMessageSize = 32 * 1024 * 1024
 )
 
 type (
@@ -81,12 +95,29 @@ const (
 	stageLockRelease
 )
 
+// String representation of consensus stage
+func (s consensusStage) String() string {
+	switch s {
+	case stageRoundChanging:
+		return "roundchange"
+	case stageLock:
+		return "lock"
+	case stageCommit:
+		return "commit"
+	case stageLockRelease:
+		return "lock-release"
+	}
+	return fmt.Sprintf("unknown(%d)", s)
+}
+
 // messageTuple contains a state hash, a decoded incoming message
 // and it's encoded raw message with a signature.
 type messageTuple struct {
 	StateHash StateHash    // computed while adding
 	Message   *Message     // the decoded message
 	Signed    *SignedProto // the encoded message with signature
+	Weight    int          // the voting weight of the signer
+	Proposer  Identity     // the proposer of the state by the proofs, for locks
 }
 
 // a sorter for messageTuple slice
@@ -116,14 +147,21 @@ type consensusRound struct {
 	RoundChangeSent bool           // mark if the <roundchange> message of this round has sent
 	// 这轮共识已经提交
 	CommitSent bool // mark if this round has sent commit message once
+	// the time leader broadcasted <lock>, for latency estimation
+	LockSentTime time.Time
 
 	// NOTE: we MUST keep the original message, to re-marshal the message may
 	// result in different BITS LAYOUT, and different hash of course.
 	roundChanges []messageTuple // stores <roundchange> message tuples of this round
 	commits      []messageTuple // stores <commit> message tuples of this round
 
+	// proposers of states in roundChanges, computed on demand and cleared
+	// while roundChanges changes.
+	proposers map[StateHash]Identity
+
 	// track current max proposed state in <roundchange>,  we don't have to compute this for
 	// a non-leader participant, or if there're no more than 2t+1 messages for leader.
+	// the count is the sum of weights of participants proposed the state.
 	MaxProposedState State
 	MaxProposedCount int
 }
@@ -147,7 +185,8 @@ func (r *consensusRound) AddRoundChange(sp *SignedProto, m *Message) bool {
 		}
 	}
 
-	r.roundChanges = append(r.roundChanges, messageTuple{StateHash: r.c.stateHash(m.State), Message: m, Signed: sp})
+	r.roundChanges = append(r.roundChanges, messageTuple{StateHash: r.c.stateHash(m.State), Message: m, Signed: sp, Weight: r.c.signerWeight(sp)})
+	r.proposers = nil
 	return true
 }
 
@@ -171,12 +210,37 @@ func (r *consensusRound) RemoveRoundChange(idx int) {
 	r.roundChanges[idx], r.roundChanges[n] = r.roundChanges[n], r.roundChanges[idx]
 	r.roundChanges[n] = messageTuple{} // set to nil to avoid memory leak
 	r.roundChanges = r.roundChanges[:n]
+	r.proposers = nil
 }
 
 // NumRoundChanges returns count of <roundchange> messages.
 // 统计已收到的当前轮次的 <roundChanges> 个数
 func (r *consensusRound) NumRoundChanges() int { return len(r.roundChanges) }
 
+// RoundChangeWeight returns the sum of weights of <roundchange> messages.
+func (r *consensusRound) RoundChangeWeight() int {
+	var weight int
+	for k := range r.roundChanges {
+		weight += r.roundChanges[k].Weight
+	}
+	return weight
+}
+
+// Proposers returns proposers of the states in <roundchange> messages of
+// this round, from the decoded messages of exactly the proofs returned by
+// SignedRoundChanges, so the leader breaks ties of its <select> and <lock>
+// messages as verifiers do with the proofs enclosed.
+func (r *consensusRound) Proposers() map[StateHash]Identity {
+	if r.proposers == nil {
+		rcs := make(map[Identity]State)
+		for _, t := range r.roundChanges {
+			rcs[r.c.pubKeyToIdentity(t.Signed.PublicKey(r.c.curve))] = t.Message.State
+		}
+		r.proposers = r.c.proposersOf(rcs)
+	}
+	return r.proposers
+}
+
 // SignedRoundChanges converts and returns []*SignedProto(as slice)
 // 返回证明这个roundchanges的所有签名
 func (r *consensusRound) SignedRoundChanges() []*SignedProto {
@@ -208,17 +272,17 @@ func (r *consensusRound) AddCommit(sp *SignedProto, m *Message) bool {
 			return false
 		}
 	}
-	r.commits = append(r.commits, messageTuple{StateHash: r.c.stateHash(m.State), Message: m, Signed: sp})
+	r.commits = append(r.commits, messageTuple{StateHash: r.c.stateHash(m.State), Message: m, Signed: sp, Weight: r.c.signerWeight(sp)})
 	return true
 }
 
-// NumCommitted counts <commit> messages which points to what the leader has locked.
+// NumCommitted sums the weights of <commit> messages which points to what the leader has locked.
 // leader统计 <commit> 个数，leader会先锁定一个区块hash，<commit>的区块必须等于该锁定区块hash才行
 func (r *consensusRound) NumCommitted() int {
 	var count int
 	for k := range r.commits {
 		if r.commits[k].StateHash == r.LockedStateHash {
-			count++
+			count += r.commits[k].Weight
 		}
 	}
 	return count
@@ -252,22 +316,22 @@ func (r *consensusRound) GetMaxProposed() (s State, count int) {
 	}
 	sort.Sort(&sorter)
 
-	// find the maximum occurred hash
+	// find the maximum occurred hash, weighted by signers
 	// O(n)
-	maxCount := 1
+	maxCount := r.roundChanges[0].Weight
 	maxState := r.roundChanges[0]
-	curCount := 1
+	curCount := r.roundChanges[0].Weight
 
 	n := len(r.roundChanges)
 	for i := 1; i < n; i++ {
 		if r.roundChanges[i].StateHash == r.roundChanges[i-1].StateHash {
-			curCount++
+			curCount += r.roundChanges[i].Weight
 		} else {
 			if curCount > maxCount {
 				maxCount = curCount
 				maxState = r.roundChanges[i-1]
 			}
-			curCount = 1
+			curCount = r.roundChanges[i].Weight
 		}
 	}
 
@@ -318,20 +382,32 @@ type Consensus struct {
 
 	// the StateCompare function from config
 	stateCompare func(State, State) int
+
+	// tie breaker of distinct states equal under stateCompare, nil to compare
+	// proposers
+	proposalTieBreak func(a ProposalInfo, b ProposalInfo) int
 	// the StateValidate function from config
 	stateValidate func(State) bool
+	// the StateValidateAt function from config
+	stateValidateAt func(height uint64, round uint64, s State) bool
 	// message in callback
 	messageValidator func(c *Consensus, m *Message, sp *SignedProto) bool
 	// message out callback
 	messageOutCallback func(m *Message, sp *SignedProto)
 	// public key to identity function
 	pubKeyToIdentity func(pubkey *ecdsa.PublicKey) Identity
+	// logger for diagnostics
+	logger Logger
+	// scheduler for peers and simulations
+	scheduler timer.Scheduler
 
 	// the StateHash function to identify a state
 	stateHash func(State) StateHash
 
 	// private key
 	privateKey *ecdsa.PrivateKey
+	// signer of messages, wraps privateKey if Config.Signer has not set
+	signer Signer
 	// my publickey coodinate
 	identity Identity
 	// curve retrieved from private key
@@ -340,18 +416,35 @@ type Consensus struct {
 	// transmission delay
 	latency time.Duration
 
+	// <roundchange> timeout backoff from config
+	rcBaseTimeout time.Duration
+	rcMaxTimeout  time.Duration
+
 	// all connected peers
 	peers []PeerInterface
 
+	// the lock shared by transports delivering messages from their own
+	// goroutines, see Locker
+	transportLock sync.Mutex
+
 	// participants is the consensus group, current leader is r % quorum
 	// 计算leader的方式和pbft v%n 相似
 	// SperaxChain 项目中,identity是address，明显identity长度64大于address的20
 	participants []Identity
 
+	// participants queued by UpdateParticipants, to be applied at next height
+	pendingParticipants []Identity
+
 	// count num of individual identities
 	// individual identities 是participant数量吗
 	numIdentities int
 
+	// normalized voting weights of participants, nil if all participants
+	// are equal, and the sum of weights of individual participants
+	weights       map[Identity]int
+	configWeights map[Identity]uint64
+	totalWeight   int
+
 	// set to true to enable <commit> message unicast
 	enableCommitUnicast bool
 
@@ -362,9 +455,139 @@ type Consensus struct {
 	// 广播message也要给自己一份
 	loopback [][]byte
 
+	// hashes of messages in loopback signed by myself, with their counts,
+	// the signatures of these messages are trusted without verification.
+	selfSigned map[[blake2b.Size256]byte]int
+
 	// the last message which caused round change
 	// SignedProto 包含有签名、公钥、message
 	lastRoundChangeProof []*SignedProto
+
+	// the time when current round began
+	roundStartTime time.Time
+
+	// the time when current height began
+	heightStartTime time.Time
+
+	// the OnDecide hook from config
+	onDecide func(height uint64, rounds uint64, duration time.Duration)
+
+	// the queue of hooks from config, flushed before public methods return
+	hooks hookQueue
+
+	// the OnStateFinalized hook from config, and the latest height reported
+	onStateFinalized func(height uint64, round uint64, s State, sigs []SignerSignature)
+	finalizedHeight  uint64
+
+	// the policy to retry sending to peers, nil if disabled, and the retries
+	// to send from Update
+	sendRetry *SendRetry
+	retries   []pendingSend
+
+	// the protocol version to sign and accept, and the number of consecutive
+	// messages with a different version from each participant
+	protocolVersion   uint32
+	versionMismatches map[Identity]int
+
+	// the OnBeforeDecide hook from config
+	onBeforeDecide func(height uint64, round uint64, s State) error
+
+	// the OnBecomeLeader hook from config, and the height and round it has
+	// been called for lastly
+	onBecomeLeader       func(height uint64, round uint64)
+	leaderNotified       bool
+	leaderNotifiedHeight uint64
+	leaderNotifiedRound  uint64
+
+	// quorum certificates of the latest decided heights
+	qcHistory      []*QC
+	qcHistoryDepth int
+
+	// history of recent events, nil if disabled
+	events *eventHistory
+
+	// subscribers of decide events
+	subscribers subscribers
+
+	// the first <lock> message accepted from leader of each round at
+	// current height, to detect equivocation
+	leaderLocks map[uint64]messageTuple
+
+	// proposals awaiting for results
+	proposals []pendingProposal
+
+	// processed messages, nil if disabled
+	dedup *dedupCache
+
+	// write-ahead log of accepted messages, nil if disabled
+	wal       WAL
+	replaying bool // set to true while replaying wal
+
+	// set to true while a <decide> is being processed
+	deciding bool
+
+	// maximum proofs in a message, 0 for the number of participants
+	maxProofsPerMessage int
+
+	// ceiling of message size, 0 if disabled
+	maxMessageSize int
+
+	// window of future heights, and count of messages dropped beyond it
+	maxFutureHeight        uint64
+	numFutureHeightDropped uint64
+
+	// heights in flight in pipelined mode, states proposed and <roundchange>
+	// messages for heights beyond the next height, and the highest height
+	// whose <roundchange> has been announced ahead.
+	pipelineDepth     uint64
+	pipelinedStates   []pipelinedState
+	pipelinedMessages []pipelinedMessage
+	pipelineAnnounced uint64
+
+	// count and bytes of messages handed to peers
+	numMessagesSent uint64
+	numBytesSent    uint64
+
+	// count and cumulative duration of signature verifications
+	numSignaturesVerified   uint64
+	signatureVerifyDuration time.Duration
+
+	// accepted messages in the replay window, nil if disabled
+	replays *replayGuard
+
+	// per participant rate limiter, nil if disabled
+	limiter *rateLimiter
+
+	// received messages pending verification in Update, nil if disabled
+	receiveQueue *receiveQueue
+
+	// messages buffered while paused, see Pause
+	paused          bool
+	pausedMessages  [][]byte
+	pauseBufferSize int
+
+	// latency estimator for adaptive timeouts, nil if disabled
+	latencyEstimator LatencyEstimator
+
+	// codec of states embedded in messages
+	stateCodec StateCodec
+
+	// hash function of messages for signing and dedup, nil means blake2b
+	hasher func() hash.Hash
+
+	// compressor for messages on wire, nil if disabled
+	compressor        Compressor
+	compressThreshold int
+
+	// read-only observer without private key, see NewObserver
+	observer bool
+
+	// tracer for profiling, nil if disabled
+	tracer Tracer
+
+	// set by Close
+	closed    bool
+	closeOnce sync.Once
 }
 
 // NewConsensus creates a BDLS consensus object to participant in consensus procedure,
@@ -379,6 +602,40 @@ func NewConsensus(config *Config) (*Consensus, error) {
 
 	c := new(Consensus)
 	c.init(config)
+	defer c.hooks.flush()
+
+	// rebuild states from write-ahead log
+	if config.WAL != nil {
+		if err := c.replayWAL(config.WAL, config.Epoch); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// NewObserver creates a read-only consensus object to follow the decided
+// states, Config.PrivateKey is not required and ignored. An observer never
+// signs or sends messages, it verifies and propagates <decide> messages
+// from the participants to track the latest height and state, other
+// messages are verified and then ignored. TryPropose on an observer returns
+// ErrObserverCannotPropose.
+func NewObserver(config *Config) (*Consensus, error) {
+	err := verifyConfig(config, true)
+	if err != nil {
+		return nil, err
+	}
+
+	c := new(Consensus)
+	c.observer = true
+	c.init(config)
+	defer c.hooks.flush()
+
+	// rebuild states from write-ahead log
+	if config.WAL != nil {
+		if err := c.replayWAL(config.WAL, config.Epoch); err != nil {
+			return nil, err
+		}
+	}
 	return c, nil
 }
 
@@ -386,15 +643,106 @@ func NewConsensus(config *Config) (*Consensus, error) {
 func (c *Consensus) init(config *Config) {
 	// setting current state & height
 	c.latestHeight = config.CurrentHeight
+	c.latestState = config.CurrentState
 	c.participants = config.Participants
 	c.stateCompare = config.StateCompare
+	c.proposalTieBreak = config.ProposalTieBreak
 	c.stateValidate = config.StateValidate
+	c.stateValidateAt = config.StateValidateAt
 	c.messageValidator = config.MessageValidator
 	c.messageOutCallback = config.MessageOutCallback
-	c.privateKey = config.PrivateKey
+	if !c.observer {
+		c.privateKey = config.PrivateKey
+		c.signer = config.Signer
+		if c.signer == nil {
+			c.signer = privateKeySigner{config.PrivateKey}
+		}
+	}
 	c.pubKeyToIdentity = config.PubKeyToIdentity
 	c.enableCommitUnicast = config.EnableCommitUnicast
-
+	c.rcBaseTimeout = config.RoundChangeBaseTimeout
+	c.rcMaxTimeout = config.RoundChangeMaxTimeout
+	c.onDecide = config.OnDecide
+	c.onBeforeDecide = config.OnBeforeDecide
+	if config.SendRetry != nil {
+		retry := *config.SendRetry
+		c.sendRetry = &retry
+	}
+	c.onStateFinalized = config.OnStateFinalized
+	c.finalizedHeight = config.CurrentHeight
+	c.onBecomeLeader = config.OnBecomeLeader
+	c.qcHistoryDepth = config.QCHistoryDepth
+	if config.EventHistoryDepth > 0 {
+		c.events = newEventHistory(config.EventHistoryDepth)
+	}
+	if config.ParticipantWeights != nil {
+		c.configWeights = config.ParticipantWeights
+		c.weights = normalizeWeights(config.ParticipantWeights)
+	}
+	c.heightStartTime = config.Epoch
+	c.maxMessageSize = config.MaxMessageSize
+	if c.maxMessageSize == 0 {
+		c.maxMessageSize = DefaultMaxMessageSize
+	} else if c.maxMessageSize < 0 {
+		c.maxMessageSize = 0
+	}
+	c.maxFutureHeight = config.MaxFutureHeight
+	c.maxProofsPerMessage = config.MaxProofsPerMessage
+	if c.maxFutureHeight == 0 {
+		c.maxFutureHeight = DefaultMaxFutureHeight
+	}
+	c.pipelineDepth = 1
+	if config.PipelineDepth > 1 {
+		c.pipelineDepth = uint64(config.PipelineDepth)
+	}
+	c.latencyEstimator = config.LatencyEstimator
+	c.stateCodec = config.StateCodec
+	if c.stateCodec == nil {
+		c.stateCodec = RawStateCodec{}
+	}
+	c.hasher = config.Hasher
+	c.protocolVersion = config.ProtocolVersion
+	if c.protocolVersion == 0 {
+		c.protocolVersion = ProtocolVersion
+	}
+	c.compressor = config.Compressor
+	c.compressThreshold = config.CompressThreshold
+	if c.compressThreshold == 0 {
+		c.compressThreshold = DefaultCompressThreshold
+	}
+
+	// duplicated messages filter
+	switch {
+	case config.DedupCacheSize == 0:
+		c.dedup = newDedupCache(DefaultDedupCacheSize)
+	case config.DedupCacheSize > 0:
+		c.dedup = newDedupCache(config.DedupCacheSize)
+	}
+	if config.ReplayWindow > 0 {
+		c.replays = newReplayGuard(config.ReplayWindow)
+	}
+	if config.PerParticipantRateLimit > 0 {
+		burst := config.PerParticipantRateBurst
+		if burst == 0 {
+			burst = DefaultRateLimitBurst
+		}
+		c.limiter = newRateLimiter(config.PerParticipantRateLimit, burst)
+	}
+	if config.ReceiveQueueSize > 0 {
+		c.receiveQueue = newReceiveQueue(config.ReceiveQueueSize)
+	}
+	c.pauseBufferSize = config.PauseBufferSize
+	if c.pauseBufferSize == 0 {
+		c.pauseBufferSize = DefaultPauseBufferSize
+	}
+	c.logger = config.Logger
+	c.tracer = config.Tracer
+	c.scheduler = config.Scheduler
+
+	// if config has not set compare function, use the default
+	if c.stateCompare == nil {
+		c.stateCompare = DefaultStateCompare
+	}
 	// if config has not set hash function, use the default
 	if c.stateHash == nil {
 		c.stateHash = defaultHash
@@ -403,30 +751,61 @@ func (c *Consensus) init(config *Config) {
 	if c.pubKeyToIdentity == nil {
 		c.pubKeyToIdentity = DefaultPubKeyToIdentity
 	}
-	c.identity = c.pubKeyToIdentity(&c.privateKey.PublicKey)
-	c.curve = c.privateKey.Curve
+	// if config has not set logger, discard all logs
+	if c.logger == nil {
+		c.logger = nopLogger{}
+	}
+	// if config has not set scheduler, use the system scheduler
+	if c.scheduler == nil {
+		c.scheduler = timer.SystemTimedSched
+	}
+	if c.observer {
+		// observers have no identity, and verify messages on default curve
+		c.curve = S256Curve
+	} else {
+		c.identity = c.pubKeyToIdentity(c.signer.Public())
+		c.curve = c.signer.Public().Curve
+	}
 
 	// initial default parameters settings
 	c.latency = DefaultConsensusLatency
 
 	// and initiated the first <roundchange> proposal
-	c.switchRound(0)
+	c.switchRound(0, config.Epoch)
 	c.currentRound.Stage = stageRoundChanging
-	c.broadcastRoundChange()
+	c.broadcastRoundChange(config.Epoch)
 	// set rcTimeout to lockTimeout
 	c.rcTimeout = config.Epoch.Add(c.roundchangeDuration(0))
 
 	// count number of individual identites
+	c.numIdentities = countIdentities(c.participants)
+	c.totalWeight = c.sumWeights(c.participants)
+}
+
+// countIdentities counts the number of individual identities in participants
+func countIdentities(participants []Identity) int {
 	ids := make(map[Identity]bool)
-	for _, id := range c.participants {
+	for _, id := range participants {
 		ids[id] = true
 	}
-	c.numIdentities = len(ids)
+	return len(ids)
 }
 
 //  calculates roundchangeDuration
 // 共识的轮次越多，需要超时等待的时间越长
 func (c *Consensus) roundchangeDuration(round uint64) time.Duration {
+	// adaptive timeout from observed latencies
+	if c.latencyEstimator != nil {
+		if base := c.latencyEstimator.Timeout(); base > 0 {
+			return c.roundchangeBackoff(base, round)
+		}
+	}
+
+	// backoff from config
+	if c.rcBaseTimeout > 0 {
+		return c.roundchangeBackoff(c.rcBaseTimeout, round)
+	}
+
 	// 1<<round，表示1右移round次
 	d := 2 * c.latency * (1 << round)
 	if d > MaxConsensusLatency {
@@ -435,6 +814,37 @@ func (c *Consensus) roundchangeDuration(round uint64) time.Duration {
 	return d
 }
 
+// roundchangeBackoff doubles base timeout on each round, capped by
+// Config.RoundChangeMaxTimeout
+func (c *Consensus) roundchangeBackoff(base time.Duration, round uint64) time.Duration {
+	max := c.rcMaxTimeout
+	if max == 0 {
+		max = MaxConsensusLatency
+	}
+
+	// doubling step by step to prevent from overflow
+	d := base
+	for i := uint64(0); i < round && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// observeCommitLatency feeds the round trip time from leader's <lock> to
+// a <commit> from another participant to the latency estimator
+func (c *Consensus) observeCommitLatency(signed *SignedProto, now time.Time) {
+	if c.latencyEstimator == nil || c.currentRound.LockSentTime.IsZero() {
+		return
+	}
+	if c.pubKeyToIdentity(signed.PublicKey(c.curve)) == c.identity {
+		return
+	}
+	c.latencyEstimator.Observe(now.Sub(c.currentRound.LockSentTime))
+}
+
 //  calculates collectDuration
 func (c *Consensus) collectDuration(round uint64) time.Duration {
 	d := 2 * c.latency * (1 << round)
@@ -476,9 +886,10 @@ func (c *Consensus) lockReleaseDuration(round uint64) time.Duration {
 // 取锁定的最大区块hash，⟨lock, h, r, B′, proof⟩i 锁定消息只由leader广播
 func (c *Consensus) maximalLocked() State {
 	if len(c.locks) > 0 {
+		proposers := c.lockProposers()
 		maxState := c.locks[0].Message.State
 		for i := 1; i < len(c.locks); i++ {
-			if c.stateCompare(maxState, c.locks[i].Message.State) < 0 {
+			if c.compareProposals(maxState, c.locks[i].Message.State, proposers, c.identity) < 0 {
 				maxState = c.locks[i].Message.State
 			}
 		}
@@ -487,14 +898,126 @@ func (c *Consensus) maximalLocked() State {
 	return nil
 }
 
+// compareProposals compares states as stateCompare does, and breaks ties of
+// distinct states by ProposalTieBreak, or the identities of proposers, so
+// the maximal state selected won't depend on the order of proposals. The
+// proposers are from proposersOf, states not in it are proposed by owner.
+func (c *Consensus) compareProposals(a State, b State, proposers map[StateHash]Identity, owner Identity) int {
+	if r := c.stateCompare(a, b); r != 0 {
+		return r
+	}
+	if bytes.Equal(a, b) {
+		return 0
+	}
+
+	proposerA, ok := proposers[c.stateHash(a)]
+	if !ok {
+		proposerA = owner
+	}
+	proposerB, ok := proposers[c.stateHash(b)]
+	if !ok {
+		proposerB = owner
+	}
+	if c.proposalTieBreak != nil {
+		return c.proposalTieBreak(
+			ProposalInfo{PublicKey: c.identityToPubKey(proposerA), State: a},
+			ProposalInfo{PublicKey: c.identityToPubKey(proposerB), State: b})
+	}
+	if r := bytes.Compare(proposerB[:], proposerA[:]); r != 0 {
+		return r
+	}
+	return bytes.Compare(a, b)
+}
+
+// proposersOf maps the non-NULL states of <roundchange> messages, keyed by
+// signers, to the lowest identity carrying each. Ties in a <select> or <lock>
+// message are broken by proposers from its own proofs, so every participant
+// agrees on them whatever <roundchange> messages it has received.
+func (c *Consensus) proposersOf(rcs map[Identity]State) map[StateHash]Identity {
+	proposers := make(map[StateHash]Identity)
+	for id, s := range rcs {
+		if s == nil {
+			continue
+		}
+		hash := c.stateHash(s)
+		if proposer, ok := proposers[hash]; !ok || bytes.Compare(id[:], proposer[:]) < 0 {
+			proposers[hash] = id
+		}
+	}
+	return proposers
+}
+
+// roundProposers returns proposers of <roundchange> messages of current
+// round, see consensusRound.Proposers.
+func (c *Consensus) roundProposers() map[StateHash]Identity {
+	if c.currentRound == nil {
+		return nil
+	}
+	return c.currentRound.Proposers()
+}
+
+// lockProposers returns proposers of locked states, each from the proofs of
+// its own <lock> message, kept while the <lock> message was verified.
+func (c *Consensus) lockProposers() map[StateHash]Identity {
+	proposers := make(map[StateHash]Identity)
+	for k := range c.locks {
+		proposer := c.locks[k].Proposer
+		if p, ok := proposers[c.locks[k].StateHash]; !ok || bytes.Compare(proposer[:], p[:]) < 0 {
+			proposers[c.locks[k].StateHash] = proposer
+		}
+	}
+	return proposers
+}
+
+// lockProposer returns the proposer of the state of a verified <lock>
+// message by its proofs, for locks restored without verification.
+func (c *Consensus) lockProposer(m *Message) Identity {
+	rcs := make(map[Identity]State)
+	for _, proof := range m.Proof {
+		mProof, err := DecodeMessage(proof.Message)
+		if err != nil {
+			continue
+		}
+		rcs[c.pubKeyToIdentity(proof.PublicKey(c.curve))] = mProof.State
+	}
+	return c.proposersOf(rcs)[c.stateHash(m.State)]
+}
+
+// maximalQuorumProposed returns the maximal state proposed in <roundchange>
+// messages of current round reaching the quorum, as more than one may reach
+// it when the number of participants isn't 3t+1.
+func (c *Consensus) maximalQuorumProposed() State {
+	weights := make(map[StateHash]int)
+	states := make(map[StateHash]State)
+	for _, t := range c.currentRound.roundChanges {
+		if t.Message.State != nil {
+			weights[t.StateHash] += t.Weight
+			states[t.StateHash] = t.Message.State
+		}
+	}
+
+	var maxState State
+	proposers := c.roundProposers()
+	for hash, weight := range weights {
+		if weight < c.quorum() {
+			continue
+		}
+		if maxState == nil || c.compareProposals(maxState, states[hash], proposers, c.identity) < 0 {
+			maxState = states[hash]
+		}
+	}
+	return maxState
+}
+
 // maximalUnconfirmed finds the maximal unconfirmed data with,
 // regard to the StateCompare function in config.
 // 返回最大未确认区块
 func (c *Consensus) maximalUnconfirmed() State {
 	if len(c.unconfirmed) > 0 {
+		proposers := c.roundProposers()
 		maxState := c.unconfirmed[0]
 		for i := 1; i < len(c.unconfirmed); i++ {
-			if c.stateCompare(maxState, c.unconfirmed[i]) < 0 {
+			if c.compareProposals(maxState, c.unconfirmed[i], proposers, c.identity) < 0 {
 				maxState = c.unconfirmed[i]
 			}
 		}
@@ -509,6 +1032,12 @@ func (c *Consensus) maximalUnconfirmed() State {
 // returns nil and error if message has not been correctly signed or from an unknown participant.
 // 验证签名的message
 func (c *Consensus) verifyMessage(signed *SignedProto) (*Message, error) {
+	return c.verifyMessageBatch(signed, nil)
+}
+
+// verifyMessageBatch verifies message as verifyMessage does, with results of
+// signatures verified by batchVerify.
+func (c *Consensus) verifyMessageBatch(signed *SignedProto, batch map[*SignedProto]bool) (*Message, error) {
 	if signed == nil {
 		return nil, ErrMessageIsEmpty
 	}
@@ -528,6 +1057,11 @@ func (c *Consensus) verifyMessage(signed *SignedProto) (*Message, error) {
 		return nil, ErrMessageUnknownParticipant
 	}
 
+	// reject malleated signatures before verifying
+	if !signed.IsLowS(c.curve) {
+		return nil, ErrMessageSignatureMalleable
+	}
+
 	/*
 		// public key validation
 		p := defaultCurve.Params().P
@@ -542,7 +1076,12 @@ func (c *Consensus) verifyMessage(signed *SignedProto) (*Message, error) {
 	*/
 
 	// as public key is proven , we don't have to verify the public key
-	if !signed.Verify(c.curve) {
+	// signatures verified in batch are not verified again
+	if verified, ok := batch[signed]; ok {
+		if !verified {
+			return nil, ErrMessageSignature
+		}
+	} else if !c.verifySignature(signed) {
 		return nil, ErrMessageSignature
 	}
 
@@ -552,9 +1091,53 @@ func (c *Consensus) verifyMessage(signed *SignedProto) (*Message, error) {
 	if err != nil {
 		return nil, err
 	}
+	if !isCanonicalMessage(m, signed.Message) {
+		return nil, ErrMessageNotCanonical
+	}
+	if err := c.decodeState(m); err != nil {
+		return nil, err
+	}
 	return m, nil
 }
 
+// checkProofSetSize rejects proofs more than allowed in a message, this must
+// be checked before verifying any of the proofs.
+func (c *Consensus) checkProofSetSize(proofs []*SignedProto) error {
+	max := c.maxProofsPerMessage
+	if max == 0 {
+		max = len(c.participants)
+	}
+	if max > 0 && len(proofs) > max {
+		return ErrProofSetTooLarge
+	}
+	return nil
+}
+
+// checkProofSigners rejects proofs with more than one from a participant,
+// which would otherwise be counted as individual proofs toward quorum, this
+// must be checked before verifying any of the proofs.
+func (c *Consensus) checkProofSigners(proofs []*SignedProto) error {
+	type signer struct{ X, Y PubKeyAxis }
+	seen := make(map[signer]struct{}, len(proofs))
+	for _, proof := range proofs {
+		key := signer{proof.X, proof.Y}
+		if _, ok := seen[key]; ok {
+			return ErrProofDuplicateParticipant
+		}
+		seen[key] = struct{}{}
+	}
+	return nil
+}
+
+// validateState validates a state at the given height and round, with
+// StateValidateAt from config if set, or fallback to StateValidate.
+func (c *Consensus) validateState(height uint64, round uint64, s State) bool {
+	if c.stateValidateAt != nil {
+		return c.stateValidateAt(height, round, s)
+	}
+	return c.stateValidate(s)
+}
+
 // verify <roundchange> message
 // 验证 <roundchange> 是否合法
 func (c *Consensus) verifyRoundChangeMessage(m *Message) error {
@@ -572,7 +1155,7 @@ func (c *Consensus) verifyRoundChangeMessage(m *Message) error {
 
 	// state data validation for non-null <roundchange>
 	if m.State != nil {
-		if !c.stateValidate(m.State) {
+		if !c.validateState(m.Height, m.Round, m.State) {
 			return ErrRoundChangeStateValidation
 		}
 	}
@@ -584,63 +1167,80 @@ func (c *Consensus) verifyRoundChangeMessage(m *Message) error {
 // a lock message must contain at least 2t+1 individual <roundchange>
 // messages on B'
 func (c *Consensus) verifyLockMessage(m *Message, signed *SignedProto) error {
+	_, err := c.verifyLockProofs(m, signed)
+	return err
+}
+
+// verifyLockProofs verifies a <lock> message as verifyLockMessage does, and
+// returns the proposer of B' by the proofs for breaking ties of locks.
+func (c *Consensus) verifyLockProofs(m *Message, signed *SignedProto) (Identity, error) {
 	// check message height
 	if m.Height != c.latestHeight+1 {
-		return ErrLockHeightMismatch
+		return Identity{}, ErrLockHeightMismatch
 	}
 
 	// check round in protocol
 	if m.Round < c.currentRound.RoundNumber {
-		return ErrLockRoundLower
+		return Identity{}, ErrLockRoundLower
 	}
 
 	// a <lock> message from leader MUST include data along with the message
 	if m.State == nil {
-		return ErrLockEmptyState
+		return Identity{}, ErrLockEmptyState
 	}
 
 	// state data validation
-	if !c.stateValidate(m.State) {
-		return ErrLockStateValidation
+	if !c.validateState(m.Height, m.Round, m.State) {
+		return Identity{}, ErrLockStateValidation
 	}
 
 	// make sure this message has been signed by the leader
 	leaderKey := c.roundLeader(m.Round)
 	if c.pubKeyToIdentity(signed.PublicKey(c.curve)) != leaderKey {
-		return ErrLockNotSignedByLeader
+		return Identity{}, ErrLockNotSignedByLeader
+	}
+
+	if err := c.checkProofSetSize(m.Proof); err != nil {
+		return Identity{}, err
+	}
+	if err := c.checkProofSigners(m.Proof); err != nil {
+		return Identity{}, err
 	}
+	span := c.startProofsSpan(m.Proof)
+	defer span.End()
 
 	// validate proofs enclosed in the message one by one
 	rcs := make(map[Identity]State)
+	batch := c.batchVerify(m.Proof)
 	for _, proof := range m.Proof {
 		// first we need to verify the signature,and identity of this proof
-		mProof, err := c.verifyMessage(proof)
+		mProof, err := c.verifyMessageBatch(proof, batch)
 		if err != nil {
 			if err == ErrMessageUnknownParticipant {
-				return ErrLockProofUnknownParticipant
+				return Identity{}, ErrLockProofUnknownParticipant
 			}
-			return err
+			return Identity{}, err
 		}
 
 		// then we need to check the message type
 		if mProof.Type != MessageType_RoundChange {
-			return ErrLockProofTypeMismatch
+			return Identity{}, ErrLockProofTypeMismatch
 		}
 
 		// and we also need to check the height & round field,
 		// all <roundchange> messages must be in the same round as the lock message
 		if mProof.Height != m.Height {
-			return ErrLockProofHeightMismatch
+			return Identity{}, ErrLockProofHeightMismatch
 		}
 
 		if mProof.Round != m.Round {
-			return ErrLockProofRoundMismatch
+			return Identity{}, ErrLockProofRoundMismatch
 		}
 
 		// state data validation in proofs
 		if mProof.State != nil {
-			if !c.stateValidate(mProof.State) {
-				return ErrLockProofStateValidation
+			if !c.validateState(mProof.Height, mProof.Round, mProof.State) {
+				return Identity{}, ErrLockProofStateValidation
 			}
 		}
 
@@ -652,40 +1252,60 @@ func (c *Consensus) verifyLockMessage(m *Message, signed *SignedProto) error {
 	// count individual proofs to B', which has already guaranteed to be the maximal one.
 	var numValidateProofs int
 	mHash := c.stateHash(m.State)
-	for _, v := range rcs {
+	for id, v := range rcs {
 		if c.stateHash(v) == mHash { // B'
-			numValidateProofs++
+			numValidateProofs += c.weightOf(id)
 		}
 	}
 
 	// check if valid proofs count is less that 2*t+1
-	if numValidateProofs < 2*c.t()+1 {
-		return ErrLockProofInsufficient
+	if numValidateProofs < c.quorum() {
+		return Identity{}, ErrLockProofInsufficient
 	}
-	return nil
+
+	// other states may reach the quorum too if the number of participants
+	// isn't 3t+1, B' must be the maximal one of them, with ties broken by
+	// the proposers in proofs.
+	weights := make(map[StateHash]int)
+	states := make(map[StateHash]State)
+	for id, v := range rcs {
+		if v != nil {
+			hash := c.stateHash(v)
+			weights[hash] += c.weightOf(id)
+			states[hash] = v
+		}
+	}
+	proposers := c.proposersOf(rcs)
+	for hash, weight := range weights {
+		if weight >= c.quorum() && c.compareProposals(m.State, states[hash], proposers, leaderKey) < 0 {
+			return Identity{}, ErrLockProofNotTheMaximal
+		}
+	}
+	return proposers[mHash], nil
 }
 
 // verifyLockReleaseMessage will verify LockRelease field in a <lock-release> messages,
-// returns the embedded <lock> message if valid
+// returns the embedded <lock> message if valid, along with the proposer of
+// the locked state by its proofs.
 // 在commitTimeout之前，若没有收到 <decide, h, r, B', proof'>i 则进入lock-release
-func (c *Consensus) verifyLockReleaseMessage(signed *SignedProto) (*Message, error) {
+func (c *Consensus) verifyLockReleaseMessage(signed *SignedProto) (*Message, Identity, error) {
 	// not in lock release status, omit this message
 	if c.currentRound.Stage != stageLockRelease {
-		return nil, ErrLockReleaseStatus
+		return nil, Identity{}, ErrLockReleaseStatus
 	}
 
 	// verify and decode the embedded lock message
 	lockmsg, err := c.verifyMessage(signed)
 	if err != nil {
-		return nil, err
+		return nil, Identity{}, err
 	}
 
 	// recursively verify proofs in lock message
-	err = c.verifyLockMessage(lockmsg, signed)
+	proposer, err := c.verifyLockProofs(lockmsg, signed)
 	if err != nil {
-		return nil, err
+		return nil, Identity{}, err
 	}
-	return lockmsg, nil
+	return lockmsg, proposer, nil
 }
 
 // verifySelectMessage verifies proofs from <select> message,
@@ -705,7 +1325,7 @@ func (c *Consensus) verifySelectMessage(m *Message, signed *SignedProto) error {
 
 	// state data validation for non-null <select>
 	if m.State != nil {
-		if !c.stateValidate(m.State) {
+		if !c.validateState(m.Height, m.Round, m.State) {
 			return ErrSelectStateValidation
 		}
 	}
@@ -718,9 +1338,19 @@ func (c *Consensus) verifySelectMessage(m *Message, signed *SignedProto) error {
 		return ErrSelectNotSignedByLeader
 	}
 
+	if err := c.checkProofSetSize(m.Proof); err != nil {
+		return err
+	}
+	if err := c.checkProofSigners(m.Proof); err != nil {
+		return err
+	}
+	span := c.startProofsSpan(m.Proof)
+	defer span.End()
+
 	rcs := make(map[Identity]State)
+	batch := c.batchVerify(m.Proof)
 	for _, proof := range m.Proof {
-		mProof, err := c.verifyMessage(proof)
+		mProof, err := c.verifyMessageBatch(proof, batch)
 		if err != nil {
 			if err == ErrMessageUnknownParticipant {
 				return ErrSelectProofUnknownParticipant
@@ -742,36 +1372,44 @@ func (c *Consensus) verifySelectMessage(m *Message, signed *SignedProto) error {
 
 		// state data validation in proofs
 		if mProof.State != nil {
-			if !c.stateValidate(mProof.State) {
+			if !c.validateState(mProof.Height, mProof.Round, mProof.State) {
 				return ErrSelectProofStateValidation
 			}
 		}
 
-		// we also need to check the B'' selected by leader is the maximal one,
-		// if data has been proposed.
-		// 检查leader选出的确实是最大区块
-		if mProof.State != nil && m.State != nil {
-			if c.stateCompare(m.State, mProof.State) < 0 {
-				return ErrSelectProofNotTheMaximal
-			}
-		}
-
 		// we also stores B'' == NULL for counting
 		// 为了计数<roundchange>的个数
 		rcs[c.pubKeyToIdentity(proof.PublicKey(c.curve))] = mProof.State
 	}
 
+	// we also need to check the B'' selected by leader is the maximal one,
+	// if data has been proposed, ties are broken by the proposers in proofs,
+	// B'' not in proofs is proposed by the leader.
+	// 检查leader选出的确实是最大区块
+	if m.State != nil {
+		proposers := c.proposersOf(rcs)
+		for _, s := range rcs {
+			if s != nil && c.compareProposals(m.State, s, proposers, leaderKey) < 0 {
+				return ErrSelectProofNotTheMaximal
+			}
+		}
+	}
+
 	// check we have at least 2*t+1 proof
-	if len(rcs) < 2*c.t()+1 {
+	var numProofs int
+	for id := range rcs {
+		numProofs += c.weightOf(id)
+	}
+	if numProofs < c.quorum() {
 		return ErrSelectProofInsufficient
 	}
 
 	// count maximum proofs with B' != NULL with identical data hash,
 	// to prevent leader cheating on select.
 	dataProposals := make(map[StateHash]int)
-	for _, data := range rcs {
+	for id, data := range rcs {
 		if data != nil {
-			dataProposals[c.stateHash(data)]++
+			dataProposals[c.stateHash(data)] += c.weightOf(id)
 		}
 	}
 
@@ -793,7 +1431,7 @@ func (c *Consensus) verifySelectMessage(m *Message, signed *SignedProto) error {
 	// if these are more than 2*t+1 valid <roundchange> proofs to B',
 	// this also suggests that the leader may cheat.
 	// 检查leader的select proof中，确实不会有locked state（一个state，拥有的proof超过2t+1）
-	if maxProposed >= 2*c.t()+1 {
+	if maxProposed >= c.quorum() {
 		return ErrSelectProofExceeded
 	}
 
@@ -814,7 +1452,7 @@ func (c *Consensus) verifyCommitMessage(m *Message) error {
 	}
 
 	// state data validation
-	if !c.stateValidate(m.State) {
+	if !c.validateState(m.Height, m.Round, m.State) {
 		return ErrCommitStateValidation
 	}
 
@@ -841,6 +1479,10 @@ func (c *Consensus) verifyCommitMessage(m *Message) error {
 // the targetState is to compare the target state enclosed in decide message
 // 840~974行，主要包含了对<decide, h, r, B', proof> 的验证
 func (c *Consensus) ValidateDecideMessage(bts []byte, targetState []byte) error {
+	if c.closed {
+		return ErrConsensusClosed
+	}
+
 	signed, err := DecodeSignedMessage(bts)
 	if err != nil {
 		return err
@@ -873,30 +1515,30 @@ func DecodeMessage(bts []byte) (*Message, error) {
 // the consensus core must be correctly initialized to validate.
 func (c *Consensus) validateDecideMessage(signed *SignedProto, targetState []byte) error {
 	// check message version
-	if signed.Version != ProtocolVersion {
-		return ErrMessageVersion
+	if err := c.checkVersion(signed); err != nil {
+		return err
 	}
 
 	// check message signature & qualifications
 	m, err := c.verifyMessage(signed)
 	if err != nil {
-		return err
+		return fmt.Errorf("verifying message from %x: %w", signed.X, err)
 	}
 
 	// compare state
 	if !bytes.Equal(m.State, targetState) {
-		return ErrMismatchedTargetState
+		return verifyError(m, signed, ErrMismatchedTargetState)
 	}
 
 	// verify decide message
 	if m.Type == MessageType_Decide {
 		err := c.verifyDecideMessage(m, signed)
 		if err != nil {
-			return err
+			return verifyError(m, signed, err)
 		}
 		return nil
 	}
-	return ErrMessageUnknownMessageType
+	return verifyError(m, signed, ErrMessageUnknownMessageType)
 }
 
 // verifyDecideMessage verifies proofs from <decide> message, which MUST
@@ -908,7 +1550,7 @@ func (c *Consensus) verifyDecideMessage(m *Message, signed *SignedProto) error {
 	}
 
 	// state data validation
-	if !c.stateValidate(m.State) {
+	if !c.validateState(m.Height, m.Round, m.State) {
 		return ErrDecideStateValidation
 	}
 
@@ -923,63 +1565,88 @@ func (c *Consensus) verifyDecideMessage(m *Message, signed *SignedProto) error {
 		return ErrDecideNotSignedByLeader
 	}
 
+	numValidateProofs, err := c.countCommitProofs(m.Height, m.Round, m.Proof, m.State, true)
+	if err != nil {
+		return err
+	}
+
+	// check to see if the message has at least 2*t+1 <commit> valid proofs,
+	// if not, the leader may cheat.
+	if numValidateProofs < c.quorum() {
+		return ErrDecideProofInsufficient
+	}
+	return nil
+}
+
+// countCommitProofs verifies the <commit> proofs at the given height and round,
+// and returns the number of individual participants committed to s. If distinct
+// is set, proofs must be from distinct participants as in a <decide> message,
+// otherwise proofs from the same participant count once.
+func (c *Consensus) countCommitProofs(height uint64, round uint64, proofs []*SignedProto, s State, distinct bool) (int, error) {
+	if err := c.checkProofSetSize(proofs); err != nil {
+		return 0, err
+	}
+	if distinct {
+		if err := c.checkProofSigners(proofs); err != nil {
+			return 0, err
+		}
+	}
+	span := c.startProofsSpan(proofs)
+	defer span.End()
+
 	commits := make(map[Identity]State)
-	for _, proof := range m.Proof {
-		mProof, err := c.verifyMessage(proof)
+	batch := c.batchVerify(proofs)
+	for _, proof := range proofs {
+		mProof, err := c.verifyMessageBatch(proof, batch)
 		if err != nil {
 			if err == ErrMessageUnknownParticipant {
-				return ErrDecideProofUnknownParticipant
+				return 0, ErrDecideProofUnknownParticipant
 			}
-			return err
+			return 0, err
 		}
 
 		if mProof.Type != MessageType_Commit {
-			return ErrDecideProofTypeMismatch
+			return 0, ErrDecideProofTypeMismatch
 		}
 
-		if mProof.Height != m.Height {
-			return ErrDecideProofHeightMismatch
+		if mProof.Height != height {
+			return 0, ErrDecideProofHeightMismatch
 		}
 
-		if mProof.Round != m.Round {
-			return ErrDecideProofRoundMismatch
+		if mProof.Round != round {
+			return 0, ErrDecideProofRoundMismatch
 		}
 
-		if !c.stateValidate(mProof.State) {
-			return ErrDecideProofStateValidation
+		if !c.validateState(mProof.Height, mProof.Round, mProof.State) {
+			return 0, ErrDecideProofStateValidation
 		}
 
 		// state data validation in proofs
 		if mProof.State != nil {
-			if !c.stateValidate(mProof.State) {
-				return ErrSelectProofStateValidation
+			if !c.validateState(mProof.Height, mProof.Round, mProof.State) {
+				return 0, ErrSelectProofStateValidation
 			}
 		}
 
 		commits[c.pubKeyToIdentity(proof.PublicKey(c.curve))] = mProof.State
 	}
 
-	// count proofs to m.State
+	// count proofs to s
 	var numValidateProofs int
-	mHash := c.stateHash(m.State)
-	for _, v := range commits {
+	mHash := c.stateHash(s)
+	for id, v := range commits {
 		if c.stateHash(v) == mHash {
-			numValidateProofs++
+			numValidateProofs += c.weightOf(id)
 		}
 	}
 
-	// check to see if the message has at least 2*t+1 <commit> valid proofs,
-	// if not, the leader may cheat.
-	if numValidateProofs < 2*c.t()+1 {
-		return ErrDecideProofInsufficient
-	}
-	return nil
+	return numValidateProofs, nil
 }
 
 // broadcastRoundChange will broadcast <roundchange> messages on
 // current round, taking the maximal B' from unconfirmed data.
 // 广播<roundchange, h, r, B'>j
-func (c *Consensus) broadcastRoundChange() {
+func (c *Consensus) broadcastRoundChange(now time.Time) {
 	// if <roundchange> has sent in this round,
 	// then just ignore. But if we are in roundchanging state,
 	// we should send repeatedly, for boostrap process.
@@ -1007,7 +1674,7 @@ func (c *Consensus) broadcastRoundChange() {
 	m.Height = c.latestHeight + 1
 	m.Round = c.currentRound.RoundNumber
 	m.State = data
-	c.broadcast(&m)
+	c.broadcast(&m, now)
 	c.currentRound.RoundChangeSent = true
 	//log.Println("broadcast:<roundchange>")
 }
@@ -1016,59 +1683,59 @@ func (c *Consensus) broadcastRoundChange() {
 // the currentRound should have a chosen data in this round.
 // 按论文的理解https://eprint.iacr.org/2019/1460.pdf 第十三页，<lock, h, r, B', proof>i 由leader广播，
 // 广播该message的条件是该轮次的B' <roundchange> 个数至少是 2t+1 个
-func (c *Consensus) broadcastLock() {
+func (c *Consensus) broadcastLock(now time.Time) {
 	var m Message
 	m.Type = MessageType_Lock
 	m.Height = c.latestHeight + 1
 	m.Round = c.currentRound.RoundNumber
 	m.State = c.currentRound.LockedState
 	m.Proof = c.currentRound.SignedRoundChanges()
-	c.broadcast(&m)
+	c.broadcast(&m, now)
 	//log.Println("broadcast:<lock>")
 }
 
 // broadcastLockRelease will broadcast <lock-release> messages,
 // Pj 在commitTimeout之前未收到 <decide> (可能从leader节点广播获得，或邻居节点获得)，则进入 lock-release，即表示
 // 即将把已locked的B'释放掉进入unconfirmed，重新在下一轮次确认；若Pj收到有关
-func (c *Consensus) broadcastLockRelease(signed *SignedProto) {
+func (c *Consensus) broadcastLockRelease(signed *SignedProto, now time.Time) {
 	var m Message
 	m.Type = MessageType_LockRelease
 	m.Height = c.latestHeight + 1
 	m.Round = c.currentRound.RoundNumber
 	m.LockRelease = signed
-	c.broadcast(&m)
+	c.broadcast(&m, now)
 	//log.Println("broadcast:<lock-release>")
 }
 
 // broadcastSelect will broadcast a <select> message by the leader,
 // from current round with <roundchange> proofs.
-func (c *Consensus) broadcastSelect() {
+func (c *Consensus) broadcastSelect(now time.Time) {
 	var m Message
 	m.Type = MessageType_Select
 	m.Height = c.latestHeight + 1
 	m.Round = c.currentRound.RoundNumber
 	m.State = c.maximalUnconfirmed() // B' may be NULL
 	m.Proof = c.currentRound.SignedRoundChanges()
-	c.broadcast(&m)
+	c.broadcast(&m, now)
 	//log.Println("broadcast:<select>", m.State)
 }
 
 // broadcastDecide will broadcast a <decide> message by the leader,
 // from current round with <commit> proofs.
-func (c *Consensus) broadcastDecide() *SignedProto {
+func (c *Consensus) broadcastDecide(now time.Time) *SignedProto {
 	var m Message
 	m.Type = MessageType_Decide
 	m.Height = c.latestHeight + 1
 	m.Round = c.currentRound.RoundNumber
 	m.State = c.currentRound.LockedState
 	m.Proof = c.currentRound.SignedCommits()
-	return c.broadcast(&m)
+	return c.broadcast(&m, now)
 	//log.Println("broadcast:<decide>")
 }
 
 // broadcastResync will broadcast a <resync> message by the leader,
 // from current round with <roundchange> proofs.
-func (c *Consensus) broadcastResync() {
+func (c *Consensus) broadcastResync(now time.Time) {
 	if c.lastRoundChangeProof == nil {
 		return
 	}
@@ -1077,13 +1744,13 @@ func (c *Consensus) broadcastResync() {
 	m.Type = MessageType_Resync
 	// we only care about <roundchange> messages in resync
 	m.Proof = c.lastRoundChangeProof
-	c.broadcast(&m)
+	c.broadcast(&m, now)
 	//log.Println("broadcast:<resync>")
 }
 
 // sendCommit will send a <commit> message by participants to the leader
 // from received <lock> message.
-func (c *Consensus) sendCommit(msgLock *Message) {
+func (c *Consensus) sendCommit(msgLock *Message, now time.Time) {
 	if c.currentRound.CommitSent {
 		return
 	}
@@ -1094,21 +1761,32 @@ func (c *Consensus) sendCommit(msgLock *Message) {
 	m.Round = msgLock.Round   // r
 	m.State = msgLock.State   // B'j
 	if c.enableCommitUnicast {
-		c.sendTo(&m, c.roundLeader(m.Round))
+		c.sendTo(&m, c.roundLeader(m.Round), now)
 	} else {
-		c.broadcast(&m)
+		c.broadcast(&m, now)
 	}
 	c.currentRound.CommitSent = true
+
+	// the following heights start to exchange <roundchange> while committing
+	c.announcePipeline(now)
 	//log.Println("send:<commit>")
 }
 
 // broadcast signs the message with private key before broadcasting to all peers.
-func (c *Consensus) broadcast(m *Message) *SignedProto {
+func (c *Consensus) broadcast(m *Message, now time.Time) *SignedProto {
+	// observers never sign
+	if c.observer {
+		return nil
+	}
+
 	// sign
 	sp := new(SignedProto)
-	sp.Version = ProtocolVersion
+	sp.Version = c.protocolVersion
 	// 对message签名，签名结果放在sp，广播的是sp
-	sp.Sign(m, c.privateKey)
+	if err := sp.SignWithHasher(c.encodeState(m), c.signer, c.hasher); err != nil {
+		c.logger.Warnf("signing <%v> message: %v", m.Type, err)
+		return nil
+	}
 
 	// message callback
 	if c.messageOutCallback != nil {
@@ -1121,21 +1799,30 @@ func (c *Consensus) broadcast(m *Message) *SignedProto {
 	}
 
 	// send to peers one by one
+	frame := c.encodeFrame(out)
 	for _, peer := range c.peers {
-		_ = peer.Send(out)
+		c.sendPeer(peer, frame, now)
 	}
 
 	// we also need to send this message to myself
-	c.loopback = append(c.loopback, out)
+	c.loopbackSelf(out)
 	return sp
 }
 
 // sendTo signs the message with private key before transmitting to the peer.
-func (c *Consensus) sendTo(m *Message, leader Identity) {
+func (c *Consensus) sendTo(m *Message, leader Identity, now time.Time) {
+	// observers never sign
+	if c.observer {
+		return
+	}
+
 	// sign
 	sp := new(SignedProto)
-	sp.Version = ProtocolVersion
-	sp.Sign(m, c.privateKey)
+	sp.Version = c.protocolVersion
+	if err := sp.SignWithHasher(c.encodeState(m), c.signer, c.hasher); err != nil {
+		c.logger.Warnf("signing <%v> message: %v", m.Type, err)
+		return
+	}
 
 	// message callback
 	if c.messageOutCallback != nil {
@@ -1150,27 +1837,65 @@ func (c *Consensus) sendTo(m *Message, leader Identity) {
 
 	// we need to send this message to myself (via loopback) if i'm the leader
 	if leader == c.identity {
-		c.loopback = append(c.loopback, out)
+		c.loopbackSelf(out)
 		return
 	}
 
 	// otherwise, find and transmit to the leader
+	frame := c.encodeFrame(out)
 	for _, peer := range c.peers {
 		if pk := peer.GetPublicKey(); pk != nil {
 			coord := c.pubKeyToIdentity(pk)
 			if coord == leader {
 				// we do not return here to avoid missing re-connected peer.
-				peer.Send(out)
+				c.sendPeer(peer, frame, now)
 			}
 		}
 	}
 }
 
+// loopbackSelf sends a message just signed by myself to myself, the hash
+// is remembered to skip verifying my own signature when processed.
+func (c *Consensus) loopbackSelf(out []byte) {
+	if c.selfSigned == nil {
+		c.selfSigned = make(map[[blake2b.Size256]byte]int)
+	}
+	c.selfSigned[c.messageKey(out)]++
+	c.loopback = append(c.loopback, out)
+}
+
+// takeSelfSigned checks if key is the hash of a message sent by loopbackSelf,
+// and forgets it, so a message signed by myself is trusted once per sending.
+// Hashes of messages dropped from loopback are forgotten at the next height.
+func (c *Consensus) takeSelfSigned(key [blake2b.Size256]byte) bool {
+	n, ok := c.selfSigned[key]
+	if !ok {
+		return false
+	}
+	if n > 1 {
+		c.selfSigned[key] = n - 1
+	} else {
+		delete(c.selfSigned, key)
+	}
+	return true
+}
+
 // propagate broadcasts signed message UNCHANGED to peers.
-func (c *Consensus) propagate(bts []byte) {
+func (c *Consensus) propagate(bts []byte, now time.Time) {
 	// send to peers one by one
+	frame := c.encodeFrame(bts)
 	for _, peer := range c.peers {
-		_ = peer.Send(bts)
+		c.sendPeer(peer, frame, now)
+	}
+}
+
+// sendPeer hands a frame to the peer, and accounts the frame as sent
+// regardless of the result, as the peer owns delivery from now on.
+func (c *Consensus) sendPeer(peer PeerInterface, frame []byte, now time.Time) {
+	c.numMessagesSent++
+	c.numBytesSent += uint64(len(frame))
+	if err := peer.Send(frame); err != nil {
+		c.retrySend(peer, frame, 1, err, now)
 	}
 }
 
@@ -1210,7 +1935,7 @@ func (c *Consensus) getRound(idx uint64, purgeLower bool) *consensusRound {
 // lockRelease updates locks while entering lock-release status
 // and will broadcast its max B' if there is any.
 // <lock-release, h, r, <lock, h, r1, B', proof>>
-func (c *Consensus) lockRelease() {
+func (c *Consensus) lockRelease(now time.Time) {
 	// only keep the locked B' with the max round number
 	// while switching to lock-release status
 	// 广播roundNumber 最大的区块B'
@@ -1222,14 +1947,22 @@ func (c *Consensus) lockRelease() {
 			}
 		}
 		c.locks = []messageTuple{max}
-		c.broadcastLockRelease(max.Signed)
+		c.broadcastLockRelease(max.Signed, now)
 	}
 }
 
 // switchRound sets currentRound to the given idx, and creates new a consensusRound
 // if it's not been initialized.
 // and all lower rounds will be cleared while switching.
-func (c *Consensus) switchRound(round uint64) { c.currentRound = c.getRound(round, true) }
+// the round start time will be set to now if round has changed.
+func (c *Consensus) switchRound(round uint64, now time.Time) {
+	if c.currentRound == nil || c.currentRound.RoundNumber != round {
+		c.roundStartTime = now
+		c.recordEvent(Event{Type: EventRoundChange, Height: c.latestHeight + 1, Round: round})
+		c.notifyBecomeLeader(c.latestHeight+1, round)
+	}
+	c.currentRound = c.getRound(round, true)
+}
 
 // roundLeader returns leader's identity for a given round
 // r%n 从participant队列取leader
@@ -1242,46 +1975,316 @@ func (c *Consensus) roundLeader(round uint64) Identity {
 	return c.participants[int(round)%len(c.participants)]
 }
 
-// heightSync changes current height to the given height with state
-// resets all fields to this new height.
-// 进入下一个区块高度
-func (c *Consensus) heightSync(height uint64, round uint64, s State, now time.Time) {
+// notifyBecomeLeader calls the OnBecomeLeader hook if I'm the leader of the
+// given round, once for each height and round.
+func (c *Consensus) notifyBecomeLeader(height uint64, round uint64) {
+	if c.onBecomeLeader == nil || c.observer || c.roundLeader(round) != c.identity {
+		return
+	}
+
+	if c.leaderNotified && c.leaderNotifiedHeight == height && c.leaderNotifiedRound == round {
+		return
+	}
+	c.leaderNotified = true
+	c.leaderNotifiedHeight = height
+	c.leaderNotifiedRound = round
+
+	// the hook is queued as OnDecide is, in the order raised
+	c.hooks.push(func() { c.onBecomeLeader(height, round) })
+}
+
+// vetoDecide consults Config.OnBeforeDecide before a decided state is
+// finalized, an error wrapping ErrDecideVetoed is returned if it's vetoed.
+func (c *Consensus) vetoDecide(height uint64, round uint64, s State) error {
+	if c.onBeforeDecide == nil {
+		return nil
+	}
+	if err := c.onBeforeDecide(height, round, s); err != nil {
+		return fmt.Errorf("%w: %v", ErrDecideVetoed, err)
+	}
+	return nil
+}
+
+// heightSync changes current height to the given height with state
+// resets all fields to this new height.
+// 进入下一个区块高度
+func (c *Consensus) heightSync(height uint64, round uint64, s State, now time.Time) {
+	span := c.startSpan(SpanDecide)
+	defer span.End()
+	if c.tracer != nil {
+		span.SetAttribute("height", height)
+		span.SetAttribute("round", round)
+	}
+
+	// NOTE: the decided state MUST be committed before any notification,
+	// so CurrentState returns at least the decided height once notified.
+	duration := now.Sub(c.heightStartTime)
+	c.resetStates(height, round, s, now)
+	c.retainQC(height, round, s, c.latestProof)
+
+	// the hook is queued to run when the call to consensus returns, heights
+	// are reported in order.
+	if c.onDecide != nil {
+		c.hooks.push(func() { c.onDecide(height, round+1, duration) })
+	}
+	c.notifyFinalized(height, round, s, c.latestProof)
+
+	// deliver results of proposals
+	c.resolveProposals(height, round, s)
+
+	// notify subscribers of the decided height
+	c.notifyDecide(DecideEvent{Height: height, Round: round, State: s, Proof: c.latestProof})
+}
+
+// resetStates sets the latest confirmed height, round and state, and resets
+// all fields of current height to start a new round at the next height.
+func (c *Consensus) resetStates(height uint64, round uint64, s State, now time.Time) {
 	c.latestHeight = height // set height
 	c.latestRound = round   // set round
 	c.latestState = s       // set state
 
+	// next height begins
+	c.heightStartTime = now
+
+	// forget messages sliding out of the replay window
+	if c.replays != nil {
+		c.replays.Prune(height)
+	}
+
+	// apply participants queued by UpdateParticipants at height boundary
+	if c.pendingParticipants != nil {
+		c.participants = c.pendingParticipants
+		c.numIdentities = countIdentities(c.participants)
+		c.totalWeight = c.sumWeights(c.participants)
+		c.pendingParticipants = nil
+	}
+
 	c.currentRound = nil         // clean current round pointer
 	c.lastRoundChangeProof = nil // clean round change proof
 	c.rounds.Init()              // clean all round
 	c.locks = nil                // clean locks
+	c.leaderLocks = nil          // clean leader's <lock> messages
 	c.unconfirmed = nil          // clean all unconfirmed states from previous heights
-	c.switchRound(0)             // start new round at new height
+	c.selfSigned = nil           // forget my messages of previous heights
+	c.switchRound(0, now)        // start new round at new height
 	c.currentRound.Stage = stageRoundChanging
+	c.promotePipeline() // move pipelined states and messages to the new height
 }
 
-// t calculates (n-1)/3
-func (c *Consensus) t() int { return (c.numIdentities - 1) / 3 }
+// Reset restarts consensus from a known state at the given height, such as
+// after chain reorgs or snapshot sync, all buffered messages, rounds, locks
+// and unconfirmed states are discarded and a new <roundchange> is started
+// at height+1 with timeouts from now. Pending proposals are resolved as if
+// state has been decided at height.
+//
+// Reset cannot be called while a <decide> is being processed, e.g. from
+// Config.MessageOutCallback, ErrResetWhileDeciding will be returned.
+func (c *Consensus) Reset(height uint64, state State, now time.Time) error {
+	defer c.hooks.flush()
+	if c.closed {
+		return ErrConsensusClosed
+	}
+
+	if c.deciding {
+		return ErrResetWhileDeciding
+	}
+
+	c.loopback = nil
+	c.selfSigned = nil
+	c.latestProof = nil
+	c.pipelinedStates = nil
+	c.pipelinedMessages = nil
+	c.pipelineAnnounced = 0
+	if c.dedup != nil {
+		c.dedup = newDedupCache(c.dedup.size)
+	}
+	if c.replays != nil {
+		c.replays = newReplayGuard(c.replays.window)
+	}
+	c.resetStates(height, 0, state, now)
+	c.resolveProposals(height, 0, state)
+	c.rcTimeout = now.Add(c.roundchangeDuration(0))
+	c.broadcastRoundChange(now)
+	return nil
+}
+
+// ForceRoundChange moves to the next round immediately as if the timeouts of
+// the current round have fired, and broadcasts <roundchange> for the next
+// round with timeouts from now, it's a manual liveness nudge for a round
+// hung by a faulty leader.
+//
+// The round cannot be forced while the node has entered the commit stage or
+// a <decide> is being processed, ErrForceRoundChangeCommit will be returned,
+// as the locked state must be released by the protocol.
+func (c *Consensus) ForceRoundChange(now time.Time) error {
+	defer c.hooks.flush()
+	if c.closed {
+		return ErrConsensusClosed
+	}
+
+	if c.observer {
+		return ErrObserverCannotRoundChange
+	}
+
+	if c.deciding || c.currentRound.Stage == stageCommit {
+		return ErrForceRoundChangeCommit
+	}
+
+	// broadcast the locked B' as lock-release stage does
+	if c.currentRound.Stage != stageLockRelease {
+		c.lockRelease(now)
+	}
+	c.currentRound.Stage = stageRoundChanging
+	c.switchRound(c.currentRound.RoundNumber+1, now)
+	c.broadcastRoundChange(now)
+	c.rcTimeout = now.Add(c.roundchangeDuration(c.currentRound.RoundNumber))
+	return nil
+}
+
+// quorum calculates 2t+1 of individual participants, or the minimum weight
+// more than 2/3 of the total weight if weights have set.
+func (c *Consensus) quorum() int {
+	if c.weights != nil {
+		return weightedQuorumSize(c.totalWeight)
+	}
+	return quorumSize(c.numIdentities)
+}
+
+// weightedQuorumSize calculates floor(2W/3)+1, the minimum weight more than
+// 2/3 of the total weight W, so any two quorums overlap in more than 1/3 of
+// the total weight. It's used only for unequal weights, as 2t+1 exceeds
+// 2/3 only if W = 3t+1, while the total of normalized weights is arbitrary.
+func weightedQuorumSize(total int) int { return int(2*int64(total)/3) + 1 }
+
+// maxFaulty calculates t = (n-1)/3, the maximum faulty participants tolerated
+func maxFaulty(n int) int { return (n - 1) / 3 }
+
+// quorumSize calculates 2t+1, the minimum participants to make progress
+func quorumSize(n int) int { return 2*maxFaulty(n) + 1 }
+
+// MaxFaulty returns the maximum number of faulty participants t = (n-1)/3
+// tolerated by n participants, 0 will be returned if n is less than
+// ConfigMinimumParticipants.
+func MaxFaulty(n int) int {
+	if n < ConfigMinimumParticipants {
+		return 0
+	}
+	return maxFaulty(n)
+}
+
+// QuorumSize returns the quorum 2t+1 for n participants, which is the number
+// of individual messages required to proceed in consensus, 0 will be returned
+// if n is less than ConfigMinimumParticipants.
+func QuorumSize(n int) int {
+	if n < ConfigMinimumParticipants {
+		return 0
+	}
+	return quorumSize(n)
+}
 
 // Propose adds a new state to unconfirmed queue to particpate in
 // consensus at next height.
 // 参与这次共识的区块
-func (c *Consensus) Propose(s State) {
+//
+// States proposed to a closed, paused or observer consensus are dropped,
+// use TryPropose to learn whether the state has been accepted.
+func (c *Consensus) Propose(s State) { c.TryPropose(s) }
+
+// TryPropose adds a new state to unconfirmed queue as Propose does, and
+// returns ErrConsensusClosed, ErrConsensusPaused or ErrObserverCannotPropose
+// if the state cannot be proposed.
+func (c *Consensus) TryPropose(s State) error {
+	if c.closed {
+		return ErrConsensusClosed
+	}
+
+	if c.paused {
+		return ErrConsensusPaused
+	}
+
+	if c.observer {
+		return ErrObserverCannotPropose
+	}
+
 	if s == nil {
-		return
+		return nil
 	}
 
 	sHash := c.stateHash(s)
 	for k := range c.unconfirmed {
 		if c.stateHash(c.unconfirmed[k]) == sHash {
-			return
+			return nil
 		}
 	}
 	c.unconfirmed = append(c.unconfirmed, s)
+	return nil
 }
 
 // ReceiveMessage processes incoming consensus messages, and returns error
-// if message cannot be processed for some reason.
-func (c *Consensus) ReceiveMessage(bts []byte, now time.Time) (err error) {
+// if message cannot be processed for some reason. If Config.ReceiveQueueSize
+// has set, decodable messages are queued and verified in Update instead, and
+// the errors are reported to the logger and rejection subscribers only.
+func (c *Consensus) ReceiveMessage(bts []byte, now time.Time) error {
+	defer c.hooks.flush()
+	return c.receiveFrame(bts, now, false)
+}
+
+// receiveFrame is the entry of messages on wire for ReceiveMessage and
+// ImportProof, the frame is checked for size and decoded before the message
+// being processed. Imported messages are processed in place instead of being
+// buffered or queued, and only <lock> and <select> messages are accepted.
+func (c *Consensus) receiveFrame(bts []byte, now time.Time, imported bool) error {
+	if c.closed {
+		return ErrConsensusClosed
+	}
+
+	if err := c.checkMessageSize(bts); err != nil {
+		c.logger.Warnf("message rejected: %v", err)
+		c.notifyRejection(nil, err)
+		return err
+	}
+
+	if c.paused {
+		if imported {
+			return ErrConsensusPaused
+		}
+		return c.bufferPaused(bts)
+	}
+
+	bts, err := c.decodeFrame(bts)
+	if err != nil {
+		c.logger.Warnf("message rejected: %v", err)
+		c.notifyRejection(nil, err)
+		return err
+	}
+
+	if imported {
+		if err := checkImportType(bts); err != nil {
+			c.notifyRejection(bts, err)
+			return err
+		}
+	} else if c.receiveQueue != nil && c.enqueueMessage(bts) {
+		return nil
+	}
+
+	err = c.receive(bts, now)
+	if err != nil {
+		c.notifyRejection(bts, err)
+	}
+	return err
+}
+
+// checkMessageSize rejects oversized messages before any decoding
+func (c *Consensus) checkMessageSize(bts []byte) error {
+	if c.maxMessageSize > 0 && len(bts) > c.maxMessageSize {
+		return ErrMessageTooLarge
+	}
+	return nil
+}
+
+// receive processes a decoded message along with messages directed to
+// myself queued while processing.
+func (c *Consensus) receive(bts []byte, now time.Time) (err error) {
 	// messages broadcasted to myself may be queued recursively, and
 	// we only process these messages in defer to avoid side effects
 	// while processing.
@@ -1290,48 +2293,130 @@ func (c *Consensus) ReceiveMessage(bts []byte, now time.Time) (err error) {
 			bts := c.loopback[0]
 			c.loopback = c.loopback[1:]
 			// NOTE: message directed to myself ignores error.
-			_ = c.receiveMessage(bts, now)
+			if err := c.receiveMessage(bts, now); err != nil {
+				c.logger.Debugf("loopback message rejected: %v", err)
+			}
 		}
 	}()
 
-	return c.receiveMessage(bts, now)
+	err = c.receiveMessage(bts, now)
+	if err != nil {
+		c.logger.Warnf("message rejected: %v", err)
+	}
+	return err
 }
 
-func (c *Consensus) receiveMessage(bts []byte, now time.Time) error {
-	// unmarshal signed message
-	signed := new(SignedProto)
-	err := proto.Unmarshal(bts, signed)
-	if err != nil {
-		return err
+// messageKey hashes a message on wire for dedup, with Config.Hasher if set,
+// digests are truncated or zero padded to the key size.
+func (c *Consensus) messageKey(bts []byte) (key [blake2b.Size256]byte) {
+	if c.hasher == nil {
+		return blake2b.Sum256(bts)
 	}
+	h := c.hasher()
+	h.Write(bts)
+	copy(key[:], h.Sum(nil))
+	return key
+}
 
-	// check message version
-	if signed.Version != ProtocolVersion {
-		return ErrMessageVersion
+func (c *Consensus) receiveMessage(bts []byte, now time.Time) (err error) {
+	// short-circuit messages which have been processed successfully,
+	// rejected messages are not remembered as they may become valid later.
+	var key [blake2b.Size256]byte
+	if c.dedup != nil || c.replays != nil || len(c.selfSigned) > 0 {
+		key = c.messageKey(bts)
 	}
+	selfSigned := len(c.selfSigned) > 0 && c.takeSelfSigned(key)
 
-	// check message signature & qualifications
-	m, err := c.verifyMessage(signed)
+	// exact replays of accepted messages are rejected explicitly
+	if c.replays != nil && c.replays.Seen(key) {
+		return ErrMessageReplay
+	}
+
+	if c.dedup != nil && c.dedup.Seen(key) {
+		return nil
+	}
+
+	var signed *SignedProto
+	var m *Message
+	var pipelined bool
+	span := c.startSpan(SpanReceiveMessage)
+	defer func() {
+		if c.tracer != nil {
+			if m != nil {
+				span.SetAttribute("type", m.Type.String())
+				span.SetAttribute("height", m.Height)
+				span.SetAttribute("round", m.Round)
+			}
+			if err != nil {
+				span.SetAttribute("error", err.Error())
+			}
+		}
+		span.End()
+	}()
+
+	// NOTE: pipelined messages are remembered once replayed at their height
+	defer func() {
+		if err == nil && !pipelined {
+			if c.dedup != nil {
+				c.dedup.Add(key)
+			}
+			if c.replays != nil {
+				c.replays.Add(m.Height, key)
+			}
+			// persist accepted messages
+			c.appendWAL(bts)
+		}
+	}()
+
+	if err := c.rejectEarly(bts); err != nil {
+		return err
+	}
+
+	verifySpan := c.startSpan(SpanVerifyMessage)
+	signed, m, err = c.decodeMessageSigned(bts, selfSigned)
+	verifySpan.End()
 	if err != nil {
 		return err
 	}
 
+	// throttle participants sending too many messages
+	if c.limiter != nil {
+		if signer := c.pubKeyToIdentity(signed.PublicKey(c.curve)); signer != c.identity && !c.limiter.Allow(signer, now) {
+			return verifyError(m, signed, ErrMessageRateLimited)
+		}
+	}
+
 	// callback for incoming message
 	if c.messageValidator != nil {
 		if !c.messageValidator(c, m, signed) {
-			return ErrMessageValidator
+			return verifyError(m, signed, ErrMessageValidator)
 		}
 	}
 
+	// observers only follow the decided states
+	if c.observer && m.Type != MessageType_Decide {
+		return nil
+	}
+
+	messageSpan := c.startMessageSpan(m.Type)
+	defer messageSpan.End()
+
 	// message switch
 	switch m.Type {
 	case MessageType_Nop:
 		// nop does nothing
 		return nil
 	case MessageType_RoundChange:
+		// <roundchange> messages for heights beyond the next height are kept
+		// in pipelined mode, and replayed once the preceding height decided.
+		if c.pipelineRoundChange(m, signed, bts) {
+			pipelined = true
+			return nil
+		}
+
 		err := c.verifyRoundChangeMessage(m)
 		if err != nil {
-			return err
+			return verifyError(m, signed, err)
 		}
 
 		// for <roundchange> message, we need to find in each round
@@ -1393,16 +2478,20 @@ func (c *Consensus) receiveMessage(bts []byte, now time.Time) error {
 			// more to reset timeout.
 			// 为什么这里大于等于 2t+1
 			// round.Stage < stageLock处理得巧妙
-			if round.NumRoundChanges() == 2*c.t()+1 && round.Stage < stageLock {
+			//
+			// NOTE: with weighted participants, the quorum may be exceeded by a single
+			// message, so we check the message makes the round cross the quorum.
+			weight := round.RoundChangeWeight()
+			if weight >= c.quorum() && weight-c.signerWeight(signed) < c.quorum() && round.Stage < stageLock {
 				// switch to this round
 				// 原来进入 lock，会伴随轮次切换
-				c.switchRound(m.Round)
+				c.switchRound(m.Round, now)
 				// record this round change proof for resyncing
 				c.lastRoundChangeProof = c.currentRound.SignedRoundChanges()
 
 				// If Pj has not broadcasted the round-change message yet,
 				// it broadcasts now.
-				c.broadcastRoundChange()
+				c.broadcastRoundChange(now)
 
 				// leader of this round MUST wait on collectDuration,
 				// to decide to broadcast <lock> or <select>.
@@ -1423,7 +2512,7 @@ func (c *Consensus) receiveMessage(bts []byte, now time.Time) error {
 
 			// for the leader, who's current round has at least 2*t+1 <roundchange>,
 			// we will track max proposed state for each valid added <roundchange>
-			if round == c.currentRound && round.NumRoundChanges() >= 2*c.t()+1 {
+			if round == c.currentRound && round.RoundChangeWeight() >= c.quorum() {
 				leaderKey := c.roundLeader(m.Round)
 				if leaderKey == c.identity {
 					round.MaxProposedState, round.MaxProposedCount = round.GetMaxProposed()
@@ -1436,12 +2525,12 @@ func (c *Consensus) receiveMessage(bts []byte, now time.Time) error {
 		// <select>由leader签名
 		err := c.verifySelectMessage(m, signed)
 		if err != nil {
-			return err
+			return verifyError(m, signed, err)
 		}
 
 		// round will be increased monotonically
 		if m.Round > c.currentRound.RoundNumber {
-			c.switchRound(m.Round)
+			c.switchRound(m.Round, now)
 			c.lastRoundChangeProof = []*SignedProto{signed} // record this proof for resyncing
 		}
 
@@ -1450,22 +2539,27 @@ func (c *Consensus) receiveMessage(bts []byte, now time.Time) error {
 		if c.currentRound.Stage < stageLockRelease {
 			c.currentRound.Stage = stageLockRelease
 			c.lockReleaseTimeout = now.Add(c.commitDuration(m.Round))
-			c.lockRelease()
+			c.lockRelease(now)
 			// add to Blockj
 			c.Propose(m.State)
 		}
 
 	case MessageType_Lock:
 		// verify <lock> message
-		err := c.verifyLockMessage(m, signed)
+		proposer, err := c.verifyLockProofs(m, signed)
 		if err != nil {
-			return err
+			return verifyError(m, signed, err)
+		}
+
+		// leader signing different <lock> messages in the same round
+		if err := c.checkEquivocation(m, signed); err != nil {
+			return verifyError(m, signed, err)
 		}
 		// 已经检查，m.Round小于当前的则无效
 
 		// round will be increased monotonically
 		if m.Round > c.currentRound.RoundNumber {
-			c.switchRound(m.Round)
+			c.switchRound(m.Round, now)
 			c.lastRoundChangeProof = []*SignedProto{signed} // record this proof for resyncing
 		}
 
@@ -1487,24 +2581,24 @@ func (c *Consensus) receiveMessage(bts []byte, now time.Time) error {
 			}
 			c.locks = c.locks[:o]
 			// append the new element
-			c.locks = append(c.locks, messageTuple{StateHash: mHash, Message: m, Signed: signed})
+			c.locks = append(c.locks, messageTuple{StateHash: mHash, Message: m, Signed: signed, Proposer: proposer})
 		}
 
 		// for any incoming <lock,h,r,B'> message with r=r', sendCommit will send
 		// <commit,h,r',B'> once.
 		// sendCommit会防止重复调用 CommitSent
-		c.sendCommit(m)
+		c.sendCommit(m, now)
 
 	case MessageType_LockRelease:
 		// verifies the LockRelease field in message.
-		lockmsg, err := c.verifyLockReleaseMessage(m.LockRelease)
+		lockmsg, proposer, err := c.verifyLockReleaseMessage(m.LockRelease)
 		if err != nil {
-			return err
+			return verifyError(m, signed, err)
 		}
 
 		// length of locks is 0, append and return.
 		if len(c.locks) == 0 {
-			c.locks = append(c.locks, messageTuple{StateHash: c.stateHash(lockmsg.State), Message: lockmsg, Signed: m.LockRelease})
+			c.locks = append(c.locks, messageTuple{StateHash: c.stateHash(lockmsg.State), Message: lockmsg, Signed: m.LockRelease, Proposer: proposer})
 			return nil
 		}
 
@@ -1524,7 +2618,7 @@ func (c *Consensus) receiveMessage(bts []byte, now time.Time) error {
 		// then we keep this lock.
 		if o < len(c.locks) {
 			c.locks = c.locks[:o]
-			c.locks = append(c.locks, messageTuple{StateHash: c.stateHash(lockmsg.State), Message: lockmsg, Signed: m.LockRelease})
+			c.locks = append(c.locks, messageTuple{StateHash: c.stateHash(lockmsg.State), Message: lockmsg, Signed: m.LockRelease, Proposer: proposer})
 		}
 
 	case MessageType_Commit:
@@ -1537,17 +2631,23 @@ func (c *Consensus) receiveMessage(bts []byte, now time.Time) error {
 			// NOTE: leader only accept commits for current height & round.
 			err := c.verifyCommitMessage(m)
 			if err != nil {
-				return err
+				return verifyError(m, signed, err)
 			}
 
 			// verifyCommitMessage can guarantee that the message is to currentRound,
 			// so we're safe to process in current round.
 			if c.currentRound.AddCommit(signed, m) {
+				c.observeCommitLatency(signed, now)
 				// NOTE: we proceed the following only when AddCommit returns true.
 				// NumCommitted will only return commits with locked B'
 				// and ignore non-B' commits.
 				// NumCommitted()会统计所锁定的state是否被支持至少 2t+1
-				if c.currentRound.NumCommitted() >= 2*c.t()+1 {
+				if c.currentRound.NumCommitted() >= c.quorum() {
+					err := c.vetoDecide(c.latestHeight+1, c.currentRound.RoundNumber, c.currentRound.LockedState)
+					if err != nil {
+						return verifyError(m, signed, err)
+					}
+
 					/*
 						log.Println("======= LEADER'S DECIDE=====")
 						log.Println("Height:", c.currentHeight+1)
@@ -1555,13 +2655,15 @@ func (c *Consensus) receiveMessage(bts []byte, now time.Time) error {
 						log.Println("State:", State(c.currentRound.LockedState).hash())
 					*/
 
+					c.deciding = true
 					// broadcast decide will return what it has sent
-					c.latestProof = c.broadcastDecide()
+					c.latestProof = c.broadcastDecide(now)
 					c.heightSync(c.latestHeight+1, c.currentRound.RoundNumber, c.currentRound.LockedState, now)
 					// leader should wait for 1 more latency
 					c.rcTimeout = now.Add(c.roundchangeDuration(0) + c.latency)
 					// broadcast <roundchange> at new height
-					c.broadcastRoundChange()
+					c.broadcastRoundChange(now)
+					c.deciding = false
 				}
 			}
 		}
@@ -1570,15 +2672,21 @@ func (c *Consensus) receiveMessage(bts []byte, now time.Time) error {
 		// 收到的<decide>区块比当前共识区块高
 		err := c.verifyDecideMessage(m, signed)
 		if err != nil {
-			return err
+			return verifyError(m, signed, err)
+		}
+
+		err = c.vetoDecide(m.Height, m.Round, m.State)
+		if err != nil {
+			return verifyError(m, signed, err)
 		}
 
+		c.deciding = true
 		// record this proof for chaining
 		c.latestProof = signed
 
 		// propagate this <decide> message to my neighbour.
 		// NOTE: verifyDecideMessage() can stop broadcast storm.
-		c.propagate(bts)
+		c.propagate(bts, now)
 		// passive confirmation from the leader.
 		// 被动接收decide，直接跳到m.Height的下一个区块
 		// 每一个共识，决定一个高度，但是有可能当前节点想要共识的这个高度已被decide，那只能跳到最新的已被确认高度的next
@@ -1586,7 +2694,8 @@ func (c *Consensus) receiveMessage(bts []byte, now time.Time) error {
 		// non-leader starts waiting for rcTimeout
 		c.rcTimeout = now.Add(c.roundchangeDuration(0))
 		// we sync our height and broadcast new <roundchange>.
-		c.broadcastRoundChange()
+		c.broadcastRoundChange(now)
+		c.deciding = false
 	case MessageType_Resync:
 		// push the proofs in loopback device
 		for k := range m.Proof {
@@ -1598,19 +2707,180 @@ func (c *Consensus) receiveMessage(bts []byte, now time.Time) error {
 			c.loopback = append(c.loopback, out)
 		}
 	default:
-		return ErrMessageUnknownMessageType
+		return verifyError(m, signed, ErrMessageUnknownMessageType)
+	}
+	return nil
+}
+
+// rejectEarly peeks the type, height and round of a message, and rejects
+// messages for stale or far-future heights before any signature is verified,
+// to save verifications of replayed or flooded messages. The errors are the
+// same as the checks of the message types return. The header is scanned
+// from the message on wire, and the message is decoded only once afterwards,
+// undecodable messages are left to decodeMessage to report.
+func (c *Consensus) rejectEarly(bts []byte) error {
+	h, ok := peekMessage(bts)
+	if !ok {
+		return nil
+	}
+
+	// drop messages for far-future heights, a <decide> message is exempted
+	// as it's self-proved with <commit> messages to help lagging behind
+	// participants to catch up.
+	if h.Type != MessageType_Decide && h.Height > c.latestHeight+1+c.maxFutureHeight {
+		c.numFutureHeightDropped++
+		return h.verifyError(ErrMessageFutureHeightExceeded)
+	}
+
+	// observers ignore messages other than <decide> silently
+	if h.Height > c.latestHeight || (c.observer && h.Type != MessageType_Decide) {
+		return nil
+	}
+
+	// messages at decided heights, the height of a <lock-release> message is
+	// checked in the embedded <lock> message.
+	switch h.Type {
+	case MessageType_RoundChange:
+		return h.verifyError(ErrRoundChangeHeightMismatch)
+	case MessageType_Lock:
+		return h.verifyError(ErrLockHeightMismatch)
+	case MessageType_Select:
+		return h.verifyError(ErrSelectHeightMismatch)
+	case MessageType_Commit:
+		return h.verifyError(ErrCommitHeightMismatch)
+	case MessageType_Decide:
+		return h.verifyError(ErrDecideHeightLower)
 	}
 	return nil
 }
 
+// decodeMessage unmarshals a signed message, and verifies the version,
+// signature and signer of the message.
+func (c *Consensus) decodeMessage(bts []byte) (*SignedProto, *Message, error) {
+	return c.decodeMessageSigned(bts, false)
+}
+
+// decodeMessageSigned decodes a message as decodeMessage does, the signature
+// is trusted without verification if the message has been signed by myself,
+// see takeSelfSigned, other checks still apply.
+func (c *Consensus) decodeMessageSigned(bts []byte, selfSigned bool) (*SignedProto, *Message, error) {
+	// unmarshal signed message
+	signed := new(SignedProto)
+	err := proto.Unmarshal(bts, signed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// check message version
+	if err := c.checkVersion(signed); err != nil {
+		return nil, nil, err
+	}
+
+	// check message signature & qualifications
+	var trusted map[*SignedProto]bool
+	if selfSigned && c.pubKeyToIdentity(signed.PublicKey(c.curve)) == c.identity {
+		trusted = map[*SignedProto]bool{signed: true}
+	}
+	m, err := c.verifyMessageBatch(signed, trusted)
+	if err != nil {
+		return nil, nil, fmt.Errorf("verifying message from %x: %w", signed.X, err)
+	}
+	return signed, m, nil
+}
+
+// ValidateMessage runs the stateless checks of a message, i.e. the version,
+// signature, signer being a participant and message type, a nil error means
+// the message is well-formed and safe to relay in gossip networks. The message
+// is not applied and consensus states are not changed, the message may still
+// be rejected by ReceiveMessage, e.g. for a mismatched height.
+func (c *Consensus) ValidateMessage(bts []byte) error {
+	if c.closed {
+		return ErrConsensusClosed
+	}
+
+	if err := c.checkMessageSize(bts); err != nil {
+		return err
+	}
+
+	bts, err := c.decodeFrame(bts)
+	if err != nil {
+		return err
+	}
+
+	signed, m, err := c.decodeMessage(bts)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := MessageType_name[int32(m.Type)]; !ok {
+		return verifyError(m, signed, ErrMessageUnknownMessageType)
+	}
+	return nil
+}
+
+// verifyError wraps the error of message verification with message type,
+// signer and height, the wrapped error can be tested with errors.Is
+func verifyError(m *Message, signed *SignedProto, err error) error {
+	return fmt.Errorf("verifying <%v> from %x at height %d round %d: %w", m.Type, signed.X, m.Height, m.Round, err)
+}
+
 // Update will process timing event for the state machine, callers
 // from outside MUST call this function periodically(like 20ms).
 // 超时计算并处理
 func (c *Consensus) Update(now time.Time) error {
+	return c.UpdateWithContext(context.Background(), now)
+}
+
+// UpdateWithContext processes timing events as Update does, and aborts
+// when ctx is cancelled, ctx.Err() will be returned if aborted. ctx is
+// checked between the queued messages, before resending failed frames,
+// before the stage timeouts and between the messages directed to myself,
+// the remaining work is kept and carried out in next update.
+func (c *Consensus) UpdateWithContext(ctx context.Context, now time.Time) (err error) {
+	defer c.hooks.flush()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if c.closed {
+		return ErrConsensusClosed
+	}
+
+	// neither messages nor timeouts are processed while paused
+	if c.paused {
+		return nil
+	}
+
+	// verify queued messages before timing events
+	if c.receiveQueue != nil {
+		if err := c.processReceiveQueue(ctx, now); err != nil {
+			return err
+		}
+	}
+
+	// resend frames failed to peers
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.processRetries(now)
+
+	// observers have no timing events
+	if c.observer {
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// as in ReceiveMessage, we also need to handle broadcasting messages
 	// directed to myself.
 	defer func() {
 		for len(c.loopback) > 0 {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				err = ctxErr
+				return
+			}
 			bts := c.loopback[0]
 			c.loopback = c.loopback[1:]
 			_ = c.receiveMessage(bts, now)
@@ -1625,8 +2895,8 @@ func (c *Consensus) Update(now time.Time) error {
 		}
 		// 对于Pj，如果在rcTimeout没有进入另一个状态，就会重新广播<roundchange>
 		if now.After(c.rcTimeout) {
-			c.broadcastRoundChange()
-			c.broadcastResync() // we also need to broadcast the round change event message if there is any
+			c.broadcastRoundChange(now)
+			c.broadcastResync(now) // we also need to broadcast the round change event message if there is any
 			c.rcTimeout = now.Add(c.roundchangeDuration(c.currentRound.RoundNumber))
 		}
 	case stageLock:
@@ -1640,14 +2910,15 @@ func (c *Consensus) Update(now time.Time) error {
 			// 如果我是leader
 			// check if we have enough 2t+1 <roundchange> to lock B',
 			// which B' != NULL
-			if c.currentRound.MaxProposedCount >= 2*c.t()+1 {
+			if c.currentRound.MaxProposedCount >= c.quorum() {
 				// 已经收到一个区块B'，支持的 participant 数量超过了2t+1
 				// lock B' to c.currentRound
-				c.currentRound.LockedState = c.currentRound.MaxProposedState
+				c.currentRound.LockedState = c.maximalQuorumProposed()
 				// and computes its hash for comparing B' in <commit> message
-				c.currentRound.LockedStateHash = c.stateHash(c.currentRound.MaxProposedState)
+				c.currentRound.LockedStateHash = c.stateHash(c.currentRound.LockedState)
 				// broadcast this <lock>, leader itself will receive this message too.
-				c.broadcastLock()
+				c.broadcastLock(now)
+				c.currentRound.LockSentTime = now
 				// enter commit stage
 				c.currentRound.Stage = stageCommit
 				c.commitTimeout = now.Add(c.commitDuration(c.currentRound.RoundNumber) + c.latency)
@@ -1667,11 +2938,11 @@ func (c *Consensus) Update(now time.Time) error {
 
 				// broadcast this <select>, leader itself will receive this message too.
 				// 选择最大的未提议队列状态广播
-				c.broadcastSelect()
+				c.broadcastSelect(now)
 				// enter lock-release stage
 				c.currentRound.Stage = stageLockRelease
 				c.lockReleaseTimeout = now.Add(c.lockReleaseDuration(c.currentRound.RoundNumber) + c.latency)
-				c.lockRelease()
+				c.lockRelease(now)
 				return nil
 			}
 		} else if now.After(c.lockTimeout) {
@@ -1690,7 +2961,7 @@ func (c *Consensus) Update(now time.Time) error {
 		if now.After(c.commitTimeout) {
 			c.currentRound.Stage = stageLockRelease
 			c.lockReleaseTimeout = now.Add(c.lockReleaseDuration(c.currentRound.RoundNumber))
-			c.lockRelease()
+			c.lockRelease(now)
 		}
 
 	case stageLockRelease:
@@ -1702,8 +2973,8 @@ func (c *Consensus) Update(now time.Time) error {
 		if now.After(c.lockReleaseTimeout) {
 			c.currentRound.Stage = stageRoundChanging
 			// move to round +1 when lock release has timeout
-			c.switchRound(c.currentRound.RoundNumber + 1)
-			c.broadcastRoundChange()
+			c.switchRound(c.currentRound.RoundNumber+1, now)
+			c.broadcastRoundChange(now)
 			c.rcTimeout = now.Add(c.roundchangeDuration(c.currentRound.RoundNumber))
 		}
 	}
@@ -1717,9 +2988,176 @@ func (c *Consensus) CurrentState() (height uint64, round uint64, data State) {
 	return c.latestHeight, c.latestRound, c.latestState
 }
 
+// Height returns the height as in CurrentState, without touching the state.
+func (c *Consensus) Height() uint64 { return c.latestHeight }
+
+// Round returns the round being run, as reported by CurrentLeader, the round
+// in which the current height was decided is returned by CurrentState.
+func (c *Consensus) Round() uint64 {
+	if c.currentRound == nil {
+		return 0
+	}
+	return c.currentRound.RoundNumber
+}
+
+// RoundStartTime returns when the round being run began by the scheduler
+// clock, it's updated along with the round in CurrentLeader, so both read
+// between two calls to consensus are consistent, for monitoring stuck rounds.
+func (c *Consensus) RoundStartTime() time.Time { return c.roundStartTime }
+
 // CurrentProof returns current <decide> message for current height
 func (c *Consensus) CurrentProof() *SignedProto { return c.latestProof }
 
+// CurrentLeader returns the public key of the leader of current round, along
+// with the round it leads, the leader is the one expected to sign <lock>,
+// <select> and <decide> messages in this round. nil will be returned if the
+// consensus hasn't been initialized, or the leader's identity cannot be
+// converted back to a public key, as with a custom Config.PubKeyToIdentity.
+func (c *Consensus) CurrentLeader() (*ecdsa.PublicKey, uint64) {
+	if c.currentRound == nil || len(c.participants) == 0 {
+		return nil, 0
+	}
+	round := c.currentRound.RoundNumber
+	return c.identityToPubKey(c.roundLeader(round)), round
+}
+
+// DiffState compares two states as the selection of proposals does, i.e. by
+// Config.StateCompare, and ties of distinct states are broken as described in
+// Config.ProposalTieBreak. It returns 1 if a is preferred over b, -1 if b is
+// preferred, and 0 only if they're identical, regardless of the magnitude the
+// comparator returns.
+func (c *Consensus) DiffState(a State, b State) int {
+	r := c.compareProposals(a, b, c.roundProposers(), c.identity)
+	switch {
+	case r > 0:
+		return 1
+	case r < 0:
+		return -1
+	}
+	return 0
+}
+
+// CommitProgress returns the weights of valid <commit> messages collected to
+// the state locked in current round, and the quorum needed to broadcast the
+// <decide> message, weights are individual participants if
+// Config.ParticipantWeights has not set. Only the leader of the round collects
+// <commit> messages, others always have 0. It's read-only.
+func (c *Consensus) CommitProgress() (have int, need int) {
+	if c.currentRound == nil {
+		return 0, c.quorum()
+	}
+	return c.currentRound.NumCommitted(), c.quorum()
+}
+
+// LockedState returns the state B' locked by a <lock> message and the round
+// of that lock, the lock with the highest round is returned when more than
+// one B' has been locked at the height, as the one to be broadcasted in
+// lock-release status. hasLock is false if no lock is held. It's read-only.
+func (c *Consensus) LockedState() (state State, round uint64, hasLock bool) {
+	if len(c.locks) == 0 {
+		return nil, 0, false
+	}
+	max := c.locks[0]
+	for i := 1; i < len(c.locks); i++ {
+		if max.Message.Round < c.locks[i].Message.Round {
+			max = c.locks[i]
+		}
+	}
+	return max.Message.State, max.Message.Round, true
+}
+
+// LeaderForRound returns the public key of the leader of any round at the
+// height in progress, by the same rotation as the leader expected to sign
+// messages, without changing consensus states. ErrLeaderHeight will be
+// returned for other heights, as the participants may have changed by then.
+func (c *Consensus) LeaderForRound(height uint64, round uint64) (*ecdsa.PublicKey, error) {
+	if height != c.latestHeight+1 {
+		return nil, ErrLeaderHeight
+	}
+	if len(c.participants) == 0 {
+		return nil, ErrConfigParticipants
+	}
+
+	pubkey := c.identityToPubKey(c.roundLeader(round))
+	if pubkey == nil {
+		return nil, ErrLeaderPublicKey
+	}
+	return pubkey, nil
+}
+
+// ParticipantInfo describes a participant in the consensus group
+type ParticipantInfo struct {
+	Index    int      // position in the group, round r is led by Index == r % n
+	Identity Identity // the coordinate from Config.PubKeyToIdentity
+	// PublicKey is converted back from the identity, nil if the identity is
+	// not a point on the curve, as with a custom Config.PubKeyToIdentity
+	PublicKey *ecdsa.PublicKey
+	Weight    int // the normalized voting weight, 1 if weights have not set
+}
+
+// Participants returns a snapshot of the consensus group in use at current
+// height, in the order of Config.Participants, to check identities have been
+// mapped from public keys as expected. A group queued by UpdateParticipants
+// is not included until applied.
+func (c *Consensus) Participants() []ParticipantInfo {
+	infos := make([]ParticipantInfo, 0, len(c.participants))
+	for k, id := range c.participants {
+		infos = append(infos, ParticipantInfo{
+			Index:     k,
+			Identity:  id,
+			PublicKey: c.identityToPubKey(id),
+			Weight:    c.weightOf(id),
+		})
+	}
+	return infos
+}
+
+// identityToPubKey converts an identity created by DefaultPubKeyToIdentity
+// back to public key, returns nil if it's not a point on the curve
+func (c *Consensus) identityToPubKey(id Identity) *ecdsa.PublicKey {
+	pubkey := new(ecdsa.PublicKey)
+	pubkey.Curve = c.curve
+	pubkey.X = new(big.Int).SetBytes(id[:SizeAxis])
+	pubkey.Y = new(big.Int).SetBytes(id[SizeAxis:])
+	if !c.curve.IsOnCurve(pubkey.X, pubkey.Y) {
+		return nil
+	}
+	return pubkey
+}
+
+// UpdateParticipants queues a new consensus group to take effect at the next
+// height boundary, i.e. after a <decide> message has been accepted or broadcasted,
+// the active round at current height keeps on using the current group.
+//
+// The quorum(2t+1, with t = (n-1)/3) will be recomputed with the number of
+// individual identities in the new group when it's applied. Calling it more
+// than once before the next height replaces the queued group. If
+// Config.ParticipantWeights has set, every participant in the new group
+// must have a weight in it.
+func (c *Consensus) UpdateParticipants(participants []*ecdsa.PublicKey) error {
+	if c.closed {
+		return ErrConsensusClosed
+	}
+
+	ids := make([]Identity, 0, len(participants))
+	for _, pubkey := range participants {
+		ids = append(ids, c.pubKeyToIdentity(pubkey))
+	}
+
+	if countIdentities(ids) < ConfigMinimumParticipants {
+		return ErrConfigParticipants
+	}
+
+	if c.configWeights != nil {
+		if err := verifyWeights(ids, c.configWeights); err != nil {
+			return err
+		}
+	}
+
+	c.pendingParticipants = ids
+	return nil
+}
+
 // SetLatency sets participants expected latency for consensus core
 func (c *Consensus) SetLatency(latency time.Duration) { c.latency = latency }
 
@@ -1751,10 +3189,20 @@ func (c *Consensus) HasProposed(state State) bool {
 	return false
 }
 
+// Locker returns the lock shared by transports of this consensus object
+// which deliver messages from their own goroutines, such as TCPPeer. As
+// Consensus is not thread-safe, once such a transport is attached, all
+// other calls like Update and Propose must be made with this lock held.
+func (c *Consensus) Locker() sync.Locker { return &c.transportLock }
+
 // Join adds a peer to consensus for message delivery, a peer is
 // identified by its address.
 // 添加节点
 func (c *Consensus) Join(p PeerInterface) bool {
+	if c.closed {
+		return false
+	}
+
 	for k := range c.peers {
 		if p.RemoteAddr().String() == c.peers[k].RemoteAddr().String() {
 			return false
@@ -1770,10 +3218,64 @@ func (c *Consensus) Join(p PeerInterface) bool {
 func (c *Consensus) Leave(addr net.Addr) bool {
 	for k := range c.peers {
 		if addr.String() == c.peers[k].RemoteAddr().String() {
-			copy(c.peers[k:], c.peers[k+1:])
-			c.peers = c.peers[:len(c.peers)-1]
+			c.removePeer(k)
+			return true
+		}
+	}
+	return false
+}
+
+// AddPeer adds a peer to the peer set of consensus, every message emitted is
+// sent to all peers in the set. As Join, a peer with the same address as any
+// peer in the set will not be added.
+func (c *Consensus) AddPeer(p PeerInterface) bool { return c.Join(p) }
+
+// RemovePeer removes the given peer from the peer set, peers are compared by
+// identity, so implementations must be comparable, such as pointers.
+//
+// Peers can be added or removed from PeerInterface.Send, a message being sent
+// reaches exactly the peers in the set when sending began.
+func (c *Consensus) RemovePeer(p PeerInterface) bool {
+	for k := range c.peers {
+		if c.peers[k] == p {
+			c.removePeer(k)
 			return true
 		}
 	}
 	return false
 }
+
+// Peers returns a copy of the peer set
+func (c *Consensus) Peers() []PeerInterface {
+	return append([]PeerInterface(nil), c.peers...)
+}
+
+// removePeer removes the peer at idx with copy-on-write, the slice being
+// iterated while sending is never modified in place.
+func (c *Consensus) removePeer(idx int) {
+	peers := make([]PeerInterface, 0, len(c.peers)-1)
+	peers = append(peers, c.peers[:idx]...)
+	c.peers = append(peers, c.peers[idx+1:]...)
+}
+
+// Close tears down consensus, pending proposals are resolved with
+// ProposeRejected, channels of subscribers are closed and peers are
+// released, Config.WAL is not closed as it's owned by the caller.
+// Afterwards, methods return ErrConsensusClosed, and Join returns false.
+// It's safe to call Close more than once.
+func (c *Consensus) Close() {
+	c.closeOnce.Do(func() {
+		c.closed = true
+		for _, p := range c.proposals {
+			p.ch <- ProposeResult{Status: ProposeRejected, Height: p.height}
+			close(p.ch)
+		}
+		c.proposals = nil
+		c.peers = nil
+		c.retries = nil
+		c.loopback = nil
+		c.selfSigned = nil
+		c.unconfirmed = nil
+		c.subscribers.close()
+	})
+}
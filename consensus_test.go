@@ -2,18 +2,22 @@ package bdls
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	fmt "fmt"
 	"io"
 	"log"
 	math "math"
 	mrand "math/rand"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -22,6 +26,7 @@ import (
 
 	"code.cloudfoundry.org/bytefmt"
 	"github.com/Sperax/bdls/crypto/blake2b"
+	"github.com/Sperax/bdls/timer"
 	"github.com/davecgh/go-spew/spew"
 	proto "github.com/gogo/protobuf/proto"
 	"github.com/olekukonko/tablewriter"
@@ -54,7 +59,7 @@ func (c *Consensus) AddParticipant(key *ecdsa.PublicKey) {
 
 // createConsensus creates a valid consensus object with given height & round and random state
 // the c.particpants[0] will always be the consensus's publickey
-func createConsensus(t *testing.T, height uint64, round uint64, quorum []*ecdsa.PublicKey) *Consensus {
+func createConsensus(t testing.TB, height uint64, round uint64, quorum []*ecdsa.PublicKey) *Consensus {
 	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
 	assert.Nil(t, err)
 
@@ -81,7 +86,7 @@ func createConsensus(t *testing.T, height uint64, round uint64, quorum []*ecdsa.
 
 	consensus := new(Consensus)
 	consensus.init(config)
-	consensus.switchRound(round)
+	consensus.switchRound(round, time.Now())
 
 	return consensus
 }
@@ -287,6 +292,47 @@ func TestLockReleaseMessageRoundSwitch(t *testing.T) {
 	assert.Equal(t, 1, len(consensus.locks))
 }
 
+func TestLockedState(t *testing.T) {
+	m, sp, privateKey, proofKeys := createLockMessage(t, 20, 1, 10, 1, 10)
+	consensus := createConsensus(t, 0, 1, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+	_, _, hasLock := consensus.LockedState()
+	assert.False(t, hasLock)
+
+	// locked on the proposal of the leader
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	assert.Equal(t, stageCommit, consensus.currentRound.Stage)
+	state, round, hasLock := consensus.LockedState()
+	assert.True(t, hasLock)
+	assert.Equal(t, m.State, []byte(state))
+	assert.Equal(t, uint64(10), round)
+
+	// the lock of the highest round is reported
+	consensus.participants = nil
+	m, sp, privateKey, proofKeys = createLockMessage(t, 20, 1, 11, 1, 11)
+	consensus.AddParticipant(&privateKey.PublicKey)
+	consensus.SetLeader(&privateKey.PublicKey)
+	for k := range proofKeys {
+		consensus.AddParticipant(proofKeys[k])
+	}
+	bts, err = proto.Marshal(sp)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	assert.Equal(t, 2, len(consensus.locks))
+	state, round, hasLock = consensus.LockedState()
+	assert.True(t, hasLock)
+	assert.Equal(t, m.State, []byte(state))
+	assert.Equal(t, uint64(11), round)
+
+	// released on the next height
+	assert.Nil(t, consensus.Reset(2, State("2"), time.Now()))
+	_, _, hasLock = consensus.LockedState()
+	assert.False(t, hasLock)
+}
+
 func TestStageChangeLeader(t *testing.T) {
 	testStageChange(t, true)
 }
@@ -372,6 +418,49 @@ func TestCommitTimeout(t *testing.T) {
 	assert.Equal(t, 1, len(consensus.locks))
 }
 
+func TestRoundChangeTimeoutBackoff(t *testing.T) {
+	consensus := createConsensus(t, 0, 0, nil)
+	consensus.rcBaseTimeout = 100 * time.Millisecond
+	consensus.rcMaxTimeout = time.Second
+
+	// consecutive failed rounds
+	expected := []time.Duration{
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	now := time.Now()
+	for k := range expected {
+		consensus.currentRound.Stage = stageLockRelease
+		consensus.lockReleaseTimeout = now
+		now = now.Add(time.Millisecond)
+		consensus.Update(now)
+		assert.Equal(t, uint64(k+1), consensus.currentRound.RoundNumber)
+		assert.Equal(t, expected[k], consensus.rcTimeout.Sub(now))
+	}
+
+	// no overflow for large rounds
+	assert.Equal(t, time.Second, consensus.roundchangeDuration(math.MaxUint64))
+
+	// reset on decide
+	_, sp, privateKey, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus = createConsensus(t, 9, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+	consensus.rcBaseTimeout = 100 * time.Millisecond
+	consensus.rcMaxTimeout = time.Second
+	assert.Equal(t, time.Second, consensus.roundchangeDuration(consensus.currentRound.RoundNumber))
+
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	err = consensus.ReceiveMessage(bts, now)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), consensus.currentRound.RoundNumber)
+	assert.Equal(t, 100*time.Millisecond, consensus.rcTimeout.Sub(now))
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 //
 // consensus functional tests via IPC
@@ -385,6 +474,392 @@ type testParam struct {
 	expectedLatency time.Duration
 }
 
+func randomPublicKeys(t *testing.T, n int) []*ecdsa.PublicKey {
+	var keys []*ecdsa.PublicKey
+	for i := 0; i < n; i++ {
+		privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+		assert.Nil(t, err)
+		keys = append(keys, &privateKey.PublicKey)
+	}
+	return keys
+}
+
+// testUpdateParticipants queues a new group of participants and checks
+// it's applied only after a <decide> message has been accepted.
+func testUpdateParticipants(t *testing.T, newKeys []*ecdsa.PublicKey) {
+	_, sp, privateKey, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+	consensus.numIdentities = countIdentities(consensus.participants)
+	numIdentities := consensus.numIdentities
+
+	err := consensus.UpdateParticipants(newKeys)
+	assert.Nil(t, err)
+
+	// changes are queued in the middle of a height
+	assert.Equal(t, numIdentities, consensus.numIdentities)
+	assert.Equal(t, numIdentities, len(consensus.participants))
+
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	err = consensus.ReceiveMessage(bts, time.Now())
+	assert.Nil(t, err)
+
+	// applied at the height boundary
+	height, _, _ := consensus.CurrentState()
+	assert.Equal(t, uint64(10), height)
+	assert.Equal(t, len(newKeys), consensus.numIdentities)
+	assert.Equal(t, len(newKeys), len(consensus.participants))
+	for k := range newKeys {
+		assert.Equal(t, DefaultPubKeyToIdentity(newKeys[k]), consensus.participants[k])
+	}
+	assert.Nil(t, consensus.pendingParticipants)
+}
+
+func TestUpdateParticipantsAdd(t *testing.T) {
+	testUpdateParticipants(t, randomPublicKeys(t, 30))
+}
+
+func TestUpdateParticipantsRemove(t *testing.T) {
+	testUpdateParticipants(t, randomPublicKeys(t, ConfigMinimumParticipants))
+}
+
+func TestUpdateParticipantsBelowMinimum(t *testing.T) {
+	consensus := createConsensus(t, 0, 0, randomPublicKeys(t, ConfigMinimumParticipants))
+	keys := randomPublicKeys(t, ConfigMinimumParticipants-1)
+	err := consensus.UpdateParticipants(keys)
+	assert.Equal(t, ErrConfigParticipants, err)
+
+	// duplicated identities are counted once
+	keys = append(keys, keys[0])
+	err = consensus.UpdateParticipants(keys)
+	assert.Equal(t, ErrConfigParticipants, err)
+	assert.Nil(t, consensus.pendingParticipants)
+}
+
+func TestReset(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	for _, config := range configs {
+		config.Scheduler = scheduler
+	}
+
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond)
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	// run consensus to the target height on all peers
+	runTo := func(target uint64) {
+		data := make([]byte, 1024)
+		_, err := io.ReadFull(rand.Reader, data)
+		assert.Nil(t, err)
+		for _, p := range network.Peers() {
+			p.Propose(data)
+		}
+
+		for i := 0; i < 60000; i++ {
+			decided := true
+			for _, p := range network.Peers() {
+				if height, _, _ := p.GetLatestState(); height < target {
+					decided = false
+				}
+			}
+			if decided {
+				break
+			}
+			scheduler.Advance(10 * time.Millisecond)
+		}
+
+		for _, p := range network.Peers() {
+			height, _, state := p.GetLatestState()
+			assert.Equal(t, target, height)
+			assert.Equal(t, State(data), state)
+		}
+	}
+
+	for height := uint64(1); height <= 3; height++ {
+		runTo(height)
+	}
+	// deliver messages in flight
+	scheduler.Advance(time.Second)
+
+	// reset to an earlier height
+	for _, p := range network.Peers() {
+		assert.Nil(t, p.Reset(1, State("snapshot")))
+		height, round, state := p.GetLatestState()
+		assert.Equal(t, uint64(1), height)
+		assert.Equal(t, uint64(0), round)
+		assert.Equal(t, State("snapshot"), state)
+		assert.Nil(t, p.c.loopback)
+		assert.Nil(t, p.c.locks)
+		assert.Nil(t, p.c.latestProof)
+		assert.Equal(t, uint64(0), p.c.currentRound.RoundNumber)
+		assert.Equal(t, stageRoundChanging, p.c.currentRound.Stage)
+	}
+
+	// consensus proceeds from the reset height
+	runTo(2)
+	runTo(3)
+}
+
+func TestForceRoundChange(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	for _, config := range configs {
+		config.Scheduler = scheduler
+	}
+
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond)
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	// the leader of round 0 is non-responsive
+	assert.Nil(t, network.Partition([][]int{{1, 2, 3}}))
+	peers := network.Peers()
+	for _, p := range peers {
+		p.Propose(State("data"))
+	}
+
+	// the others are waiting for <lock> or <select> from leader
+	for i := 0; i < 100; i++ {
+		scheduler.Advance(10 * time.Millisecond)
+	}
+	for _, p := range peers[1:] {
+		p.Lock()
+		assert.Equal(t, uint64(0), p.c.currentRound.RoundNumber)
+		assert.Equal(t, stageLock, p.c.currentRound.Stage)
+		p.Unlock()
+	}
+
+	for _, p := range peers[1:] {
+		assert.Nil(t, p.ForceRoundChange())
+		p.Lock()
+		assert.Equal(t, uint64(1), p.c.currentRound.RoundNumber)
+		assert.Equal(t, stageRoundChanging, p.c.currentRound.Stage)
+		p.Unlock()
+	}
+
+	// the state is decided in round 1 before round 0 times out
+	for i := 0; i < 60; i++ {
+		scheduler.Advance(10 * time.Millisecond)
+	}
+	for _, p := range peers[1:] {
+		height, round, state := p.GetLatestState()
+		assert.Equal(t, uint64(1), height)
+		assert.Equal(t, uint64(1), round)
+		assert.Equal(t, State("data"), state)
+	}
+}
+
+func TestForceRoundChangeWhileCommitting(t *testing.T) {
+	consensus := createConsensus(t, 0, 0, randomPublicKeys(t, ConfigMinimumParticipants))
+	consensus.currentRound.Stage = stageCommit
+	assert.Equal(t, ErrForceRoundChangeCommit, consensus.ForceRoundChange(time.Now()))
+	assert.Equal(t, uint64(0), consensus.currentRound.RoundNumber)
+
+	// allowed after the lock has been released
+	consensus.currentRound.Stage = stageLockRelease
+	now := time.Now().Add(time.Hour)
+	assert.Nil(t, consensus.ForceRoundChange(now))
+	assert.Equal(t, uint64(1), consensus.currentRound.RoundNumber)
+	assert.Equal(t, stageRoundChanging, consensus.currentRound.Stage)
+	assert.Equal(t, now.Add(consensus.roundchangeDuration(1)), consensus.rcTimeout)
+
+	consensus.Close()
+	assert.Equal(t, ErrConsensusClosed, consensus.ForceRoundChange(time.Now()))
+}
+
+// resetPeer resets consensus when messages are sent to it
+type resetPeer struct {
+	c    *Consensus
+	key  *ecdsa.PublicKey
+	errs []error
+}
+
+func (p *resetPeer) GetPublicKey() *ecdsa.PublicKey { return p.key }
+func (p *resetPeer) RemoteAddr() net.Addr           { return fakeAddress("reset") }
+func (p *resetPeer) Send(msg []byte) error {
+	p.errs = append(p.errs, p.c.Reset(3, State("snapshot"), time.Now()))
+	return nil
+}
+
+func TestResetWhileDeciding(t *testing.T) {
+	_, sp, privateKey, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+
+	// reset while propagating <decide>
+	peer := &resetPeer{c: consensus, key: proofKeys[0]}
+	assert.True(t, consensus.Join(peer))
+
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	assert.Equal(t, []error{ErrResetWhileDeciding}, peer.errs)
+	height, _, _ := consensus.CurrentState()
+	assert.Equal(t, uint64(10), height)
+
+	// reset is allowed after <decide> processed
+	assert.Nil(t, consensus.Reset(3, State("snapshot"), time.Now()))
+	height, _, _ = consensus.CurrentState()
+	assert.Equal(t, uint64(3), height)
+}
+
+func TestResetResolvesProposals(t *testing.T) {
+	consensus := createConsensus(t, 0, 0, randomPublicKeys(t, ConfigMinimumParticipants))
+	finalized := consensus.ProposeWithResult(State("snapshot"))
+	superseded := consensus.ProposeWithResult(State("another"))
+	now := time.Now().Add(time.Hour)
+	assert.Nil(t, consensus.Reset(1, State("snapshot"), now))
+	assert.Equal(t, ProposeFinalized, (<-finalized).Status)
+	assert.Equal(t, ProposeSuperseded, (<-superseded).Status)
+	assert.Nil(t, consensus.unconfirmed)
+
+	// timeouts start from the time given
+	assert.Equal(t, now.Add(consensus.roundchangeDuration(0)), consensus.rcTimeout)
+	assert.Equal(t, now, consensus.heightStartTime)
+}
+
+func TestCurrentLeader(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	consensus, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+
+	// leader rotates with rounds
+	for round := uint64(0); round < 10; round++ {
+		consensus.switchRound(round, time.Now())
+		leader, leaderRound := consensus.CurrentLeader()
+		assert.Equal(t, round, leaderRound)
+		assert.NotNil(t, leader)
+		identity := DefaultPubKeyToIdentity(leader)
+		assert.Equal(t, configs[0].Participants[round%4], identity)
+		assert.Equal(t, consensus.roundLeader(round), identity)
+	}
+
+	// the leader of current round signs <lock> messages
+	consensus.switchRound(1, time.Now())
+	leader, _ := consensus.CurrentLeader()
+	for _, config := range configs {
+		if config.PrivateKey.PublicKey.X.Cmp(leader.X) == 0 {
+			m := new(Message)
+			m.Type = MessageType_Lock
+			m.Height = 1
+			m.Round = 1
+			m.State = State("state")
+			signed := new(SignedProto)
+			signed.Sign(m, config.PrivateKey)
+			assert.NotEqual(t, ErrLockNotSignedByLeader, consensus.verifyLockMessage(m, signed))
+		}
+	}
+}
+
+func TestCurrentLeaderUninitialized(t *testing.T) {
+	leader, round := new(Consensus).CurrentLeader()
+	assert.Nil(t, leader)
+	assert.Equal(t, uint64(0), round)
+}
+
+func TestCurrentLeaderCustomIdentity(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	config := configs[0]
+	config.PubKeyToIdentity = func(pubkey *ecdsa.PublicKey) Identity {
+		raw := DefaultPubKeyToIdentity(pubkey)
+		return Identity(blake2b.Sum512(raw[:]))
+	}
+	for k := range config.Participants {
+		config.Participants[k] = config.PubKeyToIdentity(&configs[k].PrivateKey.PublicKey)
+	}
+
+	consensus, err := NewConsensus(config)
+	assert.Nil(t, err)
+	leader, round := consensus.CurrentLeader()
+	assert.Nil(t, leader)
+	assert.Equal(t, uint64(0), round)
+}
+
+func TestParticipants(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	consensus, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+
+	infos := consensus.Participants()
+	assert.Equal(t, 4, len(infos))
+	for k, info := range infos {
+		assert.Equal(t, k, info.Index)
+		assert.Equal(t, DefaultPubKeyToIdentity(&configs[k].PrivateKey.PublicKey), info.Identity)
+		assert.Equal(t, 0, configs[k].PrivateKey.PublicKey.X.Cmp(info.PublicKey.X))
+		assert.Equal(t, 0, configs[k].PrivateKey.PublicKey.Y.Cmp(info.PublicKey.Y))
+		assert.Equal(t, 1, info.Weight)
+	}
+
+	// a snapshot
+	infos[0].Index = 100
+	assert.Equal(t, 0, consensus.Participants()[0].Index)
+
+	// identities not convertible back to public keys
+	config := configs[1]
+	config.PubKeyToIdentity = func(pubkey *ecdsa.PublicKey) Identity {
+		raw := DefaultPubKeyToIdentity(pubkey)
+		return Identity(blake2b.Sum512(raw[:]))
+	}
+	for k := range config.Participants {
+		config.Participants[k] = config.PubKeyToIdentity(&configs[k].PrivateKey.PublicKey)
+	}
+	consensus, err = NewConsensus(config)
+	assert.Nil(t, err)
+	for k, info := range consensus.Participants() {
+		assert.Equal(t, config.Participants[k], info.Identity)
+		assert.Nil(t, info.PublicKey)
+	}
+}
+
+func TestUpdateWithContext(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	consensus, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+	consensus.loopback = nil
+
+	// queue some messages directed to myself
+	for round := uint64(1); round <= 3; round++ {
+		_, signed, _ := createRoundChangeMessageSigner(t, 1, round, State("state"), configs[0].PrivateKey)
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		consensus.loopback = append(consensus.loopback, bts)
+	}
+
+	// a cancelled context aborts the update, messages are kept
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = consensus.UpdateWithContext(ctx, time.Now())
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 3, len(consensus.loopback))
+
+	// a context cancelled between the phases skips the stage timeouts
+	now := time.Now()
+	consensus.rcTimeout = now.Add(-time.Second)
+	for n := 1; n <= 2; n++ {
+		err = consensus.UpdateWithContext(&countdownContext{Context: context.Background(), n: n}, now)
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, now.Add(-time.Second), consensus.rcTimeout)
+		assert.Equal(t, 3, len(consensus.loopback))
+	}
+
+	// a live context drains the loopback
+	err = consensus.UpdateWithContext(context.Background(), time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(consensus.loopback))
+
+	// Update keeps working as before
+	_, signed, _ := createRoundChangeMessageSigner(t, 1, 4, State("state"), configs[0].PrivateKey)
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	consensus.loopback = append(consensus.loopback, bts)
+	assert.Nil(t, consensus.Update(time.Now()))
+	assert.Equal(t, 0, len(consensus.loopback))
+}
+
 func TestConsensusTableFormat(t *testing.T) {
 	var params = []testParam{
 		{
@@ -1110,3 +1585,637 @@ func testConsensus(t *testing.T, param *testParam) []string {
 	}
 
 }
+
+func TestHeightRound(t *testing.T) {
+	_, sp, privateKey, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 3, proofKeys)
+	assert.Equal(t, uint64(9), consensus.Height())
+	_, leaderRound := consensus.CurrentLeader()
+	assert.Equal(t, uint64(3), leaderRound)
+	assert.Equal(t, leaderRound, consensus.Round())
+
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+
+	height, round, _ := consensus.CurrentState()
+	assert.Equal(t, uint64(10), height)
+	assert.Equal(t, uint64(10), round)
+	assert.Equal(t, height, consensus.Height())
+	_, leaderRound = consensus.CurrentLeader()
+	assert.Equal(t, leaderRound, consensus.Round())
+}
+
+func TestNewObserver(t *testing.T) {
+	config := *createIPCNetworkConfigs(t, 4)[0]
+	config.PrivateKey = nil
+	_, err := NewConsensus(&config)
+	assert.Equal(t, ErrConfigPrivateKey, err)
+
+	config.StateValidate = nil
+	_, err = NewObserver(&config)
+	assert.Equal(t, ErrConfigStateValidate, err)
+
+	config.StateValidate = func(State) bool { return true }
+	var sent int
+	config.MessageOutCallback = func(*Message, *SignedProto) { sent++ }
+	observer, err := NewObserver(&config)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrObserverCannotPropose, observer.TryPropose(State("state")))
+	assert.Equal(t, ErrObserverCannotPropose, observer.CanPropose(State("state")))
+	result := <-observer.ProposeWithResult(State("state"))
+	assert.Equal(t, ProposeRejected, result.Status)
+	assert.Nil(t, observer.Update(time.Now().Add(time.Hour)))
+	assert.Equal(t, 0, sent)
+}
+
+func TestObserverFollowsDecide(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	for _, config := range configs {
+		config.Scheduler = scheduler
+	}
+
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond)
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	// the observer is connected to all participants
+	observerConfig := *configs[0]
+	observerConfig.PrivateKey = nil
+	var sent int
+	observerConfig.MessageOutCallback = func(*Message, *SignedProto) { sent++ }
+	observer, err := NewObserver(&observerConfig)
+	assert.Nil(t, err)
+	op := NewIPCPeer(observer, 100*time.Millisecond)
+	for _, p := range network.Peers() {
+		p.c.Join(op)
+	}
+	op.Update()
+	defer op.Close()
+
+	for _, p := range network.Peers() {
+		data := make([]byte, 1024)
+		_, err := io.ReadFull(rand.Reader, data)
+		assert.Nil(t, err)
+		p.Propose(data)
+	}
+
+	decided := func() bool {
+		for _, p := range append(network.Peers(), op) {
+			if height, _, _ := p.GetLatestState(); height == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; i < 60000 && !decided(); i++ {
+		scheduler.Advance(10 * time.Millisecond)
+	}
+
+	height, _, state := op.GetLatestState()
+	assert.Equal(t, uint64(1), height)
+	_, _, expected := network.Peers()[0].GetLatestState()
+	assert.True(t, bytes.Equal(expected, state))
+	assert.Equal(t, 0, sent)
+}
+
+func TestClose(t *testing.T) {
+	_, sp, privateKey, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+
+	decides, unsubscribe := consensus.Subscribe()
+	pending := consensus.ProposeWithResult(State("pending"))
+	consensus.Close()
+	consensus.Close()
+
+	// subscribers and pending proposals are released
+	_, ok := <-decides
+	assert.False(t, ok)
+	unsubscribe()
+	result := <-pending
+	assert.Equal(t, ProposeRejected, result.Status)
+	rejections, unsubscribe := consensus.SubscribeRejection()
+	_, ok = <-rejections
+	assert.False(t, ok)
+	unsubscribe()
+
+	now := time.Now()
+	assert.Equal(t, ErrConsensusClosed, consensus.ReceiveMessage(bts, now))
+	assert.Equal(t, ErrConsensusClosed, consensus.Update(now))
+	assert.Equal(t, ErrConsensusClosed, consensus.UpdateWithContext(context.Background(), now))
+	assert.Equal(t, ErrConsensusClosed, consensus.TryPropose(State("state")))
+	assert.Equal(t, ErrConsensusClosed, consensus.CanPropose(State("state")))
+	result = <-consensus.ProposeWithResult(State("state"))
+	assert.Equal(t, ProposeRejected, result.Status)
+	assert.Equal(t, ErrConsensusClosed, consensus.Reset(10, State("state"), time.Now()))
+	assert.Equal(t, ErrConsensusClosed, consensus.UpdateParticipants(proofKeys))
+	assert.Equal(t, ErrConsensusClosed, consensus.ValidateMessage(bts))
+	assert.Equal(t, ErrConsensusClosed, consensus.ValidateDecideMessage(bts, nil))
+	_, err = consensus.HasQuorum([][]byte{bts}, State("state"))
+	assert.Equal(t, ErrConsensusClosed, err)
+	_, err = consensus.LatestDecideProof()
+	assert.Equal(t, ErrConsensusClosed, err)
+	_, err = consensus.MarshalState()
+	assert.Equal(t, ErrConsensusClosed, err)
+	assert.Equal(t, ErrConsensusClosed, consensus.UnmarshalState(nil))
+	assert.False(t, consensus.Join(NewIPCPeer(consensus, 0)))
+
+	// states are untouched
+	height, _, _ := consensus.CurrentState()
+	assert.Equal(t, uint64(9), height)
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	consensus := createConsensus(t, 0, 0, randomPublicKeys(t, ConfigMinimumParticipants))
+	consensus.maxMessageSize = 1024
+	rejections, unsubscribe := consensus.SubscribeRejection()
+	defer unsubscribe()
+
+	// a message from unknown participant is rejected after decoding
+	_, signed, _ := createRoundChangeMessageState(t, 1, 0, make([]byte, 2048))
+	oversized, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrMessageTooLarge, consensus.ReceiveMessage(oversized, time.Now()))
+	assert.Equal(t, ErrMessageTooLarge, consensus.ValidateMessage(oversized))
+	assert.Equal(t, ErrMessageTooLarge, consensus.ImportProof(oversized, time.Now()))
+	event := <-rejections
+	assert.Equal(t, ErrMessageTooLarge, event.Err)
+	assert.Nil(t, event.Sender)
+
+	// oversized messages are rejected before allocating decode structures
+	rejected := testing.AllocsPerRun(100, func() {
+		_ = consensus.ReceiveMessage(oversized, time.Now())
+	})
+
+	consensus.maxMessageSize = 0
+	assert.True(t, errors.Is(consensus.ReceiveMessage(oversized, time.Now()), ErrMessageUnknownParticipant))
+	decoded := testing.AllocsPerRun(100, func() {
+		_ = consensus.ReceiveMessage(oversized, time.Now())
+	})
+	assert.True(t, rejected*10 < decoded, "rejected: %v, decoded: %v", rejected, decoded)
+}
+
+func TestOnBecomeLeader(t *testing.T) {
+	consensus := createConsensus(t, 9, 0, randomPublicKeys(t, 4))
+	type leading struct{ height, round uint64 }
+	ch := make(chan leading, 16)
+	consensus.onBecomeLeader = func(height uint64, round uint64) { ch <- leading{height, round} }
+
+	// leader of rounds 5 and 10 out of 5 participants
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, consensus.ForceRoundChange(time.Now()))
+	}
+
+	// height 21 is led at round 0, and called only once
+	assert.Nil(t, consensus.Reset(20, State("20"), time.Now()))
+	assert.Nil(t, consensus.Reset(20, State("20"), time.Now()))
+
+	var calls []leading
+	for len(calls) < 3 {
+		select {
+		case l := <-ch:
+			calls = append(calls, l)
+		case <-time.After(time.Second):
+			t.Fatal("OnBecomeLeader not called")
+		}
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		if calls[i].height != calls[j].height {
+			return calls[i].height < calls[j].height
+		}
+		return calls[i].round < calls[j].round
+	})
+	assert.Equal(t, []leading{{10, 5}, {10, 10}, {21, 0}}, calls)
+
+	select {
+	case l := <-ch:
+		t.Fatal("unexpected OnBecomeLeader", l)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// namedPeer counts messages sent to it, and calls onSend while sending
+type namedPeer struct {
+	name   string
+	count  int
+	onSend func()
+}
+
+func (p *namedPeer) GetPublicKey() *ecdsa.PublicKey { return nil }
+func (p *namedPeer) RemoteAddr() net.Addr           { return fakeAddress(p.name) }
+func (p *namedPeer) Send(msg []byte) error {
+	p.count++
+	if p.onSend != nil {
+		p.onSend()
+	}
+	return nil
+}
+
+func TestPeerSet(t *testing.T) {
+	consensus := createConsensus(t, 0, 0, randomPublicKeys(t, 4))
+	a, b, c, d := &namedPeer{name: "a"}, &namedPeer{name: "b"}, &namedPeer{name: "c"}, &namedPeer{name: "d"}
+	counts := func() []int { return []int{a.count, b.count, c.count, d.count} }
+
+	assert.True(t, consensus.AddPeer(a))
+	assert.True(t, consensus.AddPeer(b))
+	assert.True(t, consensus.AddPeer(c))
+	assert.False(t, consensus.AddPeer(a))
+	assert.False(t, consensus.RemovePeer(d))
+	assert.Equal(t, []PeerInterface{a, b, c}, consensus.Peers())
+
+	consensus.broadcast(&Message{Type: MessageType_Nop}, time.Now())
+	assert.Equal(t, []int{1, 1, 1, 0}, counts())
+
+	assert.True(t, consensus.RemovePeer(b))
+	assert.False(t, consensus.RemovePeer(b))
+	consensus.broadcast(&Message{Type: MessageType_Nop}, time.Now())
+	assert.Equal(t, []int{2, 1, 2, 0}, counts())
+
+	// changes while sending take effect from the next message
+	a.onSend = func() {
+		assert.True(t, consensus.RemovePeer(a))
+		assert.True(t, consensus.RemovePeer(c))
+		assert.True(t, consensus.AddPeer(d))
+	}
+	consensus.broadcast(&Message{Type: MessageType_Nop}, time.Now())
+	assert.Equal(t, []int{3, 1, 3, 0}, counts())
+	assert.Equal(t, []PeerInterface{d}, consensus.Peers())
+
+	consensus.broadcast(&Message{Type: MessageType_Nop}, time.Now())
+	assert.Equal(t, []int{3, 1, 3, 1}, counts())
+
+	// removed by address
+	assert.True(t, consensus.Leave(fakeAddress("d")))
+	assert.Empty(t, consensus.Peers())
+}
+
+func TestRoundStartTime(t *testing.T) {
+	consensus := createConsensus(t, 9, 0, randomPublicKeys(t, 4))
+	scheduler := timer.NewManualScheduler(time.Now())
+	consensus.scheduler = scheduler
+	assert.False(t, consensus.RoundStartTime().IsZero())
+
+	// resets on each round transition
+	for round := uint64(1); round <= 3; round++ {
+		scheduler.Advance(time.Second)
+		assert.Nil(t, consensus.ForceRoundChange(scheduler.Now()))
+		_, leading := consensus.CurrentLeader()
+		assert.Equal(t, round, leading)
+		assert.Equal(t, scheduler.Now(), consensus.RoundStartTime())
+
+		p := NewIPCPeer(consensus, time.Millisecond)
+		r, start := p.GetRoundStartTime()
+		assert.Equal(t, round, r)
+		assert.Equal(t, scheduler.Now(), start)
+	}
+
+	// and at the next height
+	scheduler.Advance(time.Second)
+	assert.Nil(t, consensus.Reset(20, State("20"), scheduler.Now()))
+	assert.Equal(t, scheduler.Now(), consensus.RoundStartTime())
+}
+
+func TestLeaderForRound(t *testing.T) {
+	consensus := createConsensus(t, 9, 0, randomPublicKeys(t, 4))
+
+	// rotates through participants in order of rounds
+	n := uint64(len(consensus.participants))
+	for round := uint64(0); round < 3*n; round++ {
+		leader, err := consensus.LeaderForRound(10, round)
+		assert.Nil(t, err)
+		assert.Equal(t, consensus.participants[round%n], consensus.pubKeyToIdentity(leader))
+	}
+	leader, round := consensus.CurrentLeader()
+	expected, err := consensus.LeaderForRound(10, round)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, leader)
+
+	// state is not changed
+	assert.Equal(t, uint64(9), consensus.Height())
+	_, round = consensus.CurrentLeader()
+	assert.Equal(t, uint64(0), round)
+
+	_, err = consensus.LeaderForRound(9, 0)
+	assert.Equal(t, ErrLeaderHeight, err)
+	_, err = consensus.LeaderForRound(11, 0)
+	assert.Equal(t, ErrLeaderHeight, err)
+
+	// identities not on the curve
+	consensus.pubKeyToIdentity = func(pubkey *ecdsa.PublicKey) (ret Identity) { return }
+	consensus.participants = []Identity{{}}
+	_, err = consensus.LeaderForRound(10, 0)
+	assert.Equal(t, ErrLeaderPublicKey, err)
+}
+
+func TestPause(t *testing.T) {
+	consensus := createConsensus(t, 9, 0, randomPublicKeys(t, 4))
+	consensus.pauseBufferSize = 2
+	consensus.Pause()
+	assert.True(t, consensus.Paused())
+	assert.Equal(t, ErrConsensusPaused, consensus.TryPropose(State("10")))
+	assert.Equal(t, ErrConsensusPaused, consensus.ProposeAt(10, State("10")))
+	assert.Equal(t, ProposeRejected, (<-consensus.ProposeWithResult(State("10"))).Status)
+
+	// buffered up to the cap
+	var msgs [][]byte
+	for i := 0; i < 3; i++ {
+		_, signed, _ := createRoundChangeMessageState(t, 10, 0, State(fmt.Sprint(i)))
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		msgs = append(msgs, bts)
+	}
+	assert.Nil(t, consensus.ReceiveMessage(msgs[0], time.Now()))
+	assert.Nil(t, consensus.ReceiveMessage(msgs[1], time.Now()))
+	assert.Equal(t, ErrPauseBufferFull, consensus.ReceiveMessage(msgs[2], time.Now()))
+	assert.Nil(t, consensus.Update(time.Now().Add(time.Hour)))
+	assert.Equal(t, 0, consensus.currentRound.NumRoundChanges())
+
+	// processed in arrival order on resume
+	consensus.AddParticipant(signerKey(t, msgs[0]))
+	consensus.AddParticipant(signerKey(t, msgs[1]))
+	consensus.numIdentities = countIdentities(consensus.participants)
+	consensus.Resume(time.Now())
+	assert.False(t, consensus.Paused())
+	assert.Equal(t, []State{State("0"), State("1")}, consensus.currentRound.RoundChangeStates())
+	assert.Nil(t, consensus.TryPropose(State("10")))
+}
+
+// signerKey returns the public key of the signer of a serialized message
+func signerKey(t *testing.T, bts []byte) *ecdsa.PublicKey {
+	signed, err := DecodeSignedMessage(bts)
+	assert.Nil(t, err)
+	return signed.PublicKey(S256Curve)
+}
+
+func TestCommitProgress(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	consensus := createConsensus(t, 0, 0, participants)
+	consensus.numIdentities = countIdentities(consensus.participants)
+	have, need := consensus.CommitProgress()
+	assert.Equal(t, 0, have)
+	assert.Equal(t, QuorumSize(5), need)
+
+	// I'm the leader of round 0, and have locked the state
+	state := State("1")
+	consensus.currentRound.Stage = stageCommit
+	consensus.currentRound.LockedState = state
+	consensus.currentRound.LockedStateHash = consensus.stateHash(state)
+
+	deliver := func(key *ecdsa.PrivateKey, s State) {
+		_, signed, _ := createCommitMessageSigner(t, 1, 0, s, key)
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		_ = consensus.ReceiveMessage(bts, time.Now())
+	}
+
+	// commits to other states and duplicates are not counted
+	deliver(keys[0], State("2"))
+	deliver(keys[1], state)
+	deliver(keys[1], state)
+	have, _ = consensus.CommitProgress()
+	assert.Equal(t, 1, have)
+
+	// climbs to the quorum one at a time, then decides
+	for k := 2; k < need; k++ {
+		deliver(keys[k], state)
+		have, _ = consensus.CommitProgress()
+		assert.Equal(t, k, have)
+	}
+	deliver(keys[need], state)
+	assert.Equal(t, uint64(1), consensus.Height())
+	have, _ = consensus.CommitProgress()
+	assert.Equal(t, 0, have)
+}
+
+func TestOnBeforeDecide(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	decides := createDecideChain(t, keys, 10)
+	consensus := createConsensus(t, 9, 0, participants)
+	consensus.SetLeader(&keys[0].PublicKey)
+
+	// held by the application
+	hold := errors.New("regulatory hold")
+	var height, round uint64
+	var state State
+	consensus.onBeforeDecide = func(h uint64, r uint64, s State) error {
+		height, round, state = h, r, s
+		return hold
+	}
+	err := consensus.ReceiveMessage(decides[0], time.Now())
+	assert.True(t, errors.Is(err, ErrDecideVetoed))
+	assert.Equal(t, uint64(10), height)
+	assert.Equal(t, uint64(0), round)
+	assert.Equal(t, State{10}, state)
+	assert.Equal(t, uint64(9), consensus.Height())
+
+	// accepted once the hold is removed
+	consensus.onBeforeDecide = nil
+	assert.Nil(t, consensus.ReceiveMessage(decides[0], time.Now()))
+	assert.Equal(t, uint64(10), consensus.Height())
+}
+
+func TestOnBeforeDecideLeader(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	consensus := createConsensus(t, 0, 0, participants)
+	consensus.numIdentities = countIdentities(consensus.participants)
+	state := State("1")
+	consensus.currentRound.Stage = stageCommit
+	consensus.currentRound.LockedState = state
+	consensus.currentRound.LockedStateHash = consensus.stateHash(state)
+
+	var sent []*Message
+	consensus.messageOutCallback = func(m *Message, signed *SignedProto) { sent = append(sent, m) }
+	consensus.onBeforeDecide = func(h uint64, r uint64, s State) error { return errors.New("hold") }
+
+	var errs []error
+	for _, key := range keys {
+		_, signed, _ := createCommitMessageSigner(t, 1, 0, state, key)
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		errs = append(errs, consensus.ReceiveMessage(bts, time.Now()))
+	}
+
+	// vetoed once quorum reached, and no <decide> broadcasted
+	assert.Nil(t, errs[0])
+	assert.True(t, errors.Is(errs[2], ErrDecideVetoed))
+	assert.Equal(t, uint64(0), consensus.Height())
+	for _, m := range sent {
+		assert.NotEqual(t, MessageType_Decide, m.Type)
+	}
+}
+
+func TestLoopbackSelfSigned(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	consensus, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+	consensus.loopback = nil
+	consensus.selfSigned = nil
+
+	// my own <roundchange> is applied without verifying my signature
+	consensus.broadcast(&Message{Type: MessageType_RoundChange, Height: 1, Round: 0, State: State("mine")}, time.Now())
+	verified := consensus.numSignaturesVerified
+	assert.Nil(t, consensus.Update(time.Now()))
+	assert.Equal(t, verified, consensus.numSignaturesVerified)
+	assert.Equal(t, 1, consensus.currentRound.NumRoundChanges())
+	assert.Empty(t, consensus.selfSigned)
+
+	// messages signed by my key but not sent by myself, e.g. proofs in a
+	// <resync>, are verified
+	_, signed, _ := createRoundChangeMessageSigner(t, 1, 0, State("mine again"), configs[0].PrivateKey)
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	consensus.loopback = append(consensus.loopback, bts)
+	assert.Nil(t, consensus.Update(time.Now()))
+	assert.Equal(t, verified+1, consensus.numSignaturesVerified)
+
+	// messages signed by others are always verified
+	_, signed, _ = createRoundChangeMessageSigner(t, 1, 0, State("theirs"), configs[1].PrivateKey)
+	bts, err = proto.Marshal(signed)
+	assert.Nil(t, err)
+	consensus.loopbackSelf(bts)
+	assert.Nil(t, consensus.Update(time.Now()))
+	assert.Equal(t, verified+2, consensus.numSignaturesVerified)
+	assert.Equal(t, 2, consensus.currentRound.NumRoundChanges())
+
+	// and rejected if tampered
+	_, signed, _ = createRoundChangeMessageSigner(t, 1, 0, State("forged"), configs[2].PrivateKey)
+	signed.S[len(signed.S)-1] ^= 0x01
+	bts, err = proto.Marshal(signed)
+	assert.Nil(t, err)
+	consensus.loopbackSelf(bts)
+	assert.Nil(t, consensus.Update(time.Now()))
+	assert.Equal(t, 2, consensus.currentRound.NumRoundChanges())
+
+	// buffers reused for other messages are not trusted
+	consensus.broadcast(&Message{Type: MessageType_RoundChange, Height: 1, Round: 0, State: State("mine")}, time.Now())
+	assert.Len(t, consensus.loopback, 1)
+	out := consensus.loopback[0]
+	consensus.loopback = nil
+	for bts = nil; len(bts) != len(out); {
+		_, signed, _ = createRoundChangeMessageSigner(t, 1, 0, State("used"), configs[3].PrivateKey)
+		bts, err = proto.Marshal(signed)
+		assert.Nil(t, err)
+	}
+	buf := append(out[:0], bts...)
+	assert.True(t, &buf[0] == &out[0])
+	verified = consensus.numSignaturesVerified
+	assert.Nil(t, consensus.ReceiveMessage(buf, time.Now()))
+	assert.Equal(t, verified+1, consensus.numSignaturesVerified)
+	assert.Equal(t, 3, consensus.currentRound.NumRoundChanges())
+
+	// and hashes of dropped loopbacks are forgotten at the next height
+	assert.Len(t, consensus.selfSigned, 1)
+	consensus.resetStates(1, 0, State("decided"), time.Now())
+	assert.Empty(t, consensus.selfSigned)
+}
+
+func benchmarkDecodeMessageSigned(b *testing.B, selfSigned bool) {
+	configs := createIPCNetworkConfigs(b, 4)
+	consensus, err := NewConsensus(configs[0])
+	assert.Nil(b, err)
+	_, signed, _ := createRoundChangeMessageSigner(b, 1, 0, State("mine"), configs[0].PrivateKey)
+	bts, err := proto.Marshal(signed)
+	assert.Nil(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := consensus.decodeMessageSigned(bts, selfSigned); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeSelfMessageVerified(b *testing.B) {
+	benchmarkDecodeMessageSigned(b, false)
+}
+
+func BenchmarkDecodeSelfMessageTrusted(b *testing.B) {
+	benchmarkDecodeMessageSigned(b, true)
+}
+
+func TestRejectEarly(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	consensus := createConsensus(t, 10, 0, participants)
+	consensus.SetLeader(&keys[0].PublicKey)
+
+	marshal := func(signed *SignedProto, tamper bool) []byte {
+		if tamper {
+			signed.R = signed.S
+		}
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		return bts
+	}
+
+	// stale messages are rejected with the errors of their types before any
+	// signature is verified, even if tampered
+	for _, tamper := range []bool{false, true} {
+		_, signed, _ := createRoundChangeMessageSigner(t, 10, 0, State("10"), keys[1])
+		err := consensus.ReceiveMessage(marshal(signed, tamper), time.Now())
+		assert.True(t, errors.Is(err, ErrRoundChangeHeightMismatch))
+
+		_, signed, _ = createCommitMessageSigner(t, 9, 0, State("9"), keys[1])
+		err = consensus.ReceiveMessage(marshal(signed, tamper), time.Now())
+		assert.True(t, errors.Is(err, ErrCommitHeightMismatch))
+	}
+	err := consensus.ReceiveMessage(createDecideChain(t, keys, 10)[0], time.Now())
+	assert.True(t, errors.Is(err, ErrDecideHeightLower))
+	assert.Equal(t, uint64(0), consensus.numSignaturesVerified)
+
+	// far-future messages too
+	_, signed, _ := createRoundChangeMessageSigner(t, 12+consensus.maxFutureHeight, 0, State("far"), keys[1])
+	err = consensus.ReceiveMessage(marshal(signed, false), time.Now())
+	assert.True(t, errors.Is(err, ErrMessageFutureHeightExceeded))
+	assert.Equal(t, uint64(1), consensus.numFutureHeightDropped)
+	assert.Equal(t, uint64(0), consensus.numSignaturesVerified)
+
+	// messages at the next height are verified
+	_, signed, _ = createRoundChangeMessageSigner(t, 11, 0, State("11"), keys[1])
+	assert.Nil(t, consensus.ReceiveMessage(marshal(signed, false), time.Now()))
+	assert.Equal(t, uint64(1), consensus.numSignaturesVerified)
+}
+
+func BenchmarkReceiveStaleDecide(b *testing.B) {
+	keys, participants := createDecideChainKeys(b, 20)
+	consensus := createConsensus(b, 10, 0, participants)
+	consensus.SetLeader(&keys[0].PublicKey)
+	bts := createDecideChain(b, keys, 10)[0]
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := consensus.ReceiveMessage(bts, time.Now()); !errors.Is(err, ErrDecideHeightLower) {
+			b.Fatal(err)
+		}
+	}
+}
+
+// the verification a stale <decide> message took before rejected by height
+func BenchmarkVerifyStaleDecide(b *testing.B) {
+	keys, participants := createDecideChainKeys(b, 20)
+	consensus := createConsensus(b, 10, 0, participants)
+	consensus.SetLeader(&keys[0].PublicKey)
+	bts := createDecideChain(b, keys, 10)[0]
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		signed, m, err := consensus.decodeMessage(bts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		consensus.latestHeight = 9
+		err = consensus.verifyDecideMessage(m, signed)
+		consensus.latestHeight = 10
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/Sperax/bdls/crypto/blake2b"
+)
+
+const (
+	// DefaultDedupCacheSize is the default number of processed messages
+	// remembered to short-circuit duplicates
+	DefaultDedupCacheSize = 4096
+)
+
+// dedupCache is a bounded LRU set of message hashes, it's safe for
+// concurrent use.
+type dedupCache struct {
+	size  int
+	order list.List // front is the most recently used
+	index map[[blake2b.Size256]byte]*list.Element
+	hits  uint64
+	mu    sync.Mutex
+}
+
+// newDedupCache creates a LRU cache remembering at most size messages
+func newDedupCache(size int) *dedupCache {
+	d := new(dedupCache)
+	d.size = size
+	d.index = make(map[[blake2b.Size256]byte]*list.Element)
+	return d
+}
+
+// Seen checks if the message has been added, a hit counts as a duplicate
+func (d *dedupCache) Seen(key [blake2b.Size256]byte) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	elem, ok := d.index[key]
+	if !ok {
+		return false
+	}
+	d.order.MoveToFront(elem)
+	d.hits++
+	return true
+}
+
+// Add remembers the message, the least recently used one will be evicted
+// if the cache is full.
+func (d *dedupCache) Add(key [blake2b.Size256]byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if elem, ok := d.index[key]; ok {
+		d.order.MoveToFront(elem)
+		return
+	}
+
+	d.index[key] = d.order.PushFront(key)
+	if d.order.Len() > d.size {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.index, oldest.Value.([blake2b.Size256]byte))
+	}
+}
+
+// Len returns the number of messages remembered
+func (d *dedupCache) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.order.Len()
+}
+
+// Hits returns the number of duplicates detected
+func (d *dedupCache) Hits() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.hits
+}
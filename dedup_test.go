@@ -0,0 +1,76 @@
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sperax/bdls/crypto/blake2b"
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupCacheEviction(t *testing.T) {
+	d := newDedupCache(2)
+	k1 := blake2b.Sum256([]byte{1})
+	k2 := blake2b.Sum256([]byte{2})
+	k3 := blake2b.Sum256([]byte{3})
+
+	d.Add(k1)
+	d.Add(k2)
+	assert.True(t, d.Seen(k1)) // k1 becomes the most recently used
+	d.Add(k3)                  // k2 evicted
+	assert.Equal(t, 2, d.Len())
+	assert.False(t, d.Seen(k2))
+	assert.True(t, d.Seen(k1))
+	assert.True(t, d.Seen(k3))
+	assert.Equal(t, uint64(3), d.Hits())
+}
+
+func TestDedupCacheConcurrent(t *testing.T) {
+	d := newDedupCache(100)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := blake2b.Sum256([]byte{byte(i), byte(j)})
+				d.Add(key)
+				d.Seen(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 100, d.Len())
+}
+
+func TestReceiveMessageDuplicated(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+	consensus := createConsensus(t, 0, 0, []*ecdsa.PublicKey{&privateKey.PublicKey})
+
+	state := make([]byte, 1024)
+	_, err = io.ReadFull(rand.Reader, state)
+	assert.Nil(t, err)
+	_, signed, _ := createRoundChangeMessageSigner(t, 1, 0, state, privateKey)
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	}
+	assert.Equal(t, uint64(2), consensus.Metrics(time.Now()).NumDuplicateMessages)
+
+	// rejected messages are not remembered
+	_, signed, _ = createRoundChangeMessageSigner(t, 100, 0, state, privateKey)
+	bts, err = proto.Marshal(signed)
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		assert.NotNil(t, consensus.ReceiveMessage(bts, time.Now()))
+	}
+	assert.Equal(t, uint64(2), consensus.Metrics(time.Now()).NumDuplicateMessages)
+}
@@ -9,4 +9,30 @@
 //
 // As it's a pure algorithm implementation, it's not thread-safe! Users of this library
 // should take care of their own sychronziation mechanism.
+//
+// On wire, messages are protobuf encoded as defined in message.proto, a Message is
+// encoded in SignedProto.Message, and the signature covers these bytes as they
+// are, so verifiers in other languages hash the bytes received rather than
+// re-encoding the message. Only the canonical encoding of a Message is
+// accepted: fields in ascending order of field numbers, default values
+// omitted, and Type, Height and Round in minimal unsigned varints(base 128,
+// least significant group first), e.g. height 300 is encoded as
+// 0x10 0xac 0x02. The signature is the ECDSA signature of the blake2b-256
+// digest(or Config.Hasher) of:
+//
+//	"BDLS_CONSENSUS_SIGNATURE" | version(uint32, little-endian) | X(32 bytes) |
+//	Y(32 bytes) | len(Message)(uint32, little-endian) | Message
+//
+// X and Y are the big-endian coordinates of the signer's public key, the
+// layout is pinned by the files in testdata/golden.
+//
+// Distinct states equal under Config.StateCompare are ordered by their
+// proposers in the proofs of <select> and <lock> messages, see
+// Config.ProposalTieBreak, and messages selecting a state which is not the
+// maximal one by this order are rejected with ErrSelectProofNotTheMaximal or
+// ErrLockProofNotTheMaximal. It's a breaking change of the consensus rules
+// without a change of the wire format or the protocol version: releases
+// without the tie-break select the first of equal states received, and their
+// <select> and <lock> messages may be rejected by upgraded participants,
+// so all participants must be upgraded together.
 package bdls
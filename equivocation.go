@@ -0,0 +1,113 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"crypto/ecdsa"
+)
+
+// checkEquivocation checks a verified <lock> message against the first
+// <lock> message accepted in the same round, conflicting messages from the
+// leader will be notified to subscribers and rejected.
+func (c *Consensus) checkEquivocation(m *Message, signed *SignedProto) error {
+	mHash := c.stateHash(m.State)
+	first, ok := c.leaderLocks[m.Round]
+	if !ok {
+		if c.leaderLocks == nil {
+			c.leaderLocks = make(map[uint64]messageTuple)
+		}
+		c.leaderLocks[m.Round] = messageTuple{StateHash: mHash, Message: m, Signed: signed}
+		return nil
+	}
+
+	if first.StateHash == mHash {
+		return nil
+	}
+
+	c.notifyEquivocation(EquivocationEvent{
+		Height: m.Height,
+		Round:  m.Round,
+		Leader: c.pubKeyToIdentity(signed.PublicKey(c.curve)),
+		First:  first.Signed,
+		Second: signed,
+	})
+	return ErrLeaderEquivocation
+}
+
+// VerifyEquivocation verifies the evidence of a leader signing two different
+// <lock> messages for the same height and round, such as in EquivocationEvent,
+// participants must be in the same order as Config.Participants to locate
+// the round leader, a nil error means the equivocation has been proven.
+func VerifyEquivocation(participants []*ecdsa.PublicKey, first *SignedProto, second *SignedProto) error {
+	if len(participants) == 0 {
+		return ErrConfigParticipants
+	}
+
+	// a verifier for signatures
+	c := new(Consensus)
+	c.curve = S256Curve
+	c.pubKeyToIdentity = DefaultPubKeyToIdentity
+	c.stateHash = defaultHash
+	c.stateCodec = RawStateCodec{}
+	for _, pubkey := range participants {
+		c.participants = append(c.participants, c.pubKeyToIdentity(pubkey))
+	}
+
+	m1, err := c.verifyMessage(first)
+	if err != nil {
+		return err
+	}
+	m2, err := c.verifyMessage(second)
+	if err != nil {
+		return err
+	}
+
+	if m1.Type != MessageType_Lock || m2.Type != MessageType_Lock {
+		return ErrEquivocationMessageType
+	}
+
+	if m1.Height != m2.Height || m1.Round != m2.Round {
+		return ErrEquivocationHeightRoundMismatch
+	}
+
+	// both messages must be signed by the leader of the round
+	leaderKey := c.roundLeader(m1.Round)
+	if c.pubKeyToIdentity(first.PublicKey(c.curve)) != leaderKey ||
+		c.pubKeyToIdentity(second.PublicKey(c.curve)) != leaderKey {
+		return ErrEquivocationSignerMismatch
+	}
+
+	if c.stateHash(m1.State) == c.stateHash(m2.State) {
+		return ErrEquivocationSameState
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// createLeaderLockMessage creates a <lock> message signed by leader, with
+// <roundchange> proofs from signers on state
+func createLeaderLockMessage(t *testing.T, leader *ecdsa.PrivateKey, signers []*ecdsa.PrivateKey, state State, height uint64, round uint64) *SignedProto {
+	m := new(Message)
+	m.Type = MessageType_Lock
+	m.Height = height
+	m.Round = round
+	m.State = state
+	for _, key := range signers {
+		_, signedRc, _ := createRoundChangeMessageSigner(t, height, round, state, key)
+		m.Proof = append(m.Proof, signedRc)
+	}
+
+	signed := new(SignedProto)
+	signed.Sign(m, leader)
+	return signed
+}
+
+// createEquivocation creates 4 participants with the leader of round at
+// the right position, and two conflicting <lock> messages from the leader.
+func createEquivocation(t *testing.T, height uint64, round uint64) ([]*ecdsa.PrivateKey, *SignedProto, *SignedProto) {
+	var keys []*ecdsa.PrivateKey
+	for i := 0; i < ConfigMinimumParticipants; i++ {
+		key, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+		assert.Nil(t, err)
+		keys = append(keys, key)
+	}
+	leader := keys[int(round)%len(keys)]
+
+	stateA := make([]byte, 1024)
+	_, err := io.ReadFull(rand.Reader, stateA)
+	assert.Nil(t, err)
+	stateB := make([]byte, 1024)
+	_, err = io.ReadFull(rand.Reader, stateB)
+	assert.Nil(t, err)
+
+	first := createLeaderLockMessage(t, leader, keys[:3], stateA, height, round)
+	second := createLeaderLockMessage(t, leader, keys[1:], stateB, height, round)
+	return keys, first, second
+}
+
+func publicKeys(keys []*ecdsa.PrivateKey) []*ecdsa.PublicKey {
+	var pubkeys []*ecdsa.PublicKey
+	for _, key := range keys {
+		pubkeys = append(pubkeys, &key.PublicKey)
+	}
+	return pubkeys
+}
+
+func TestLeaderEquivocation(t *testing.T) {
+	keys, first, second := createEquivocation(t, 1, 1)
+	leader := keys[1]
+
+	consensus := createConsensus(t, 0, 1, publicKeys(keys))
+	consensus.SetLeader(&leader.PublicKey)
+	events, unsubscribe := consensus.SubscribeEquivocation()
+	defer unsubscribe()
+
+	bts, err := proto.Marshal(first)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+
+	// the same <lock> message is not an equivocation
+	consensus.dedup = nil
+	assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	assert.Equal(t, 0, len(events))
+
+	bts, err = proto.Marshal(second)
+	assert.Nil(t, err)
+	err = consensus.ReceiveMessage(bts, time.Now())
+	assert.True(t, errors.Is(err, ErrLeaderEquivocation))
+
+	event := <-events
+	assert.Equal(t, uint64(1), event.Height)
+	assert.Equal(t, uint64(1), event.Round)
+	assert.Equal(t, DefaultPubKeyToIdentity(&leader.PublicKey), event.Leader)
+	assert.Equal(t, first, event.First)
+	assert.Equal(t, second, event.Second)
+
+	// the evidence is verifiable without consensus
+	assert.Nil(t, VerifyEquivocation(publicKeys(keys), event.First, event.Second))
+}
+
+func TestLeaderEquivocationNewHeight(t *testing.T) {
+	keys, first, _ := createEquivocation(t, 1, 1)
+	consensus := createConsensus(t, 0, 1, publicKeys(keys))
+	consensus.SetLeader(&keys[1].PublicKey)
+
+	bts, err := proto.Marshal(first)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	assert.Equal(t, 1, len(consensus.leaderLocks))
+
+	consensus.heightSync(1, 1, State("decided"), time.Now())
+	assert.Nil(t, consensus.leaderLocks)
+}
+
+func TestVerifyEquivocation(t *testing.T) {
+	keys, first, second := createEquivocation(t, 1, 1)
+	participants := publicKeys(keys)
+	assert.Nil(t, VerifyEquivocation(participants, first, second))
+	assert.Nil(t, VerifyEquivocation(participants, second, first))
+
+	// no participants
+	assert.Equal(t, ErrConfigParticipants, VerifyEquivocation(nil, first, second))
+
+	// same state
+	assert.Equal(t, ErrEquivocationSameState, VerifyEquivocation(participants, first, first))
+
+	// unknown participants
+	others := []*ecdsa.PublicKey{participants[0], participants[2], participants[3]}
+	assert.Equal(t, ErrMessageUnknownParticipant, VerifyEquivocation(others, first, second))
+
+	// tampered signature
+	tampered := *second
+	tampered.R[0] ^= 0xff
+	assert.Equal(t, ErrMessageSignature, VerifyEquivocation(participants, first, &tampered))
+
+	// not <lock>
+	_, rc, _ := createRoundChangeMessageSigner(t, 1, 1, State("state"), keys[1])
+	assert.Equal(t, ErrEquivocationMessageType, VerifyEquivocation(participants, first, rc))
+
+	// another round
+	another := createLeaderLockMessage(t, keys[1], keys[:3], State("state"), 1, 5)
+	assert.Equal(t, ErrEquivocationHeightRoundMismatch, VerifyEquivocation(participants, first, another))
+
+	// not signed by the leader of the round
+	notLeader := createLeaderLockMessage(t, keys[2], keys[:3], State("state"), 1, 1)
+	assert.Equal(t, ErrEquivocationSignerMismatch, VerifyEquivocation(participants, first, notLeader))
+}
@@ -37,18 +37,37 @@ var (
 	ErrConfigEpoch              = errors.New("Config.Epoch is nil")
 	ErrConfigStateNil           = errors.New("Config.CurrentState is nil")
 	ErrConfigStateCompare       = errors.New("Config.StateCompare function has not set")
+	ErrConfigStateCompareOrder  = errors.New("Config.StateCompare function is not a strict total order")
 	ErrConfigStateValidate      = errors.New("Config.StateValidate function has not set")
 	ErrConfigPrivateKey         = errors.New("Config.PrivateKey has not set")
+	ErrConfigSigner             = errors.New("Config.Signer must have a public key, and cannot be set along with Config.PrivateKey")
 	ErrConfigParticipants       = errors.New("Config.Participants must contain at least 4 participants")
 	ErrConfigPubKeyToCoordinate = errors.New("Config.must contain at least 4 participants")
+	ErrConfigRoundChangeTimeout = errors.New("Config.RoundChangeMaxTimeout is less than Config.RoundChangeBaseTimeout")
+	ErrConfigParticipantWeights = errors.New("Config.ParticipantWeights must be positive for every participant, and sum within MaxTotalParticipantWeight")
+	ErrConfigRateLimit          = errors.New("Config.PerParticipantRateLimit and Config.PerParticipantRateBurst must not be negative")
+	ErrConfigPipelineDepth      = errors.New("Config.PipelineDepth must not be negative, nor exceed Config.MaxFutureHeight+1")
+	ErrConfigGenesisHeight      = errors.New("Config.CurrentHeight is lower than Config.GenesisHeight")
+	ErrConfigCurrentState       = errors.New("Config.CurrentState failed the state validation")
+	ErrConfigSendRetry          = errors.New("Config.SendRetry must have at least 1 attempt, and non-negative backoffs")
 
 	// common errors related to every message
-	ErrMessageVersion            = errors.New("the message has different version")
-	ErrMessageValidator          = errors.New("the message has been rejected by external validator")
-	ErrMessageIsEmpty            = errors.New("the message being verified is empty")
-	ErrMessageUnknownMessageType = errors.New("unrecognized message type")
-	ErrMessageSignature          = errors.New("cannot verify the signature of this message")
-	ErrMessageUnknownParticipant = errors.New("the message is from unknown partcipants")
+	ErrMessageVersion              = errors.New("the message has different version")
+	ErrMessageValidator            = errors.New("the message has been rejected by external validator")
+	ErrMessageIsEmpty              = errors.New("the message being verified is empty")
+	ErrMessageUnknownMessageType   = errors.New("unrecognized message type")
+	ErrMessageSignature            = errors.New("cannot verify the signature of this message")
+	ErrMessageSignatureMalleable   = errors.New("the signature of this message is not in low-S form")
+	ErrMessageUnknownParticipant   = errors.New("the message is from unknown partcipants")
+	ErrMessageFutureHeightExceeded = errors.New("the message has height beyond the maximum future height")
+	ErrMessageMalformed            = errors.New("the message cannot be decoded")
+	ErrMessageNotCanonical         = errors.New("the message is not in canonical encoding")
+	ErrMessageReplay               = errors.New("the message is an exact replay of an accepted message")
+	ErrMessageFrameFlag            = errors.New("the message has unknown compression flag")
+	ErrMessageRateLimited          = errors.New("the message exceeded the rate limit of the participant")
+	ErrMessageTooLarge             = errors.New("the message exceeds the maximum message size")
+	ErrProofSetTooLarge            = errors.New("the message has more proofs than allowed")
+	ErrProofDuplicateParticipant   = errors.New("the message has more than one proof from a participant")
 
 	// <roundchange> related
 	ErrRoundChangeHeightMismatch  = errors.New("the <roundchange> message has another height than expected")
@@ -67,6 +86,7 @@ var (
 	ErrLockProofRoundMismatch      = errors.New("the proofs in <lock> message has mismatched round")
 	ErrLockProofStateValidation    = errors.New("the proofs in <lock> message has invalid state data")
 	ErrLockProofInsufficient       = errors.New("the <lock> message has insufficient <roundchange> proofs to the proposed state")
+	ErrLockProofNotTheMaximal      = errors.New("the locked state is not the maximal one reaching quorum in the <lock> message")
 
 	// <select> related
 	ErrSelectStateValidation         = errors.New("the state data validation failed <select> message")
@@ -85,6 +105,7 @@ var (
 
 	// <decide> Related
 	ErrDecideHeightLower             = errors.New("the <decide> message has lower height than expected")
+	ErrDecideHeightGap               = errors.New("the <decide> message has higher height than expected")
 	ErrDecideEmptyState              = errors.New("the state is empty in <decide> message")
 	ErrDecideStateValidation         = errors.New("the state data validation failed <decide> message")
 	ErrDecideNotSignedByLeader       = errors.New("the <decide> message is not signed by leader")
@@ -94,6 +115,7 @@ var (
 	ErrDecideProofRoundMismatch      = errors.New("the proofs in <decide> message has mismatched round")
 	ErrDecideProofStateValidation    = errors.New("the proofs in <decide> message has invalid state data")
 	ErrDecideProofInsufficient       = errors.New("the <decide> message has insufficient <commit> proofs to the proposed state")
+	ErrDecideVetoed                  = errors.New("the decided state has been vetoed by Config.OnBeforeDecide")
 
 	// <lock-release> related
 	ErrLockReleaseStatus = errors.New("received <lock-release> message in non LOCK-RELEASE state")
@@ -105,7 +127,72 @@ var (
 	ErrCommitStatus          = errors.New("received <commit> message in non COMMIT state")
 	ErrCommitHeightMismatch  = errors.New("the <commit> messge has another height than expected")
 	ErrCommitRoundMismatch   = errors.New("the <commit> message is from another round")
+	ErrCommitMessageType     = errors.New("the message is not a <commit> message")
+
+	// equivocation related
+	ErrLeaderEquivocation              = errors.New("the leader has signed conflicting <lock> messages in the same round")
+	ErrEquivocationMessageType         = errors.New("the evidence of equivocation is not <lock> message")
+	ErrEquivocationHeightRoundMismatch = errors.New("the evidence of equivocation has mismatched height or round")
+	ErrEquivocationSignerMismatch      = errors.New("the evidence of equivocation is not signed by the same leader")
+	ErrEquivocationSameState           = errors.New("the evidence of equivocation has the same state")
+
+	// Signer related
+	ErrSignatureSize = errors.New("the signature from Signer is not R || S in SizeSignature bytes")
+
+	// proposal related
+	ErrObserverCannotPropose = errors.New("the observer cannot propose states")
+	ErrProposeEmptyState     = errors.New("the state being proposed is empty")
+	ErrProposeHeight         = errors.New("the height proposed for is not in the pipeline window")
+
+	// QuorumCertificate related
+	ErrHeightNotRetained = errors.New("the quorum certificate of the height is not retained")
+
+	// LeaderForRound related
+	ErrLeaderHeight    = errors.New("the leader can only be computed for the height in progress")
+	ErrLeaderPublicKey = errors.New("the identity of the leader cannot be converted to a public key")
+
+	// Reset related
+	ErrResetWhileDeciding = errors.New("cannot reset consensus while a <decide> message is being processed")
+
+	// ForceRoundChange related
+	ErrForceRoundChangeCommit    = errors.New("cannot force round change while committing a state")
+	ErrObserverCannotRoundChange = errors.New("the observer cannot change rounds")
+
+	// ImportProof related
+	ErrImportProofType = errors.New("only <lock> or <select> message can be imported as proof")
+
+	// Pause related
+	ErrConsensusPaused = errors.New("the consensus has been paused")
+	ErrPauseBufferFull = errors.New("the buffer of messages received while paused is full")
+
+	// Close related
+	ErrConsensusClosed = errors.New("the consensus has been closed")
+
+	// state snapshot related
+	ErrStateSnapshotVersion      = errors.New("the state snapshot has different version")
+	ErrStateSnapshotIdentity     = errors.New("the state snapshot is taken from another identity")
+	ErrStateSnapshotCurrentRound = errors.New("the state snapshot has no current round")
 
 	// <decide> verification
 	ErrMismatchedTargetState = errors.New("the state in <decide> message does not match the provided target state")
+	ErrNoDecideProof         = errors.New("no <decide> message has been accepted for any height")
+
+	// TCPPeer related
+	ErrTCPFrameSizeExceeded = errors.New("the frame size exceeded maximum")
+	ErrTCPPeerClosed        = errors.New("the tcp peer has been closed")
+	ErrTCPSendQueueFull     = errors.New("the send queue of the tcp peer is full")
+
+	// UDPPeer related
+	ErrUDPMessageTooLarge = errors.New("the message cannot be fragmented into maximum allowed fragments")
+	ErrUDPPeerClosed      = errors.New("the udp peer has been closed")
+	ErrUDPFragmentSize    = errors.New("the fragment size must be larger than the fragment header")
+
+	// compression related
+	ErrDecompressedSizeExceeded = errors.New("the decompressed message size exceeded maximum")
+
+	// IPCNetwork related
+	ErrIPCPartitionPeer = errors.New("the partition has unknown or duplicated peer index")
+
+	// WSPeer related
+	ErrWSPeerClosed = errors.New("the websocket peer has been closed")
 )
@@ -0,0 +1,137 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType is the kind of an Event kept in the event history
+type EventType int
+
+const (
+	// EventRoundChange is recorded when consensus switches to another round
+	EventRoundChange EventType = iota
+	// EventDecide is recorded when a height is decided
+	EventDecide
+	// EventRejection is recorded when a received message is rejected
+	EventRejection
+	// EventEquivocation is recorded when the leader is caught equivocating
+	EventEquivocation
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventRoundChange:
+		return "roundchange"
+	case EventDecide:
+		return "decide"
+	case EventRejection:
+		return "rejection"
+	case EventEquivocation:
+		return "equivocation"
+	}
+	return "unknown"
+}
+
+// Event is a significant event kept for post-hoc debugging, see RecentEvents,
+// the event delivered to subscribers is set along as Type indicates, and it's
+// nil for round changes.
+type Event struct {
+	Type   EventType
+	Time   time.Time // the time of the event by the scheduler clock
+	Height uint64    // the height being decided, or the height of the event
+	Round  uint64    // the round switched to, or the round of the event
+
+	Decide       *DecideEvent
+	Rejection    *RejectionEvent
+	Equivocation *EquivocationEvent
+}
+
+// eventHistory is a ring buffer of the most recent events, it's safe for
+// concurrent use, so it can be read while the consensus is running.
+type eventHistory struct {
+	events []Event
+	next   int // position for the next event once the buffer is full
+	mu     sync.Mutex
+}
+
+func newEventHistory(size int) *eventHistory {
+	h := new(eventHistory)
+	h.events = make([]Event, 0, size)
+	return h
+}
+
+// Add appends an event, overwriting the oldest one if full.
+func (h *eventHistory) Add(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.events) < cap(h.events) {
+		h.events = append(h.events, e)
+		return
+	}
+	h.events[h.next] = e
+	h.next = (h.next + 1) % len(h.events)
+}
+
+// Recent returns at most n latest events from the oldest to the newest
+func (h *eventHistory) Recent(n int) []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ordered := make([]Event, 0, len(h.events))
+	ordered = append(ordered, h.events[h.next:]...)
+	ordered = append(ordered, h.events[:h.next]...)
+	if n >= 0 && n < len(ordered) {
+		ordered = ordered[len(ordered)-n:]
+	}
+	return ordered
+}
+
+// RecentEvents returns the latest n events of round changes, decides,
+// rejections and equivocations from the oldest to the newest, as many as
+// Config.EventHistoryDepth are kept, n < 0 returns all of them. It's safe to
+// be called concurrently with consensus, e.g. for crash dumps.
+func (c *Consensus) RecentEvents(n int) []Event {
+	if c.events == nil {
+		return nil
+	}
+	return c.events.Recent(n)
+}
+
+// recordEvent keeps an event in the history if enabled
+func (c *Consensus) recordEvent(e Event) {
+	if c.events == nil {
+		return
+	}
+	e.Time = c.scheduler.Now()
+	c.events.Add(e)
+}
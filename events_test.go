@@ -0,0 +1,67 @@
+package bdls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Sperax/bdls/timer"
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecentEvents(t *testing.T) {
+	_, sp, privateKey, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 0, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+	consensus.numIdentities = countIdentities(consensus.participants)
+	scheduler := timer.NewManualScheduler(time.Now())
+	consensus.scheduler = scheduler
+	assert.Nil(t, consensus.RecentEvents(-1))
+	consensus.events = newEventHistory(8)
+
+	// two rounds, a rejection, then the height decided
+	for i := 0; i < 2; i++ {
+		scheduler.Advance(time.Second)
+		assert.Nil(t, consensus.ForceRoundChange(scheduler.Now()))
+	}
+	assert.NotNil(t, consensus.ReceiveMessage([]byte{0xff}, scheduler.Now()))
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ReceiveMessage(bts, scheduler.Now()))
+
+	type summary struct {
+		typ           EventType
+		height, round uint64
+	}
+	var events []summary
+	for _, e := range consensus.RecentEvents(-1) {
+		events = append(events, summary{e.Type, e.Height, e.Round})
+	}
+	assert.Equal(t, []summary{
+		{EventRoundChange, 10, 1},
+		{EventRoundChange, 10, 2},
+		{EventRejection, 0, 0},
+		{EventRoundChange, 11, 0},
+		{EventDecide, 10, 10},
+	}, events)
+
+	recent := consensus.RecentEvents(2)
+	assert.Equal(t, 2, len(recent))
+	assert.Equal(t, EventDecide, recent[1].Type)
+	assert.Equal(t, uint64(10), recent[1].Decide.Height)
+	assert.Equal(t, scheduler.Now(), recent[1].Time)
+	assert.Nil(t, recent[1].Rejection)
+	assert.NotNil(t, consensus.RecentEvents(-1)[2].Rejection.Err)
+
+	// bounded to the latest events
+	for i := 0; i < 10; i++ {
+		consensus.switchRound(uint64(i+1), scheduler.Now())
+	}
+	events = events[:0]
+	for _, e := range consensus.RecentEvents(-1) {
+		events = append(events, summary{e.Type, e.Height, e.Round})
+	}
+	assert.Equal(t, 8, len(events))
+	assert.Equal(t, summary{EventRoundChange, 11, 10}, events[7])
+}
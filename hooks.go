@@ -0,0 +1,61 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+// hookQueue holds the hooks of Config.OnDecide, Config.OnStateFinalized and
+// Config.OnBecomeLeader raised while processing, they're flushed in the order
+// raised on the caller's goroutine just before the method of consensus
+// returns, so hooks see the states after the call and can call back into
+// consensus, and timing of hooks is as deterministic as consensus itself.
+type hookQueue struct {
+	hooks    []func()
+	flushing bool
+}
+
+// push queues a hook to run at next flush
+func (q *hookQueue) push(hook func()) { q.hooks = append(q.hooks, hook) }
+
+// flush runs queued hooks in order until the queue is empty, including those
+// queued by the hooks being run, a flush from within a hook returns at once.
+func (q *hookQueue) flush() {
+	if q.flushing {
+		return
+	}
+	q.flushing = true
+	defer func() { q.flushing = false }()
+
+	for len(q.hooks) > 0 {
+		hook := q.hooks[0]
+		q.hooks[0] = nil
+		q.hooks = q.hooks[1:]
+		hook()
+	}
+}
@@ -0,0 +1,66 @@
+package bdls
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHookQueueOrder(t *testing.T) {
+	var q hookQueue
+	var called []int
+	for i := 0; i < 1000; i++ {
+		i := i
+		q.push(func() {
+			called = append(called, i)
+			// hooks queued from hooks run after the queued ones
+			if i%100 == 0 {
+				q.push(func() { called = append(called, -i) })
+				q.flush()
+			}
+		})
+	}
+	assert.Empty(t, called)
+
+	q.flush()
+	assert.Equal(t, 1010, len(called))
+	var expected []int
+	for i := 0; i < 1000; i++ {
+		expected = append(expected, i)
+	}
+	for i := 0; i < 1000; i += 100 {
+		expected = append(expected, -i)
+	}
+	assert.Equal(t, expected, called)
+	assert.Empty(t, q.hooks)
+}
+
+func TestHooksInOrder(t *testing.T) {
+	const heights = 20
+	keys, participants := createDecideChainKeys(t, 4)
+	consensus := createConsensus(t, 0, 0, participants)
+	consensus.SetLeader(&keys[0].PublicKey)
+
+	var calls []string
+	consensus.onDecide = func(height uint64, rounds uint64, duration time.Duration) {
+		// hooks can call back into consensus
+		latest, _, _ := consensus.CurrentState()
+		assert.Equal(t, height, latest)
+		calls = append(calls, fmt.Sprint("decide ", height))
+	}
+	consensus.onStateFinalized = func(height uint64, round uint64, s State, sigs []SignerSignature) {
+		calls = append(calls, fmt.Sprint("finalized ", height))
+	}
+
+	var expected []string
+	for height := uint64(1); height <= heights; height++ {
+		decides := createDecideChain(t, keys, height)
+		assert.Nil(t, consensus.ReceiveMessage(decides[0], time.Now()))
+
+		// called on the caller's goroutine before ReceiveMessage returns
+		expected = append(expected, fmt.Sprint("decide ", height), fmt.Sprint("finalized ", height))
+		assert.Equal(t, expected, calls)
+	}
+}
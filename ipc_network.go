@@ -0,0 +1,240 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"crypto/ecdsa"
+	rand "math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Sperax/bdls/timer"
+)
+
+// IPCNetwork is a fully connected mesh of IPCPeers for simulation, each config
+// creates one consensus object along with it's IPCPeer.
+type IPCNetwork struct {
+	peers    []*IPCPeer
+	die      chan struct{}
+	stopOnce sync.Once
+
+	// group of each peer while partitioned, nil if fully connected
+	groups     []int
+	groupsLock sync.RWMutex
+
+	// the virtual clock driving all peers in deterministic mode, nil if
+	// the network runs on wall clock
+	scheduler *timer.ManualScheduler
+}
+
+// IPCNetworkOption configures an IPCNetwork on creation
+type IPCNetworkOption func(n *IPCNetwork, configs []*Config)
+
+// DeterministicMode makes runs of an IPCNetwork reproducible, all peers are
+// driven by a single virtual clock starting at the epoch of the first config,
+// messages and updates are processed one at a time in strict time order by
+// Run, and the latencies of the i-th peer are generated from a source seeded
+// with i. Runs with the same configs and inputs produce the same sequence of
+// messages, except the signatures, as ECDSA signing is randomized.
+//
+// Config.Scheduler is overridden, hooks such as Config.OnDecide are called
+// in the same order as the messages and updates raising them.
+func DeterministicMode() IPCNetworkOption {
+	return func(n *IPCNetwork, configs []*Config) {
+		if len(configs) > 0 {
+			n.scheduler = timer.NewManualScheduler(configs[0].Epoch)
+		}
+	}
+}
+
+// NewIPCNetwork creates consensus objects and IPCPeers from configs, connects
+// each peer to all the others, and starts their update loops, the latency is
+// used both as the peers' link latency and consensus expected latency.
+func NewIPCNetwork(configs []*Config, latency time.Duration, options ...IPCNetworkOption) (*IPCNetwork, error) {
+	n := new(IPCNetwork)
+	n.die = make(chan struct{})
+	for _, option := range options {
+		option(n, configs)
+	}
+
+	for i, config := range configs {
+		if n.scheduler != nil {
+			copied := *config
+			copied.Scheduler = n.scheduler
+			config = &copied
+		}
+
+		consensus, err := NewConsensus(config)
+		if err != nil {
+			return nil, err
+		}
+		consensus.SetLatency(latency)
+		if n.scheduler != nil {
+			n.peers = append(n.peers, NewIPCPeerWithSource(consensus, latency, rand.New(rand.NewSource(int64(i)))))
+		} else {
+			n.peers = append(n.peers, NewIPCPeer(consensus, latency))
+		}
+	}
+
+	// establish full connected mesh
+	for i := range n.peers {
+		for j := range n.peers {
+			if i != j {
+				n.peers[i].c.Join(&ipcLink{n: n, from: i, to: j, peer: n.peers[j]})
+			}
+		}
+	}
+
+	// start updaters after all connections have been established,
+	// to prevent from missing <decide> messages
+	for i := range n.peers {
+		n.peers[i].Update()
+	}
+	return n, nil
+}
+
+// Peers returns all peers in this network, in the order of configs
+func (n *IPCNetwork) Peers() []*IPCPeer { return n.peers }
+
+// Run blocks for the given duration while the network is running,
+// it returns early if the network has been stopped. In deterministic mode,
+// the virtual clock is advanced by duration instead, processing all due
+// messages and updates in time order on the calling goroutine.
+func (n *IPCNetwork) Run(duration time.Duration) {
+	if n.scheduler != nil {
+		select {
+		case <-n.die:
+		default:
+			n.scheduler.Advance(duration)
+		}
+		return
+	}
+
+	select {
+	case <-time.After(duration):
+	case <-n.die:
+	}
+}
+
+// Partition splits the network into groups of peer indices, in the order of
+// configs, messages sent across groups will be dropped until next Partition
+// or Heal, peers not in any group are isolated from all the others.
+func (n *IPCNetwork) Partition(groups [][]int) error {
+	assigned := make([]int, len(n.peers))
+	for k := range assigned {
+		assigned[k] = -1
+	}
+
+	for g := range groups {
+		for _, idx := range groups[g] {
+			if idx < 0 || idx >= len(n.peers) || assigned[idx] != -1 {
+				return ErrIPCPartitionPeer
+			}
+			assigned[idx] = g
+		}
+	}
+
+	// isolated peers form groups of their own
+	for k := range assigned {
+		if assigned[k] == -1 {
+			assigned[k] = len(groups) + k
+		}
+	}
+
+	n.groupsLock.Lock()
+	defer n.groupsLock.Unlock()
+	n.groups = assigned
+	return nil
+}
+
+// Heal restores full connectivity of the network
+func (n *IPCNetwork) Heal() {
+	n.groupsLock.Lock()
+	defer n.groupsLock.Unlock()
+	n.groups = nil
+}
+
+// connected tests if messages from peer i can be delivered to peer j
+func (n *IPCNetwork) connected(i, j int) bool {
+	n.groupsLock.RLock()
+	defer n.groupsLock.RUnlock()
+	return n.groups == nil || n.groups[i] == n.groups[j]
+}
+
+// GetMessageCount returns messages count received by all peers
+func (n *IPCNetwork) GetMessageCount() (count int64) {
+	for _, p := range n.peers {
+		count += p.GetMessageCount()
+	}
+	return
+}
+
+// GetBytesCount returns messages bytes count received by all peers
+func (n *IPCNetwork) GetBytesCount() (count int64) {
+	for _, p := range n.peers {
+		count += p.GetBytesCount()
+	}
+	return
+}
+
+// StopAll closes all peers in this network
+func (n *IPCNetwork) StopAll() {
+	n.stopOnce.Do(func() {
+		for _, p := range n.peers {
+			p.Close()
+		}
+		close(n.die)
+	})
+}
+
+// ipcLink is the directed link from one peer to another in IPCNetwork,
+// messages will be dropped while the peers are partitioned.
+type ipcLink struct {
+	n    *IPCNetwork
+	from int
+	to   int
+	peer *IPCPeer
+}
+
+// GetPublicKey implements PeerInterface.GetPublicKey
+func (l *ipcLink) GetPublicKey() *ecdsa.PublicKey { return l.peer.GetPublicKey() }
+
+// RemoteAddr implements PeerInterface.RemoteAddr
+func (l *ipcLink) RemoteAddr() net.Addr { return l.peer.RemoteAddr() }
+
+// Send implements PeerInterface.Send
+func (l *ipcLink) Send(msg []byte) error {
+	if !l.n.connected(l.from, l.to) {
+		return nil
+	}
+	return l.peer.sendFrom(l.n.peers[l.from], msg)
+}
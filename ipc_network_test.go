@@ -0,0 +1,445 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Sperax/bdls/timer"
+	"github.com/stretchr/testify/assert"
+)
+
+func createIPCNetworkConfigs(t testing.TB, n int) []*Config {
+	var privateKeys []*ecdsa.PrivateKey
+	var participants []Identity
+	for i := 0; i < n; i++ {
+		privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+		assert.Nil(t, err)
+		privateKeys = append(privateKeys, privateKey)
+		participants = append(participants, DefaultPubKeyToIdentity(&privateKey.PublicKey))
+	}
+
+	var configs []*Config
+	epoch := time.Now()
+	for i := 0; i < n; i++ {
+		config := new(Config)
+		config.Epoch = epoch
+		config.PrivateKey = privateKeys[i]
+		config.Participants = participants
+		config.StateCompare = func(a State, b State) int { return bytes.Compare(a, b) }
+		config.StateValidate = func(a State) bool { return true }
+		configs = append(configs, config)
+	}
+	return configs
+}
+
+func TestIPCNetwork(t *testing.T) {
+	network, err := NewIPCNetwork(createIPCNetworkConfigs(t, 4), 10*time.Millisecond)
+	assert.Nil(t, err)
+	defer network.StopAll()
+	assert.Equal(t, 4, len(network.Peers()))
+
+	for _, p := range network.Peers() {
+		data := make([]byte, 1024)
+		_, err := io.ReadFull(rand.Reader, data)
+		assert.Nil(t, err)
+		p.Propose(data)
+	}
+
+	decided := func() bool {
+		for _, p := range network.Peers() {
+			if height, _, _ := p.GetLatestState(); height == 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i := 0; i < 100 && !decided(); i++ {
+		network.Run(100 * time.Millisecond)
+	}
+	assert.True(t, decided())
+	assert.True(t, network.GetMessageCount() > 0)
+	assert.True(t, network.GetBytesCount() > network.GetMessageCount())
+}
+
+func TestIPCNetworkInvalidConfig(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	configs[2].StateValidate = nil
+	_, err := NewIPCNetwork(configs, 10*time.Millisecond)
+	assert.Equal(t, ErrConfigStateValidate, err)
+}
+
+func TestIPCNetworkStopAll(t *testing.T) {
+	network, err := NewIPCNetwork(createIPCNetworkConfigs(t, 4), 10*time.Millisecond)
+	assert.Nil(t, err)
+	network.StopAll()
+	network.StopAll()
+
+	start := time.Now()
+	network.Run(time.Minute)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestIPCNetworkVirtualTime(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	for _, config := range configs {
+		config.Scheduler = scheduler
+	}
+
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond)
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	for _, p := range network.Peers() {
+		data := make([]byte, 1024)
+		_, err := io.ReadFull(rand.Reader, data)
+		assert.Nil(t, err)
+		p.Propose(data)
+	}
+
+	// run 10 minutes in virtual time
+	start := time.Now()
+	for i := 0; i < 60000; i++ {
+		scheduler.Advance(10 * time.Millisecond)
+	}
+	assert.Equal(t, configs[0].Epoch.Add(10*time.Minute), scheduler.Now())
+	assert.True(t, time.Since(start) < time.Minute)
+
+	for _, p := range network.Peers() {
+		height, _, _ := p.GetLatestState()
+		assert.Equal(t, uint64(1), height)
+	}
+}
+
+func TestIPCNetworkOnDecide(t *testing.T) {
+	type decided struct {
+		height   uint64
+		rounds   uint64
+		duration time.Duration
+		latest   uint64
+	}
+
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	var network *IPCNetwork
+	events := make(chan decided, 16)
+	for i, config := range configs {
+		i := i
+		config.Scheduler = scheduler
+		config.OnDecide = func(height uint64, rounds uint64, duration time.Duration) {
+			// hooks run with the peer locked, calling back into consensus
+			// must not deadlock
+			latest, _, _ := network.Peers()[i].c.CurrentState()
+			events <- decided{height, rounds, duration, latest}
+		}
+	}
+
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond)
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	for _, p := range network.Peers() {
+		data := make([]byte, 1024)
+		_, err := io.ReadFull(rand.Reader, data)
+		assert.Nil(t, err)
+		p.Propose(data)
+	}
+
+	// advance until all peers decided
+	for i := 0; i < 60000 && len(events) < len(configs); i++ {
+		scheduler.Advance(10 * time.Millisecond)
+	}
+	elapsed := scheduler.Now().Sub(configs[0].Epoch)
+
+	for range configs {
+		select {
+		case e := <-events:
+			assert.Equal(t, uint64(1), e.height)
+			assert.Equal(t, uint64(1), e.latest)
+			assert.True(t, e.rounds >= 1)
+			assert.True(t, e.duration > 0)
+			assert.True(t, e.duration <= elapsed)
+		case <-time.After(10 * time.Second):
+			t.Fatal("OnDecide has not been called")
+		}
+	}
+}
+
+func TestIPCNetworkPartition(t *testing.T) {
+	network, err := NewIPCNetwork(createIPCNetworkConfigs(t, 5), 10*time.Millisecond)
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	assert.Equal(t, ErrIPCPartitionPeer, network.Partition([][]int{{0, 1}, {1, 2}}))
+	assert.Equal(t, ErrIPCPartitionPeer, network.Partition([][]int{{0, 5}}))
+	assert.Equal(t, ErrIPCPartitionPeer, network.Partition([][]int{{-1}}))
+
+	assert.Nil(t, network.Partition([][]int{{0, 1, 2}, {3}}))
+	assert.True(t, network.connected(0, 2))
+	assert.False(t, network.connected(2, 3))
+	assert.False(t, network.connected(3, 4)) // isolated
+	assert.True(t, network.connected(4, 4))
+
+	network.Heal()
+	assert.True(t, network.connected(2, 3))
+	assert.True(t, network.connected(3, 4))
+}
+
+func createPartitionedIPCNetwork(t *testing.T, n int, groups [][]int) (*IPCNetwork, *timer.ManualScheduler) {
+	configs := createIPCNetworkConfigs(t, n)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	for _, config := range configs {
+		config.Scheduler = scheduler
+	}
+
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Nil(t, network.Partition(groups))
+
+	for _, p := range network.Peers() {
+		data := make([]byte, 1024)
+		_, err := io.ReadFull(rand.Reader, data)
+		assert.Nil(t, err)
+		p.Propose(data)
+	}
+	return network, scheduler
+}
+
+func TestIPCNetworkPartitionHeal(t *testing.T) {
+	// 2-2 split, neither group reaches the quorum of 3
+	network, scheduler := createPartitionedIPCNetwork(t, 4, [][]int{{0, 1}, {2, 3}})
+	defer network.StopAll()
+
+	// no decide in 2 minutes of virtual time
+	for i := 0; i < 12000; i++ {
+		scheduler.Advance(10 * time.Millisecond)
+	}
+	for _, p := range network.Peers() {
+		height, _, _ := p.GetLatestState()
+		assert.Equal(t, uint64(0), height)
+	}
+
+	// all peers decide the same state after healing
+	network.Heal()
+	for i := 0; i < 60000; i++ {
+		scheduler.Advance(10 * time.Millisecond)
+	}
+	_, _, expected := network.Peers()[0].GetLatestState()
+	for _, p := range network.Peers() {
+		height, _, state := p.GetLatestState()
+		assert.Equal(t, uint64(1), height)
+		assert.True(t, bytes.Equal(expected, state))
+	}
+}
+
+func TestIPCNetworkPartitionMinority(t *testing.T) {
+	// 3-2 split, only the majority reaches the quorum of 3
+	network, scheduler := createPartitionedIPCNetwork(t, 5, [][]int{{0, 1, 2}, {3, 4}})
+	defer network.StopAll()
+
+	for i := 0; i < 12000; i++ {
+		scheduler.Advance(10 * time.Millisecond)
+	}
+
+	peers := network.Peers()
+	_, _, expected := peers[0].GetLatestState()
+	for _, p := range peers[:3] {
+		height, _, state := p.GetLatestState()
+		assert.Equal(t, uint64(1), height)
+		assert.True(t, bytes.Equal(expected, state))
+	}
+	for _, p := range peers[3:] {
+		height, _, _ := p.GetLatestState()
+		assert.Equal(t, uint64(0), height)
+	}
+}
+
+// sentMessage records a message sent in IPCNetwork
+type sentMessage struct {
+	at     time.Duration
+	sender int
+	typ    MessageType
+	height uint64
+	round  uint64
+	state  StateHash
+}
+
+func runDeterministicIPCNetwork(t *testing.T, configs []*Config) []sentMessage {
+	var network *IPCNetwork
+	var sent []sentMessage
+	for i := range configs {
+		i := i
+		configs[i].MessageOutCallback = func(m *Message, signed *SignedProto) {
+			sent = append(sent, sentMessage{
+				at:     network.scheduler.Now().Sub(configs[0].Epoch),
+				sender: i,
+				typ:    m.Type,
+				height: m.Height,
+				round:  m.Round,
+				state:  defaultHash(m.State),
+			})
+		}
+	}
+
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond, DeterministicMode())
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	for height := uint64(1); height <= 2; height++ {
+		for k, p := range network.Peers() {
+			p.Propose(State(fmt.Sprintf("height %d by %d", height, k)))
+		}
+		network.Run(5 * time.Second)
+		for _, p := range network.Peers() {
+			h, _, _ := p.GetLatestState()
+			assert.Equal(t, height, h)
+		}
+	}
+	return sent
+}
+
+func TestIPCNetworkDeterministicMode(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	first := runDeterministicIPCNetwork(t, configs)
+	second := runDeterministicIPCNetwork(t, configs)
+	assert.NotEmpty(t, first)
+	assert.Equal(t, first, second)
+
+	// configs are not modified
+	for _, config := range configs {
+		assert.Nil(t, config.Scheduler)
+	}
+}
+
+func TestIPCNetworkMinimumParticipants(t *testing.T) {
+	decided := func(groups [][]int) []uint64 {
+		configs := createIPCNetworkConfigs(t, ConfigMinimumParticipants)
+		network, err := NewIPCNetwork(configs, 100*time.Millisecond, DeterministicMode())
+		assert.Nil(t, err)
+		defer network.StopAll()
+
+		// faulty participants are isolated as crashed
+		assert.Nil(t, network.Partition(groups))
+		for k, p := range network.Peers() {
+			p.Propose(State(fmt.Sprintf("proposed by %d", k)))
+		}
+		network.Run(time.Minute)
+
+		var heights []uint64
+		_, _, expected := network.Peers()[groups[0][0]].GetLatestState()
+		for _, p := range network.Peers() {
+			height, _, state := p.GetLatestState()
+			if height > 0 {
+				assert.Equal(t, expected, state)
+			}
+			heights = append(heights, height)
+		}
+		return heights
+	}
+
+	// t = 1 for n = 4, any single faulty participant is tolerated
+	assert.Equal(t, 1, MaxFaulty(ConfigMinimumParticipants))
+	assert.Equal(t, 3, QuorumSize(ConfigMinimumParticipants))
+	for faulty := 0; faulty < ConfigMinimumParticipants; faulty++ {
+		var correct []int
+		for k := 0; k < ConfigMinimumParticipants; k++ {
+			if k != faulty {
+				correct = append(correct, k)
+			}
+		}
+		heights := decided([][]int{correct})
+		for _, k := range correct {
+			assert.Equal(t, uint64(1), heights[k], "faulty=%d peer=%d", faulty, k)
+		}
+		assert.Equal(t, uint64(0), heights[faulty])
+	}
+
+	// 2 faulty participants, the correct ones cannot reach the quorum
+	assert.Equal(t, []uint64{0, 0, 0, 0}, decided([][]int{{0, 1}}))
+	assert.Equal(t, []uint64{0, 0, 0, 0}, decided([][]int{{2, 3}}))
+}
+
+func TestIPCNetworkHasher(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	for _, config := range configs {
+		config.Hasher = sha256.New
+	}
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond, DeterministicMode())
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	for k, p := range network.Peers() {
+		p.Propose(State(fmt.Sprintf("proposed by %d", k)))
+	}
+	network.Run(10 * time.Second)
+	for _, p := range network.Peers() {
+		height, _, _ := p.GetLatestState()
+		assert.Equal(t, uint64(1), height)
+	}
+}
+
+func TestIPCNetworkPauseResume(t *testing.T) {
+	network, err := NewIPCNetwork(createIPCNetworkConfigs(t, 4), 100*time.Millisecond, DeterministicMode())
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	peers := network.Peers()
+	for k, p := range peers {
+		p.Propose(State(fmt.Sprintf("proposed by %d", k)))
+	}
+
+	// paused during the round, the others decide without it
+	network.Run(100 * time.Millisecond)
+	peers[3].Pause()
+	network.Run(10 * time.Second)
+	for _, p := range peers[:3] {
+		height, _, _ := p.GetLatestState()
+		assert.Equal(t, uint64(1), height)
+	}
+	height, _, _ := peers[3].GetLatestState()
+	assert.Equal(t, uint64(0), height)
+
+	// catches up from buffered messages
+	peers[3].Resume()
+	_, _, expected := peers[0].GetLatestState()
+	height, _, state := peers[3].GetLatestState()
+	assert.Equal(t, uint64(1), height)
+	assert.Equal(t, expected, state)
+}
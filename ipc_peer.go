@@ -31,11 +31,13 @@
 package bdls
 
 import (
+	"context"
 	"crypto/ecdsa"
 	fmt "fmt"
 	math "math"
 	rand "math/rand"
 	"net"
+	"sort"
 	"sync"
 	"time"
 	"unsafe"
@@ -43,6 +45,12 @@ import (
 	"github.com/Sperax/bdls/timer"
 )
 
+const (
+	// DefaultLatencyStdDevFactor is the default standard deviation of
+	// IPCPeer's latency, as a factor of latency
+	DefaultLatencyStdDevFactor = 0.1
+)
+
 // fake address for IPCPeer
 type fakeAddress string
 
@@ -59,26 +67,140 @@ type IPCPeer struct {
 	latency      time.Duration
 	die          chan struct{}
 	dieOnce      sync.Once
+	ctx          context.Context // cancelled when the peer is closed
+	cancel       context.CancelFunc
 	msgCount     int64
+	msgTypeCount map[MessageType]int64
 	bytesCount   int64
 	minLatency   time.Duration
 	maxLatency   time.Duration
 	totalLatency time.Duration
+	latencies    *latencyReservoir // sampled latencies, nil if disabled
+	trace        *messageTrace     // trace of delivered messages, nil if disabled
+	scheduler    timer.Scheduler   // scheduler for message delivery and updates
+
+	// fields accessed synchronously in Send, Send may be called concurrently
+	// by other consensus objects while this peer's mutex is held for delivery,
+	// so they're guarded by sendLock.
+	rng           *rand.Rand    // random source for latency generation
+	lossRate      float64       // probability of a message to be dropped
+	droppedCount  int64         // count of dropped messages
+	bandwidth     int64         // bytes per second of the link, <= 0 means unlimited
+	decideLatency time.Duration // latency of <decide> messages, negative to use latency
+	sendLatency   time.Duration // latency of messages sent by this peer in IPCNetwork
+	stdDevFactor  float64       // standard deviation of latency, as a factor of latency
+	synchronous   bool          // deliver messages inline in Send, see SetSynchronous
+	locked        bool          // the peer's mutex is held, see Lock
+	draining      bool          // synchronous messages are being delivered
+	syncQueue     [][]byte      // synchronous messages awaiting delivery
+	clampCounting bool          // count and log negative delays clamped to zero
+	clampedCount  int64         // count of negative delays clamped to zero
+	sendLock      sync.Mutex
 }
 
 // NewIPCPeer creates IPC based peer with latency, latency is distributed with
 // standard normal distribution.
 func NewIPCPeer(c *Consensus, latency time.Duration) *IPCPeer {
+	return NewIPCPeerWithSource(c, latency, rand.New(rand.NewSource(time.Now().UnixNano())))
+}
+
+// NewIPCPeerWithSource creates IPC based peer with latency generated from
+// the given random source, peers created with identically seeded sources
+// will generate identical latency sequences.
+func NewIPCPeerWithSource(c *Consensus, latency time.Duration, rng *rand.Rand) *IPCPeer {
 	p := new(IPCPeer)
 	p.c = c
 	p.latency = latency
+	p.rng = rng
+	p.scheduler = timer.SystemTimedSched
+	if c != nil {
+		p.scheduler = c.scheduler
+	}
+	p.msgTypeCount = make(map[MessageType]int64)
 	p.die = make(chan struct{})
+	p.ctx, p.cancel = context.WithCancel(context.Background())
 	p.minLatency = math.MaxInt64
+	p.decideLatency = -1
+	p.stdDevFactor = DefaultLatencyStdDevFactor
 	return p
 }
 
-// GetPublicKey returns peer's public key as identity
-func (p *IPCPeer) GetPublicKey() *ecdsa.PublicKey { return &p.c.privateKey.PublicKey }
+// NewIPCPeerAsymmetric creates IPC based peer with different latencies for
+// the two directions of it's link, recvLatency applies to messages delivered
+// to this peer by Send, as latency in NewIPCPeer, and sendLatency applies to
+// messages this peer's consensus sends to other peers in IPCNetwork, so a
+// message from peer A to peer B is delayed by A's sendLatency plus B's
+// recvLatency, both randomized. Messages passed to Send directly have no
+// known sender, so only recvLatency applies.
+func NewIPCPeerAsymmetric(c *Consensus, sendLatency time.Duration, recvLatency time.Duration) *IPCPeer {
+	p := NewIPCPeer(c, recvLatency)
+	p.sendLatency = sendLatency
+	return p
+}
+
+// SetSendLatency sets the latency of messages this peer sends to others in
+// IPCNetwork, see NewIPCPeerAsymmetric, 0 disables, which is the default.
+func (p *IPCPeer) SetSendLatency(latency time.Duration) {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	p.sendLatency = latency
+}
+
+// SetLatencyReservoir enables latency percentile reporting, each delay will
+// be sampled into a reservoir of at most size entries, so memory stays bounded
+// regardless of the number of messages sent. Samples collected previously
+// are discarded, size <= 0 disables reporting, which is the default.
+func (p *IPCPeer) SetLatencyReservoir(size int) {
+	p.Lock()
+	defer p.Unlock()
+	if size <= 0 {
+		p.latencies = nil
+		return
+	}
+	p.latencies = newLatencyReservoir(size)
+}
+
+// TraceEntry is a message delivered by IPCPeer, recorded by SetTrace.
+type TraceEntry struct {
+	Time  time.Time     // when the message was delivered to consensus
+	Delay time.Duration // delay computed for the message, including transmission
+	Type  MessageType   // message type, MessageType_Nop if it cannot be decoded
+	Typed bool          // whether the message could be decoded
+	Size  int           // size of the message on wire
+}
+
+// SetTrace enables recording of delivered messages, the trace keeps the most
+// recent size entries as a ring buffer, so long runs won't grow unbounded.
+// Entries recorded previously are discarded, size <= 0 disables tracing,
+// which is the default.
+func (p *IPCPeer) SetTrace(size int) {
+	p.Lock()
+	defer p.Unlock()
+	if size <= 0 {
+		p.trace = nil
+		return
+	}
+	p.trace = newMessageTrace(size)
+}
+
+// GetTrace returns a copy of the recorded trace in delivery order, nil if
+// tracing is disabled.
+func (p *IPCPeer) GetTrace() []TraceEntry {
+	p.Lock()
+	defer p.Unlock()
+	if p.trace == nil {
+		return nil
+	}
+	return p.trace.Entries()
+}
+
+// GetPublicKey returns peer's public key as identity, nil for observers
+func (p *IPCPeer) GetPublicKey() *ecdsa.PublicKey {
+	if p.c.signer == nil {
+		return nil
+	}
+	return p.c.signer.Public()
+}
 
 // RemoteAddr implements Peer.RemoteAddr, the address is p's memory address
 func (p *IPCPeer) RemoteAddr() net.Addr { return fakeAddress(fmt.Sprint(unsafe.Pointer(p))) }
@@ -90,6 +212,18 @@ func (p *IPCPeer) GetMessageCount() int64 {
 	return p.msgCount
 }
 
+// GetMessageCountByType returns messages count this peer received by message types,
+// messages failed to decode are not counted.
+func (p *IPCPeer) GetMessageCountByType() map[MessageType]int64 {
+	p.Lock()
+	defer p.Unlock()
+	counts := make(map[MessageType]int64, len(p.msgTypeCount))
+	for k, v := range p.msgTypeCount {
+		counts[k] = v
+	}
+	return counts
+}
+
 // GetBytesCount returns messages bytes count this peer received
 func (p *IPCPeer) GetBytesCount() int64 {
 	p.Lock()
@@ -97,6 +231,135 @@ func (p *IPCPeer) GetBytesCount() int64 {
 	return p.bytesCount
 }
 
+// GetDroppedCount returns messages count dropped by packet loss emulation
+func (p *IPCPeer) GetDroppedCount() int64 {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	return p.droppedCount
+}
+
+// SetLossRate sets the probability in [0, 1] of a message being dropped
+// to emulate lossy networks, 0 disables packet loss.
+func (p *IPCPeer) SetLossRate(rate float64) {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	p.lossRate = rate
+}
+
+// SetBandwidth sets the link throughput in bytes per second, an additional
+// transmission delay proportional to message size will be added to latency,
+// zero or negative value disables throttling.
+func (p *IPCPeer) SetBandwidth(bytesPerSecond int64) {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	p.bandwidth = bytesPerSecond
+}
+
+// SetLatencyStdDevFactor sets the standard deviation of latency as a factor
+// of latency, to model stable or jittery links, 0 makes the latency constant.
+// By default, it's DefaultLatencyStdDevFactor.
+func (p *IPCPeer) SetLatencyStdDevFactor(f float64) {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	p.stdDevFactor = f
+}
+
+// SetClampCounting enables counting of randomized delays clamped to zero, to
+// tell whether the jitter parameters produce unrealistic samples, a large
+// standard deviation factor makes normal distribution yield negative delays.
+// Negative delays are always clamped, counting only reports them via
+// GetClampedCount and debug logs of the consensus. By default, it's disabled.
+func (p *IPCPeer) SetClampCounting(enabled bool) {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	p.clampCounting = enabled
+}
+
+// GetClampedCount returns the count of negative delays clamped to zero since
+// clamp counting was enabled, see SetClampCounting
+func (p *IPCPeer) GetClampedCount() int64 {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	return p.clampedCount
+}
+
+// SetSynchronous sets whether messages are delivered inline in Send with zero
+// delay instead of scheduled, for focused unit tests to assert right after a
+// message has been sent without a scheduler. Counters are updated as usual,
+// and latency, bandwidth and loss emulation are bypassed. A message sent to a
+// peer while it's locked, e.g. a reply to the peer sending, is delivered as
+// soon as the peer is unlocked, before the outermost Send returns if it's
+// unlocked then. By default, it's asynchronous.
+func (p *IPCPeer) SetSynchronous(synchronous bool) {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	p.synchronous = synchronous
+}
+
+// Lock locks the peer as the embedded mutex does, and tracks the lock for
+// synchronous delivery.
+func (p *IPCPeer) Lock() {
+	p.Mutex.Lock()
+	p.sendLock.Lock()
+	p.locked = true
+	p.sendLock.Unlock()
+}
+
+// Unlock unlocks the peer, and delivers synchronous messages sent to the
+// peer while it was locked.
+func (p *IPCPeer) Unlock() {
+	p.sendLock.Lock()
+	p.locked = false
+	synchronous := p.synchronous
+	p.sendLock.Unlock()
+	p.Mutex.Unlock()
+
+	if synchronous {
+		p.drainSync()
+	}
+}
+
+// drainSync delivers queued synchronous messages unless the peer is locked,
+// or they're being delivered by the caller up the stack.
+func (p *IPCPeer) drainSync() {
+	p.sendLock.Lock()
+	if p.draining {
+		p.sendLock.Unlock()
+		return
+	}
+	p.draining = true
+	for len(p.syncQueue) > 0 && !p.locked {
+		msg := p.syncQueue[0]
+		p.syncQueue = p.syncQueue[1:]
+		p.sendLock.Unlock()
+
+		typ, typed := p.messageType(msg)
+		p.deliver(msg, 0, typ, typed)
+		p.sendLock.Lock()
+	}
+	p.draining = false
+	p.sendLock.Unlock()
+}
+
+// SetDecideLatency sets the latency for <decide> messages, to model networks
+// prioritizing finalization traffic, the latency is randomized as for other
+// messages, and transmission delay still applies. Negative value disables,
+// <decide> messages are delayed as others, which is the default.
+func (p *IPCPeer) SetDecideLatency(latency time.Duration) {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	p.decideLatency = latency
+}
+
+// SetScheduler sets the scheduler for message delivery and updates, it must
+// be called before the peer joins consensus. By default, the scheduler from
+// Config.Scheduler of the consensus object is used.
+func (p *IPCPeer) SetScheduler(scheduler timer.Scheduler) {
+	p.Lock()
+	defer p.Unlock()
+	p.scheduler = scheduler
+}
+
 // Propose a state, awaiting to be finalized at next height.
 func (p *IPCPeer) Propose(s State) {
 	p.Lock()
@@ -104,6 +367,79 @@ func (p *IPCPeer) Propose(s State) {
 	p.c.Propose(s)
 }
 
+// ProposeWithResult proposes a state, and returns a channel to receive
+// the result of the proposal.
+func (p *IPCPeer) ProposeWithResult(s State) <-chan ProposeResult {
+	p.Lock()
+	defer p.Unlock()
+	return p.c.ProposeWithResult(s)
+}
+
+// ProposeAt proposes a new state for the given height in pipelined mode
+func (p *IPCPeer) ProposeAt(height uint64, s State) error {
+	p.Lock()
+	defer p.Unlock()
+	return p.c.ProposeAt(height, s)
+}
+
+// Reset restarts consensus from a known state at the given height
+func (p *IPCPeer) Reset(height uint64, state State) error {
+	p.Lock()
+	defer p.Unlock()
+	return p.c.Reset(height, state, p.scheduler.Now())
+}
+
+// ForceRoundChange moves consensus to the next round immediately
+func (p *IPCPeer) ForceRoundChange() error {
+	p.Lock()
+	defer p.Unlock()
+	return p.c.ForceRoundChange(p.scheduler.Now())
+}
+
+// Pause stops the consensus from processing messages, see Consensus.Pause
+func (p *IPCPeer) Pause() {
+	p.Lock()
+	defer p.Unlock()
+	p.c.Pause()
+}
+
+// Resume processes messages buffered while paused, see Consensus.Resume
+func (p *IPCPeer) Resume() {
+	p.Lock()
+	defer p.Unlock()
+	p.c.Resume(p.scheduler.Now())
+}
+
+// CommitProgress returns the <commit> messages collected to the state locked,
+// see Consensus.CommitProgress
+func (p *IPCPeer) CommitProgress() (have int, need int) {
+	p.Lock()
+	defer p.Unlock()
+	return p.c.CommitProgress()
+}
+
+// LockedState returns the state currently locked and the round of the lock,
+// see Consensus.LockedState
+func (p *IPCPeer) LockedState() (state State, round uint64, hasLock bool) {
+	p.Lock()
+	defer p.Unlock()
+	return p.c.LockedState()
+}
+
+// Participants returns a snapshot of the consensus group
+func (p *IPCPeer) Participants() []ParticipantInfo {
+	p.Lock()
+	defer p.Unlock()
+	return p.c.Participants()
+}
+
+// QuorumCertificate returns the quorum certificate of a decided height
+func (p *IPCPeer) QuorumCertificate(height uint64) (*QC, error) {
+	p.Lock()
+	defer p.Unlock()
+	return p.c.QuorumCertificate(height)
+}
+
 // GetLatestState returns latest state
 func (p *IPCPeer) GetLatestState() (height uint64, round uint64, data State) {
 	p.Lock()
@@ -111,6 +447,14 @@ func (p *IPCPeer) GetLatestState() (height uint64, round uint64, data State) {
 	return p.c.CurrentState()
 }
 
+// GetRoundStartTime returns the round being run along with when it began,
+// read atomically, see Consensus.RoundStartTime.
+func (p *IPCPeer) GetRoundStartTime() (round uint64, start time.Time) {
+	p.Lock()
+	defer p.Unlock()
+	return p.c.currentRound.RoundNumber, p.c.RoundStartTime()
+}
+
 // GetLatencies returns actual generated latency
 func (p *IPCPeer) GetLatencies() (min time.Duration, max time.Duration, total time.Duration) {
 	p.Lock()
@@ -118,37 +462,177 @@ func (p *IPCPeer) GetLatencies() (min time.Duration, max time.Duration, total ti
 	return p.minLatency, p.maxLatency, p.totalLatency
 }
 
-// Send implements Peer.Send
-func (p *IPCPeer) Send(msg []byte) error {
-	delay := p.delay()
-	txDelay := func() {
-		p.Lock()
-		defer p.Unlock()
+// GetLatencyPercentiles returns the estimated latency at each of the given
+// percentiles in range [0, 100], estimated from the sampled latencies.
+// It returns nil if reporting is disabled or no latency has been sampled,
+// see SetLatencyReservoir.
+func (p *IPCPeer) GetLatencyPercentiles(percentiles ...float64) []time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	if p.latencies == nil {
+		return nil
+	}
+	return p.latencies.Percentiles(percentiles...)
+}
 
-		if p.minLatency > delay {
-			p.minLatency = delay
-		}
+// ReceiveBatch processes a batch of messages under a single acquisition of
+// the lock, see Consensus.ReceiveBatch
+func (p *IPCPeer) ReceiveBatch(msgs [][]byte) []error {
+	p.Lock()
+	defer p.Unlock()
+	return p.c.ReceiveBatch(msgs, p.scheduler.Now())
+}
 
-		if p.maxLatency < delay {
-			p.maxLatency = delay
-		}
-		p.totalLatency += delay
-		p.msgCount++
-		p.bytesCount += int64(len(msg))
+// Send implements Peer.Send, the message is delivered to this peer
+func (p *IPCPeer) Send(msg []byte) error { return p.sendFrom(nil, msg) }
 
-		err := p.c.ReceiveMessage(msg, time.Now())
-		if err != nil {
-			//		log.Println(err)
-		}
+// sendFrom delivers a message sent by the peer from, and adds the send
+// latency of from, nil if the sender is unknown.
+func (p *IPCPeer) sendFrom(from *IPCPeer, msg []byte) error {
+	p.sendLock.Lock()
+	if p.synchronous {
+		p.syncQueue = append(p.syncQueue, msg)
+		p.sendLock.Unlock()
+		p.drainSync()
+		return nil
+	}
+	p.sendLock.Unlock()
+
+	typ, typed := p.messageType(msg)
+	var delay time.Duration
+	if typed && typ == MessageType_Decide {
+		delay = p.decideDelay()
+	} else {
+		delay = p.delay()
+	}
+	delay += p.transmissionDelay(len(msg))
+	if from != nil {
+		delay += from.upstreamDelay()
+	}
+	if p.lost() {
+		return nil
 	}
 
-	timer.SystemTimedSched.Put(txDelay, time.Now().Add(delay))
+	txDelay := func() { p.deliver(msg, delay, typ, typed) }
+	p.scheduler.Put(txDelay, p.scheduler.Now().Add(delay))
 	return nil
 }
 
+// deliver accounts a message delivered after delay, and feeds it to consensus
+func (p *IPCPeer) deliver(msg []byte, delay time.Duration, typ MessageType, typed bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.minLatency > delay {
+		p.minLatency = delay
+	}
+
+	if p.maxLatency < delay {
+		p.maxLatency = delay
+	}
+	p.totalLatency += delay
+	if p.latencies != nil {
+		p.latencies.Add(delay)
+	}
+	p.msgCount++
+	p.bytesCount += int64(len(msg))
+	if typed {
+		p.msgTypeCount[typ]++
+	}
+	if p.trace != nil {
+		p.trace.Add(TraceEntry{Time: p.scheduler.Now(), Delay: delay, Type: typ, Typed: typed, Size: len(msg)})
+	}
+
+	// rejected messages are reported via Config.Logger
+	_ = p.c.ReceiveMessage(msg, p.scheduler.Now())
+}
+
+// messageType decodes the type of message, false will be returned if the
+// message cannot be decoded
+func (p *IPCPeer) messageType(msg []byte) (MessageType, bool) {
+	if p.c == nil {
+		return MessageType_Nop, false
+	}
+
+	// messages on wire may be compressed
+	bts, err := p.c.decodeFrame(msg)
+	if err != nil {
+		return MessageType_Nop, false
+	}
+	signed, err := DecodeSignedMessage(bts)
+	if err != nil {
+		return MessageType_Nop, false
+	}
+	m, err := DecodeMessage(signed.Message)
+	if err != nil {
+		return MessageType_Nop, false
+	}
+	return m.Type, true
+}
+
 // delay is randomized with standard normal distribution
 func (p *IPCPeer) delay() time.Duration {
-	return time.Duration(0.1*rand.NormFloat64()*float64(p.latency)) + p.latency
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	return p.randomize(p.latency)
+}
+
+// upstreamDelay is the randomized send latency, see SetSendLatency
+func (p *IPCPeer) upstreamDelay() time.Duration {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	if p.sendLatency <= 0 {
+		return 0
+	}
+	return p.randomize(p.sendLatency)
+}
+
+// decideDelay is the delay of <decide> messages, see SetDecideLatency
+func (p *IPCPeer) decideDelay() time.Duration {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	if p.decideLatency >= 0 {
+		return p.randomize(p.decideLatency)
+	}
+	return p.randomize(p.latency)
+}
+
+// randomize latency with normal distribution, negative delays are clamped
+// to zero, sendLock must be held
+func (p *IPCPeer) randomize(latency time.Duration) time.Duration {
+	d := time.Duration(p.stdDevFactor*p.rng.NormFloat64()*float64(latency)) + latency
+	if d < 0 {
+		if p.clampCounting {
+			p.clampedCount++
+			if p.c != nil {
+				p.c.logger.Debugf("negative latency %v clamped to 0", d)
+			}
+		}
+		return 0
+	}
+	return d
+}
+
+// transmissionDelay calculates the time to transmit size bytes with regard to bandwidth
+func (p *IPCPeer) transmissionDelay(size int) time.Duration {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	if p.bandwidth <= 0 {
+		return 0
+	}
+	return time.Duration(int64(size) * int64(time.Second) / p.bandwidth)
+}
+
+// lost decides whether a message should be dropped with regard to loss rate,
+// and counts the dropped one.
+func (p *IPCPeer) lost() bool {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	if p.lossRate > 0 && p.rng.Float64() < p.lossRate {
+		p.droppedCount++
+		return true
+	}
+	return false
 }
 
 // Update will call itself perodically
@@ -160,8 +644,9 @@ func (p *IPCPeer) Update() {
 	case <-p.die:
 	default:
 		// call consensus update
-		_ = p.c.Update(time.Now())
-		timer.SystemTimedSched.Put(p.Update, time.Now().Add(20*time.Millisecond))
+		now := p.scheduler.Now()
+		_ = p.c.UpdateWithContext(p.ctx, now)
+		p.scheduler.Put(p.Update, now.Add(20*time.Millisecond))
 	}
 }
 
@@ -169,5 +654,88 @@ func (p *IPCPeer) Update() {
 func (p *IPCPeer) Close() {
 	p.dieOnce.Do(func() {
 		close(p.die)
+		p.cancel()
 	})
 }
+
+// latencyReservoir keeps a uniform random sample of bounded size over a stream
+// of latencies, with reservoir sampling(Algorithm R).
+type latencyReservoir struct {
+	samples []time.Duration
+	seen    int64      // number of latencies offered to the reservoir
+	rng     *rand.Rand // random source for replacement, fixed seed for reproducibility
+}
+
+func newLatencyReservoir(size int) *latencyReservoir {
+	r := new(latencyReservoir)
+	r.samples = make([]time.Duration, 0, size)
+	r.rng = rand.New(rand.NewSource(1))
+	return r
+}
+
+// Add offers a latency to the reservoir, every latency seen so far has equal
+// probability to be kept.
+func (r *latencyReservoir) Add(d time.Duration) {
+	r.seen++
+	if len(r.samples) < cap(r.samples) {
+		r.samples = append(r.samples, d)
+		return
+	}
+
+	if j := r.rng.Int63n(r.seen); j < int64(len(r.samples)) {
+		r.samples[j] = d
+	}
+}
+
+// Percentiles returns the nearest-rank percentiles of the samples, percentiles
+// out of range [0, 100] are clamped.
+func (r *latencyReservoir) Percentiles(percentiles ...float64) []time.Duration {
+	if len(r.samples) == 0 {
+		return nil
+	}
+
+	sorted := make([]time.Duration, len(r.samples))
+	copy(sorted, r.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	results := make([]time.Duration, len(percentiles))
+	for k, pct := range percentiles {
+		rank := int(math.Ceil(pct / 100 * float64(len(sorted))))
+		if rank < 1 {
+			rank = 1
+		} else if rank > len(sorted) {
+			rank = len(sorted)
+		}
+		results[k] = sorted[rank-1]
+	}
+	return results
+}
+
+// messageTrace is a ring buffer of the most recent trace entries.
+type messageTrace struct {
+	entries []TraceEntry
+	next    int // position for the next entry once the buffer is full
+}
+
+func newMessageTrace(size int) *messageTrace {
+	r := new(messageTrace)
+	r.entries = make([]TraceEntry, 0, size)
+	return r
+}
+
+// Add appends an entry to the trace, overwriting the oldest one if full.
+func (r *messageTrace) Add(e TraceEntry) {
+	if len(r.entries) < cap(r.entries) {
+		r.entries = append(r.entries, e)
+		return
+	}
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+}
+
+// Entries returns the entries from the oldest to the newest.
+func (r *messageTrace) Entries() []TraceEntry {
+	entries := make([]TraceEntry, 0, len(r.entries))
+	entries = append(entries, r.entries[r.next:]...)
+	return append(entries, r.entries[:r.next]...)
+}
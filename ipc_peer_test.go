@@ -2,8 +2,14 @@ package bdls
 
 import (
 	"log"
+	math "math"
+	"math/rand"
 	"testing"
 	"time"
+
+	"github.com/Sperax/bdls/timer"
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestLatencyDistribution200ms(t *testing.T) {
@@ -19,3 +25,355 @@ func TestLatencyDistribution500ms(t *testing.T) {
 		log.Println(p.delay())
 	}
 }
+
+func TestLatencyDeterministicSource(t *testing.T) {
+	p1 := NewIPCPeerWithSource(nil, 200*time.Millisecond, rand.New(rand.NewSource(1234)))
+	p2 := NewIPCPeerWithSource(nil, 200*time.Millisecond, rand.New(rand.NewSource(1234)))
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, p1.delay(), p2.delay())
+	}
+}
+
+func TestPacketLoss(t *testing.T) {
+	p := NewIPCPeer(nil, 200*time.Millisecond)
+	p.SetLossRate(1)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, p.Send([]byte{0}))
+	}
+	assert.Equal(t, int64(100), p.GetDroppedCount())
+	assert.Equal(t, int64(0), p.GetMessageCount())
+
+	// dropped messages do not contribute to latencies
+	min, max, total := p.GetLatencies()
+	assert.Equal(t, time.Duration(math.MaxInt64), min)
+	assert.Equal(t, time.Duration(0), max)
+	assert.Equal(t, time.Duration(0), total)
+}
+
+func TestMessageCountByType(t *testing.T) {
+	p := NewIPCPeer(createConsensus(t, 0, 0, nil), time.Millisecond)
+	for i := 0; i < 3; i++ {
+		_, signed, _ := createRoundChangeMessage(t, 1, 0)
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		assert.Nil(t, p.Send(bts))
+	}
+	_, signed, _ := createCommitMessage(t, 1, 0, make([]byte, 1024))
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	assert.Nil(t, p.Send(bts))
+
+	// garbage is not counted by type
+	assert.Nil(t, p.Send([]byte{0xff}))
+
+	assert.Eventually(t, func() bool { return p.GetMessageCount() == 5 }, time.Second, 10*time.Millisecond)
+	counts := p.GetMessageCountByType()
+	assert.Equal(t, int64(3), counts[MessageType_RoundChange])
+	assert.Equal(t, int64(1), counts[MessageType_Commit])
+	assert.Equal(t, 2, len(counts))
+}
+
+func TestBandwidth(t *testing.T) {
+	p := NewIPCPeer(createConsensus(t, 0, 0, nil), 0)
+	assert.Equal(t, time.Duration(0), p.transmissionDelay(1024*1024))
+
+	p.SetBandwidth(1024 * 1024) // 1MB/s
+	assert.Equal(t, time.Second, p.transmissionDelay(1024*1024))
+	assert.True(t, p.transmissionDelay(1024) < p.transmissionDelay(2048))
+
+	// send a 100KB message, the observed delay should be ~100ms
+	assert.Nil(t, p.Send(make([]byte, 100*1024)))
+	assert.Eventually(t, func() bool { return p.GetMessageCount() == 1 }, time.Second, 10*time.Millisecond)
+	_, _, total := p.GetLatencies()
+	assert.Equal(t, 100*1024*time.Second/(1024*1024), total)
+
+	// zero disables throttling
+	p.SetBandwidth(0)
+	assert.Equal(t, time.Duration(0), p.transmissionDelay(1024*1024))
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	p := NewIPCPeer(nil, 0)
+	// disabled by default
+	assert.Nil(t, p.GetLatencyPercentiles(50))
+
+	// uniform latencies of 1..100000us, shuffled
+	const numSamples = 100000
+	p.SetLatencyReservoir(1024)
+	rng := rand.New(rand.NewSource(1234))
+	for _, i := range rng.Perm(numSamples) {
+		p.latencies.Add(time.Duration(i+1) * time.Microsecond)
+	}
+	assert.Equal(t, 1024, len(p.latencies.samples))
+
+	expected := []float64{10, 50, 90, 99}
+	percentiles := p.GetLatencyPercentiles(expected...)
+	assert.Equal(t, len(expected), len(percentiles))
+	for k := range expected {
+		// within 5% of the latency range
+		actual := float64(percentiles[k]) / float64(time.Microsecond)
+		assert.InDelta(t, expected[k]*numSamples/100, actual, 0.05*numSamples, "p%v", expected[k])
+	}
+
+	// bounds are clamped
+	percentiles = p.GetLatencyPercentiles(-1, 101)
+	assert.True(t, percentiles[0] >= time.Microsecond)
+	assert.True(t, percentiles[1] <= numSamples*time.Microsecond)
+
+	// zero disables reporting
+	p.SetLatencyReservoir(0)
+	assert.Nil(t, p.GetLatencyPercentiles(50))
+}
+
+func TestLatencyPercentilesSend(t *testing.T) {
+	p := NewIPCPeer(createConsensus(t, 0, 0, nil), 10*time.Millisecond)
+	p.SetLatencyReservoir(100)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, p.Send([]byte{0}))
+	}
+	assert.Eventually(t, func() bool { return p.GetMessageCount() == 10 }, time.Second, 10*time.Millisecond)
+
+	min, max, _ := p.GetLatencies()
+	percentiles := p.GetLatencyPercentiles(0, 50, 100)
+	assert.Equal(t, min, percentiles[0])
+	assert.True(t, percentiles[1] >= min && percentiles[1] <= max)
+	assert.Equal(t, max, percentiles[2])
+}
+
+func TestDecideLatency(t *testing.T) {
+	_, decide, _, _ := createDecideMessage(t, 20, 10, 10, 10, 10)
+	decideBts, err := proto.Marshal(decide)
+	assert.Nil(t, err)
+	_, roundchange, _ := createRoundChangeMessage(t, 10, 10)
+	roundchangeBts, err := proto.Marshal(roundchange)
+	assert.Nil(t, err)
+
+	// deliveries in 20ms, for 100ms latency
+	deliveries := func(decideLatency time.Duration) map[MessageType]int64 {
+		p := NewIPCPeer(createConsensus(t, 0, 0, nil), 100*time.Millisecond)
+		scheduler := timer.NewManualScheduler(time.Now())
+		p.SetScheduler(scheduler)
+		p.SetDecideLatency(decideLatency)
+		assert.Nil(t, p.Send(decideBts))
+		assert.Nil(t, p.Send(roundchangeBts))
+		scheduler.Advance(20 * time.Millisecond)
+		return p.GetMessageCountByType()
+	}
+
+	// disabled by default
+	counts := deliveries(-1)
+	assert.Equal(t, 0, len(counts))
+
+	counts = deliveries(10 * time.Millisecond)
+	assert.Equal(t, int64(1), counts[MessageType_Decide])
+	assert.Equal(t, int64(0), counts[MessageType_RoundChange])
+
+	counts = deliveries(0)
+	assert.Equal(t, int64(1), counts[MessageType_Decide])
+	assert.Equal(t, int64(0), counts[MessageType_RoundChange])
+}
+
+func TestLatencyStdDevFactor(t *testing.T) {
+	spread := func(f float64) (min time.Duration, max time.Duration) {
+		p := NewIPCPeerWithSource(nil, 100*time.Millisecond, rand.New(rand.NewSource(1234)))
+		if f >= 0 {
+			p.SetLatencyStdDevFactor(f)
+		}
+		min = math.MaxInt64
+		for i := 0; i < 1000; i++ {
+			d := p.delay()
+			if d < min {
+				min = d
+			}
+			if d > max {
+				max = d
+			}
+		}
+		return
+	}
+
+	// default is 0.1
+	min, max := spread(-1)
+	defaultMin, defaultMax := spread(DefaultLatencyStdDevFactor)
+	assert.Equal(t, defaultMin, min)
+	assert.Equal(t, defaultMax, max)
+
+	// constant latency
+	min, max = spread(0)
+	assert.Equal(t, 100*time.Millisecond, min)
+	assert.Equal(t, 100*time.Millisecond, max)
+
+	// larger factor widens the spread
+	min, max = spread(0.5)
+	assert.True(t, max-min > defaultMax-defaultMin)
+
+	// negative delays are clamped
+	min, _ = spread(10)
+	assert.Equal(t, time.Duration(0), min)
+}
+
+func TestClampedCount(t *testing.T) {
+	p := NewIPCPeerWithSource(nil, 100*time.Millisecond, rand.New(rand.NewSource(1234)))
+	p.SetLatencyStdDevFactor(10)
+
+	clamped := func() (n int64) {
+		for i := 0; i < 1000; i++ {
+			if p.delay() == 0 {
+				n++
+			}
+		}
+		return
+	}
+
+	// not counted by default
+	assert.True(t, clamped() > 0)
+	assert.Equal(t, int64(0), p.GetClampedCount())
+
+	// a factor of 10 makes ~46% of samples negative
+	p.SetClampCounting(true)
+	n := clamped()
+	assert.Equal(t, n, p.GetClampedCount())
+	assert.True(t, n > 400 && n < 520, n)
+
+	// stays when disabled again
+	p.SetClampCounting(false)
+	clamped()
+	assert.Equal(t, n, p.GetClampedCount())
+}
+
+func TestTrace(t *testing.T) {
+	_, decide, _, _ := createDecideMessage(t, 20, 10, 10, 10, 10)
+	decideBts, err := proto.Marshal(decide)
+	assert.Nil(t, err)
+	_, roundchange, _ := createRoundChangeMessage(t, 10, 10)
+	roundchangeBts, err := proto.Marshal(roundchange)
+	assert.Nil(t, err)
+
+	p := NewIPCPeer(createConsensus(t, 0, 0, nil), 100*time.Millisecond)
+	scheduler := timer.NewManualScheduler(time.Now())
+	p.SetScheduler(scheduler)
+	p.SetLatencyStdDevFactor(0)
+	p.SetDecideLatency(10 * time.Millisecond)
+
+	// disabled by default
+	assert.Nil(t, p.Send(roundchangeBts))
+	scheduler.Advance(time.Second)
+	assert.Nil(t, p.GetTrace())
+
+	// the <decide> sent later is delivered first
+	start := scheduler.Now()
+	p.SetTrace(2)
+	assert.Nil(t, p.Send(roundchangeBts))
+	assert.Nil(t, p.Send(decideBts))
+	scheduler.Advance(time.Second)
+	trace := p.GetTrace()
+	assert.Equal(t, 2, len(trace))
+	assert.Equal(t, TraceEntry{Time: start.Add(10 * time.Millisecond), Delay: 10 * time.Millisecond, Type: MessageType_Decide, Typed: true, Size: len(decideBts)}, trace[0])
+	assert.Equal(t, TraceEntry{Time: start.Add(100 * time.Millisecond), Delay: 100 * time.Millisecond, Type: MessageType_RoundChange, Typed: true, Size: len(roundchangeBts)}, trace[1])
+
+	// bounded to the most recent entries
+	assert.Nil(t, p.Send([]byte{0xff}))
+	scheduler.Advance(time.Second)
+	trace = p.GetTrace()
+	assert.Equal(t, 2, len(trace))
+	assert.Equal(t, MessageType_RoundChange, trace[0].Type)
+	assert.False(t, trace[1].Typed)
+	assert.Equal(t, 1, trace[1].Size)
+
+	p.SetTrace(0)
+	assert.Nil(t, p.GetTrace())
+}
+
+func TestAsymmetricLatency(t *testing.T) {
+	_, roundchange, _ := createRoundChangeMessage(t, 10, 10)
+	bts, err := proto.Marshal(roundchange)
+	assert.Nil(t, err)
+
+	// a satellite uplink and a fast downlink on peer a
+	scheduler := timer.NewManualScheduler(time.Now())
+	a := NewIPCPeerAsymmetric(createConsensus(t, 0, 0, nil), 300*time.Millisecond, 10*time.Millisecond)
+	b := NewIPCPeer(createConsensus(t, 0, 0, nil), 10*time.Millisecond)
+	n := &IPCNetwork{peers: []*IPCPeer{a, b}}
+	for _, p := range n.peers {
+		p.SetScheduler(scheduler)
+		p.SetLatencyStdDevFactor(0)
+		p.SetTrace(1)
+	}
+
+	// a -> b is delayed by a's send latency and b's receive latency
+	assert.Nil(t, (&ipcLink{n: n, from: 0, to: 1, peer: b}).Send(bts))
+	assert.Nil(t, (&ipcLink{n: n, from: 1, to: 0, peer: a}).Send(bts))
+	scheduler.Advance(time.Second)
+	assert.Equal(t, 310*time.Millisecond, b.GetTrace()[0].Delay)
+	assert.Equal(t, 10*time.Millisecond, a.GetTrace()[0].Delay)
+
+	// unknown sender
+	assert.Nil(t, b.Send(bts))
+	scheduler.Advance(time.Second)
+	assert.Equal(t, 10*time.Millisecond, b.GetTrace()[0].Delay)
+
+	a.SetSendLatency(0)
+	assert.Nil(t, (&ipcLink{n: n, from: 0, to: 1, peer: b}).Send(bts))
+	scheduler.Advance(time.Second)
+	assert.Equal(t, 10*time.Millisecond, b.GetTrace()[0].Delay)
+}
+
+func TestSynchronous(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	var peers []*IPCPeer
+	for _, config := range configs {
+		consensus, err := NewConsensus(config)
+		assert.Nil(t, err)
+		p := NewIPCPeer(consensus, 100*time.Millisecond)
+		p.SetSynchronous(true)
+		peers = append(peers, p)
+	}
+	for i := range peers {
+		for j := range peers {
+			if i != j {
+				peers[i].c.Join(peers[j])
+			}
+		}
+	}
+	update := func(p *IPCPeer, now time.Time) {
+		p.Lock()
+		defer p.Unlock()
+		assert.Nil(t, p.c.Update(now))
+	}
+
+	// the <roundchange> proposing is received by others once sent
+	now := configs[0].Epoch
+	peers[0].Propose(State("proposal"))
+	update(peers[0], now.Add(time.Second))
+	for _, p := range peers[1:] {
+		assert.Equal(t, int64(1), p.GetMessageCount())
+		assert.Equal(t, int64(1), p.GetMessageCountByType()[MessageType_RoundChange])
+		assert.Equal(t, []State{State("proposal")}, p.c.currentRound.RoundChangeStates())
+		min, max, _ := p.GetLatencies()
+		assert.Equal(t, time.Duration(0), min)
+		assert.Equal(t, time.Duration(0), max)
+	}
+
+	// replies to a locked peer are delivered once it's unlocked
+	for i := 0; i < 100; i++ {
+		decided := true
+		for _, p := range peers {
+			if h, _, _ := p.GetLatestState(); h < 1 {
+				decided = false
+			}
+		}
+		if decided {
+			break
+		}
+		now = now.Add(100 * time.Millisecond)
+		for _, p := range peers {
+			p.Propose(State("proposal"))
+			update(p, now)
+		}
+	}
+	for _, p := range peers {
+		h, _, s := p.GetLatestState()
+		assert.Equal(t, uint64(1), h)
+		assert.Equal(t, State("proposal"), s)
+	}
+}
@@ -0,0 +1,92 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import "time"
+
+// LatencyEstimator suggests the timeout of <roundchange> stage from latency
+// samples observed by consensus, to keep timeouts tight on fast networks
+// and relaxed on slow ones. Consensus messages carry no timestamps, so
+// samples are the round trip times measured by the leader of a round, from
+// broadcasting <lock> to receiving each <commit> in response.
+type LatencyEstimator interface {
+	// Observe feeds a latency sample
+	Observe(latency time.Duration)
+	// Timeout returns the suggested timeout of <roundchange> stage at
+	// round 0, 0 if there are not enough samples to suggest.
+	Timeout() time.Duration
+}
+
+// EWMALatencyEstimator is the default LatencyEstimator, it smooths latency
+// samples with exponentially weighted moving averages of mean and deviation,
+// and suggests timeout as mean + 4 * deviation, as TCP retransmission
+// timeout does in RFC 6298.
+type EWMALatencyEstimator struct {
+	mean      time.Duration
+	deviation time.Duration
+	observed  bool
+}
+
+// NewEWMALatencyEstimator creates an EWMALatencyEstimator without samples
+func NewEWMALatencyEstimator() *EWMALatencyEstimator {
+	return new(EWMALatencyEstimator)
+}
+
+// Observe implements LatencyEstimator
+func (e *EWMALatencyEstimator) Observe(latency time.Duration) {
+	if latency < 0 {
+		return
+	}
+
+	if !e.observed {
+		e.mean = latency
+		e.deviation = latency / 2
+		e.observed = true
+		return
+	}
+
+	// deviation = 3/4 * deviation + 1/4 * |mean - latency|
+	diff := e.mean - latency
+	if diff < 0 {
+		diff = -diff
+	}
+	e.deviation = e.deviation - e.deviation/4 + diff/4
+	// mean = 7/8 * mean + 1/8 * latency
+	e.mean = e.mean - e.mean/8 + latency/8
+}
+
+// Timeout implements LatencyEstimator
+func (e *EWMALatencyEstimator) Timeout() time.Duration {
+	if !e.observed {
+		return 0
+	}
+	return e.mean + 4*e.deviation
+}
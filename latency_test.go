@@ -0,0 +1,116 @@
+package bdls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Sperax/bdls/timer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEWMALatencyEstimator(t *testing.T) {
+	e := NewEWMALatencyEstimator()
+	assert.Equal(t, time.Duration(0), e.Timeout())
+
+	// steady latencies converge to the latency
+	for i := 0; i < 100; i++ {
+		e.Observe(100 * time.Millisecond)
+	}
+	assert.InDelta(t, float64(100*time.Millisecond), float64(e.Timeout()), float64(5*time.Millisecond))
+
+	// tracks a slower network
+	for i := 0; i < 100; i++ {
+		e.Observe(500 * time.Millisecond)
+	}
+	assert.InDelta(t, float64(500*time.Millisecond), float64(e.Timeout()), float64(25*time.Millisecond))
+
+	// and back to a faster network
+	for i := 0; i < 100; i++ {
+		e.Observe(50 * time.Millisecond)
+	}
+	assert.InDelta(t, float64(50*time.Millisecond), float64(e.Timeout()), float64(5*time.Millisecond))
+
+	// jitters relax the timeout
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			e.Observe(20 * time.Millisecond)
+		} else {
+			e.Observe(80 * time.Millisecond)
+		}
+	}
+	assert.True(t, e.Timeout() > 150*time.Millisecond)
+
+	// negative samples are ignored
+	timeout := e.Timeout()
+	e.Observe(-time.Second)
+	assert.Equal(t, timeout, e.Timeout())
+}
+
+// fixedEstimator suggests a fixed timeout, and records samples
+type fixedEstimator struct {
+	timeout time.Duration
+	samples []time.Duration
+}
+
+func (e *fixedEstimator) Observe(latency time.Duration) { e.samples = append(e.samples, latency) }
+func (e *fixedEstimator) Timeout() time.Duration        { return e.timeout }
+
+func TestRoundChangeDurationEstimator(t *testing.T) {
+	consensus := createConsensus(t, 0, 0, randomPublicKeys(t, ConfigMinimumParticipants))
+	consensus.rcBaseTimeout = time.Second
+	estimator := new(fixedEstimator)
+	consensus.latencyEstimator = estimator
+
+	// falls back to the static timeout without suggestion
+	assert.Equal(t, time.Second, consensus.roundchangeDuration(0))
+
+	estimator.timeout = 100 * time.Millisecond
+	assert.Equal(t, 100*time.Millisecond, consensus.roundchangeDuration(0))
+	assert.Equal(t, 400*time.Millisecond, consensus.roundchangeDuration(2))
+	assert.Equal(t, MaxConsensusLatency, consensus.roundchangeDuration(100))
+}
+
+func TestLatencyEstimatorSamples(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	var estimators []*fixedEstimator
+	for _, config := range configs {
+		estimator := new(fixedEstimator)
+		estimators = append(estimators, estimator)
+		config.LatencyEstimator = estimator
+		config.Scheduler = scheduler
+	}
+
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond)
+	assert.Nil(t, err)
+	defer network.StopAll()
+	for _, p := range network.Peers() {
+		p.Propose(State("data"))
+	}
+
+	for i := 0; i < 6000; i++ {
+		decided := true
+		for _, p := range network.Peers() {
+			if height, _, _ := p.GetLatestState(); height < 1 {
+				decided = false
+			}
+		}
+		if decided {
+			break
+		}
+		scheduler.Advance(10 * time.Millisecond)
+	}
+
+	// the leader of round 0 measured round trips of <lock> & <commit>
+	p := network.Peers()[0]
+	p.Lock()
+	defer p.Unlock()
+	height, round, _ := p.c.CurrentState()
+	assert.Equal(t, uint64(1), height)
+	assert.Equal(t, uint64(0), round)
+	assert.NotEmpty(t, estimators[0].samples)
+	for _, sample := range estimators[0].samples {
+		// twice the link latency, with jitters
+		assert.True(t, sample > 100*time.Millisecond && sample < 400*time.Millisecond, sample)
+	}
+}
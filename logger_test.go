@@ -0,0 +1,74 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewStdLogger(log.New(&buf, "", 0))
+	l.Debugf("a %d", 1)
+	l.Infof("b %d", 2)
+	l.Warnf("c %d", 3)
+	l.Errorf("d %d", 4)
+	assert.Equal(t, "[DEBUG] a 1\n[INFO] b 2\n[WARN] c 3\n[ERROR] d 4\n", buf.String())
+}
+
+func TestDefaultLogger(t *testing.T) {
+	consensus := createConsensus(t, 0, 0, nil)
+	assert.Equal(t, nopLogger{}, consensus.logger)
+}
+
+func TestLoggerWarnOnRejection(t *testing.T) {
+	_, sp, privateKey, proofKeys := createLockMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 10, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+
+	var buf bytes.Buffer
+	consensus.logger = NewStdLogger(log.New(&buf, "", 0))
+
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	err = consensus.ReceiveMessage(bts, time.Now())
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "[WARN] "))
+	assert.Contains(t, buf.String(), err.Error())
+}
@@ -31,13 +31,16 @@
 package bdls
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
-	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	fmt "fmt"
+	"hash"
 	"math/big"
+	"sync"
 
 	"github.com/Sperax/bdls/crypto/blake2b"
 	"github.com/Sperax/bdls/crypto/btcec"
@@ -57,6 +60,80 @@ const (
 	SignaturePrefix = "BDLS_CONSENSUS_SIGNATURE"
 )
 
+// String representation of message type, as it's referred to in the paper
+func (t MessageType) String() string {
+	switch t {
+	case MessageType_Nop:
+		return "nop"
+	case MessageType_RoundChange:
+		return "roundchange"
+	case MessageType_Lock:
+		return "lock"
+	case MessageType_Select:
+		return "select"
+	case MessageType_Commit:
+		return "commit"
+	case MessageType_LockRelease:
+		return "lock-release"
+	case MessageType_Decide:
+		return "decide"
+	case MessageType_Resync:
+		return "resync"
+	}
+	return fmt.Sprintf("unknown(%d)", int32(t))
+}
+
+// IsProofBearing reports whether messages of this type carry proofs to be
+// counted for quorum, they're <lock>, <select> and <decide>.
+func IsProofBearing(t MessageType) bool {
+	switch t {
+	case MessageType_Lock, MessageType_Select, MessageType_Decide:
+		return true
+	}
+	return false
+}
+
+// DecodedMessage is the structural content of a signed message
+type DecodedMessage struct {
+	Type   MessageType // the message type
+	Height uint64      // the height of the message
+	Round  uint64      // the round of the message
+	State  State       // the raw state enclosed, not validated
+}
+
+// ParseMessage decodes the structure of a signed message without verifying
+// signatures or validating states, and has no side effects, it's a target
+// for fuzzers and returns errors rather than panicking on arbitrary bytes.
+func ParseMessage(b []byte) (*DecodedMessage, error) {
+	if len(b) == 0 {
+		return nil, ErrMessageIsEmpty
+	}
+
+	signed := new(SignedProto)
+	if err := proto.Unmarshal(b, signed); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMessageMalformed, err)
+	}
+
+	if signed.Version != ProtocolVersion {
+		return nil, ErrMessageVersion
+	}
+
+	if len(signed.Message) == 0 {
+		return nil, ErrMessageIsEmpty
+	}
+
+	m := new(Message)
+	if err := proto.Unmarshal(signed.Message, m); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMessageMalformed, err)
+	}
+
+	if _, ok := MessageType_name[int32(m.Type)]; !ok {
+		return nil, ErrMessageUnknownMessageType
+	}
+
+	return &DecodedMessage{Type: m.Type, Height: m.Height, Round: m.Round, State: m.State}, nil
+}
+
 // PubKeyAxis defines X-axis or Y-axis in a public key
 type PubKeyAxis [SizeAxis]byte
 
@@ -120,84 +197,258 @@ func DefaultPubKeyToIdentity(pubkey *ecdsa.PublicKey) (ret Identity) {
 	return
 }
 
+// isCanonicalMessage checks if bts is the canonical encoding of the decoded
+// message m, i.e. fields in ascending order of field numbers, integers in
+// minimal varints and default values omitted, as Marshal encodes. Signatures
+// cover the bytes on wire, so rejecting other encodings of the same message
+// makes every message have exactly one signable representation.
+func isCanonicalMessage(m *Message, bts []byte) bool {
+	canonical, err := m.Marshal()
+	return err == nil && bytes.Equal(canonical, bts)
+}
+
+// scanFields walks the fields of an encoded protobuf message without
+// decoding it, fn is called with the field number and the value of varint
+// fields or the bytes of length-delimited fields, other fields are skipped.
+// It fails on truncated fields and groups, which Marshal never produces.
+func scanFields(bts []byte, fn func(field uint64, v uint64, b []byte)) bool {
+	for len(bts) > 0 {
+		key, n := proto.DecodeVarint(bts)
+		if n == 0 {
+			return false
+		}
+		bts = bts[n:]
+
+		switch key & 7 {
+		case proto.WireVarint:
+			v, n := proto.DecodeVarint(bts)
+			if n == 0 {
+				return false
+			}
+			bts = bts[n:]
+			fn(key>>3, v, nil)
+		case proto.WireFixed64:
+			if len(bts) < 8 {
+				return false
+			}
+			bts = bts[8:]
+		case proto.WireFixed32:
+			if len(bts) < 4 {
+				return false
+			}
+			bts = bts[4:]
+		case proto.WireBytes:
+			l, n := proto.DecodeVarint(bts)
+			if n == 0 || l > uint64(len(bts)-n) {
+				return false
+			}
+			fn(key>>3, 0, bts[n:n+int(l)])
+			bts = bts[n+int(l):]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// scanSignedMessage reads the X-axis of the signer and the encoded Message
+// from an encoded SignedProto without decoding it, the last occurrence of a
+// field wins as in decoding.
+func scanSignedMessage(bts []byte) (x PubKeyAxis, message []byte, ok bool) {
+	ok = scanFields(bts, func(field uint64, v uint64, b []byte) {
+		switch field {
+		case 2: // SignedProto.Message
+			message = b
+		case 3: // SignedProto.X
+			copy(x[:], b)
+		}
+	})
+	return x, message, ok
+}
+
+// scanHeightRound reads the type, height and round from an encoded Message
+// without decoding it, the last occurrence of a field wins as in decoding.
+func scanHeightRound(bts []byte) (typ MessageType, height uint64, round uint64, ok bool) {
+	ok = scanFields(bts, func(field uint64, v uint64, b []byte) {
+		if b != nil {
+			return
+		}
+		switch field {
+		case 1: // Message.Type
+			typ = MessageType(v)
+		case 2: // Message.Height
+			height = v
+		case 3: // Message.Round
+			round = v
+		}
+	})
+	return typ, height, round, ok
+}
+
+// messageHeader is the signer's X-axis, and the type, height and round of a
+// message on wire, scanned by peekMessage to check a message before decoding.
+type messageHeader struct {
+	X      PubKeyAxis
+	Type   MessageType
+	Height uint64
+	Round  uint64
+}
+
+// peekMessage scans the header of a signed message without decoding it
+func peekMessage(bts []byte) (h messageHeader, ok bool) {
+	x, message, ok := scanSignedMessage(bts)
+	if !ok {
+		return h, false
+	}
+	h.X = x
+	h.Type, h.Height, h.Round, ok = scanHeightRound(message)
+	return h, ok
+}
+
+// verifyError wraps err as verifyError does for the decoded message
+func (h *messageHeader) verifyError(err error) error {
+	return verifyError(&Message{Type: h.Type, Height: h.Height, Round: h.Round}, &SignedProto{X: h.X}, err)
+}
+
+// signedHasher is the reusable scratch for hashing a signed message.
+type signedHasher struct {
+	hash hash.Hash
+	buf  [4]byte
+}
+
+// signedHasherPool recycles hashers on the message decoding path, a hasher
+// holds no reference to the message after its digest has been summed.
+var signedHasherPool = sync.Pool{
+	New: func() interface{} {
+		hash, err := blake2b.New256(nil)
+		if err != nil {
+			panic(err)
+		}
+		return &signedHasher{hash: hash}
+	},
+}
+
+// signaturePrefix is SignaturePrefix in bytes
+var signaturePrefix = []byte(SignaturePrefix)
+
 // Hash concats and hash as follows:
 // blake2b(signPrefix + version + pubkey.X + pubkey.Y+len_32bit(msg) + message)
 func (sp *SignedProto) Hash() []byte {
-	hash, err := blake2b.New256(nil)
-	if err != nil {
-		panic(err)
+	return sp.appendHash(nil, nil)
+}
+
+// HashWith hashes the signed message as Hash does, with the hash function
+// created by newHash instead of blake2b, nil newHash means blake2b.
+func (sp *SignedProto) HashWith(newHash func() hash.Hash) []byte {
+	return sp.appendHash(nil, newHash)
+}
+
+// appendHash appends the hash of the signed message to dst and returns
+// the extended buffer, blake2b hashers are recycled if newHash is nil.
+func (sp *SignedProto) appendHash(dst []byte, newHash func() hash.Hash) []byte {
+	if newHash != nil {
+		var buf [4]byte
+		return sp.sumHash(dst, newHash(), buf[:])
 	}
+
+	h := signedHasherPool.Get().(*signedHasher)
+	defer signedHasherPool.Put(h)
+	h.hash.Reset()
+	return sp.sumHash(dst, h.hash, h.buf[:])
+}
+
+// sumHash writes the signed message to a reset hash, and appends the digest
+// to dst, buf is the scratch for 32bit integers.
+func (sp *SignedProto) sumHash(dst []byte, h hash.Hash, buf []byte) []byte {
 	// write prefix
-	_, err = hash.Write([]byte(SignaturePrefix))
-	if err != nil {
-		panic(err)
-	}
+	h.Write(signaturePrefix)
 
 	// write version
-	err = binary.Write(hash, binary.LittleEndian, sp.Version)
-	if err != nil {
-		panic(err)
-	}
+	binary.LittleEndian.PutUint32(buf, sp.Version)
+	h.Write(buf)
 
 	// write X & Y
-	_, err = hash.Write(sp.X[:])
-	if err != nil {
-		panic(err)
-	}
-
-	_, err = hash.Write(sp.Y[:])
-	if err != nil {
-		panic(err)
-	}
+	h.Write(sp.X[:])
+	h.Write(sp.Y[:])
 
 	// write message length
-	err = binary.Write(hash, binary.LittleEndian, uint32(len(sp.Message)))
-	if err != nil {
-		panic(err)
-	}
+	binary.LittleEndian.PutUint32(buf, uint32(len(sp.Message)))
+	h.Write(buf)
 
 	// write message
-	_, err = hash.Write(sp.Message)
+	h.Write(sp.Message)
+
+	return h.Sum(dst)
+}
+
+// Sign the message with a private key
+func (sp *SignedProto) Sign(m *Message, privateKey *ecdsa.PrivateKey) {
+	err := sp.SignWith(m, privateKeySigner{privateKey})
 	if err != nil {
 		panic(err)
 	}
+}
 
-	return hash.Sum(nil)
+// SignWith signs the message with a signer, the private key can be kept
+// outside of the process.
+func (sp *SignedProto) SignWith(m *Message, signer Signer) error {
+	return sp.SignWithHasher(m, signer, nil)
 }
 
-// Sign the message with a private key
-func (sp *SignedProto) Sign(m *Message, privateKey *ecdsa.PrivateKey) {
+// SignWithHasher signs the message with a signer as SignWith does, the
+// message is hashed by the hash function created by newHash, see HashWith.
+// The message is signed with sp.Version, or ProtocolVersion if it's unset.
+func (sp *SignedProto) SignWithHasher(m *Message, signer Signer, newHash func() hash.Hash) error {
 	bts, err := proto.Marshal(m)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	// hash message, the version set in advance is signed
+	if sp.Version == 0 {
+		sp.Version = ProtocolVersion
 	}
-	// hash message
-	sp.Version = ProtocolVersion
 	sp.Message = bts
 
-	err = sp.X.Unmarshal(privateKey.PublicKey.X.Bytes())
+	publicKey := signer.Public()
+	err = sp.X.Unmarshal(publicKey.X.Bytes())
 	if err != nil {
-		panic(err)
+		return err
 	}
-	err = sp.Y.Unmarshal(privateKey.PublicKey.Y.Bytes())
+	err = sp.Y.Unmarshal(publicKey.Y.Bytes())
 	if err != nil {
-		panic(err)
+		return err
 	}
-	hash := sp.Hash()
+	hash := sp.HashWith(newHash)
 
 	// sign the message
-	r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash)
+	sig, err := signer.Sign(hash)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	r, s, err := decodeSignature(sig)
+	if err != nil {
+		return err
+	}
+	// (r, n-s) verifies as (r, s) does, only the low-S form is accepted
+	if n := publicKey.Curve.Params().N; s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s.Sub(n, s)
 	}
 	sp.R = r.Bytes()
 	sp.S = s.Bytes()
+	return nil
 }
 
 // Verify the signature of this signed message
 func (sp *SignedProto) Verify(curve elliptic.Curve) bool {
+	return sp.VerifyWithHasher(curve, nil)
+}
+
+// VerifyWithHasher verifies the signature of this signed message hashed by
+// the hash function created by newHash, see HashWith.
+func (sp *SignedProto) VerifyWithHasher(curve elliptic.Curve, newHash func() hash.Hash) bool {
 	var X, Y, R, S big.Int
-	hash := sp.Hash()
+	var digest [blake2b.Size256]byte
+	hash := sp.appendHash(digest[:0], newHash)
 	// verify against public key and r, s
 	pubkey := ecdsa.PublicKey{}
 	pubkey.Curve = curve
@@ -211,6 +462,16 @@ func (sp *SignedProto) Verify(curve elliptic.Curve) bool {
 	return ecdsa.Verify(&pubkey, hash, &R, &S)
 }
 
+// IsLowS reports whether the signature is in the canonical low-S form, with
+// s no larger than half of the order of the curve. ECDSA signatures are
+// malleable, (r, n-s) is another valid signature of the same message, so
+// only the low-S form is accepted to keep the bits of a message unique.
+func (sp *SignedProto) IsLowS(curve elliptic.Curve) bool {
+	var S big.Int
+	S.SetBytes(sp.S)
+	return S.Cmp(new(big.Int).Rsh(curve.Params().N, 1)) <= 0
+}
+
 // PublicKey returns the public key of this signed message
 func (sp *SignedProto) PublicKey(curve elliptic.Curve) *ecdsa.PublicKey {
 	pubkey := new(ecdsa.PublicKey)
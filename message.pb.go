@@ -67,10 +67,6 @@ var MessageType_value = map[string]int32{
 	"Resync":      7,
 }
 
-func (x MessageType) String() string {
-	return proto.EnumName(MessageType_name, int32(x))
-}
-
 func (MessageType) EnumDescriptor() ([]byte, []int) {
 	return fileDescriptor_33c57e4bae7b9afd, []int{0}
 }
@@ -255,31 +251,32 @@ func init() {
 func init() { proto.RegisterFile("message.proto", fileDescriptor_33c57e4bae7b9afd) }
 
 var fileDescriptor_33c57e4bae7b9afd = []byte{
-	// 381 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x92, 0xcd, 0xaa, 0xda, 0x40,
-	0x14, 0xc7, 0x1d, 0xf3, 0x61, 0x39, 0xd1, 0x76, 0x3a, 0x94, 0x32, 0x74, 0xa1, 0x41, 0x28, 0x95,
-	0x42, 0x23, 0xd4, 0x27, 0xa8, 0x76, 0x51, 0xe8, 0x07, 0x32, 0xf6, 0x05, 0xf2, 0x71, 0x8c, 0xa1,
-	0x26, 0x23, 0x99, 0xa4, 0x98, 0xc7, 0xe9, 0xdb, 0xb8, 0x2c, 0x5d, 0x76, 0x21, 0x17, 0x9f, 0xe4,
-	0x32, 0x13, 0xbd, 0x64, 0x71, 0xef, 0xee, 0xfc, 0xce, 0xff, 0x7f, 0xce, 0xf9, 0x27, 0x0c, 0x8c,
-	0x72, 0x54, 0x2a, 0x4c, 0x31, 0x38, 0x94, 0xb2, 0x92, 0xcc, 0x8e, 0x92, 0xbd, 0x7a, 0xf3, 0x21,
-	0xcd, 0xaa, 0x5d, 0x1d, 0x05, 0xb1, 0xcc, 0xe7, 0xa9, 0x4c, 0xe5, 0xdc, 0x88, 0x51, 0xbd, 0x35,
-	0x64, 0xc0, 0x54, 0xed, 0xd0, 0xf4, 0x0f, 0x01, 0x6f, 0x93, 0xa5, 0x05, 0x26, 0x6b, 0xb3, 0x84,
-	0xc3, 0xe0, 0x37, 0x96, 0x2a, 0x93, 0x05, 0x27, 0x3e, 0x99, 0x8d, 0xc4, 0x0d, 0xb5, 0xf2, 0xbd,
-	0xbd, 0xc7, 0xfb, 0x3e, 0x99, 0x0d, 0xc5, 0x0d, 0x99, 0x0f, 0xe4, 0xc8, 0x2d, 0xdd, 0x5b, 0xb2,
-	0xd3, 0x79, 0xd2, 0xfb, 0x7f, 0x9e, 0xc0, 0xba, 0x8e, 0xbe, 0x62, 0xf3, 0xe9, 0x98, 0x29, 0x41,
-	0x8e, 0xda, 0xd1, 0x70, 0xfb, 0x69, 0x47, 0xc3, 0x86, 0x40, 0x4a, 0xee, 0x98, 0xbd, 0xa4, 0xd4,
-	0xa4, 0xb8, 0xdb, 0x92, 0x9a, 0xfe, 0x23, 0x0f, 0xa7, 0xd9, 0x5b, 0xb0, 0x7f, 0x36, 0x07, 0x34,
-	0xe1, 0x9e, 0x7f, 0x7c, 0x19, 0xe8, 0x6f, 0x0e, 0xae, 0xa2, 0x16, 0x84, 0x91, 0xd9, 0x6b, 0x70,
-	0xbf, 0x60, 0x96, 0xee, 0x2a, 0x93, 0xd5, 0x16, 0x57, 0x62, 0xaf, 0xc0, 0x11, 0xb2, 0x2e, 0x12,
-	0x13, 0xd7, 0x16, 0x2d, 0xe8, 0xee, 0xa6, 0x0a, 0x2b, 0x6c, 0x23, 0x8a, 0x16, 0xd8, 0x3b, 0x70,
-	0xd6, 0xa5, 0x94, 0x5b, 0xee, 0xf8, 0xd6, 0xcc, 0xbb, 0xdd, 0xea, 0xfc, 0x2c, 0xd1, 0xea, 0x6c,
-	0x01, 0xde, 0x37, 0x19, 0xff, 0x12, 0xb8, 0xc7, 0x50, 0xa1, 0xc9, 0xfd, 0xa8, 0xbd, 0xeb, 0x7a,
-	0x5f, 0x82, 0xd7, 0x89, 0xcd, 0x06, 0x60, 0xfd, 0x90, 0x07, 0xda, 0x63, 0x2f, 0xc0, 0x33, 0xa1,
-	0x56, 0xbb, 0xb0, 0x48, 0x91, 0x12, 0xf6, 0x0c, 0x6c, 0x3d, 0x47, 0xfb, 0x0c, 0xc0, 0xdd, 0xe0,
-	0x1e, 0xe3, 0x8a, 0x5a, 0xba, 0x5e, 0xc9, 0x3c, 0xcf, 0x2a, 0x6a, 0xeb, 0x91, 0xce, 0x66, 0xea,
-	0x68, 0xf1, 0x33, 0xc6, 0x59, 0x82, 0xd4, 0xd5, 0xb5, 0x40, 0xd5, 0x14, 0x31, 0x1d, 0x2c, 0x87,
-	0xa7, 0xcb, 0x98, 0xfc, 0xbd, 0x8c, 0xc9, 0xdd, 0x65, 0x4c, 0x22, 0xd7, 0xbc, 0x80, 0xc5, 0x7d,
-	0x00, 0x00, 0x00, 0xff, 0xff, 0x43, 0xc3, 0x64, 0x6c, 0x47, 0x02, 0x00, 0x00,
+	// 386 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x92, 0xcf, 0xaa, 0xd3, 0x40,
+	0x14, 0xc6, 0x3b, 0x37, 0x93, 0x54, 0x4e, 0x7a, 0x75, 0x1c, 0x44, 0x86, 0xbb, 0xc8, 0x0d, 0x17,
+	0xc4, 0x22, 0x98, 0x0b, 0xde, 0x27, 0xf0, 0xd6, 0x85, 0xe0, 0x1f, 0xca, 0xd4, 0x17, 0xc8, 0x9f,
+	0xd3, 0x34, 0xd8, 0x64, 0x4a, 0x26, 0x91, 0xe4, 0x71, 0x04, 0x17, 0x3e, 0x4a, 0x97, 0xe2, 0xd2,
+	0x45, 0x91, 0x3e, 0x89, 0xcc, 0xa4, 0x95, 0x2c, 0x74, 0x77, 0x7e, 0xe7, 0xfb, 0xce, 0x39, 0x5f,
+	0xc2, 0xc0, 0x65, 0x89, 0x5a, 0xc7, 0x39, 0x46, 0xbb, 0x5a, 0x35, 0x8a, 0xd3, 0x24, 0xdb, 0xea,
+	0xab, 0x97, 0x79, 0xd1, 0x6c, 0xda, 0x24, 0x4a, 0x55, 0x79, 0x9b, 0xab, 0x5c, 0xdd, 0x5a, 0x31,
+	0x69, 0xd7, 0x96, 0x2c, 0xd8, 0x6a, 0x18, 0xba, 0xf9, 0x4a, 0xc0, 0x5f, 0x15, 0x79, 0x85, 0xd9,
+	0xd2, 0x2e, 0x11, 0x30, 0xfd, 0x82, 0xb5, 0x2e, 0x54, 0x25, 0x48, 0x48, 0xe6, 0x97, 0xf2, 0x8c,
+	0x46, 0xf9, 0x30, 0xdc, 0x13, 0x17, 0x21, 0x99, 0xcf, 0xe4, 0x19, 0x79, 0x08, 0xa4, 0x13, 0x8e,
+	0xe9, 0xdd, 0xf3, 0xfd, 0xe1, 0x7a, 0xf2, 0xeb, 0x70, 0x0d, 0xcb, 0x36, 0x79, 0x87, 0xfd, 0xeb,
+	0xae, 0xd0, 0x92, 0x74, 0xc6, 0xd1, 0x0b, 0xfa, 0x7f, 0x47, 0xcf, 0x67, 0x40, 0x6a, 0xe1, 0xda,
+	0xbd, 0xa4, 0x36, 0xa4, 0x85, 0x37, 0x90, 0xbe, 0xf9, 0x49, 0xfe, 0x9e, 0xe6, 0xcf, 0x80, 0x7e,
+	0xea, 0x77, 0x68, 0xc3, 0x3d, 0x7c, 0xf5, 0x38, 0x32, 0xdf, 0x1c, 0x9d, 0x44, 0x23, 0x48, 0x2b,
+	0xf3, 0xa7, 0xe0, 0xbd, 0xc5, 0x22, 0xdf, 0x34, 0x36, 0x2b, 0x95, 0x27, 0xe2, 0x4f, 0xc0, 0x95,
+	0xaa, 0xad, 0x32, 0x1b, 0x97, 0xca, 0x01, 0x4c, 0x77, 0xd5, 0xc4, 0x0d, 0x0e, 0x11, 0xe5, 0x00,
+	0xfc, 0x39, 0xb8, 0xcb, 0x5a, 0xa9, 0xb5, 0x70, 0x43, 0x67, 0xee, 0x9f, 0x6f, 0x8d, 0x7e, 0x96,
+	0x1c, 0x74, 0x7e, 0x07, 0xfe, 0x7b, 0x95, 0x7e, 0x96, 0xb8, 0xc5, 0x58, 0xa3, 0xcd, 0xfd, 0x4f,
+	0xfb, 0xd8, 0xf5, 0xa2, 0x03, 0x7f, 0x14, 0x9b, 0x4f, 0xc1, 0xf9, 0xa8, 0x76, 0x6c, 0xc2, 0x1f,
+	0x81, 0x6f, 0x43, 0x2d, 0x36, 0x71, 0x95, 0x23, 0x23, 0xfc, 0x01, 0x50, 0x33, 0xc7, 0x2e, 0x38,
+	0x80, 0xb7, 0xc2, 0x2d, 0xa6, 0x0d, 0x73, 0x4c, 0xbd, 0x50, 0x65, 0x59, 0x34, 0x8c, 0x9a, 0x91,
+	0xd1, 0x66, 0xe6, 0x1a, 0xf1, 0x0d, 0xa6, 0x45, 0x86, 0xcc, 0x33, 0xb5, 0x44, 0xdd, 0x57, 0x29,
+	0x9b, 0x5e, 0xd1, 0xef, 0xdf, 0x82, 0xc9, 0xfd, 0x6c, 0x7f, 0x0c, 0xc8, 0x8f, 0x63, 0x40, 0x7e,
+	0x1f, 0x03, 0x92, 0x78, 0xf6, 0x1d, 0xdc, 0xfd, 0x19, 0x00, 0xc7, 0x4f, 0x12, 0x67, 0x4d, 0x02,
+	0x00, 0x00,
 }
 
 func (m *SignedProto) Marshal() (dAtA []byte, err error) {
@@ -4,9 +4,17 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	fmt "fmt"
+	"hash"
 	"io"
+	"io/ioutil"
+	"math/big"
 	mrand "math/rand"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -48,7 +56,7 @@ func createRoundChangeMessageSigner(t testing.TB, height uint64, round uint64, s
 }
 
 // createCommitMessage generates a random valid <commit> message
-func createCommitMessageSigner(t *testing.T, height uint64, round uint64, state State, signer *ecdsa.PrivateKey) (*Message, *SignedProto, *ecdsa.PrivateKey) {
+func createCommitMessageSigner(t testing.TB, height uint64, round uint64, state State, signer *ecdsa.PrivateKey) (*Message, *SignedProto, *ecdsa.PrivateKey) {
 	// <roundchange>
 	rc := new(Message)
 	rc.Type = MessageType_Commit
@@ -63,7 +71,7 @@ func createCommitMessageSigner(t *testing.T, height uint64, round uint64, state
 }
 
 // createCommitMessage generates a random valid <commit> message
-func createCommitMessage(t *testing.T, height uint64, round uint64, state State) (*Message, *SignedProto, *ecdsa.PrivateKey) {
+func createCommitMessage(t testing.TB, height uint64, round uint64, state State) (*Message, *SignedProto, *ecdsa.PrivateKey) {
 	// key generation
 	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
 	assert.Nil(t, err)
@@ -182,7 +190,7 @@ func createSelectMessage(t *testing.T, numProofs int, height uint64, round uint6
 
 // createDecideMessage creates a valid <decide> message, and generate <commit> proofs based on quorum,
 // the first 2t+1 roundchange proposals are the same
-func createDecideMessage(t *testing.T, numProofs int, height uint64, round uint64, proofHeight uint64, proofRound uint64) (*Message, *SignedProto, *ecdsa.PrivateKey, []*ecdsa.PublicKey) {
+func createDecideMessage(t testing.TB, numProofs int, height uint64, round uint64, proofHeight uint64, proofRound uint64) (*Message, *SignedProto, *ecdsa.PrivateKey, []*ecdsa.PublicKey) {
 	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
 	assert.Nil(t, err)
 	valid := 2*((numProofs-1)/3) + 1
@@ -251,6 +259,7 @@ func TestVerifyMessage(t *testing.T) {
 	// change signature to random to verify incorrect signature
 	_, _ = io.ReadFull(rand.Reader, sp.R)
 	_, _ = io.ReadFull(rand.Reader, sp.S)
+	sp.S[0] &= 0x7f // keep low-S, so it fails on the signature itself
 	_, err = consensus.verifyMessage(sp)
 	assert.Equal(t, ErrMessageSignature, err)
 
@@ -276,6 +285,41 @@ func TestVerifyMessage(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestPeekMessage(t *testing.T) {
+	_, rc, _ := createRoundChangeMessage(t, 10, 3)
+	_, lock, _, _ := createLockMessage(t, 4, 11, 2, 11, 2)
+	_, sel, _, _ := createSelectMessage(t, 4, 12, 1, 12, 1)
+	for _, signed := range []*SignedProto{rc, lock, sel} {
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		m, err := DecodeMessage(signed.Message)
+		assert.Nil(t, err)
+
+		// the header is the same as decoded
+		h, ok := peekMessage(bts)
+		assert.True(t, ok)
+		assert.Equal(t, messageHeader{X: signed.X, Type: m.Type, Height: m.Height, Round: m.Round}, h)
+		assert.Equal(t, verifyError(m, signed, ErrMessageReplay).Error(), h.verifyError(ErrMessageReplay).Error())
+
+		// truncated messages fail
+		_, ok = peekMessage(bts[:len(bts)-1])
+		assert.False(t, ok)
+		_, _, _, ok = scanHeightRound(signed.Message[:3])
+		assert.False(t, ok)
+	}
+
+	// the last occurrence of a field wins as in decoding
+	bts := append(proto.EncodeVarint(2<<3|proto.WireVarint), proto.EncodeVarint(7)...)
+	bts = append(bts, proto.EncodeVarint(2<<3|proto.WireVarint)...)
+	bts = append(bts, proto.EncodeVarint(8)...)
+	_, height, _, ok := scanHeightRound(bts)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(8), height)
+	m, err := DecodeMessage(bts)
+	assert.Nil(t, err)
+	assert.Equal(t, m.Height, height)
+}
+
 func TestVerifyMessageUnknownVersion(t *testing.T) {
 	// signer
 	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
@@ -309,7 +353,7 @@ func TestVerifyMessageUnknownVersion(t *testing.T) {
 	bts, err = proto.Marshal(sp)
 	assert.Nil(t, err)
 	err = consensus.ReceiveMessage(bts, time.Now())
-	assert.Equal(t, ErrMessageVersion, err)
+	assert.True(t, errors.Is(err, ErrMessageVersion))
 }
 
 func TestVerifyMessageUnknownType(t *testing.T) {
@@ -328,7 +372,7 @@ func TestVerifyMessageUnknownType(t *testing.T) {
 	bts, err := proto.Marshal(sp)
 	assert.Nil(t, err)
 	err = consensus.ReceiveMessage(bts, time.Now())
-	assert.Equal(t, ErrMessageUnknownMessageType, err)
+	assert.True(t, errors.Is(err, ErrMessageUnknownMessageType))
 }
 
 func TestVerifyMessageUnknownParticipant(t *testing.T) {
@@ -346,6 +390,121 @@ func TestVerifyMessageUnknownParticipant(t *testing.T) {
 	assert.Equal(t, ErrMessageUnknownParticipant, err)
 }
 
+func TestValidateMessage(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+	consensus := createConsensus(t, 0, 0, []*ecdsa.PublicKey{&privateKey.PublicKey})
+
+	sign := func(m *Message, key *ecdsa.PrivateKey) []byte {
+		sp := new(SignedProto)
+		sp.Sign(m, key)
+		bts, err := proto.Marshal(sp)
+		assert.Nil(t, err)
+		return bts
+	}
+
+	// valid message is not applied
+	state := State("state")
+	rc, _, _ := createRoundChangeMessageSigner(t, 1, 0, state, privateKey)
+	bts := sign(rc, privateKey)
+	numRounds := consensus.rounds.Len()
+	assert.Nil(t, consensus.ValidateMessage(bts))
+	assert.False(t, consensus.HasProposed(state))
+	assert.Equal(t, numRounds, consensus.rounds.Len())
+	assert.Equal(t, 0, consensus.dedup.Len())
+
+	// messages are validated regardless of height
+	_, signed, _ := createRoundChangeMessageSigner(t, 100, 0, state, privateKey)
+	bts, err = proto.Marshal(signed)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ValidateMessage(bts))
+
+	// garbage
+	assert.NotNil(t, consensus.ValidateMessage([]byte("garbage")))
+
+	// version
+	sp := new(SignedProto)
+	sp.Sign(rc, privateKey)
+	sp.Version = ProtocolVersion + 1
+	bts, err = proto.Marshal(sp)
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(consensus.ValidateMessage(bts), ErrMessageVersion))
+
+	// signature
+	sp = new(SignedProto)
+	sp.Sign(rc, privateKey)
+	sp.Message = append(sp.Message, 0)
+	bts, err = proto.Marshal(sp)
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(consensus.ValidateMessage(bts), ErrMessageSignature))
+
+	// unknown participant
+	randKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(consensus.ValidateMessage(sign(rc, randKey)), ErrMessageUnknownParticipant))
+
+	// unknown message type
+	unknown := &Message{Type: MessageType(100)}
+	assert.True(t, errors.Is(consensus.ValidateMessage(sign(unknown, privateKey)), ErrMessageUnknownMessageType))
+}
+
+func TestReceiveMessageFutureHeight(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+	keys := randomPublicKeys(t, ConfigMinimumParticipants)
+	consensus := createConsensus(t, 10, 0, append(keys, &privateKey.PublicKey))
+	consensus.dedup = nil
+	numRounds := consensus.rounds.Len()
+
+	// flood of messages for future heights from a participant
+	for height := uint64(12); height < 1000; height++ {
+		_, signed, _ := createRoundChangeMessageSigner(t, height, 0, State("state"), privateKey)
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		err = consensus.ReceiveMessage(bts, time.Now())
+		if height > 11+DefaultMaxFutureHeight {
+			assert.True(t, errors.Is(err, ErrMessageFutureHeightExceeded))
+		} else {
+			assert.True(t, errors.Is(err, ErrRoundChangeHeightMismatch))
+		}
+	}
+
+	// nothing has been kept
+	assert.Equal(t, numRounds, consensus.rounds.Len())
+	for elem := consensus.rounds.Front(); elem != nil; elem = elem.Next() {
+		assert.Equal(t, 0, elem.Value.(*consensusRound).NumRoundChanges())
+	}
+	assert.Equal(t, uint64(1000-12-DefaultMaxFutureHeight), consensus.Metrics(time.Now()).NumFutureHeightDropped)
+}
+
+func TestReceiveMessageFutureHeightConfig(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+	consensus := createConsensus(t, 10, 0, append(randomPublicKeys(t, ConfigMinimumParticipants), &privateKey.PublicKey))
+	consensus.maxFutureHeight = 1
+
+	_, signed, _ := createRoundChangeMessageSigner(t, 13, 0, State("state"), privateKey)
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(consensus.ReceiveMessage(bts, time.Now()), ErrMessageFutureHeightExceeded))
+	assert.Equal(t, uint64(1), consensus.Metrics(time.Now()).NumFutureHeightDropped)
+}
+
+func TestReceiveMessageFutureHeightDecide(t *testing.T) {
+	// a <decide> message far beyond the window is accepted to catch up
+	_, sp, privateKey, proofKeys := createDecideMessage(t, 20, 100, 10, 100, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	height, _, _ := consensus.CurrentState()
+	assert.Equal(t, uint64(100), height)
+	assert.Equal(t, uint64(0), consensus.Metrics(time.Now()).NumFutureHeightDropped)
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 //
 // <roundchange> message related tests
@@ -395,6 +554,21 @@ func TestVerifyLockMessageHeight(t *testing.T) {
 	assert.Equal(t, ErrLockHeightMismatch, err)
 }
 
+func TestReceiveLockMessageWrappedError(t *testing.T) {
+	_, sp, privateKey, proofKeys := createLockMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 10, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	err = consensus.ReceiveMessage(bts, time.Now())
+	assert.True(t, errors.Is(err, ErrLockHeightMismatch))
+	assert.Contains(t, err.Error(), "verifying <lock>")
+	assert.Contains(t, err.Error(), "at height 10")
+	assert.Contains(t, err.Error(), sp.X.String())
+}
+
 func TestVerifyLockMessageRound(t *testing.T) {
 	m, sp, privateKey, proofKeys := createLockMessage(t, 20, 1, 0, 1, 0)
 	consensus := createConsensus(t, 0, 1, proofKeys)
@@ -404,6 +578,24 @@ func TestVerifyLockMessageRound(t *testing.T) {
 	assert.Equal(t, ErrLockRoundLower, err)
 }
 
+func TestVerifyLockMessageStateValidateAt(t *testing.T) {
+	m, sp, privateKey, proofKeys := createLockMessage(t, 20, 1, 0, 1, 0)
+	consensus := createConsensus(t, 0, 0, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+
+	var heights, rounds []uint64
+	consensus.stateValidateAt = func(height uint64, round uint64, s State) bool {
+		heights = append(heights, height)
+		rounds = append(rounds, round)
+		return height != 1
+	}
+
+	err := consensus.verifyLockMessage(m, sp)
+	assert.Equal(t, ErrLockStateValidation, err)
+	assert.Equal(t, []uint64{1}, heights)
+	assert.Equal(t, []uint64{0}, rounds)
+}
+
 func TestVerifyLockMessageStateNil(t *testing.T) {
 	m, sp, privateKey, proofKeys := createLockMessageState(t, 20, nil, 1, 0, 1, 0)
 	consensus := createConsensus(t, 0, 0, proofKeys)
@@ -437,6 +629,7 @@ func TestVerifyLockMessageProofSignature(t *testing.T) {
 	i := mrand.Int() % len(m.Proof)
 	_, _ = io.ReadFull(rand.Reader, m.Proof[i].R)
 	_, _ = io.ReadFull(rand.Reader, m.Proof[i].S)
+	m.Proof[i].S[0] &= 0x7f // keep low-S, so it fails on the signature itself
 	// re-sign the sp with a incorrectly signed proof
 	sp.Sign(m, privateKey)
 
@@ -606,6 +799,7 @@ func TestVerifySelectMessageProofSignature(t *testing.T) {
 	i := mrand.Int() % len(m.Proof)
 	_, _ = io.ReadFull(rand.Reader, m.Proof[i].R)
 	_, _ = io.ReadFull(rand.Reader, m.Proof[i].S)
+	m.Proof[i].S[0] &= 0x7f // keep low-S, so it fails on the signature itself
 	// re-sign the sp with a incorrectly signed proof
 	sp.Sign(m, privateKey)
 
@@ -784,9 +978,11 @@ func TestVerifyLockReleaseMessageValid(t *testing.T) {
 
 	// set status
 	consensus.currentRound.Stage = stageLockRelease
-	msg, err := consensus.verifyLockReleaseMessage(sp)
+	msg, proposer, err := consensus.verifyLockReleaseMessage(sp)
 	assert.Nil(t, err)
 	assert.NotNil(t, msg)
+	assert.NotEqual(t, Identity{}, proposer)
+	assert.Equal(t, consensus.lockProposer(msg), proposer)
 }
 
 func TestVerifyLockReleaseMessageStatusInValid(t *testing.T) {
@@ -798,7 +994,7 @@ func TestVerifyLockReleaseMessageStatusInValid(t *testing.T) {
 	consensus.participants = consensus.participants[1:]
 	assert.Equal(t, quorum, len(consensus.participants))
 
-	msg, err := consensus.verifyLockReleaseMessage(sp)
+	msg, _, err := consensus.verifyLockReleaseMessage(sp)
 	assert.Equal(t, ErrLockReleaseStatus, err)
 	assert.Nil(t, msg)
 }
@@ -1069,6 +1265,7 @@ func TestVerifyDecideMessageProofSignature(t *testing.T) {
 	i := mrand.Int() % len(m.Proof)
 	_, _ = io.ReadFull(rand.Reader, m.Proof[i].R)
 	_, _ = io.ReadFull(rand.Reader, m.Proof[i].S)
+	m.Proof[i].S[0] &= 0x7f // keep low-S, so it fails on the signature itself
 	// re-sign the sp with a incorrectly signed proof
 	sp.Sign(m, privateKey)
 
@@ -1103,6 +1300,34 @@ func BenchmarkSecp256k1Verify(b *testing.B) {
 	}
 }
 
+func BenchmarkSignedProtoHash(b *testing.B) {
+	privateKey, _ := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	_, sp, _ := createCommitMessageSigner(b, 1, 0, make([]byte, 1024), privateKey)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sp.Hash()
+	}
+}
+
+func BenchmarkDecodeMessage(b *testing.B) {
+	privateKey, _ := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	_, sp, _ := createCommitMessageSigner(b, 1, 0, make([]byte, 1024), privateKey)
+	bts, err := proto.Marshal(sp)
+	if err != nil {
+		b.Fatal(err)
+	}
+	consensus := createConsensus(b, 0, 0, []*ecdsa.PublicKey{&privateKey.PublicKey})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := consensus.decodeMessage(bts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func TestMessageMarshalJson(t *testing.T) {
 	_, sp, _, _ := createDecideMessage(t, 10, 1, 0, 1, 0)
 	bts, err := json.Marshal(sp)
@@ -1113,3 +1338,323 @@ func TestMessageMarshalJson(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, sp, sp2)
 }
+
+func TestMessageTypeString(t *testing.T) {
+	var names = map[MessageType]string{
+		MessageType_Nop:         "nop",
+		MessageType_RoundChange: "roundchange",
+		MessageType_Lock:        "lock",
+		MessageType_Select:      "select",
+		MessageType_Commit:      "commit",
+		MessageType_LockRelease: "lock-release",
+		MessageType_Decide:      "decide",
+		MessageType_Resync:      "resync",
+	}
+
+	for k := range MessageType_name {
+		_, ok := names[MessageType(k)]
+		assert.True(t, ok, "missing name for %d", k)
+	}
+
+	for k, v := range names {
+		assert.Equal(t, v, k.String())
+	}
+	assert.Equal(t, "unknown(100)", MessageType(100).String())
+	assert.Equal(t, "<lock>", fmt.Sprintf("<%v>", MessageType_Lock))
+}
+
+func TestIsProofBearing(t *testing.T) {
+	for k := range MessageType_name {
+		switch MessageType(k) {
+		case MessageType_Lock, MessageType_Select, MessageType_Decide:
+			assert.True(t, IsProofBearing(MessageType(k)))
+		default:
+			assert.False(t, IsProofBearing(MessageType(k)))
+		}
+	}
+	assert.False(t, IsProofBearing(MessageType(100)))
+}
+
+func TestParseMessage(t *testing.T) {
+	m, signed, _ := createRoundChangeMessage(t, 10, 3)
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	decoded, err := ParseMessage(bts)
+	assert.Nil(t, err)
+	assert.Equal(t, MessageType_RoundChange, decoded.Type)
+	assert.Equal(t, uint64(10), decoded.Height)
+	assert.Equal(t, uint64(3), decoded.Round)
+	assert.True(t, bytes.Equal(m.State, decoded.State))
+
+	// empty
+	_, err = ParseMessage(nil)
+	assert.Equal(t, ErrMessageIsEmpty, err)
+
+	// version
+	version := *signed
+	version.Version = ProtocolVersion + 1
+	bts, err = proto.Marshal(&version)
+	assert.Nil(t, err)
+	_, err = ParseMessage(bts)
+	assert.Equal(t, ErrMessageVersion, err)
+
+	// unknown message type
+	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+	m.Type = MessageType(100)
+	unknown := new(SignedProto)
+	unknown.Sign(m, privateKey)
+	bts, err = proto.Marshal(unknown)
+	assert.Nil(t, err)
+	_, err = ParseMessage(bts)
+	assert.Equal(t, ErrMessageUnknownMessageType, err)
+
+	// malformed
+	_, err = ParseMessage([]byte{0xff, 0xff, 0xff})
+	assert.True(t, errors.Is(err, ErrMessageMalformed))
+}
+
+// the seed corpus for fuzzing must be valid messages, and mutations of them
+// must never panic
+func TestParseMessageCorpus(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "corpus", "*"))
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, len(files))
+
+	for _, file := range files {
+		bts, err := ioutil.ReadFile(file)
+		assert.Nil(t, err)
+		m, err := ParseMessage(bts)
+		assert.Nil(t, err, file)
+		assert.NotNil(t, m, file)
+
+		// truncations
+		for i := 0; i < len(bts); i++ {
+			m, err := ParseMessage(bts[:i])
+			assert.Equal(t, m == nil, err != nil)
+		}
+
+		// random mutations
+		mutated := make([]byte, len(bts))
+		for i := 0; i < 1000; i++ {
+			copy(mutated, bts)
+			for j := 0; j < 1+mrand.Intn(8); j++ {
+				mutated[mrand.Intn(len(mutated))] = byte(mrand.Intn(256))
+			}
+			m, err := ParseMessage(mutated)
+			assert.Equal(t, m == nil, err != nil)
+		}
+	}
+}
+
+func TestVerifyProofSetTooLarge(t *testing.T) {
+	type verifier func(c *Consensus, m *Message, sp *SignedProto) error
+	cases := []struct {
+		create func(t *testing.T, numProofs int, height uint64, round uint64, proofHeight uint64, proofRound uint64) (*Message, *SignedProto, *ecdsa.PrivateKey, []*ecdsa.PublicKey)
+		verify verifier
+	}{
+		{createLockMessage, (*Consensus).verifyLockMessage},
+		{createSelectMessage, (*Consensus).verifySelectMessage},
+		{func(t *testing.T, numProofs int, height uint64, round uint64, proofHeight uint64, proofRound uint64) (*Message, *SignedProto, *ecdsa.PrivateKey, []*ecdsa.PublicKey) {
+			return createDecideMessage(t, numProofs, height, round, proofHeight, proofRound)
+		}, (*Consensus).verifyDecideMessage},
+	}
+
+	for _, tc := range cases {
+		m, sp, privateKey, proofKeys := tc.create(t, 20, 10, 10, 10, 10)
+		consensus := createConsensus(t, 9, 10, proofKeys)
+		consensus.SetLeader(&privateKey.PublicKey)
+		assert.Nil(t, tc.verify(consensus, m, sp))
+
+		// configured limit
+		consensus.maxProofsPerMessage = 19
+		assert.Equal(t, ErrProofSetTooLarge, tc.verify(consensus, m, sp))
+		consensus.maxProofsPerMessage = 20
+		assert.Nil(t, tc.verify(consensus, m, sp))
+
+		// by default, no more proofs than participants, rejected before
+		// verifying the forged ones
+		consensus.maxProofsPerMessage = 0
+		for len(m.Proof) <= len(consensus.participants) {
+			forged := *m.Proof[0]
+			forged.R = []byte{1}
+			m.Proof = append(m.Proof, &forged)
+		}
+		assert.Equal(t, ErrProofSetTooLarge, tc.verify(consensus, m, sp))
+
+		// negative disables the limit, the forged proofs get verified
+		consensus.maxProofsPerMessage = -1
+		assert.NotEqual(t, ErrProofSetTooLarge, tc.verify(consensus, m, sp))
+		assert.NotNil(t, tc.verify(consensus, m, sp))
+	}
+}
+
+func TestVerifyProofDuplicateParticipant(t *testing.T) {
+	type verifier func(c *Consensus, m *Message, sp *SignedProto) error
+	cases := []struct {
+		create       func(t *testing.T, numProofs int, height uint64, round uint64, proofHeight uint64, proofRound uint64) (*Message, *SignedProto, *ecdsa.PrivateKey, []*ecdsa.PublicKey)
+		verify       verifier
+		insufficient error
+	}{
+		{createLockMessage, (*Consensus).verifyLockMessage, ErrLockProofInsufficient},
+		{createSelectMessage, (*Consensus).verifySelectMessage, ErrSelectProofInsufficient},
+		{func(t *testing.T, numProofs int, height uint64, round uint64, proofHeight uint64, proofRound uint64) (*Message, *SignedProto, *ecdsa.PrivateKey, []*ecdsa.PublicKey) {
+			return createDecideMessage(t, numProofs, height, round, proofHeight, proofRound)
+		}, (*Consensus).verifyDecideMessage, ErrDecideProofInsufficient},
+	}
+
+	for _, tc := range cases {
+		// 3 valid proofs out of 5 participants
+		m, sp, privateKey, proofKeys := tc.create(t, 4, 10, 10, 10, 10)
+		consensus := createConsensus(t, 9, 10, proofKeys)
+		consensus.SetLeader(&privateKey.PublicKey)
+		assert.Nil(t, tc.verify(consensus, m, sp))
+
+		// 2 individual participants cannot reach quorum
+		valid := m.Proof[:3]
+		m.Proof = []*SignedProto{valid[0], valid[1]}
+		assert.Equal(t, tc.insufficient, tc.verify(consensus, m, sp))
+
+		// even if a participant's proof appears twice
+		m.Proof = []*SignedProto{valid[0], valid[1], valid[1]}
+		assert.Equal(t, ErrProofDuplicateParticipant, tc.verify(consensus, m, sp))
+	}
+}
+
+// malleate returns the (r, n-s) variant of the signature, which verifies
+// against the same message
+func malleate(sp *SignedProto) *SignedProto {
+	var S big.Int
+	S.SetBytes(sp.S)
+	S.Sub(S256Curve.Params().N, &S)
+	malleated := *sp
+	malleated.S = S.Bytes()
+	return &malleated
+}
+
+func TestSignatureMalleability(t *testing.T) {
+	for i := 0; i < 64; i++ {
+		key, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+		assert.Nil(t, err)
+		_, sp, _ := createRoundChangeMessageSigner(t, 10, 0, State("10"), key)
+		assert.True(t, sp.IsLowS(S256Curve))
+
+		// the high-S variant is still a valid ECDSA signature
+		malleated := malleate(sp)
+		assert.False(t, malleated.IsLowS(S256Curve))
+		assert.True(t, malleated.Verify(S256Curve))
+
+		consensus := createConsensus(t, 9, 0, []*ecdsa.PublicKey{&key.PublicKey})
+		bts, err := proto.Marshal(malleated)
+		assert.Nil(t, err)
+		assert.True(t, errors.Is(consensus.ReceiveMessage(bts, time.Now()), ErrMessageSignatureMalleable))
+	}
+
+	// proofs enclosed in a message
+	m, sp, privateKey, proofKeys := createLockMessage(t, 4, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	assert.Nil(t, consensus.verifyLockMessage(m, sp))
+	m.Proof[0] = malleate(m.Proof[0])
+	assert.Equal(t, ErrMessageSignatureMalleable, consensus.verifyLockMessage(m, sp))
+}
+
+func TestHasher(t *testing.T) {
+	key, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+	m := &Message{Type: MessageType_RoundChange, Height: 10, State: State("10")}
+
+	// round-trips under a custom hasher
+	sp := new(SignedProto)
+	assert.Nil(t, sp.SignWithHasher(m, privateKeySigner{key}, sha256.New))
+	assert.Equal(t, sha256.Size, len(sp.HashWith(sha256.New)))
+	assert.True(t, sp.VerifyWithHasher(S256Curve, sha256.New))
+	assert.False(t, sp.Verify(S256Curve))
+
+	// nil means the default
+	assert.Equal(t, sp.Hash(), sp.HashWith(nil))
+	assert.Nil(t, sp.SignWithHasher(m, privateKeySigner{key}, nil))
+	assert.True(t, sp.Verify(S256Curve))
+	assert.False(t, sp.VerifyWithHasher(S256Curve, sha256.New))
+
+	// nodes with mismatched hashers reject the messages of each other
+	signed := new(SignedProto)
+	assert.Nil(t, signed.SignWithHasher(m, privateKeySigner{key}, sha256.New))
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+
+	consensus := createConsensus(t, 9, 0, []*ecdsa.PublicKey{&key.PublicKey})
+	assert.True(t, errors.Is(consensus.ReceiveMessage(bts, time.Now()), ErrMessageSignature))
+	consensus.hasher = sha256.New
+	assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	assert.Equal(t, 1, consensus.currentRound.NumRoundChanges())
+}
+
+// preimageHash records what's written, and sums to the bytes written
+type preimageHash struct{ bytes.Buffer }
+
+func (h *preimageHash) Sum(b []byte) []byte { return append(b, h.Bytes()...) }
+func (h *preimageHash) Size() int           { return h.Len() }
+func (h *preimageHash) BlockSize() int      { return 1 }
+
+func readGolden(t *testing.T, name string) []byte {
+	bts, err := ioutil.ReadFile(filepath.Join("testdata", "golden", name))
+	assert.Nil(t, err)
+	golden, err := hex.DecodeString(string(bytes.TrimSpace(bts)))
+	assert.Nil(t, err)
+	return golden
+}
+
+// the byte layout of messages is pinned for implementations in other languages
+func TestWireFormatGolden(t *testing.T) {
+	m := &Message{Type: MessageType_Commit, Height: 0x0102030405, Round: 300, State: State("abc")}
+	bts, err := proto.Marshal(m)
+	assert.Nil(t, err)
+	assert.Equal(t, readGolden(t, "message.hex"), bts)
+
+	// signed by the private key 1, whose public key is the base point
+	sp := &SignedProto{Version: ProtocolVersion, Message: bts}
+	assert.Nil(t, sp.X.Unmarshal(S256Curve.Params().Gx.Bytes()))
+	assert.Nil(t, sp.Y.Unmarshal(S256Curve.Params().Gy.Bytes()))
+	preimage := sp.HashWith(func() hash.Hash { return new(preimageHash) })
+	assert.Equal(t, readGolden(t, "signature-preimage.hex"), preimage)
+}
+
+// signRawMessage signs the bytes as the encoded message as they are
+func signRawMessage(t *testing.T, bts []byte, key *ecdsa.PrivateKey) *SignedProto {
+	sp := new(SignedProto)
+	sp.Sign(new(Message), key)
+	sp.Message = bts
+	sig, err := privateKeySigner{key}.Sign(sp.Hash())
+	assert.Nil(t, err)
+	r, s, err := decodeSignature(sig)
+	assert.Nil(t, err)
+	if n := S256Curve.Params().N; s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s.Sub(n, s)
+	}
+	sp.R = r.Bytes()
+	sp.S = s.Bytes()
+	return sp
+}
+
+func TestVerifyMessageCanonical(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 1)
+	consensus := createConsensus(t, 0, 0, participants)
+
+	canonical := readGolden(t, "message.hex")
+	m, err := consensus.verifyMessage(signRawMessage(t, canonical, keys[0]))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(300), m.Round)
+
+	for _, raw := range []string{
+		"0804" + "1085888c909000" + "18ac02" + "2203616263",        // height in non-minimal varint
+		"0804" + "18ac02" + "1085888c9010" + "2203616263",          // round before height
+		"0804" + "1085888c9010" + "18ac02" + "2203616263" + "1800", // round encoded twice
+		"0804" + "1000" + "1085888c9010" + "18ac02" + "2203616263", // default height encoded
+	} {
+		bts, err := hex.DecodeString(raw)
+		assert.Nil(t, err)
+		_, err = consensus.verifyMessage(signRawMessage(t, bts, keys[0]))
+		assert.Equal(t, ErrMessageNotCanonical, err, raw)
+	}
+}
@@ -0,0 +1,124 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	fmt "fmt"
+	"time"
+)
+
+// Metrics is a snapshot of consensus internal status
+type Metrics struct {
+	// Height is the latest confirmed height, consensus is working on Height+1
+	Height uint64
+	// Round is the current round number in progress
+	Round uint64
+	// Stage is the current stage of consensus automata in current round,
+	// one of roundchange, lock, commit and lock-release
+	Stage string
+	// NumParticipants is the count of individual participants
+	NumParticipants int
+	// NumFutureRoundMessages is the count of <roundchange> messages kept for
+	// rounds higher than current round at the height in progress
+	NumFutureRoundMessages int
+	// NumFutureHeightMessages is the count of messages buffered for heights
+	// above the height in progress, to be replayed once the height is
+	// reached. Only <roundchange> messages in the window of
+	// Config.PipelineDepth are buffered, so it's always 0 without pipelining.
+	NumFutureHeightMessages int
+	// RoundStartTime is the time when current round began
+	RoundStartTime time.Time
+	// RoundDuration is the time spent in current round until the time given
+	RoundDuration time.Duration
+	// NumDuplicateMessages is the count of duplicated messages short-circuited
+	NumDuplicateMessages uint64
+	// NumFutureHeightDropped is the count of messages dropped for heights
+	// beyond Config.MaxFutureHeight
+	NumFutureHeightDropped uint64
+	// NumRateLimited is the count of messages rejected for exceeding
+	// Config.PerParticipantRateLimit
+	NumRateLimited uint64
+	// TotalSignaturesVerified is the count of signatures verified, of both
+	// messages and the proofs enclosed
+	TotalSignaturesVerified uint64
+	// TotalSignatureVerifyDuration is the cumulative wall time spent in
+	// signature verifications, signatures verified concurrently in batch
+	// account for the duration of each verification
+	TotalSignatureVerifyDuration time.Duration
+	// NumReceiveQueueDropped is the count of messages dropped for the queue
+	// of Config.ReceiveQueueSize being full
+	NumReceiveQueueDropped uint64
+	// MessagesSent and BytesSent are the count and bytes of messages handed
+	// to peers, including those relayed, framed as sent on the wire
+	MessagesSent uint64
+	BytesSent    uint64
+}
+
+// String representation of metrics for logging
+func (m Metrics) String() string {
+	return fmt.Sprintf("height:%v round:%v stage:%v participants:%v future-round-messages:%v future-height-messages:%v round-duration:%v duplicates:%v future-height-dropped:%v rate-limited:%v receive-queue-dropped:%v signatures-verified:%v signature-verify-duration:%v messages-sent:%v bytes-sent:%v",
+		m.Height, m.Round, m.Stage, m.NumParticipants, m.NumFutureRoundMessages, m.NumFutureHeightMessages, m.RoundDuration, m.NumDuplicateMessages, m.NumFutureHeightDropped, m.NumRateLimited, m.NumReceiveQueueDropped, m.TotalSignaturesVerified, m.TotalSignatureVerifyDuration, m.MessagesSent, m.BytesSent)
+}
+
+// Metrics returns a snapshot of consensus status, the round duration is
+// measured against now.
+func (c *Consensus) Metrics(now time.Time) Metrics {
+	var m Metrics
+	m.Height = c.latestHeight
+	m.Round = c.currentRound.RoundNumber
+	m.Stage = c.currentRound.Stage.String()
+	m.NumParticipants = c.numIdentities
+	m.RoundStartTime = c.roundStartTime
+	m.RoundDuration = now.Sub(c.roundStartTime)
+	m.NumFutureHeightMessages = len(c.pipelinedMessages)
+	m.NumFutureHeightDropped = c.numFutureHeightDropped
+	m.TotalSignaturesVerified = c.numSignaturesVerified
+	m.TotalSignatureVerifyDuration = c.signatureVerifyDuration
+	m.MessagesSent = c.numMessagesSent
+	m.BytesSent = c.numBytesSent
+	if c.dedup != nil {
+		m.NumDuplicateMessages = c.dedup.Hits()
+	}
+	if c.limiter != nil {
+		m.NumRateLimited = c.limiter.Throttled()
+	}
+	if c.receiveQueue != nil {
+		m.NumReceiveQueueDropped = c.receiveQueue.Dropped()
+	}
+
+	for elem := c.rounds.Front(); elem != nil; elem = elem.Next() {
+		cr := elem.Value.(*consensusRound)
+		if cr.RoundNumber > c.currentRound.RoundNumber {
+			m.NumFutureRoundMessages += cr.NumRoundChanges()
+		}
+	}
+	return m
+}
@@ -0,0 +1,144 @@
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Sperax/bdls/timer"
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+
+	quorum := []*ecdsa.PublicKey{&privateKey.PublicKey}
+	for i := 0; i < 3; i++ {
+		randKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+		assert.Nil(t, err)
+		quorum = append(quorum, &randKey.PublicKey)
+	}
+	consensus := createConsensus(t, 10, 0, quorum)
+	start := consensus.roundStartTime
+
+	// a <roundchange> message for a future round
+	state := make([]byte, 1024)
+	_, err = io.ReadFull(rand.Reader, state)
+	assert.Nil(t, err)
+	_, signed, _ := createRoundChangeMessageSigner(t, 11, 5, state, privateKey)
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+
+	m := consensus.Metrics(start.Add(time.Second))
+	assert.Equal(t, uint64(10), m.Height)
+	assert.Equal(t, uint64(0), m.Round)
+	assert.Equal(t, "roundchange", m.Stage)
+	assert.Equal(t, 5, m.NumParticipants)
+	assert.Equal(t, 1, m.NumFutureRoundMessages)
+	assert.Equal(t, time.Second, m.RoundDuration)
+	assert.NotEmpty(t, m.String())
+
+	// round switch resets round start time
+	now := time.Now()
+	consensus.switchRound(1, now)
+	m = consensus.Metrics(now)
+	assert.Equal(t, uint64(1), m.Round)
+	assert.Equal(t, now, m.RoundStartTime)
+	assert.Equal(t, time.Duration(0), m.RoundDuration)
+}
+
+func TestMetricsFutureHeightMessages(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	consensus := createConsensus(t, 9, 0, participants)
+	consensus.SetLeader(&keys[0].PublicKey)
+	consensus.pipelineDepth = 3
+	assert.Equal(t, 0, consensus.Metrics(time.Now()).NumFutureHeightMessages)
+
+	// <roundchange> messages buffered for heights 11 and 12
+	for k, height := range []uint64{11, 12, 12} {
+		_, signed, _ := createRoundChangeMessageSigner(t, height, 0, State(fmt.Sprint(height)), keys[k])
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	}
+	m := consensus.Metrics(time.Now())
+	assert.Equal(t, 3, m.NumFutureHeightMessages)
+	assert.Equal(t, 0, m.NumFutureRoundMessages)
+
+	// replayed once height 10 decided, those for height 12 are kept
+	decides := createDecideChain(t, keys, 10)
+	assert.Nil(t, consensus.ReceiveMessage(decides[0], time.Now()))
+	assert.Equal(t, 2, consensus.Metrics(time.Now()).NumFutureHeightMessages)
+}
+
+func TestMetricsSignaturesVerified(t *testing.T) {
+	_, sp, privateKey, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+	assert.Equal(t, uint64(0), consensus.Metrics(time.Now()).TotalSignaturesVerified)
+
+	// a <roundchange> message
+	_, signed, _ := createRoundChangeMessageSigner(t, 10, 10, State("data"), privateKey)
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	m := consensus.Metrics(time.Now())
+	assert.Equal(t, uint64(1), m.TotalSignaturesVerified)
+	assert.True(t, m.TotalSignatureVerifyDuration > 0)
+
+	// a <decide> message along with proofs verified in batch
+	bts, err = proto.Marshal(sp)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	assert.Equal(t, uint64(1+1+20), consensus.Metrics(time.Now()).TotalSignaturesVerified)
+
+	// signatures failed to verify are accounted too, at the next height as
+	// messages at decided heights are rejected before verification
+	_, signed, _ = createRoundChangeMessageSigner(t, 11, 10, State("data"), privateKey)
+	signed.R = signed.S
+	bts, err = proto.Marshal(signed)
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(consensus.ReceiveMessage(bts, time.Now()), ErrMessageSignature))
+	assert.Equal(t, uint64(1+1+20+1), consensus.Metrics(time.Now()).TotalSignaturesVerified)
+}
+
+func TestMetricsMessagesSent(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	var peers []*IPCPeer
+	for _, config := range configs[:2] {
+		consensus, err := NewConsensus(config)
+		assert.Nil(t, err)
+		p := NewIPCPeer(consensus, 10*time.Millisecond)
+		p.SetScheduler(scheduler)
+		peers = append(peers, p)
+	}
+	assert.True(t, peers[0].c.Join(peers[1]))
+	assert.True(t, peers[1].c.Join(peers[0]))
+	assert.Equal(t, uint64(0), peers[0].c.Metrics(time.Now()).MessagesSent)
+
+	for round := 0; round < 5; round++ {
+		for k, p := range peers {
+			p.Propose(State(fmt.Sprintf("round %d by %d", round, k)))
+			assert.Nil(t, p.ForceRoundChange())
+		}
+		scheduler.Advance(time.Second)
+	}
+
+	// what one sent is what the other received
+	for k, p := range peers {
+		m := p.c.Metrics(time.Now())
+		other := peers[1-k]
+		assert.NotZero(t, m.MessagesSent)
+		assert.Equal(t, uint64(other.GetMessageCount()), m.MessagesSent)
+		assert.Equal(t, uint64(other.GetBytesCount()), m.BytesSent)
+	}
+}
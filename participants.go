@@ -0,0 +1,68 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"sort"
+)
+
+// SortParticipants returns the public keys in the canonical order of a
+// consensus group, the input is not modified. Keys are ordered by their
+// DefaultPubKeyToIdentity bytes, i.e. the X coordinate and then the Y
+// coordinate, each as a 32-byte big-endian integer, duplicated keys are
+// kept adjacent.
+//
+// The order of Config.Participants decides the leader rotation, round r is
+// led by the participant at r % n, so all participants must agree on it.
+// The library keeps the order given, applications building configs on
+// different platforms can agree by sorting the keys with this ordering.
+func SortParticipants(keys []*ecdsa.PublicKey) []*ecdsa.PublicKey {
+	type sortKey struct {
+		identity Identity
+		pubkey   *ecdsa.PublicKey
+	}
+
+	sorted := make([]sortKey, 0, len(keys))
+	for _, pubkey := range keys {
+		sorted = append(sorted, sortKey{DefaultPubKeyToIdentity(pubkey), pubkey})
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].identity[:], sorted[j].identity[:]) < 0
+	})
+
+	ret := make([]*ecdsa.PublicKey, 0, len(sorted))
+	for k := range sorted {
+		ret = append(ret, sorted[k].pubkey)
+	}
+	return ret
+}
@@ -0,0 +1,72 @@
+package bdls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortParticipants(t *testing.T) {
+	keys := randomPublicKeys(t, 20)
+	keys = append(keys, keys[3]) // duplicated
+	input := append([]*ecdsa.PublicKey(nil), keys...)
+	sorted := SortParticipants(keys)
+	assert.Equal(t, input, keys)
+	assert.Equal(t, len(keys), len(sorted))
+
+	for k := 1; k < len(sorted); k++ {
+		prev := DefaultPubKeyToIdentity(sorted[k-1])
+		cur := DefaultPubKeyToIdentity(sorted[k])
+		assert.True(t, bytes.Compare(prev[:], cur[:]) <= 0)
+		if sorted[k-1].X.Cmp(sorted[k].X) == 0 {
+			assert.True(t, sorted[k-1].Y.Cmp(sorted[k].Y) <= 0)
+		} else {
+			assert.True(t, sorted[k-1].X.Cmp(sorted[k].X) < 0)
+		}
+	}
+
+	// the same order regardless of the input order
+	for i := 0; i < 10; i++ {
+		shuffled := append([]*ecdsa.PublicKey(nil), keys...)
+		for k := len(shuffled) - 1; k > 0; k-- {
+			j, err := rand.Int(rand.Reader, big.NewInt(int64(k+1)))
+			assert.Nil(t, err)
+			shuffled[k], shuffled[j.Int64()] = shuffled[j.Int64()], shuffled[k]
+		}
+		assert.Equal(t, sorted, SortParticipants(shuffled))
+	}
+
+	assert.Empty(t, SortParticipants(nil))
+}
+
+func TestSortParticipantsLeaderRotation(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+	sorted := SortParticipants(append(randomPublicKeys(t, 3), &privateKey.PublicKey))
+
+	config := new(Config)
+	config.Epoch = time.Now()
+	config.CurrentHeight = 0
+	config.PrivateKey = privateKey
+	config.StateCompare = func(a State, b State) int { return bytes.Compare(a, b) }
+	config.StateValidate = func(State) bool { return true }
+	for _, pubkey := range sorted {
+		config.Participants = append(config.Participants, DefaultPubKeyToIdentity(pubkey))
+	}
+	consensus, err := NewConsensus(config)
+	assert.Nil(t, err)
+
+	// round r is led by the participant at r % n
+	for round := uint64(0); round < 8; round++ {
+		leader, err := consensus.LeaderForRound(1, round)
+		assert.Nil(t, err)
+		expected := sorted[int(round)%len(sorted)]
+		assert.Equal(t, 0, leader.X.Cmp(expected.X))
+		assert.Equal(t, 0, leader.Y.Cmp(expected.Y))
+	}
+}
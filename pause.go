@@ -0,0 +1,77 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import "time"
+
+const (
+	// DefaultPauseBufferSize is the default number of messages buffered
+	// while consensus is paused
+	DefaultPauseBufferSize = 1024
+)
+
+// Pause stops consensus from processing inbound messages without closing it,
+// to emulate a node being briefly unresponsive. While paused, ReceiveMessage
+// buffers messages up to Config.PauseBufferSize, Update has no effect, and
+// proposals are rejected with ErrConsensusPaused.
+func (c *Consensus) Pause() { c.paused = true }
+
+// Resume continues processing, the messages buffered while paused are
+// processed in arrival order as in ReceiveMessage at now, the timeouts passed
+// are handled in next Update.
+func (c *Consensus) Resume(now time.Time) {
+	defer c.hooks.flush()
+	if !c.paused {
+		return
+	}
+	c.paused = false
+
+	buffered := c.pausedMessages
+	c.pausedMessages = nil
+	for _, bts := range buffered {
+		// rejected messages are reported via Config.Logger
+		_ = c.ReceiveMessage(bts, now)
+	}
+}
+
+// Paused reports whether consensus has been paused
+func (c *Consensus) Paused() bool { return c.paused }
+
+// bufferPaused keeps a message received while paused, ErrPauseBufferFull
+// will be returned if the buffer is full.
+func (c *Consensus) bufferPaused(bts []byte) error {
+	if len(c.pausedMessages) >= c.pauseBufferSize {
+		return ErrPauseBufferFull
+	}
+	// the buffer may be reused by the caller
+	c.pausedMessages = append(c.pausedMessages, append([]byte(nil), bts...))
+	return nil
+}
@@ -0,0 +1,202 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import "time"
+
+// pipelinedState is a state proposed for a height beyond the next height
+type pipelinedState struct {
+	height uint64
+	state  State
+}
+
+// pipelinedMessage is a <roundchange> message for a height beyond the next
+// height, kept UNCHANGED to be replayed once the height is in consensus.
+type pipelinedMessage struct {
+	height uint64
+	round  uint64
+	X      PubKeyAxis
+	Y      PubKeyAxis
+	raw    []byte
+}
+
+// inPipeline checks if a height beyond the next height is in the pipeline
+// window.
+func (c *Consensus) inPipeline(height uint64) bool {
+	return c.pipelineDepth > 1 && height > c.latestHeight+1 && height <= c.latestHeight+c.pipelineDepth
+}
+
+// ProposeAt adds a new state to participate in consensus at the given height,
+// in pipelined mode, heights beyond the next height within Config.PipelineDepth
+// can be proposed for, and the states will be announced in <roundchange> while
+// the preceding height is committing. Proposing for the next height is the
+// same as Propose, ErrProposeHeight will be returned for heights outside the
+// pipeline window.
+//
+// States proposed for heights beyond the next height are validated by
+// participants only when the height is in consensus.
+func (c *Consensus) ProposeAt(height uint64, s State) error {
+	if c.closed {
+		return ErrConsensusClosed
+	}
+
+	if c.paused {
+		return ErrConsensusPaused
+	}
+
+	if c.observer {
+		return ErrObserverCannotPropose
+	}
+
+	if height == c.latestHeight+1 {
+		return c.TryPropose(s)
+	}
+
+	if !c.inPipeline(height) {
+		return ErrProposeHeight
+	}
+
+	if s == nil {
+		return nil
+	}
+
+	sHash := c.stateHash(s)
+	for k := range c.pipelinedStates {
+		if c.pipelinedStates[k].height == height && c.stateHash(c.pipelinedStates[k].state) == sHash {
+			return nil
+		}
+	}
+	c.pipelinedStates = append(c.pipelinedStates, pipelinedState{height: height, state: s})
+	return nil
+}
+
+// maximalPipelined returns the maximal state proposed for a height beyond the
+// next height, nil if there's none, all of them are proposed by myself.
+func (c *Consensus) maximalPipelined(height uint64) State {
+	var maxState State
+	for k := range c.pipelinedStates {
+		if c.pipelinedStates[k].height != height {
+			continue
+		}
+		if maxState == nil || c.compareProposals(maxState, c.pipelinedStates[k].state, nil, c.identity) < 0 {
+			maxState = c.pipelinedStates[k].state
+		}
+	}
+	return maxState
+}
+
+// pipelineRoundChange keeps a <roundchange> message for a height in the
+// pipeline window, and returns false if the message is not for such a height.
+// Only the message with the maximal round from a participant is kept for each
+// height, so the messages kept are bounded to the participants in the window.
+//
+// NOTE: the message is not verified against the height, which is done while
+// replaying, as the participants and the validity of states may depend on
+// the preceding height.
+func (c *Consensus) pipelineRoundChange(m *Message, signed *SignedProto, bts []byte) bool {
+	if !c.inPipeline(m.Height) {
+		return false
+	}
+
+	for k := range c.pipelinedMessages {
+		pm := &c.pipelinedMessages[k]
+		if pm.height == m.Height && pm.X == signed.X && pm.Y == signed.Y {
+			if m.Round > pm.round {
+				pm.round = m.Round
+				pm.raw = append([]byte(nil), bts...)
+			}
+			return true
+		}
+	}
+
+	c.pipelinedMessages = append(c.pipelinedMessages, pipelinedMessage{
+		height: m.Height,
+		round:  m.Round,
+		X:      signed.X,
+		Y:      signed.Y,
+		raw:    append([]byte(nil), bts...),
+	})
+	return true
+}
+
+// announcePipeline broadcasts <roundchange> at round 0 for heights in the
+// pipeline window which have not been announced, with the maximal state
+// proposed for each height. Heights are announced in order, a height without
+// any state stops the announcement.
+func (c *Consensus) announcePipeline(now time.Time) {
+	for height := c.latestHeight + 2; c.inPipeline(height); height++ {
+		if height <= c.pipelineAnnounced {
+			continue
+		}
+
+		data := c.maximalPipelined(height)
+		if data == nil {
+			return
+		}
+
+		var m Message
+		m.Type = MessageType_RoundChange
+		m.Height = height
+		m.Round = 0
+		m.State = data
+		c.broadcast(&m, now)
+		c.pipelineAnnounced = height
+	}
+}
+
+// promotePipeline moves states proposed for the next height to unconfirmed
+// states, and replays <roundchange> messages kept for the next height via
+// loopback, those for decided heights are discarded.
+func (c *Consensus) promotePipeline() {
+	next := c.latestHeight + 1
+
+	o := 0
+	for _, ps := range c.pipelinedStates {
+		if ps.height == next {
+			c.Propose(ps.state)
+		} else if ps.height > next {
+			c.pipelinedStates[o] = ps
+			o++
+		}
+	}
+	c.pipelinedStates = c.pipelinedStates[:o]
+
+	o = 0
+	for _, pm := range c.pipelinedMessages {
+		if pm.height == next {
+			c.loopback = append(c.loopback, pm.raw)
+		} else if pm.height > next {
+			c.pipelinedMessages[o] = pm
+			o++
+		}
+	}
+	c.pipelinedMessages = c.pipelinedMessages[:o]
+}
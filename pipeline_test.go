@@ -0,0 +1,164 @@
+package bdls
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProposeAt(t *testing.T) {
+	consensus := createConsensus(t, 9, 0, randomPublicKeys(t, 4))
+	assert.Equal(t, ErrProposeHeight, consensus.ProposeAt(11, State("11")))
+
+	consensus.pipelineDepth = 3
+	assert.Nil(t, consensus.ProposeAt(10, State("10")))
+	assert.Nil(t, consensus.ProposeAt(11, State("11")))
+	assert.Nil(t, consensus.ProposeAt(11, State("11")))
+	assert.Nil(t, consensus.ProposeAt(12, State("12")))
+	assert.Equal(t, ErrProposeHeight, consensus.ProposeAt(9, State("9")))
+	assert.Equal(t, ErrProposeHeight, consensus.ProposeAt(13, State("13")))
+	assert.Equal(t, []State{State("10")}, consensus.unconfirmed)
+	assert.Equal(t, 2, len(consensus.pipelinedStates))
+
+	// announced in order while committing the next height
+	var sent []*Message
+	consensus.messageOutCallback = func(m *Message, signed *SignedProto) { sent = append(sent, m) }
+	consensus.sendCommit(&Message{Type: MessageType_Lock, Height: 10, State: State("10")}, time.Now())
+	assert.Equal(t, 3, len(sent))
+	assert.Equal(t, MessageType_Commit, sent[0].Type)
+	for k, height := range []uint64{11, 12} {
+		assert.Equal(t, MessageType_RoundChange, sent[k+1].Type)
+		assert.Equal(t, height, sent[k+1].Height)
+		assert.Equal(t, uint64(0), sent[k+1].Round)
+		assert.Equal(t, []byte(fmt.Sprint(height)), sent[k+1].State)
+	}
+
+	// announced only once
+	consensus.switchRound(1, time.Now())
+	consensus.sendCommit(&Message{Type: MessageType_Lock, Height: 10, Round: 1, State: State("10")}, time.Now())
+	assert.Equal(t, 4, len(sent))
+}
+
+func TestPipelineSafety(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	consensus := createConsensus(t, 9, 0, participants)
+	consensus.SetLeader(&keys[0].PublicKey)
+	consensus.pipelineDepth = 2
+
+	// <roundchange> messages for height 11 are kept while deciding height 10
+	for _, key := range keys[:3] {
+		_, signed, _ := createRoundChangeMessageSigner(t, 11, 0, State("11"), key)
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	}
+	assert.Equal(t, 3, len(consensus.pipelinedMessages))
+	assert.Equal(t, uint64(9), consensus.Height())
+	assert.Equal(t, 0, consensus.currentRound.NumRoundChanges())
+	assert.Equal(t, stageRoundChanging, consensus.currentRound.Stage)
+
+	// a higher round replaces the kept message of the participant
+	for round := uint64(0); round < 2; round++ {
+		_, signed, _ := createRoundChangeMessageSigner(t, 11, round, State("11"), keys[3])
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	}
+	assert.Equal(t, 4, len(consensus.pipelinedMessages))
+
+	// beyond the pipeline window
+	_, signed, _ := createRoundChangeMessageSigner(t, 12, 0, State("12"), keys[0])
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(consensus.ReceiveMessage(bts, time.Now()), ErrRoundChangeHeightMismatch))
+
+	// replayed at height 11 once height 10 decided
+	decides := createDecideChain(t, keys, 10)
+	assert.Nil(t, consensus.ReceiveMessage(decides[0], time.Now()))
+	assert.Equal(t, uint64(10), consensus.Height())
+	assert.Empty(t, consensus.pipelinedMessages)
+	assert.Equal(t, uint64(0), consensus.currentRound.RoundNumber)
+	assert.Equal(t, 3, consensus.currentRound.NumRoundChanges())
+	assert.Equal(t, stageLock, consensus.currentRound.Stage)
+	assert.Equal(t, 1, consensus.getRound(1, false).NumRoundChanges())
+}
+
+func TestPipelineReleasedOnReset(t *testing.T) {
+	consensus := createConsensus(t, 9, 0, randomPublicKeys(t, 4))
+	consensus.pipelineDepth = 2
+	assert.Nil(t, consensus.ProposeAt(11, State("11")))
+	assert.Nil(t, consensus.Reset(20, State("20"), time.Now()))
+	assert.Empty(t, consensus.pipelinedStates)
+	assert.Empty(t, consensus.unconfirmed)
+
+	// promoted at the next height
+	assert.Nil(t, consensus.ProposeAt(22, State("22")))
+	consensus.resetStates(21, 0, State("21"), time.Now())
+	assert.Equal(t, []State{State("22")}, consensus.unconfirmed)
+	assert.Empty(t, consensus.pipelinedStates)
+}
+
+func TestVerifyConfigPipelineDepth(t *testing.T) {
+	config := createIPCNetworkConfigs(t, 4)[0]
+	config.PipelineDepth = -1
+	assert.Equal(t, ErrConfigPipelineDepth, VerifyConfig(config))
+	config.PipelineDepth = DefaultMaxFutureHeight + 2
+	assert.Equal(t, ErrConfigPipelineDepth, VerifyConfig(config))
+	config.PipelineDepth = DefaultMaxFutureHeight + 1
+	assert.Nil(t, VerifyConfig(config))
+}
+
+// runPipelinedIPCNetwork decides heights with the given pipeline depth in
+// deterministic mode, and returns the virtual time taken.
+func runPipelinedIPCNetwork(t *testing.T, depth int, heights uint64) time.Duration {
+	configs := createIPCNetworkConfigs(t, 4)
+	for _, config := range configs {
+		config.PipelineDepth = depth
+	}
+
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond, DeterministicMode())
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	start := network.scheduler.Now()
+	for {
+		decided := true
+		for k, p := range network.Peers() {
+			h, _, _ := p.GetLatestState()
+			if h < heights {
+				decided = false
+			}
+			for next := h + 1; next <= h+uint64(depth) && next <= heights; next++ {
+				assert.Nil(t, p.ProposeAt(next, State(fmt.Sprintf("height %d by %d", next, k))))
+			}
+		}
+		if decided {
+			break
+		}
+
+		network.Run(10 * time.Millisecond)
+		if network.scheduler.Now().Sub(start) > time.Minute {
+			t.Fatalf("pipeline depth %v cannot decide %v heights", depth, heights)
+		}
+	}
+
+	// all peers agree on the same state
+	_, _, state := network.Peers()[0].GetLatestState()
+	for _, p := range network.Peers() {
+		_, _, s := p.GetLatestState()
+		assert.Equal(t, state, s)
+	}
+	return network.scheduler.Now().Sub(start)
+}
+
+func TestPipelineThroughput(t *testing.T) {
+	const heights = 5
+	sequential := runPipelinedIPCNetwork(t, 1, heights)
+	pipelined := runPipelinedIPCNetwork(t, 2, heights)
+	t.Logf("%v heights decided in %v sequentially, %v pipelined", heights, sequential, pipelined)
+	assert.True(t, pipelined < sequential)
+}
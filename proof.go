@@ -0,0 +1,252 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+)
+
+// LatestDecideProof returns the serialized <decide> message for the most
+// recently finalized height, along with the <commit> proofs enclosed, the
+// proof can be verified independently by VerifyDecideProof.
+func (c *Consensus) LatestDecideProof() ([]byte, error) {
+	if c.closed {
+		return nil, ErrConsensusClosed
+	}
+
+	if c.latestProof == nil {
+		return nil, ErrNoDecideProof
+	}
+	return proto.Marshal(c.latestProof)
+}
+
+// VerifyDecideProof verifies a serialized <decide> message for light clients
+// without a running consensus, the signatures of the <decide> message and
+// the enclosed <commit> proofs are checked against participants, which must
+// be in the same order as Config.Participants to locate the round leader,
+// and there must be at least 2t+1 valid <commit> proofs to targetState.
+//
+// NOTE: state data validation is not performed, as light clients
+// usually have no context to validate a state. targetState is compared
+// as embedded in the message, i.e. encoded by Config.StateCodec.
+func VerifyDecideProof(participants []*ecdsa.PublicKey, targetState State, proof []byte) error {
+	if len(participants) == 0 {
+		return ErrConfigParticipants
+	}
+
+	signed, err := DecodeSignedMessage(proof)
+	if err != nil {
+		return err
+	}
+
+	m, err := DecodeMessage(signed.Message)
+	if err != nil {
+		return err
+	}
+
+	c := newDecideVerifier(participants, m.Height)
+	return c.validateDecideMessage(signed, targetState)
+}
+
+// VerifyDecideChain verifies a sequence of serialized <decide> messages for
+// nodes syncing finalized states without a running consensus, each <decide>
+// message is verified as in VerifyDecideProof, and the heights must be
+// strictly increasing and contiguous. The finalized states are returned in
+// order, or the first error encountered.
+func VerifyDecideChain(participants []*ecdsa.PublicKey, decides [][]byte) ([]State, error) {
+	if len(participants) == 0 {
+		return nil, ErrConfigParticipants
+	}
+
+	states := make([]State, 0, len(decides))
+	var prevHeight uint64
+	for i, proof := range decides {
+		signed, err := DecodeSignedMessage(proof)
+		if err != nil {
+			return nil, err
+		}
+
+		m, err := DecodeMessage(signed.Message)
+		if err != nil {
+			return nil, err
+		}
+
+		if i > 0 {
+			if m.Height <= prevHeight {
+				return nil, fmt.Errorf("verifying <decide> at height %d after height %d: %w", m.Height, prevHeight, ErrDecideHeightLower)
+			} else if m.Height != prevHeight+1 {
+				return nil, fmt.Errorf("verifying <decide> at height %d after height %d: %w", m.Height, prevHeight, ErrDecideHeightGap)
+			}
+		}
+
+		c := newDecideVerifier(participants, m.Height)
+		if err := c.validateDecideMessage(signed, m.State); err != nil {
+			return nil, err
+		}
+
+		states = append(states, m.State)
+		prevHeight = m.Height
+	}
+	return states, nil
+}
+
+// VerifyCommit verifies a serialized <commit> message in isolation for light
+// integrations without a running consensus, the message must be signed by
+// one of participants, and commit to state at the given height and round.
+// The same errors as a running consensus are returned, such as
+// ErrCommitHeightMismatch, or ErrCommitMessageType for other message types.
+//
+// NOTE: state data validation is not performed, state is compared as
+// embedded in the message, see VerifyDecideProof.
+func VerifyCommit(participants []*ecdsa.PublicKey, height uint64, round uint64, state State, msg []byte) error {
+	if len(participants) == 0 {
+		return ErrConfigParticipants
+	}
+
+	signed, err := DecodeSignedMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	c := newDecideVerifier(participants, height)
+	m, err := c.verifyMessage(signed)
+	if err != nil {
+		return err
+	}
+
+	if m.Type != MessageType_Commit {
+		return ErrCommitMessageType
+	}
+
+	if m.State == nil {
+		return ErrCommitEmptyState
+	}
+
+	if m.Height != height {
+		return ErrCommitHeightMismatch
+	}
+
+	if m.Round != round {
+		return ErrCommitRoundMismatch
+	}
+
+	if c.stateHash(m.State) != c.stateHash(state) {
+		return ErrCommitStateMismatch
+	}
+	return nil
+}
+
+// newDecideVerifier creates a minimal consensus object to verify the
+// <decide> message at the given height.
+func newDecideVerifier(participants []*ecdsa.PublicKey, height uint64) *Consensus {
+	c := new(Consensus)
+	c.latestHeight = height - 1
+	c.curve = S256Curve
+	c.pubKeyToIdentity = DefaultPubKeyToIdentity
+	c.stateHash = defaultHash
+	c.stateCodec = RawStateCodec{}
+	c.stateValidate = func(State) bool { return true }
+	c.protocolVersion = ProtocolVersion
+	c.logger = nopLogger{}
+	for _, pubkey := range participants {
+		c.participants = append(c.participants, c.pubKeyToIdentity(pubkey))
+	}
+	c.numIdentities = countIdentities(c.participants)
+	return c
+}
+
+// ImportProof bootstraps a late-joining node with a serialized <lock> or
+// <select> message obtained from a peer, instead of collecting <roundchange>
+// messages from scratch. The message and the enclosed proofs are verified
+// as received messages, and if valid for the current height, the node adopts
+// the proof as ReceiveMessage does, e.g. locks the state and enters commit
+// stage on a <lock>. The message takes the same path as ReceiveMessage at
+// now, except it's never queued, and ErrConsensusPaused is returned while
+// paused. Errors of verification are returned, such as
+// ErrLockProofInsufficient, and ErrImportProofType for other message types.
+func (c *Consensus) ImportProof(msg []byte, now time.Time) error {
+	defer c.hooks.flush()
+	return c.receiveFrame(msg, now, true)
+}
+
+// checkImportType rejects messages other than <lock> and <select> for
+// ImportProof, undecodable messages are left to receive to report.
+func checkImportType(bts []byte) error {
+	h, ok := peekMessage(bts)
+	if !ok {
+		return nil
+	}
+
+	if h.Type != MessageType_Lock && h.Type != MessageType_Select {
+		return h.verifyError(ErrImportProofType)
+	}
+	return nil
+}
+
+// HasQuorum reports whether the serialized <commit> messages form a quorum
+// of 2t+1 individual participants to targetState, or 2t+1 of the total weight
+// if Config.ParticipantWeights has set, the messages must share
+// the same height and round, and are verified as the proofs in a <decide>
+// message. It's a read-only helper, consensus states are not changed.
+func (c *Consensus) HasQuorum(msgs [][]byte, targetState State) (bool, error) {
+	if c.closed {
+		return false, ErrConsensusClosed
+	}
+
+	if len(msgs) == 0 {
+		return false, nil
+	}
+
+	proofs := make([]*SignedProto, 0, len(msgs))
+	for _, bts := range msgs {
+		signed, err := DecodeSignedMessage(bts)
+		if err != nil {
+			return false, err
+		}
+		proofs = append(proofs, signed)
+	}
+
+	// height and round are taken from the first message
+	m, err := DecodeMessage(proofs[0].Message)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := c.countCommitProofs(m.Height, m.Round, proofs, targetState, false)
+	if err != nil {
+		return false, err
+	}
+	return n >= c.quorum(), nil
+}
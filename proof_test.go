@@ -0,0 +1,369 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatestDecideProof(t *testing.T) {
+	m, sp, privateKey, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+
+	_, err := consensus.LatestDecideProof()
+	assert.Equal(t, ErrNoDecideProof, err)
+
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	err = consensus.ReceiveMessage(bts, time.Now())
+	assert.Nil(t, err)
+
+	proof, err := consensus.LatestDecideProof()
+	assert.Nil(t, err)
+	assert.Equal(t, bts, proof)
+
+	// leader of round 10 is participants[10 % 20], and proofKeys[0] is the leader
+	participants := append([]*ecdsa.PublicKey{}, proofKeys...)
+	participants[0], participants[10] = participants[10], participants[0]
+	err = VerifyDecideProof(participants, m.State, proof)
+	assert.Nil(t, err)
+}
+
+func TestVerifyDecideProofMismatchedState(t *testing.T) {
+	_, sp, _, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	proof, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+
+	participants := append([]*ecdsa.PublicKey{}, proofKeys...)
+	participants[0], participants[10] = participants[10], participants[0]
+	err = VerifyDecideProof(participants, []byte("another state"), proof)
+	assert.True(t, errors.Is(err, ErrMismatchedTargetState))
+}
+
+func TestVerifyDecideProofNotSignedByLeader(t *testing.T) {
+	m, sp, _, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	proof, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+
+	err = VerifyDecideProof(proofKeys, m.State, proof)
+	assert.True(t, errors.Is(err, ErrDecideNotSignedByLeader))
+}
+
+func TestVerifyDecideProofInsufficient(t *testing.T) {
+	m, sp, _, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	proof, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+
+	// with more participants, the quorum cannot be satisified
+	participants := append([]*ecdsa.PublicKey{}, proofKeys...)
+	participants[0], participants[10] = participants[10], participants[0]
+	participants = append(participants, randomPublicKeys(t, 20)...)
+	err = VerifyDecideProof(participants, m.State, proof)
+	assert.True(t, errors.Is(err, ErrDecideProofInsufficient))
+}
+
+// createDecideChain generates contiguous <decide> messages at round 0 for
+// the given heights, all <commit> proofs are signed by keys, and keys[0]
+// is the leader.
+func createDecideChain(t testing.TB, keys []*ecdsa.PrivateKey, heights ...uint64) [][]byte {
+	var decides [][]byte
+	for _, height := range heights {
+		m := new(Message)
+		m.Type = MessageType_Decide
+		m.Height = height
+		m.Round = 0
+		m.State = []byte{byte(height)}
+		for _, key := range keys {
+			_, signedCommit, _ := createCommitMessageSigner(t, height, 0, m.State, key)
+			m.Proof = append(m.Proof, signedCommit)
+		}
+
+		signed := new(SignedProto)
+		signed.Sign(m, keys[0])
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		decides = append(decides, bts)
+	}
+	return decides
+}
+
+func createDecideChainKeys(t testing.TB, n int) ([]*ecdsa.PrivateKey, []*ecdsa.PublicKey) {
+	var keys []*ecdsa.PrivateKey
+	var participants []*ecdsa.PublicKey
+	for i := 0; i < n; i++ {
+		privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+		assert.Nil(t, err)
+		keys = append(keys, privateKey)
+		participants = append(participants, &privateKey.PublicKey)
+	}
+	return keys, participants
+}
+
+func TestVerifyDecideChain(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	decides := createDecideChain(t, keys, 10, 11, 12)
+
+	states, err := VerifyDecideChain(participants, decides)
+	assert.Nil(t, err)
+	assert.Equal(t, []State{{10}, {11}, {12}}, states)
+
+	states, err = VerifyDecideChain(participants, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, states)
+
+	_, err = VerifyDecideChain(nil, decides)
+	assert.Equal(t, ErrConfigParticipants, err)
+}
+
+func TestVerifyDecideChainGap(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	decides := createDecideChain(t, keys, 10, 11, 13)
+
+	_, err := VerifyDecideChain(participants, decides)
+	assert.True(t, errors.Is(err, ErrDecideHeightGap))
+}
+
+func TestVerifyDecideChainReordered(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	decides := createDecideChain(t, keys, 10, 11, 12)
+	decides[0], decides[1] = decides[1], decides[0]
+
+	_, err := VerifyDecideChain(participants, decides)
+	assert.True(t, errors.Is(err, ErrDecideHeightLower))
+
+	// duplicated height
+	decides = createDecideChain(t, keys, 10, 10)
+	_, err = VerifyDecideChain(participants, decides)
+	assert.True(t, errors.Is(err, ErrDecideHeightLower))
+}
+
+func TestVerifyDecideChainInvalidProof(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	decides := createDecideChain(t, keys, 10, 11, 12)
+
+	// with more participants, the quorum cannot be satisified
+	participants = append(participants, randomPublicKeys(t, 4)...)
+	_, err := VerifyDecideChain(participants, decides)
+	assert.True(t, errors.Is(err, ErrDecideProofInsufficient))
+}
+
+func TestHasQuorum(t *testing.T) {
+	m, _, _, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+
+	var msgs [][]byte
+	for _, proof := range m.Proof {
+		bts, err := proto.Marshal(proof)
+		assert.Nil(t, err)
+		msgs = append(msgs, bts)
+	}
+
+	ok, err := consensus.HasQuorum(msgs, m.State)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// the first 2t+1 proofs are to m.State
+	valid := QuorumSize(20)
+	ok, err = consensus.HasQuorum(msgs[:valid], m.State)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = consensus.HasQuorum(msgs[1:], m.State)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// another state
+	ok, err = consensus.HasQuorum(msgs, State("another state"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// duplicated messages count once
+	var duplicated [][]byte
+	for i := 0; i < valid; i++ {
+		duplicated = append(duplicated, msgs[0])
+	}
+	ok, err = consensus.HasQuorum(duplicated, m.State)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// empty set
+	ok, err = consensus.HasQuorum(nil, m.State)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestHasQuorumInvalidMessages(t *testing.T) {
+	m, _, _, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+	bts, err := proto.Marshal(m.Proof[0])
+	assert.Nil(t, err)
+
+	// unknown participant
+	_, signed, _ := createCommitMessage(t, 10, 10, m.State)
+	unknown, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	_, err = consensus.HasQuorum([][]byte{bts, unknown}, m.State)
+	assert.Equal(t, ErrDecideProofUnknownParticipant, err)
+
+	// mismatched round
+	_, signed, _ = createCommitMessage(t, 10, 11, m.State)
+	consensus.AddParticipant(signed.PublicKey(S256Curve))
+	mismatched, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	_, err = consensus.HasQuorum([][]byte{bts, mismatched}, m.State)
+	assert.Equal(t, ErrDecideProofRoundMismatch, err)
+
+	// not <commit>
+	_, signed, _ = createRoundChangeMessageSigner(t, 10, 10, m.State, consensus.privateKey)
+	rc, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	_, err = consensus.HasQuorum([][]byte{rc}, m.State)
+	assert.Equal(t, ErrDecideProofTypeMismatch, err)
+
+	// undecodable
+	_, err = consensus.HasQuorum([][]byte{[]byte("garbage")}, m.State)
+	assert.NotNil(t, err)
+}
+
+func TestImportProofLock(t *testing.T) {
+	m, sp, privateKey, proofKeys := createLockMessage(t, 20, 1, 10, 1, 10)
+	consensus := createConsensus(t, 0, 1, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ImportProof(bts, time.Now()))
+
+	// the node has locked the state and entered commit stage
+	assert.Equal(t, uint64(10), consensus.currentRound.RoundNumber)
+	assert.Equal(t, stageCommit, consensus.currentRound.Stage)
+	assert.Equal(t, 1, len(consensus.locks))
+	assert.Equal(t, m.State, []byte(consensus.locks[0].Message.State))
+	assert.True(t, consensus.currentRound.CommitSent)
+}
+
+func TestImportProofFrame(t *testing.T) {
+	_, sp, privateKey, proofKeys := createLockMessage(t, 20, 1, 10, 1, 10)
+	consensus := createConsensus(t, 0, 1, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+	consensus.compressor = new(GzipCompressor)
+	consensus.compressThreshold = 1
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	frame := consensus.encodeFrame(bts)
+	assert.Equal(t, frameCompressed, frame[0])
+
+	// rejected while paused as proposals are
+	consensus.Pause()
+	assert.Equal(t, ErrConsensusPaused, consensus.ImportProof(frame, time.Now()))
+	consensus.Resume(time.Now())
+
+	// stale proofs are rejected before verification
+	consensus.latestHeight = 1
+	assert.True(t, errors.Is(consensus.ImportProof(frame, time.Now()), ErrLockHeightMismatch))
+	assert.Equal(t, uint64(0), consensus.numSignaturesVerified)
+
+	// frames are decoded as ReceiveMessage does
+	consensus.latestHeight = 0
+	assert.Nil(t, consensus.ImportProof(frame, time.Now()))
+	assert.Equal(t, stageCommit, consensus.currentRound.Stage)
+}
+
+func TestImportProofInsufficient(t *testing.T) {
+	_, sp, privateKey, proofKeys := createLockMessage(t, 20, 1, 10, 1, 10)
+	consensus := createConsensus(t, 0, 1, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+
+	// with more participants, the quorum cannot be satisified
+	for _, pubkey := range randomPublicKeys(t, 20) {
+		consensus.AddParticipant(pubkey)
+	}
+	consensus.numIdentities = countIdentities(consensus.participants)
+
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(consensus.ImportProof(bts, time.Now()), ErrLockProofInsufficient))
+	assert.Equal(t, stageRoundChanging, consensus.currentRound.Stage)
+	assert.Nil(t, consensus.locks)
+}
+
+func TestImportProofType(t *testing.T) {
+	_, sp, _, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(consensus.ImportProof(bts, time.Now()), ErrImportProofType))
+}
+
+func TestVerifyCommit(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	marshal := func(sp *SignedProto) []byte {
+		bts, err := proto.Marshal(sp)
+		assert.Nil(t, err)
+		return bts
+	}
+	_, commit, _ := createCommitMessageSigner(t, 10, 2, State("10"), keys[1])
+	msg := marshal(commit)
+
+	assert.Nil(t, VerifyCommit(participants, 10, 2, State("10"), msg))
+	assert.Equal(t, ErrCommitHeightMismatch, VerifyCommit(participants, 11, 2, State("10"), msg))
+	assert.Equal(t, ErrCommitRoundMismatch, VerifyCommit(participants, 10, 3, State("10"), msg))
+	assert.Equal(t, ErrCommitStateMismatch, VerifyCommit(participants, 10, 2, State("11"), msg))
+	assert.Equal(t, ErrConfigParticipants, VerifyCommit(nil, 10, 2, State("10"), msg))
+	assert.Equal(t, ErrMessageUnknownParticipant, VerifyCommit(participants[2:], 10, 2, State("10"), msg))
+	assert.Equal(t, ErrCommitEmptyState, VerifyCommit(participants, 10, 2, State("10"), marshal(func() *SignedProto {
+		_, sp, _ := createCommitMessageSigner(t, 10, 2, nil, keys[1])
+		return sp
+	}())))
+
+	_, roundchange, _ := createRoundChangeMessageSigner(t, 10, 2, State("10"), keys[1])
+	assert.Equal(t, ErrCommitMessageType, VerifyCommit(participants, 10, 2, State("10"), marshal(roundchange)))
+
+	// tampered signature
+	commit.Message = append([]byte(nil), commit.Message...)
+	commit.Message[len(commit.Message)-1] ^= 0xff
+	assert.Equal(t, ErrMessageSignature, VerifyCommit(participants, 10, 2, State("10"), marshal(commit)))
+
+	_, err := DecodeSignedMessage([]byte{0xff})
+	assert.Equal(t, err, VerifyCommit(participants, 10, 2, State("10"), []byte{0xff}))
+}
@@ -0,0 +1,189 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"sort"
+)
+
+// ProposeStatus is the outcome of a proposal
+type ProposeStatus int
+
+const (
+	// ProposeFinalized means the proposed state has been decided
+	ProposeFinalized ProposeStatus = iota
+	// ProposeSuperseded means another state has been decided at the height
+	// the state was proposed for
+	ProposeSuperseded
+	// ProposeHeightAdvanced means consensus has synced to a height beyond the
+	// one the state was proposed for, without the state being decided
+	ProposeHeightAdvanced
+	// ProposeRejected means the proposal was not accepted, e.g. a nil state or
+	// proposed on an observer
+	ProposeRejected
+)
+
+// String implements fmt.Stringer
+func (s ProposeStatus) String() string {
+	switch s {
+	case ProposeFinalized:
+		return "finalized"
+	case ProposeSuperseded:
+		return "superseded"
+	case ProposeHeightAdvanced:
+		return "height-advanced"
+	case ProposeRejected:
+		return "rejected"
+	}
+	return "unknown"
+}
+
+// ProposalInfo is a state proposed in <roundchange> messages of current round
+type ProposalInfo struct {
+	PublicKey *ecdsa.PublicKey // the first participant seen proposing the state
+	State     State
+	NumProofs int // number of <roundchange> messages proposing the state
+	Weight    int // sum of weights of the participants proposing the state
+}
+
+// PendingProposals returns the non-nil states proposed in <roundchange>
+// messages of current round, ordered by Config.StateCompare from the maximal,
+// with ties broken by Config.ProposalTieBreak, the maximal is the one a
+// <select> message must carry. The weights are counted
+// as those against the quorum for the leader to lock a state.
+func (c *Consensus) PendingProposals() []ProposalInfo {
+	if c.currentRound == nil {
+		return nil
+	}
+
+	var proposals []ProposalInfo
+	index := make(map[StateHash]int)
+	for _, t := range c.currentRound.roundChanges {
+		if t.Message.State == nil {
+			continue
+		}
+
+		idx, ok := index[t.StateHash]
+		if !ok {
+			idx = len(proposals)
+			index[t.StateHash] = idx
+			proposals = append(proposals, ProposalInfo{PublicKey: t.Signed.PublicKey(c.curve), State: t.Message.State})
+		}
+		proposals[idx].NumProofs++
+		proposals[idx].Weight += t.Weight
+	}
+
+	proposers := c.roundProposers()
+	sort.SliceStable(proposals, func(i, j int) bool {
+		return c.compareProposals(proposals[i].State, proposals[j].State, proposers, c.identity) > 0
+	})
+	return proposals
+}
+
+// ProposeResult is the result of a proposal made by ProposeWithResult
+type ProposeResult struct {
+	Status ProposeStatus
+	Height uint64 // the height the state was proposed for
+	// DecidedHeight and DecidedRound are where the resolving <decide> happened
+	DecidedHeight uint64
+	DecidedRound  uint64
+}
+
+// pendingProposal is a proposal awaiting for it's result
+type pendingProposal struct {
+	hash   StateHash
+	height uint64
+	ch     chan ProposeResult
+}
+
+// CanPropose checks if a state would be accepted by participants if proposed
+// now, with the same validation of the state as for the <roundchange> message
+// carrying it, at the next height and current round, the specific error will
+// be returned. It does not change any state of consensus, and the state is
+// not proposed.
+func (c *Consensus) CanPropose(s State) error {
+	if c.closed {
+		return ErrConsensusClosed
+	}
+
+	if c.observer {
+		return ErrObserverCannotPropose
+	}
+
+	if s == nil {
+		return ErrProposeEmptyState
+	}
+
+	if !c.validateState(c.latestHeight+1, c.currentRound.RoundNumber, s) {
+		return ErrRoundChangeStateValidation
+	}
+	return nil
+}
+
+// ProposeWithResult proposes a state as Propose does, and returns a channel
+// to receive the result when the height it was proposed for has finished,
+// the channel receives exactly once and then closed.
+//
+// Proposing the same state more than once is allowed, the state will be
+// proposed only once, and all returned channels receive the same result.
+func (c *Consensus) ProposeWithResult(s State) <-chan ProposeResult {
+	ch := make(chan ProposeResult, 1)
+	if s == nil || c.observer || c.closed || c.paused {
+		ch <- ProposeResult{Status: ProposeRejected, Height: c.latestHeight + 1}
+		close(ch)
+		return ch
+	}
+
+	c.Propose(s)
+	c.proposals = append(c.proposals, pendingProposal{hash: c.stateHash(s), height: c.latestHeight + 1, ch: ch})
+	return ch
+}
+
+// resolveProposals delivers results to all pending proposals, as the unconfirmed
+// states are cleared when a height is decided, at any height.
+func (c *Consensus) resolveProposals(height uint64, round uint64, s State) {
+	decidedHash := c.stateHash(s)
+	for _, p := range c.proposals {
+		result := ProposeResult{Height: p.height, DecidedHeight: height, DecidedRound: round}
+		switch {
+		case p.hash == decidedHash:
+			result.Status = ProposeFinalized
+		case p.height == height:
+			result.Status = ProposeSuperseded
+		default:
+			result.Status = ProposeHeightAdvanced
+		}
+		p.ch <- result
+		close(p.ch)
+	}
+	c.proposals = nil
+}
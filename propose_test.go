@@ -0,0 +1,420 @@
+package bdls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"io"
+	"sort"
+	"testing"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// createDecideConsensus creates a consensus at height 9 and returns it along with
+// a function to deliver a <decide> message at the given height.
+func createDecideConsensus(t *testing.T, height uint64) (*Consensus, State, func()) {
+	m, sp, privateKey, proofKeys := createDecideMessage(t, 20, height, 10, height, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+
+	decide := func() {
+		bts, err := proto.Marshal(sp)
+		assert.Nil(t, err)
+		assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	}
+	return consensus, m.State, decide
+}
+
+func receiveProposeResult(t *testing.T, ch <-chan ProposeResult) ProposeResult {
+	select {
+	case result, ok := <-ch:
+		assert.True(t, ok)
+		// closed after exactly one result
+		_, ok = <-ch
+		assert.False(t, ok)
+		return result
+	default:
+		t.Fatal("propose result not delivered")
+	}
+	return ProposeResult{}
+}
+
+func TestProposeWithResultFinalized(t *testing.T) {
+	consensus, state, decide := createDecideConsensus(t, 10)
+	ch1 := consensus.ProposeWithResult(state)
+	ch2 := consensus.ProposeWithResult(state)
+	assert.Equal(t, 1, len(consensus.unconfirmed))
+	assert.Equal(t, 0, len(ch1))
+
+	decide()
+	for _, ch := range []<-chan ProposeResult{ch1, ch2} {
+		result := receiveProposeResult(t, ch)
+		assert.Equal(t, ProposeFinalized, result.Status)
+		assert.Equal(t, uint64(10), result.Height)
+		assert.Equal(t, uint64(10), result.DecidedHeight)
+		assert.Equal(t, uint64(10), result.DecidedRound)
+	}
+	assert.Nil(t, consensus.proposals)
+}
+
+func TestProposeWithResultSuperseded(t *testing.T) {
+	consensus, _, decide := createDecideConsensus(t, 10)
+	state := make([]byte, 1024)
+	_, err := io.ReadFull(rand.Reader, state)
+	assert.Nil(t, err)
+	ch := consensus.ProposeWithResult(state)
+
+	decide()
+	result := receiveProposeResult(t, ch)
+	assert.Equal(t, ProposeSuperseded, result.Status)
+	assert.Equal(t, uint64(10), result.Height)
+}
+
+func TestProposeWithResultHeightAdvanced(t *testing.T) {
+	consensus, _, decide := createDecideConsensus(t, 12)
+	state := make([]byte, 1024)
+	_, err := io.ReadFull(rand.Reader, state)
+	assert.Nil(t, err)
+	ch := consensus.ProposeWithResult(state)
+
+	decide()
+	result := receiveProposeResult(t, ch)
+	assert.Equal(t, ProposeHeightAdvanced, result.Status)
+	assert.Equal(t, uint64(10), result.Height)
+	assert.Equal(t, uint64(12), result.DecidedHeight)
+}
+
+func TestProposeWithResultRejected(t *testing.T) {
+	consensus := createConsensus(t, 0, 0, nil)
+	result := receiveProposeResult(t, consensus.ProposeWithResult(nil))
+	assert.Equal(t, ProposeRejected, result.Status)
+	assert.Equal(t, 0, len(consensus.proposals))
+}
+
+func TestCanPropose(t *testing.T) {
+	consensus := createConsensus(t, 9, 3, nil)
+	var validated []uint64
+	consensus.stateValidateAt = func(height uint64, round uint64, s State) bool {
+		validated = append(validated, height, round)
+		return len(s) > 0 && s[0] != 0
+	}
+
+	assert.Nil(t, consensus.CanPropose(State("state")))
+	assert.Equal(t, []uint64{10, 3}, validated)
+	assert.Equal(t, ErrRoundChangeStateValidation, consensus.CanPropose(State{0}))
+	assert.Equal(t, ErrProposeEmptyState, consensus.CanPropose(nil))
+
+	// nothing has been proposed
+	assert.Equal(t, 0, len(consensus.unconfirmed))
+
+	// a state passing CanPropose is accepted in <roundchange>
+	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+	consensus.AddParticipant(&privateKey.PublicKey)
+	for _, s := range []State{State("state"), {0}} {
+		m, _, _ := createRoundChangeMessageSigner(t, 10, 3, s, privateKey)
+		assert.Equal(t, consensus.CanPropose(s), consensus.verifyRoundChangeMessage(m))
+	}
+}
+
+func TestPendingProposals(t *testing.T) {
+	var keys []*ecdsa.PrivateKey
+	var pubkeys []*ecdsa.PublicKey
+	for i := 0; i < 4; i++ {
+		privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+		assert.Nil(t, err)
+		keys = append(keys, privateKey)
+		pubkeys = append(pubkeys, &privateKey.PublicKey)
+	}
+	consensus := createConsensus(t, 0, 0, pubkeys)
+	assert.Nil(t, consensus.PendingProposals())
+
+	// two competing states, and a nil proposal
+	proposals := []State{State("a"), State("b"), State("a"), nil}
+	for i, s := range proposals {
+		_, signed, _ := createRoundChangeMessageSigner(t, 1, 0, s, keys[i])
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	}
+
+	pending := consensus.PendingProposals()
+	assert.Equal(t, 2, len(pending))
+	// the maximal state comes first
+	assert.Equal(t, State("b"), pending[0].State)
+	assert.Equal(t, 1, pending[0].NumProofs)
+	assert.Equal(t, 1, pending[0].Weight)
+	assert.True(t, pending[0].PublicKey.X.Cmp(keys[1].X) == 0)
+	assert.Equal(t, State("a"), pending[1].State)
+	assert.Equal(t, 2, pending[1].NumProofs)
+	assert.Equal(t, 2, pending[1].Weight)
+	assert.True(t, pending[1].PublicKey.X.Cmp(keys[0].X) == 0)
+
+	// consistent with the most proposed state
+	s, count := consensus.currentRound.GetMaxProposed()
+	assert.Equal(t, pending[1].State, s)
+	assert.Equal(t, pending[1].Weight, count)
+
+	// read-only
+	assert.Equal(t, 4, consensus.currentRound.NumRoundChanges())
+	for i, p := range consensus.PendingProposals() {
+		assert.Equal(t, pending[i].State, p.State)
+		assert.Equal(t, pending[i].NumProofs, p.NumProofs)
+	}
+}
+
+func TestProposalTieBreak(t *testing.T) {
+	var keys []*ecdsa.PrivateKey
+	var pubkeys []*ecdsa.PublicKey
+	for i := 0; i < 4; i++ {
+		privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+		assert.Nil(t, err)
+		keys = append(keys, privateKey)
+		pubkeys = append(pubkeys, &privateKey.PublicKey)
+	}
+
+	// states are ranked by the first byte only, "x1" and "x2" are equal
+	proposals := []State{State("x1"), State("x2"), State("a")}
+	selected := func(order []int, tieBreak func(a ProposalInfo, b ProposalInfo) int) (State, []State) {
+		consensus := createConsensus(t, 0, 0, pubkeys)
+		consensus.stateCompare = func(a State, b State) int { return bytes.Compare(a[:1], b[:1]) }
+		consensus.proposalTieBreak = tieBreak
+		for _, i := range order {
+			_, signed, _ := createRoundChangeMessageSigner(t, 1, 0, proposals[i], keys[i])
+			bts, err := proto.Marshal(signed)
+			assert.Nil(t, err)
+			assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+		}
+
+		// as the leader enqueues states before <select>
+		for _, s := range consensus.currentRound.RoundChangeStates() {
+			assert.Nil(t, consensus.TryPropose(s))
+		}
+		var pending []State
+		for _, p := range consensus.PendingProposals() {
+			pending = append(pending, p.State)
+		}
+		return consensus.maximalUnconfirmed(), pending
+	}
+
+	// the lower proposer identity wins regardless of the order received
+	expected := proposals[0]
+	id0, id1 := DefaultPubKeyToIdentity(pubkeys[0]), DefaultPubKeyToIdentity(pubkeys[1])
+	if bytes.Compare(id1[:], id0[:]) < 0 {
+		expected = proposals[1]
+	}
+	for _, order := range [][]int{{0, 1, 2}, {1, 0, 2}, {2, 1, 0}} {
+		s, pending := selected(order, nil)
+		assert.Equal(t, expected, s)
+		assert.Equal(t, expected, pending[0])
+		assert.Equal(t, State("a"), pending[2])
+	}
+
+	// chosen by the application
+	var calls int
+	byState := func(a ProposalInfo, b ProposalInfo) int {
+		calls++
+		assert.NotNil(t, a.PublicKey)
+		assert.NotNil(t, b.PublicKey)
+		return bytes.Compare(b.State, a.State)
+	}
+	for _, order := range [][]int{{0, 1, 2}, {1, 0, 2}} {
+		s, _ := selected(order, byState)
+		assert.Equal(t, State("x1"), s)
+	}
+	assert.True(t, calls > 0)
+}
+
+// createTieBreakKeys creates n keys sorted by identities
+func createTieBreakKeys(t *testing.T, n int) ([]*ecdsa.PrivateKey, []*ecdsa.PublicKey) {
+	var keys []*ecdsa.PrivateKey
+	for i := 0; i < n; i++ {
+		privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+		assert.Nil(t, err)
+		keys = append(keys, privateKey)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		idI, idJ := DefaultPubKeyToIdentity(&keys[i].PublicKey), DefaultPubKeyToIdentity(&keys[j].PublicKey)
+		return bytes.Compare(idI[:], idJ[:]) < 0
+	})
+
+	var pubkeys []*ecdsa.PublicKey
+	for _, key := range keys {
+		pubkeys = append(pubkeys, &key.PublicKey)
+	}
+	return keys, pubkeys
+}
+
+// createTieBreakMessage creates a <select> or <lock> message of height 1 and
+// round 0 signed by the leader, with proofs of the states from the signers
+func createTieBreakMessage(t *testing.T, mType MessageType, state State, leader *ecdsa.PrivateKey, signers []*ecdsa.PrivateKey, states []State) (*Message, *SignedProto) {
+	m := new(Message)
+	m.Type = mType
+	m.Height = 1
+	m.State = state
+	for i, key := range signers {
+		_, signedRc, _ := createRoundChangeMessageSigner(t, 1, 0, states[i], key)
+		m.Proof = append(m.Proof, signedRc)
+	}
+
+	signed := new(SignedProto)
+	signed.Sign(m, leader)
+	return m, signed
+}
+
+func TestProposalTieBreakSelectProofs(t *testing.T) {
+	keys, pubkeys := createTieBreakKeys(t, 5)
+	leader := keys[4]
+
+	// keys[1] proposes "x1" and keys[2] proposes "x2" in the proofs, "x1"
+	// is the maximal as keys[1] has the lower identity.
+	signers := []*ecdsa.PrivateKey{keys[1], keys[2], keys[3]}
+	states := []State{State("x1"), State("x2"), nil}
+	_, selectX1 := createTieBreakMessage(t, MessageType_Select, State("x1"), leader, signers, states)
+	_, selectX2 := createTieBreakMessage(t, MessageType_Select, State("x2"), leader, signers, states)
+
+	var nodes []*Consensus
+	for i := 0; i < 2; i++ {
+		consensus := createConsensus(t, 0, 0, pubkeys)
+		consensus.stateCompare = func(a State, b State) int { return bytes.Compare(a[:1], b[:1]) }
+		consensus.SetLeader(&leader.PublicKey)
+		nodes = append(nodes, consensus)
+	}
+
+	// only the first node has seen keys[0], the lowest identity, proposing "x2"
+	_, signedRc, _ := createRoundChangeMessageSigner(t, 1, 0, State("x2"), keys[0])
+	bts, err := proto.Marshal(signedRc)
+	assert.Nil(t, err)
+	assert.Nil(t, nodes[0].ReceiveMessage(bts, time.Now()))
+	assert.Equal(t, 1, nodes[0].currentRound.NumRoundChanges())
+	assert.Equal(t, 0, nodes[1].currentRound.NumRoundChanges())
+
+	// both nodes agree on the maximal in <select> messages
+	for _, consensus := range nodes {
+		m, err := consensus.verifyMessage(selectX1)
+		assert.Nil(t, err)
+		assert.Nil(t, consensus.verifySelectMessage(m, selectX1))
+
+		m, err = consensus.verifyMessage(selectX2)
+		assert.Nil(t, err)
+		assert.Equal(t, ErrSelectProofNotTheMaximal, consensus.verifySelectMessage(m, selectX2))
+	}
+
+	// ties of states proposed by the leader only are broken by the leader
+	_, selectX3 := createTieBreakMessage(t, MessageType_Select, State("x3"), leader, signers, states)
+	for _, consensus := range nodes {
+		m, err := consensus.verifyMessage(selectX3)
+		assert.Nil(t, err)
+		assert.Equal(t, ErrSelectProofNotTheMaximal, consensus.verifySelectMessage(m, selectX3))
+	}
+}
+
+func TestProposalTieBreakLockProofs(t *testing.T) {
+	keys, pubkeys := createTieBreakKeys(t, 5)
+	leader := keys[4]
+
+	// 6 participants, both "x1" and "x2" reach the quorum of 3
+	consensus := createConsensus(t, 0, 0, pubkeys)
+	consensus.stateCompare = func(a State, b State) int { return bytes.Compare(a[:1], b[:1]) }
+	consensus.SetLeader(&leader.PublicKey)
+	assert.Equal(t, 3, consensus.quorum())
+
+	signers := []*ecdsa.PrivateKey{keys[0], keys[1], keys[2], keys[3], keys[4], consensus.privateKey}
+	states := []State{State("x2"), State("x1"), State("x1"), State("x1"), State("x2"), State("x2")}
+
+	// keys[0] proposing "x2" has the lowest identity
+	_, lockX1 := createTieBreakMessage(t, MessageType_Lock, State("x1"), leader, signers, states)
+	_, lockX2 := createTieBreakMessage(t, MessageType_Lock, State("x2"), leader, signers, states)
+	m, err := consensus.verifyMessage(lockX2)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.verifyLockMessage(m, lockX2))
+
+	m, err = consensus.verifyMessage(lockX1)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrLockProofNotTheMaximal, consensus.verifyLockMessage(m, lockX1))
+}
+
+func TestProposalTieBreakLeaderProofs(t *testing.T) {
+	keys, pubkeys := createTieBreakKeys(t, 5)
+
+	// 6 participants with the quorum of 3, the leader is at round 1
+	leader := createConsensus(t, 0, 1, pubkeys)
+	leader.stateCompare = func(a State, b State) int { return bytes.Compare(a[:1], b[:1]) }
+	leader.SetLeader(&leader.privateKey.PublicKey)
+	receive := func(round uint64, s State, key *ecdsa.PrivateKey) {
+		_, signed, _ := createRoundChangeMessageSigner(t, 1, round, s, key)
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		assert.Nil(t, leader.ReceiveMessage(bts, time.Now()))
+	}
+
+	// keys[2] proposing "x1" has a lower identity than keys[3] proposing
+	// "x2" in the proofs, but keys[0] proposing "x2" at round 2 has the
+	// lowest identity of all the <roundchange> messages received.
+	receive(1, State("x1"), keys[2])
+	receive(1, State("x2"), keys[3])
+	receive(1, nil, keys[4])
+	receive(2, State("x2"), keys[0])
+	assert.Equal(t, uint64(1), leader.currentRound.RoundNumber)
+	assert.Nil(t, leader.TryPropose(State("x1")))
+	assert.Nil(t, leader.TryPropose(State("x2")))
+
+	var m *Message
+	var signed *SignedProto
+	leader.messageOutCallback = func(out *Message, sp *SignedProto) { m, signed = out, sp }
+	leader.broadcastSelect(time.Now())
+	assert.Equal(t, MessageType_Select, m.Type)
+	assert.Equal(t, 3, len(m.Proof))
+	assert.Equal(t, State("x1"), State(m.State))
+
+	// the choice is derived from the proofs sent, which a verifier accepts
+	verifier := createConsensus(t, 0, 0, nil)
+	verifier.participants = leader.participants
+	verifier.numIdentities = countIdentities(verifier.participants)
+	verifier.stateCompare = leader.stateCompare
+	verifier.SetLeader(&leader.privateKey.PublicKey)
+	decoded, err := verifier.verifyMessage(signed)
+	assert.Nil(t, err)
+	assert.Nil(t, verifier.verifySelectMessage(decoded, signed))
+}
+
+func TestDiffState(t *testing.T) {
+	consensus := createConsensus(t, 0, 0, randomPublicKeys(t, 4))
+	// ranked by length, with magnitudes
+	compare := func(a State, b State) int { return 10 * (len(a) - len(b)) }
+	consensus.stateCompare = compare
+
+	states := []State{State("a"), State("bb"), State("ccc"), State("dd"), State("")}
+	for _, a := range states {
+		for _, b := range states {
+			diff := consensus.DiffState(a, b)
+			assert.Contains(t, []int{-1, 0, 1}, diff)
+			assert.Equal(t, -diff, consensus.DiffState(b, a))
+			switch r := compare(a, b); {
+			case r > 0:
+				assert.Equal(t, 1, diff)
+			case r < 0:
+				assert.Equal(t, -1, diff)
+			case bytes.Equal(a, b):
+				assert.Equal(t, 0, diff)
+			default:
+				// equal under comparator, broken by bytes as selected
+				assert.Equal(t, bytes.Compare(a, b), diff)
+			}
+		}
+	}
+
+	// consistent with the selection of proposals
+	assert.Nil(t, consensus.TryPropose(State("bb")))
+	assert.Nil(t, consensus.TryPropose(State("dd")))
+	assert.Nil(t, consensus.TryPropose(State("a")))
+	maximal := consensus.maximalUnconfirmed()
+	for _, s := range consensus.unconfirmed {
+		assert.True(t, consensus.DiffState(maximal, s) >= 0)
+	}
+	assert.Equal(t, State("dd"), maximal)
+}
@@ -0,0 +1,160 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+
+	proto "github.com/gogo/protobuf/proto"
+)
+
+// QCSignature is the signature of a participant in a quorum certificate
+type QCSignature struct {
+	X PubKeyAxis // the signer's public key
+	Y PubKeyAxis
+	R []byte // signature of the <commit> message
+	S []byte
+}
+
+// QC is a quorum certificate of a decided height, the signatures of the
+// <commit> messages to the decided state, taken from the <decide> message.
+// It's a compact record for auditing that the height has been decided, the
+// full <decide> message is required to verify the signatures.
+type QC struct {
+	Height     uint64
+	Round      uint64
+	StateHash  StateHash
+	Signatures []QCSignature
+}
+
+// SignerSignature is the signature of a participant to a finalized state,
+// i.e. of the participant's <commit> message to the state.
+type SignerSignature struct {
+	Identity  Identity         // the signer
+	PublicKey *ecdsa.PublicKey // the public key of the signer
+	Signature []byte           // R || S in SizeSignature bytes
+	Commit    *SignedProto     // the signed <commit> message
+}
+
+// QuorumCertificate returns the quorum certificate of a decided height kept
+// in the history of the latest Config.QCHistoryDepth heights decided by
+// <decide> messages, ErrHeightNotRetained will be returned if the height has
+// not decided yet, or is no longer kept. Heights synced by Reset have no
+// certificates.
+func (c *Consensus) QuorumCertificate(height uint64) (*QC, error) {
+	// the latest one takes precedence if a height has decided more than once
+	for i := len(c.qcHistory) - 1; i >= 0; i-- {
+		if c.qcHistory[i].Height == height {
+			qc := *c.qcHistory[i]
+			qc.Signatures = append([]QCSignature(nil), qc.Signatures...)
+			return &qc, nil
+		}
+	}
+	return nil, ErrHeightNotRetained
+}
+
+// retainQC keeps the quorum certificate from the <decide> message of the
+// height decided, the oldest one is evicted when the history is full.
+func (c *Consensus) retainQC(height uint64, round uint64, s State, decide *SignedProto) {
+	if c.qcHistoryDepth <= 0 || decide == nil {
+		return
+	}
+
+	qc := &QC{Height: height, Round: round, StateHash: c.stateHash(s)}
+	for _, proof := range c.commitProofs(decide, s) {
+		qc.Signatures = append(qc.Signatures, QCSignature{X: proof.X, Y: proof.Y, R: proof.R, S: proof.S})
+	}
+
+	if len(c.qcHistory) >= c.qcHistoryDepth {
+		n := copy(c.qcHistory, c.qcHistory[len(c.qcHistory)-c.qcHistoryDepth+1:])
+		for i := n; i < len(c.qcHistory); i++ {
+			c.qcHistory[i] = nil // avoid memory leak
+		}
+		c.qcHistory = c.qcHistory[:n]
+	}
+	c.qcHistory = append(c.qcHistory, qc)
+}
+
+// commitProofs returns the <commit> messages to the decided state s in a
+// verified <decide> message, nil if decide is nil.
+func (c *Consensus) commitProofs(decide *SignedProto, s State) []*SignedProto {
+	if decide == nil {
+		return nil
+	}
+
+	// the <decide> message has been verified
+	m := new(Message)
+	if err := proto.Unmarshal(decide.Message, m); err != nil {
+		return nil
+	}
+
+	stateHash := c.stateHash(s)
+	var proofs []*SignedProto
+	for _, proof := range m.Proof {
+		mProof := new(Message)
+		if err := proto.Unmarshal(proof.Message, mProof); err != nil {
+			continue
+		}
+		if err := c.decodeState(mProof); err != nil {
+			continue
+		}
+
+		// only <commit> messages to the decided state are certificates
+		if c.stateHash(mProof.State) == stateHash {
+			proofs = append(proofs, proof)
+		}
+	}
+	return proofs
+}
+
+// notifyFinalized calls Config.OnStateFinalized with the signatures of the
+// <commit> messages in the <decide> message, once for each height.
+func (c *Consensus) notifyFinalized(height uint64, round uint64, s State, decide *SignedProto) {
+	if c.onStateFinalized == nil || height <= c.finalizedHeight {
+		return
+	}
+	c.finalizedHeight = height
+
+	var sigs []SignerSignature
+	for _, proof := range c.commitProofs(decide, s) {
+		publicKey := proof.PublicKey(c.curve)
+		sigs = append(sigs, SignerSignature{
+			Identity:  c.pubKeyToIdentity(publicKey),
+			PublicKey: publicKey,
+			Signature: encodeSignature(new(big.Int).SetBytes(proof.R), new(big.Int).SetBytes(proof.S)),
+			Commit:    proof,
+		})
+	}
+
+	// the hook is queued after OnDecide of the same height
+	c.hooks.push(func() { c.onStateFinalized(height, round, s, sigs) })
+}
@@ -0,0 +1,154 @@
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"testing"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuorumCertificate(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	for _, config := range configs {
+		config.QCHistoryDepth = 2
+	}
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond, DeterministicMode())
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	decided := make(map[uint64]State)
+	for height := uint64(1); height <= 3; height++ {
+		for k, p := range network.Peers() {
+			p.Propose(State(fmt.Sprintf("height %d by %d", height, k)))
+		}
+		network.Run(5 * time.Second)
+		h, _, s := network.Peers()[0].GetLatestState()
+		assert.Equal(t, height, h)
+		decided[height] = s
+	}
+
+	for _, p := range network.Peers() {
+		for height := uint64(2); height <= 3; height++ {
+			qc, err := p.QuorumCertificate(height)
+			assert.Nil(t, err)
+			assert.Equal(t, height, qc.Height)
+			assert.Equal(t, defaultHash(decided[height]), qc.StateHash)
+			assert.True(t, len(qc.Signatures) >= QuorumSize(4))
+
+			// signed by distinct participants
+			signers := make(map[Identity]bool)
+			for _, sig := range qc.Signatures {
+				var id Identity
+				copy(id[:SizeAxis], sig.X[:])
+				copy(id[SizeAxis:], sig.Y[:])
+				signers[id] = true
+				assert.NotEmpty(t, sig.R)
+				assert.NotEmpty(t, sig.S)
+			}
+			assert.Equal(t, len(qc.Signatures), len(signers))
+			for id := range signers {
+				assert.Contains(t, configs[0].Participants, id)
+			}
+		}
+
+		// evicted, and not decided yet
+		_, err := p.QuorumCertificate(1)
+		assert.Equal(t, ErrHeightNotRetained, err)
+		_, err = p.QuorumCertificate(4)
+		assert.Equal(t, ErrHeightNotRetained, err)
+	}
+}
+
+func TestQuorumCertificateDisabled(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	consensus := createConsensus(t, 9, 0, participants)
+	consensus.SetLeader(&keys[0].PublicKey)
+	decides := createDecideChain(t, keys, 10)
+	assert.Nil(t, consensus.ReceiveMessage(decides[0], time.Now()))
+	_, err := consensus.QuorumCertificate(10)
+	assert.Equal(t, ErrHeightNotRetained, err)
+
+	// retained with <decide> messages only
+	consensus.qcHistoryDepth = 1
+	decides = createDecideChain(t, keys, 11)
+	assert.Nil(t, consensus.ReceiveMessage(decides[0], time.Now()))
+	qc, err := consensus.QuorumCertificate(11)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(qc.Signatures))
+
+	assert.Nil(t, consensus.Reset(12, State("12"), time.Now()))
+	_, err = consensus.QuorumCertificate(12)
+	assert.Equal(t, ErrHeightNotRetained, err)
+}
+
+func TestOnStateFinalized(t *testing.T) {
+	type finalized struct {
+		peer   int
+		height uint64
+		round  uint64
+		state  State
+		sigs   []SignerSignature
+	}
+
+	const heights = 3
+	configs := createIPCNetworkConfigs(t, 4)
+	events := make(chan finalized, 2*heights*len(configs))
+	var participants []*ecdsa.PublicKey
+	for i, config := range configs {
+		i := i
+		participants = append(participants, &config.PrivateKey.PublicKey)
+		config.OnStateFinalized = func(height uint64, round uint64, s State, sigs []SignerSignature) {
+			events <- finalized{i, height, round, s, sigs}
+		}
+	}
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond, DeterministicMode())
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	decided := make(map[uint64]State)
+	for height := uint64(1); height <= heights; height++ {
+		for k, p := range network.Peers() {
+			p.Propose(State(fmt.Sprintf("height %d by %d", height, k)))
+		}
+		network.Run(5 * time.Second)
+		h, _, s := network.Peers()[0].GetLatestState()
+		assert.Equal(t, height, h)
+		decided[height] = s
+	}
+
+	// once for each height of each peer
+	seen := make(map[[2]uint64]bool)
+	for i := 0; i < heights*len(configs); i++ {
+		var e finalized
+		select {
+		case e = <-events:
+		case <-time.After(time.Second):
+			t.Fatal("OnStateFinalized not called")
+		}
+		key := [2]uint64{uint64(e.peer), e.height}
+		assert.False(t, seen[key])
+		seen[key] = true
+		assert.Equal(t, decided[e.height], e.state)
+
+		// the signatures constitute a valid quorum
+		assert.True(t, len(e.sigs) >= QuorumSize(len(configs)))
+		signers := make(map[Identity]bool)
+		for _, sig := range e.sigs {
+			signers[sig.Identity] = true
+			assert.Contains(t, configs[0].Participants, sig.Identity)
+			assert.Equal(t, DefaultPubKeyToIdentity(sig.PublicKey), sig.Identity)
+
+			bts, err := proto.Marshal(sig.Commit)
+			assert.Nil(t, err)
+			assert.Nil(t, VerifyCommit(participants, e.height, e.round, e.state, bts))
+			r, s, err := decodeSignature(sig.Signature)
+			assert.Nil(t, err)
+			assert.True(t, ecdsa.Verify(sig.PublicKey, sig.Commit.Hash(), r, s))
+		}
+		assert.Equal(t, len(e.sigs), len(signers))
+	}
+	assert.Equal(t, 0, len(events))
+}
@@ -0,0 +1,94 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import "time"
+
+const (
+	// DefaultRateLimitBurst is the default number of messages a participant
+	// can send in a burst, when Config.PerParticipantRateLimit is set
+	DefaultRateLimitBurst = 32
+)
+
+// tokenBucket holds the tokens left for a participant, refilled at last
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter limits the messages from each participant with token buckets,
+// refilled with the time fed to consensus.
+type rateLimiter struct {
+	rate      float64 // tokens per second
+	burst     float64 // capacity of a bucket
+	buckets   map[Identity]*tokenBucket
+	throttled uint64 // count of messages rejected
+}
+
+// newRateLimiter creates a rateLimiter allowing rate messages per second,
+// and burst messages at most at once, for each participant
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	l := new(rateLimiter)
+	l.rate = rate
+	l.burst = float64(burst)
+	l.buckets = make(map[Identity]*tokenBucket)
+	return l
+}
+
+// Allow takes a token from the participant's bucket, false will be returned
+// if the bucket has been exhausted. The buckets are bounded by the number
+// of participants, as messages are verified before reaching here.
+func (l *rateLimiter) Allow(id Identity, now time.Time) bool {
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[id] = b
+	}
+
+	// refill, the clock may go backwards
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		l.throttled++
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Throttled returns the count of messages rejected
+func (l *rateLimiter) Throttled() uint64 { return l.throttled }
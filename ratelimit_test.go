@@ -0,0 +1,85 @@
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(10, 5)
+	now := time.Now()
+	var a, b Identity
+	b[0] = 1
+
+	// burst
+	for i := 0; i < 5; i++ {
+		assert.True(t, l.Allow(a, now))
+	}
+	assert.False(t, l.Allow(a, now))
+	assert.Equal(t, uint64(1), l.Throttled())
+
+	// buckets are individual
+	assert.True(t, l.Allow(b, now))
+
+	// refilled at 10 tokens per second
+	assert.False(t, l.Allow(a, now.Add(50*time.Millisecond)))
+	assert.True(t, l.Allow(a, now.Add(100*time.Millisecond)))
+	assert.False(t, l.Allow(a, now.Add(100*time.Millisecond)))
+
+	// clock going backwards does not refill
+	assert.False(t, l.Allow(a, now))
+
+	// refill is capped by burst
+	later := now.Add(time.Hour)
+	for i := 0; i < 5; i++ {
+		assert.True(t, l.Allow(a, later))
+	}
+	assert.False(t, l.Allow(a, later))
+}
+
+func TestReceiveMessageRateLimited(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+	consensus := createConsensus(t, 0, 0, append(randomPublicKeys(t, ConfigMinimumParticipants), &privateKey.PublicKey))
+	consensus.limiter = newRateLimiter(1, 3)
+
+	send := func(round uint64, now time.Time) error {
+		_, signed, _ := createRoundChangeMessageSigner(t, 1, round, State("state"), privateKey)
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		return consensus.ReceiveMessage(bts, now)
+	}
+
+	now := time.Now()
+	for i := uint64(0); i < 3; i++ {
+		assert.Nil(t, send(i, now))
+	}
+	assert.True(t, errors.Is(send(3, now), ErrMessageRateLimited))
+	assert.Equal(t, uint64(1), consensus.Metrics(now).NumRateLimited)
+
+	// one more message is allowed a second later
+	assert.Nil(t, send(4, now.Add(time.Second)))
+	assert.True(t, errors.Is(send(5, now.Add(time.Second)), ErrMessageRateLimited))
+	assert.Equal(t, uint64(2), consensus.Metrics(now).NumRateLimited)
+}
+
+func TestVerifyConfigRateLimit(t *testing.T) {
+	config := createIPCNetworkConfigs(t, 4)[0]
+	config.PerParticipantRateLimit = -1
+	assert.Equal(t, ErrConfigRateLimit, VerifyConfig(config))
+
+	config.PerParticipantRateLimit = 10
+	config.PerParticipantRateBurst = -1
+	assert.Equal(t, ErrConfigRateLimit, VerifyConfig(config))
+
+	config.PerParticipantRateBurst = 0
+	consensus, err := NewConsensus(config)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(DefaultRateLimitBurst), consensus.limiter.burst)
+}
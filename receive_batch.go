@@ -0,0 +1,90 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"sort"
+	"time"
+)
+
+// ReceiveBatch processes a batch of incoming consensus messages, e.g. received
+// at once while catching up, and returns the errors of each message in the
+// order given, as ReceiveMessage does for them individually.
+//
+// Messages are processed in ascending order of height and round, messages
+// with the same height and round, or undecodable ones, keep their relative
+// order. Each message is still verified against the states updated by the
+// previous ones, so reordering never accepts a message ReceiveMessage would
+// reject at that point, it only avoids rejecting messages which arrived ahead
+// of the <decide> before them.
+func (c *Consensus) ReceiveBatch(msgs [][]byte, now time.Time) []error {
+	defer c.hooks.flush()
+	type batchItem struct {
+		index  int
+		height uint64
+		round  uint64
+	}
+
+	items := make([]batchItem, len(msgs))
+	for k := range msgs {
+		items[k].index = k
+		items[k].height, items[k].round = c.peekHeightRound(msgs[k])
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].height != items[j].height {
+			return items[i].height < items[j].height
+		}
+		return items[i].round < items[j].round
+	})
+
+	errs := make([]error, len(msgs))
+	for _, item := range items {
+		errs[item.index] = c.ReceiveMessage(msgs[item.index], now)
+	}
+	return errs
+}
+
+// peekHeightRound returns the height and round of a message without
+// verification for ordering only, zeros are returned if it's undecodable.
+func (c *Consensus) peekHeightRound(bts []byte) (height uint64, round uint64) {
+	if err := c.checkMessageSize(bts); err != nil {
+		return 0, 0
+	}
+	bts, err := c.decodeFrame(bts)
+	if err != nil {
+		return 0, 0
+	}
+	h, ok := peekMessage(bts)
+	if !ok {
+		return 0, 0
+	}
+	return h.Height, h.Round
+}
@@ -0,0 +1,79 @@
+package bdls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiveBatch(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	decides := createDecideChain(t, keys, 10, 11, 12, 13)
+
+	// a reordered chain is rejected partially one by one
+	consensus := createConsensus(t, 9, 0, participants)
+	consensus.SetLeader(&keys[0].PublicKey)
+	assert.Nil(t, consensus.ReceiveMessage(decides[2], time.Now()))
+	assert.NotNil(t, consensus.ReceiveMessage(decides[0], time.Now()))
+
+	// and accepted entirely in a batch, errors in the order given
+	consensus = createConsensus(t, 9, 0, participants)
+	consensus.SetLeader(&keys[0].PublicKey)
+	batch := [][]byte{decides[3], decides[1], []byte("garbage"), decides[0], decides[2]}
+	errs := consensus.ReceiveBatch(batch, time.Now())
+	assert.Equal(t, len(batch), len(errs))
+	for k, err := range errs {
+		if k == 2 {
+			assert.NotNil(t, err)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+	assert.Equal(t, uint64(13), consensus.Height())
+
+	// closed
+	consensus.Close()
+	errs = consensus.ReceiveBatch(decides[:2], time.Now())
+	assert.Equal(t, []error{ErrConsensusClosed, ErrConsensusClosed}, errs)
+}
+
+func benchmarkReceiveBatch(b *testing.B, batch bool) {
+	keys, participants := createDecideChainKeys(b, 4)
+	decides := createDecideChain(b, keys, 10, 11, 12, 13, 14, 15, 16, 17)
+	// arrived out of order
+	msgs := [][]byte{decides[1], decides[0], decides[3], decides[2], decides[5], decides[4], decides[7], decides[6]}
+
+	accepted := 0
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		consensus := createConsensus(b, 9, 0, participants)
+		consensus.SetLeader(&keys[0].PublicKey)
+		p := NewIPCPeer(consensus, 0)
+		b.StartTimer()
+
+		var errs []error
+		if batch {
+			errs = p.ReceiveBatch(msgs)
+		} else {
+			for _, msg := range msgs {
+				errs = append(errs, p.ReceiveBatch([][]byte{msg})...)
+			}
+		}
+		for _, err := range errs {
+			if err == nil {
+				accepted++
+			}
+		}
+	}
+	// the loop rejects decides arrived late, and skips verifying their proofs
+	b.ReportMetric(float64(accepted)/float64(b.N), "accepted/op")
+}
+
+func BenchmarkReceiveBatch(b *testing.B) {
+	benchmarkReceiveBatch(b, true)
+}
+
+func BenchmarkReceiveLoop(b *testing.B) {
+	benchmarkReceiveBatch(b, false)
+}
@@ -0,0 +1,152 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"context"
+	"time"
+)
+
+// priorities of messages in receive queue, lower value is verified first,
+// messages advancing finality should not be starved by <roundchange> floods.
+const (
+	priorityDecide = iota
+	priorityCommit
+	priorityProof // <lock>, <select> and <lock-release>
+	priorityRoundChange
+	numPriorities
+)
+
+// messagePriority returns the priority of a message by type
+func messagePriority(t MessageType) int {
+	switch t {
+	case MessageType_Decide:
+		return priorityDecide
+	case MessageType_Commit:
+		return priorityCommit
+	case MessageType_Lock, MessageType_Select, MessageType_LockRelease:
+		return priorityProof
+	default:
+		return priorityRoundChange
+	}
+}
+
+// receiveQueue is a bounded priority queue of received messages pending
+// verification, messages of the same priority are kept in arrival order.
+// When the queue is full, a message of the lowest priority is dropped.
+type receiveQueue struct {
+	levels  [numPriorities][][]byte
+	size    int
+	limit   int
+	dropped uint64
+}
+
+// newReceiveQueue creates a queue holding at most limit messages
+func newReceiveQueue(limit int) *receiveQueue {
+	q := new(receiveQueue)
+	q.limit = limit
+	return q
+}
+
+// Push queues a message with priority, if the queue is full, the oldest
+// message of a lower priority is evicted, or the message itself is dropped
+// if none has lower priority. Both are counted as dropped.
+func (q *receiveQueue) Push(bts []byte, priority int) bool {
+	if q.size >= q.limit {
+		lowest := numPriorities - 1
+		for lowest > priority && len(q.levels[lowest]) == 0 {
+			lowest--
+		}
+		q.dropped++
+		if lowest <= priority {
+			return false
+		}
+		q.levels[lowest] = q.levels[lowest][1:]
+		q.size--
+	}
+
+	q.levels[priority] = append(q.levels[priority], bts)
+	q.size++
+	return true
+}
+
+// Pop removes and returns the oldest message of the highest priority
+func (q *receiveQueue) Pop() ([]byte, bool) {
+	for k := range q.levels {
+		if len(q.levels[k]) > 0 {
+			bts := q.levels[k][0]
+			q.levels[k] = q.levels[k][1:]
+			q.size--
+			return bts, true
+		}
+	}
+	return nil, false
+}
+
+// Len returns the number of messages queued
+func (q *receiveQueue) Len() int { return q.size }
+
+// Dropped returns the count of messages dropped for the queue being full
+func (q *receiveQueue) Dropped() uint64 { return q.dropped }
+
+// enqueueMessage queues a decoded frame for verification in Update, false
+// will be returned if the message cannot be decoded, and it should be
+// verified in place to report the error.
+func (c *Consensus) enqueueMessage(bts []byte) bool {
+	h, ok := peekMessage(bts)
+	if !ok {
+		return false
+	}
+
+	// the frame buffer may be reused by the caller
+	if !c.receiveQueue.Push(append([]byte(nil), bts...), messagePriority(h.Type)) {
+		c.logger.Debugf("receive queue full, %v message dropped", h.Type)
+	}
+	return true
+}
+
+// processReceiveQueue verifies queued messages by priority until the queue
+// has drained or ctx is done, rejected messages are reported as in
+// ReceiveMessage.
+func (c *Consensus) processReceiveQueue(ctx context.Context, now time.Time) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		bts, ok := c.receiveQueue.Pop()
+		if !ok {
+			return nil
+		}
+		if err := c.receive(bts, now); err != nil {
+			c.notifyRejection(bts, err)
+		}
+	}
+}
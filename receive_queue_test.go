@@ -0,0 +1,83 @@
+package bdls
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiveQueue(t *testing.T) {
+	q := newReceiveQueue(3)
+	assert.True(t, q.Push([]byte("roundchange 1"), priorityRoundChange))
+	assert.True(t, q.Push([]byte("roundchange 2"), priorityRoundChange))
+	assert.True(t, q.Push([]byte("commit"), priorityCommit))
+
+	// full, the oldest of the lowest priority is evicted
+	assert.True(t, q.Push([]byte("decide"), priorityDecide))
+	assert.Equal(t, 3, q.Len())
+	assert.Equal(t, uint64(1), q.Dropped())
+
+	// none has lower priority
+	assert.False(t, q.Push([]byte("roundchange 3"), priorityRoundChange))
+	assert.Equal(t, uint64(2), q.Dropped())
+
+	var popped []string
+	for bts, ok := q.Pop(); ok; bts, ok = q.Pop() {
+		popped = append(popped, string(bts))
+	}
+	assert.Equal(t, []string{"decide", "commit", "roundchange 2"}, popped)
+	assert.Equal(t, 0, q.Len())
+}
+
+// countdownContext is cancelled after Err has been called n times
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestReceiveQueueFlood(t *testing.T) {
+	_, decide, privateKey, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+	consensus.receiveQueue = newReceiveQueue(16)
+
+	// flood the queue with <roundchange>
+	for i := 0; i < 100; i++ {
+		_, roundchange, _ := createRoundChangeMessage(t, 10, 10)
+		bts, err := proto.Marshal(roundchange)
+		assert.Nil(t, err)
+		assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	}
+	assert.Equal(t, 16, consensus.receiveQueue.Len())
+
+	// the <decide> is not dropped, and verified first
+	bts, err := proto.Marshal(decide)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	assert.Equal(t, uint64(9), consensus.Height())
+
+	// only the first queued message gets verified
+	ctx := &countdownContext{Context: context.Background(), n: 2}
+	assert.Equal(t, context.Canceled, consensus.UpdateWithContext(ctx, time.Now()))
+	assert.Equal(t, uint64(10), consensus.Height())
+	assert.Equal(t, 15, consensus.receiveQueue.Len())
+
+	assert.Nil(t, consensus.Update(time.Now()))
+	assert.Equal(t, 0, consensus.receiveQueue.Len())
+	assert.Equal(t, uint64(101-16), consensus.Metrics(time.Now()).NumReceiveQueueDropped)
+
+	// undecodable messages are rejected in place
+	assert.NotNil(t, consensus.ReceiveMessage([]byte{0xff}, time.Now()))
+}
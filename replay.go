@@ -0,0 +1,82 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import "github.com/Sperax/bdls/crypto/blake2b"
+
+// replayGuard remembers the hashes of accepted messages by heights, for
+// the latest window of heights, to reject exact replays of messages.
+//
+// Unlike dedupCache, which is a bounded cache and forgets messages, a
+// message is remembered as long as it's height is in the window.
+type replayGuard struct {
+	window  uint64
+	heights map[uint64]map[[blake2b.Size256]byte]struct{}
+}
+
+// newReplayGuard creates a replayGuard remembering window heights, counting
+// back from the next height to decide, future heights are always remembered.
+func newReplayGuard(window uint64) *replayGuard {
+	g := new(replayGuard)
+	g.window = window
+	g.heights = make(map[uint64]map[[blake2b.Size256]byte]struct{})
+	return g
+}
+
+// Seen checks if a message with hash key has been accepted in the window
+func (g *replayGuard) Seen(key [blake2b.Size256]byte) bool {
+	for _, set := range g.heights {
+		if _, ok := set[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Add remembers a message with hash key accepted at height
+func (g *replayGuard) Add(height uint64, key [blake2b.Size256]byte) {
+	set, ok := g.heights[height]
+	if !ok {
+		set = make(map[[blake2b.Size256]byte]struct{})
+		g.heights[height] = set
+	}
+	set[key] = struct{}{}
+}
+
+// Prune forgets messages of heights sliding out of the window, after
+// latestHeight has been decided, the window ends at latestHeight+1.
+func (g *replayGuard) Prune(latestHeight uint64) {
+	for height := range g.heights {
+		if height+g.window <= latestHeight+1 {
+			delete(g.heights, height)
+		}
+	}
+}
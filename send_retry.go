@@ -0,0 +1,144 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"errors"
+	"time"
+)
+
+// SendRetry is the policy to retry Peer.Send on errors, for transports with
+// transient failures, see Config.SendRetry.
+type SendRetry struct {
+	// MaxAttempts is the number of attempts to send a message to a peer,
+	// including the first one, it must be at least 1.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry, and doubles on each
+	// consecutive retry.
+	Backoff time.Duration
+
+	// MaxBackoff caps the delay between retries, 0 means no cap.
+	MaxBackoff time.Duration
+}
+
+// backoff returns the delay before the retry following the given attempts
+func (r *SendRetry) backoff(attempts int) time.Duration {
+	d := r.Backoff
+	for i := 1; i < attempts; i++ {
+		if r.MaxBackoff > 0 && d >= r.MaxBackoff {
+			break
+		}
+		d *= 2
+	}
+	if r.MaxBackoff > 0 && d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d
+}
+
+// pendingSend is a frame to send to a peer again once due
+type pendingSend struct {
+	peer     PeerInterface
+	frame    []byte
+	attempts int // attempts failed so far
+	due      time.Time
+}
+
+// temporaryError reports whether a failed Send may succeed later, only
+// errors implementing Temporary() bool and returning true, like net.Error,
+// and a full send queue are temporary, others, such as a closed peer, are not.
+func temporaryError(err error) bool {
+	if errors.Is(err, ErrTCPSendQueueFull) {
+		return true
+	}
+	var temporary interface{ Temporary() bool }
+	return errors.As(err, &temporary) && temporary.Temporary()
+}
+
+// retrySend queues another attempt of sending frame to the peer after the
+// given attempts failed with err at now, or reports a SendFailureEvent if attempts
+// are exhausted or the error is not temporary. Queued retries are sent from
+// Update as they become due, with the lock held by the caller as any other
+// call to consensus, so the state machine is never blocked.
+func (c *Consensus) retrySend(peer PeerInterface, frame []byte, attempts int, err error, now time.Time) {
+	if c.sendRetry == nil || !temporaryError(err) {
+		c.logger.Debugf("sending to %v failed: %v", peer.RemoteAddr(), err)
+		c.notifySendFailure(SendFailureEvent{Peer: peer, Attempts: attempts, Err: err})
+		return
+	}
+	if attempts >= c.sendRetry.MaxAttempts {
+		c.logger.Warnf("sending to %v failed after %d attempts: %v", peer.RemoteAddr(), attempts, err)
+		c.notifySendFailure(SendFailureEvent{Peer: peer, Attempts: attempts, Err: err})
+		return
+	}
+
+	due := now.Add(c.sendRetry.backoff(attempts))
+	c.retries = append(c.retries, pendingSend{peer: peer, frame: frame, attempts: attempts, due: due})
+}
+
+// processRetries sends the queued retries due at now, retries to peers which
+// have been removed are dropped.
+func (c *Consensus) processRetries(now time.Time) {
+	if len(c.retries) == 0 {
+		return
+	}
+
+	var due []pendingSend
+	pending := c.retries[:0]
+	for _, r := range c.retries {
+		if now.Before(r.due) {
+			pending = append(pending, r)
+		} else {
+			due = append(due, r)
+		}
+	}
+	c.retries = pending
+
+	for _, r := range due {
+		if !c.hasPeer(r.peer) {
+			continue
+		}
+		if err := r.peer.Send(r.frame); err != nil {
+			c.retrySend(r.peer, r.frame, r.attempts+1, err, now)
+		}
+	}
+}
+
+// hasPeer checks if the peer is in the peer set
+func (c *Consensus) hasPeer(p PeerInterface) bool {
+	for k := range c.peers {
+		if c.peers[k] == p {
+			return true
+		}
+	}
+	return false
+}
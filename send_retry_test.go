@@ -0,0 +1,222 @@
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sperax/bdls/timer"
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// transientError is a temporary failure of sending
+type transientError struct{}
+
+func (transientError) Error() string   { return "transient failure" }
+func (transientError) Temporary() bool { return true }
+
+var errTransient error = transientError{}
+
+// flakyPeer fails the first given number of sends with err, or errTransient
+type flakyPeer struct {
+	addr     string
+	failures int
+	err      error
+	sends    int
+	received [][]byte
+	sync.Mutex
+}
+
+func (p *flakyPeer) GetPublicKey() *ecdsa.PublicKey { return nil }
+func (p *flakyPeer) RemoteAddr() net.Addr           { return fakeAddress(p.addr) }
+func (p *flakyPeer) Send(msg []byte) error {
+	p.Lock()
+	defer p.Unlock()
+	p.sends++
+	if p.sends <= p.failures {
+		if p.err != nil {
+			return p.err
+		}
+		return errTransient
+	}
+	p.received = append(p.received, msg)
+	return nil
+}
+
+func (p *flakyPeer) counts() (sends int, received int) {
+	p.Lock()
+	defer p.Unlock()
+	return p.sends, len(p.received)
+}
+
+func TestSendRetry(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	configs[0].Scheduler = scheduler
+	configs[0].SendRetry = &SendRetry{MaxAttempts: 3, Backoff: 100 * time.Millisecond}
+	consensus, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+	failures, unsubscribe := consensus.SubscribeSendFailure()
+	defer unsubscribe()
+
+	flaky := &flakyPeer{addr: "flaky", failures: 2}
+	broken := &flakyPeer{addr: "broken", failures: 100}
+	consensus.Join(flaky)
+	consensus.Join(broken)
+	consensus.broadcast(&Message{Type: MessageType_RoundChange, Height: 1, State: State("state")}, scheduler.Now())
+	advance := func(d time.Duration) {
+		scheduler.Advance(d)
+		consensus.processRetries(scheduler.Now())
+	}
+
+	// the first attempt failed without blocking
+	sends, received := flaky.counts()
+	assert.Equal(t, 1, sends)
+	assert.Equal(t, 0, received)
+
+	// retried with doubling backoff
+	advance(99 * time.Millisecond)
+	sends, _ = flaky.counts()
+	assert.Equal(t, 1, sends)
+	advance(time.Millisecond)
+	sends, _ = flaky.counts()
+	assert.Equal(t, 2, sends)
+	advance(199 * time.Millisecond)
+	sends, _ = flaky.counts()
+	assert.Equal(t, 2, sends)
+	advance(time.Millisecond)
+	sends, received = flaky.counts()
+	assert.Equal(t, 3, sends)
+	assert.Equal(t, 1, received)
+
+	// persistent failures are reported once attempts are exhausted
+	sends, received = broken.counts()
+	assert.Equal(t, 3, sends)
+	assert.Equal(t, 0, received)
+	select {
+	case event := <-failures:
+		assert.Equal(t, PeerInterface(broken), event.Peer)
+		assert.Equal(t, 3, event.Attempts)
+		assert.Equal(t, errTransient, event.Err)
+	default:
+		t.Fatal("no SendFailureEvent")
+	}
+	advance(time.Minute)
+	assert.Equal(t, 0, len(failures))
+	sends, _ = broken.counts()
+	assert.Equal(t, 3, sends)
+}
+
+func TestSendRetryDisabled(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	configs[0].Scheduler = scheduler
+	consensus, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+	failures, unsubscribe := consensus.SubscribeSendFailure()
+	defer unsubscribe()
+
+	flaky := &flakyPeer{addr: "flaky", failures: 1}
+	consensus.Join(flaky)
+	consensus.broadcast(&Message{Type: MessageType_RoundChange, Height: 1, State: State("state")}, scheduler.Now())
+	scheduler.Advance(time.Minute)
+	sends, received := flaky.counts()
+	assert.Equal(t, 1, sends)
+	assert.Equal(t, 0, received)
+	event := <-failures
+	assert.Equal(t, 1, event.Attempts)
+
+	// no retries after closed
+	consensus.Close()
+	_, ok := <-failures
+	assert.False(t, ok)
+}
+
+func TestSendRetryFromUpdate(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	configs[0].Scheduler = scheduler
+	configs[0].SendRetry = &SendRetry{MaxAttempts: 3, Backoff: 100 * time.Millisecond}
+	consensus, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+	failures, unsubscribe := consensus.SubscribeSendFailure()
+	defer unsubscribe()
+
+	flaky := &flakyPeer{addr: "flaky", failures: 1}
+	closed := &flakyPeer{addr: "closed", failures: 1, err: ErrTCPPeerClosed}
+	left := &flakyPeer{addr: "left", failures: 1}
+	consensus.Join(flaky)
+	consensus.Join(closed)
+	consensus.Join(left)
+	signed := consensus.broadcast(&Message{Type: MessageType_RoundChange, Height: 1, State: State("state")}, scheduler.Now())
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	frame := consensus.encodeFrame(bts)
+
+	// errors which are not temporary are reported without retry
+	event := <-failures
+	assert.Equal(t, PeerInterface(closed), event.Peer)
+	assert.Equal(t, 1, event.Attempts)
+	assert.Equal(t, ErrTCPPeerClosed, event.Err)
+
+	// retries are sent from Update only, and not to peers removed
+	assert.True(t, consensus.RemovePeer(left))
+	scheduler.Advance(time.Second)
+	sends, _ := flaky.counts()
+	assert.Equal(t, 1, sends)
+	assert.Nil(t, consensus.Update(scheduler.Now()))
+	flaky.Lock()
+	assert.Contains(t, flaky.received, frame)
+	flaky.Unlock()
+	sends, _ = closed.counts()
+	assert.Equal(t, 1, sends)
+	sends, _ = left.counts()
+	assert.Equal(t, 1, sends)
+	assert.Empty(t, consensus.retries)
+	assert.Equal(t, 0, len(failures))
+}
+
+func TestSendRetryCallerTime(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	configs[0].Scheduler = scheduler
+	configs[0].SendRetry = &SendRetry{MaxAttempts: 3, Backoff: 100 * time.Millisecond}
+	consensus, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+
+	// retries are due by the time fed to consensus, not by the scheduler
+	flaky := &flakyPeer{addr: "flaky", failures: 1}
+	consensus.Join(flaky)
+	now := scheduler.Now().Add(time.Hour)
+	consensus.broadcast(&Message{Type: MessageType_RoundChange, Height: 1, State: State("state")}, now)
+	assert.Equal(t, 1, len(consensus.retries))
+	assert.Equal(t, now.Add(100*time.Millisecond), consensus.retries[0].due)
+
+	consensus.processRetries(scheduler.Now().Add(time.Second))
+	sends, _ := flaky.counts()
+	assert.Equal(t, 1, sends)
+	consensus.processRetries(now.Add(100 * time.Millisecond))
+	sends, received := flaky.counts()
+	assert.Equal(t, 2, sends)
+	assert.Equal(t, 1, received)
+}
+
+func TestSendRetryBackoff(t *testing.T) {
+	retry := &SendRetry{MaxAttempts: 10, Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempts, expected := range []time.Duration{0, 1, 2, 4, 5, 5} {
+		if attempts > 0 {
+			assert.Equal(t, expected*time.Second, retry.backoff(attempts))
+		}
+	}
+
+	config := createIPCNetworkConfigs(t, 4)[0]
+	config.SendRetry = &SendRetry{}
+	assert.Equal(t, ErrConfigSendRetry, VerifyConfig(config))
+	config.SendRetry = &SendRetry{MaxAttempts: 1, Backoff: -1}
+	assert.Equal(t, ErrConfigSendRetry, VerifyConfig(config))
+	config.SendRetry = &SendRetry{MaxAttempts: 1}
+	assert.Nil(t, VerifyConfig(config))
+}
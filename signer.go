@@ -0,0 +1,85 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"math/big"
+)
+
+// SizeSignature defines byte size of a signature returned by Signer, the
+// 32-byte big-endian R followed by the 32-byte big-endian S.
+const SizeSignature = 2 * SizeAxis
+
+// Signer signs consensus messages on behalf of a participant, the private
+// key can be kept in an external KMS or HSM instead of Config.PrivateKey.
+type Signer interface {
+	// Sign signs the digest of a message, and returns the signature in
+	// SizeSignature bytes as R || S
+	Sign(digest []byte) ([]byte, error)
+	// Public returns the public key of the signer
+	Public() *ecdsa.PublicKey
+}
+
+// privateKeySigner is the default in-memory signer of Config.PrivateKey
+type privateKeySigner struct {
+	privateKey *ecdsa.PrivateKey
+}
+
+func (s privateKeySigner) Public() *ecdsa.PublicKey { return &s.privateKey.PublicKey }
+
+func (s privateKeySigner) Sign(digest []byte) ([]byte, error) {
+	r, ss, err := ecdsa.Sign(rand.Reader, s.privateKey, digest)
+	if err != nil {
+		return nil, err
+	}
+	return encodeSignature(r, ss), nil
+}
+
+// encodeSignature encodes r, s to R || S with each axis left padded
+func encodeSignature(r, s *big.Int) []byte {
+	sig := make([]byte, SizeSignature)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(sig[SizeAxis-len(rb):SizeAxis], rb)
+	copy(sig[SizeSignature-len(sb):], sb)
+	return sig
+}
+
+// decodeSignature decodes R || S to r, s
+func decodeSignature(sig []byte) (r *big.Int, s *big.Int, err error) {
+	if len(sig) != SizeSignature {
+		return nil, nil, ErrSignatureSize
+	}
+	r = new(big.Int).SetBytes(sig[:SizeAxis])
+	s = new(big.Int).SetBytes(sig[SizeAxis:])
+	return r, s, nil
+}
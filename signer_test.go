@@ -0,0 +1,133 @@
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Sperax/bdls/timer"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockSigner signs with a private key hidden from consensus, as a KMS does
+type mockSigner struct {
+	privateKey *ecdsa.PrivateKey
+	numSigned  int64
+	err        error
+	sig        []byte
+}
+
+func (s *mockSigner) Public() *ecdsa.PublicKey { return &s.privateKey.PublicKey }
+
+func (s *mockSigner) Sign(digest []byte) ([]byte, error) {
+	atomic.AddInt64(&s.numSigned, 1)
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.sig != nil {
+		return s.sig, nil
+	}
+	r, ss, err := ecdsa.Sign(rand.Reader, s.privateKey, digest)
+	if err != nil {
+		return nil, err
+	}
+	return encodeSignature(r, ss), nil
+}
+
+func TestSignWith(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+	signer := &mockSigner{privateKey: privateKey}
+
+	m := new(Message)
+	m.Type = MessageType_RoundChange
+	m.Height = 1
+	m.State = State("data")
+
+	sp := new(SignedProto)
+	assert.Nil(t, sp.SignWith(m, signer))
+	assert.True(t, sp.Verify(S256Curve))
+	assert.Equal(t, DefaultPubKeyToIdentity(&privateKey.PublicKey), DefaultPubKeyToIdentity(sp.PublicKey(S256Curve)))
+
+	// signatures must be R || S
+	signer.sig = make([]byte, SizeSignature-1)
+	assert.Equal(t, ErrSignatureSize, new(SignedProto).SignWith(m, signer))
+
+	errKMS := errors.New("kms unavailable")
+	signer.err = errKMS
+	assert.Equal(t, errKMS, new(SignedProto).SignWith(m, signer))
+}
+
+func TestConfigSigner(t *testing.T) {
+	config := createIPCNetworkConfigs(t, 4)[0]
+	signer := &mockSigner{privateKey: config.PrivateKey}
+	config.Signer = signer
+	assert.Equal(t, ErrConfigSigner, VerifyConfig(config))
+
+	config.PrivateKey = nil
+	assert.Nil(t, VerifyConfig(config))
+	consensus, err := NewConsensus(config)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultPubKeyToIdentity(signer.Public()), consensus.identity)
+	assert.Nil(t, consensus.privateKey)
+}
+
+func TestSignerConsensus(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	var signers []*mockSigner
+	for _, config := range configs {
+		signer := &mockSigner{privateKey: config.PrivateKey}
+		signers = append(signers, signer)
+		config.Signer = signer
+		config.PrivateKey = nil
+		config.Scheduler = scheduler
+	}
+
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond)
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	for _, p := range network.Peers() {
+		p.Propose(State("data"))
+	}
+	for i := 0; i < 6000; i++ {
+		decided := true
+		for _, p := range network.Peers() {
+			if height, _, _ := p.GetLatestState(); height < 1 {
+				decided = false
+			}
+		}
+		if decided {
+			break
+		}
+		scheduler.Advance(10 * time.Millisecond)
+	}
+
+	for k, p := range network.Peers() {
+		height, _, state := p.GetLatestState()
+		assert.Equal(t, uint64(1), height)
+		assert.Equal(t, State("data"), state)
+		assert.True(t, atomic.LoadInt64(&signers[k].numSigned) > 0)
+	}
+}
+
+func TestSignerFailure(t *testing.T) {
+	consensus := createConsensus(t, 0, 0, randomPublicKeys(t, ConfigMinimumParticipants))
+	signer := &mockSigner{privateKey: consensus.privateKey, err: errors.New("kms unavailable")}
+	consensus.signer = signer
+	assert.Nil(t, consensus.TryPropose(State("data")))
+
+	// messages are not sent if signing failed
+	consensus.loopback = nil
+	consensus.broadcastRoundChange(time.Now())
+	assert.Equal(t, int64(1), signer.numSigned)
+	assert.Nil(t, consensus.loopback)
+
+	signer.err = nil
+	consensus.broadcastRoundChange(time.Now())
+	assert.Equal(t, 1, len(consensus.loopback))
+}
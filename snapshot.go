@@ -0,0 +1,332 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sort"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+)
+
+const (
+	// StateSnapshotVersion is the version of snapshots by MarshalState
+	StateSnapshotVersion = 1
+)
+
+// stateSnapshot is the serialization of in-round states of consensus,
+// messages are kept in their original encoding along with signatures.
+type stateSnapshot struct {
+	Version  int
+	Identity Identity
+
+	LatestState  State
+	LatestHeight uint64
+	LatestRound  uint64
+	LatestProof  []byte
+
+	Unconfirmed  []State
+	Rounds       []roundSnapshot // in the order of c.rounds
+	CurrentRound uint64
+
+	RcTimeout          time.Time
+	LockTimeout        time.Time
+	CommitTimeout      time.Time
+	LockReleaseTimeout time.Time
+	RoundStartTime     time.Time
+	HeightStartTime    time.Time
+
+	Locks                [][]byte
+	LeaderLocks          []leaderLockSnapshot // sorted by round
+	LastRoundChangeProof [][]byte
+	Loopback             [][]byte
+
+	Participants        []Identity
+	PendingParticipants []Identity
+}
+
+// roundSnapshot is the serialization of a consensusRound
+type roundSnapshot struct {
+	Stage            consensusStage
+	RoundNumber      uint64
+	LockedState      State
+	LockedStateHash  StateHash
+	RoundChangeSent  bool
+	CommitSent       bool
+	RoundChanges     [][]byte
+	Commits          [][]byte
+	MaxProposedState State
+	MaxProposedCount int
+}
+
+// leaderLockSnapshot is the first <lock> message from the leader of a round
+type leaderLockSnapshot struct {
+	Round uint64
+	Lock  []byte
+}
+
+// MarshalState serializes the in-round states of consensus, including the
+// height, round, stage, timeouts, buffered messages and collected proofs,
+// for a hot standby to resume from via UnmarshalState. The private key is
+// not serialized, nor are proposals awaiting results and caches.
+func (c *Consensus) MarshalState() ([]byte, error) {
+	if c.closed {
+		return nil, ErrConsensusClosed
+	}
+
+	var err error
+	s := new(stateSnapshot)
+	s.Version = StateSnapshotVersion
+	s.Identity = c.identity
+	s.LatestState = c.latestState
+	s.LatestHeight = c.latestHeight
+	s.LatestRound = c.latestRound
+	if c.latestProof != nil {
+		if s.LatestProof, err = proto.Marshal(c.latestProof); err != nil {
+			return nil, err
+		}
+	}
+
+	s.Unconfirmed = c.unconfirmed
+	for elem := c.rounds.Front(); elem != nil; elem = elem.Next() {
+		r := elem.Value.(*consensusRound)
+		rs := roundSnapshot{
+			Stage:            r.Stage,
+			RoundNumber:      r.RoundNumber,
+			LockedState:      r.LockedState,
+			LockedStateHash:  r.LockedStateHash,
+			RoundChangeSent:  r.RoundChangeSent,
+			CommitSent:       r.CommitSent,
+			MaxProposedState: r.MaxProposedState,
+			MaxProposedCount: r.MaxProposedCount,
+		}
+		if rs.RoundChanges, err = marshalTuples(r.roundChanges); err != nil {
+			return nil, err
+		}
+		if rs.Commits, err = marshalTuples(r.commits); err != nil {
+			return nil, err
+		}
+		s.Rounds = append(s.Rounds, rs)
+	}
+	if c.currentRound != nil {
+		s.CurrentRound = c.currentRound.RoundNumber
+	}
+
+	s.RcTimeout = c.rcTimeout
+	s.LockTimeout = c.lockTimeout
+	s.CommitTimeout = c.commitTimeout
+	s.LockReleaseTimeout = c.lockReleaseTimeout
+	s.RoundStartTime = c.roundStartTime
+	s.HeightStartTime = c.heightStartTime
+
+	if s.Locks, err = marshalTuples(c.locks); err != nil {
+		return nil, err
+	}
+	for round, tuple := range c.leaderLocks {
+		bts, err := proto.Marshal(tuple.Signed)
+		if err != nil {
+			return nil, err
+		}
+		s.LeaderLocks = append(s.LeaderLocks, leaderLockSnapshot{Round: round, Lock: bts})
+	}
+	sort.Slice(s.LeaderLocks, func(i, j int) bool { return s.LeaderLocks[i].Round < s.LeaderLocks[j].Round })
+
+	for _, sp := range c.lastRoundChangeProof {
+		bts, err := proto.Marshal(sp)
+		if err != nil {
+			return nil, err
+		}
+		s.LastRoundChangeProof = append(s.LastRoundChangeProof, bts)
+	}
+	s.Loopback = c.loopback
+	s.Participants = c.participants
+	s.PendingParticipants = c.pendingParticipants
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalState restores the in-round states serialized by MarshalState,
+// the snapshot must be taken from a consensus with the same identity, and
+// the messages enclosed are trusted without verifying signatures again.
+//
+// NOTE: the standby must not run along with the primary, or it may sign
+// conflicting messages with the same private key.
+func (c *Consensus) UnmarshalState(bts []byte) error {
+	defer c.hooks.flush()
+	if c.closed {
+		return ErrConsensusClosed
+	}
+
+	s := new(stateSnapshot)
+	if err := gob.NewDecoder(bytes.NewReader(bts)).Decode(s); err != nil {
+		return err
+	}
+
+	if s.Version != StateSnapshotVersion {
+		return ErrStateSnapshotVersion
+	}
+
+	if s.Identity != c.identity {
+		return ErrStateSnapshotIdentity
+	}
+
+	// decode all messages before changing states
+	var latestProof *SignedProto
+	if s.LatestProof != nil {
+		var err error
+		if latestProof, err = DecodeSignedMessage(s.LatestProof); err != nil {
+			return err
+		}
+	}
+
+	var rounds []*consensusRound
+	var currentRound *consensusRound
+	for _, rs := range s.Rounds {
+		r := newConsensusRound(rs.RoundNumber, c)
+		r.Stage = rs.Stage
+		r.LockedState = rs.LockedState
+		r.LockedStateHash = rs.LockedStateHash
+		r.RoundChangeSent = rs.RoundChangeSent
+		r.CommitSent = rs.CommitSent
+		r.MaxProposedState = rs.MaxProposedState
+		r.MaxProposedCount = rs.MaxProposedCount
+
+		var err error
+		if r.roundChanges, err = c.unmarshalTuples(rs.RoundChanges); err != nil {
+			return err
+		}
+		if r.commits, err = c.unmarshalTuples(rs.Commits); err != nil {
+			return err
+		}
+		rounds = append(rounds, r)
+		if r.RoundNumber == s.CurrentRound {
+			currentRound = r
+		}
+	}
+	if currentRound == nil {
+		return ErrStateSnapshotCurrentRound
+	}
+
+	locks, err := c.unmarshalTuples(s.Locks)
+	if err != nil {
+		return err
+	}
+
+	var leaderLocks map[uint64]messageTuple
+	for _, ll := range s.LeaderLocks {
+		tuples, err := c.unmarshalTuples([][]byte{ll.Lock})
+		if err != nil {
+			return err
+		}
+		if leaderLocks == nil {
+			leaderLocks = make(map[uint64]messageTuple)
+		}
+		leaderLocks[ll.Round] = tuples[0]
+	}
+
+	var lastRoundChangeProof []*SignedProto
+	for _, bts := range s.LastRoundChangeProof {
+		sp, err := DecodeSignedMessage(bts)
+		if err != nil {
+			return err
+		}
+		lastRoundChangeProof = append(lastRoundChangeProof, sp)
+	}
+
+	// apply
+	c.latestState = s.LatestState
+	c.latestHeight = s.LatestHeight
+	c.latestRound = s.LatestRound
+	c.latestProof = latestProof
+	c.unconfirmed = s.Unconfirmed
+	c.rounds.Init()
+	for _, r := range rounds {
+		c.rounds.PushBack(r)
+	}
+	c.currentRound = currentRound
+	c.rcTimeout = s.RcTimeout
+	c.lockTimeout = s.LockTimeout
+	c.commitTimeout = s.CommitTimeout
+	c.lockReleaseTimeout = s.LockReleaseTimeout
+	c.roundStartTime = s.RoundStartTime
+	c.heightStartTime = s.HeightStartTime
+	c.locks = locks
+	for k := range c.locks {
+		c.locks[k].Proposer = c.lockProposer(c.locks[k].Message)
+	}
+	c.leaderLocks = leaderLocks
+	c.lastRoundChangeProof = lastRoundChangeProof
+	c.loopback = s.Loopback
+	c.participants = s.Participants
+	c.pendingParticipants = s.PendingParticipants
+	c.numIdentities = countIdentities(c.participants)
+	c.totalWeight = c.sumWeights(c.participants)
+	return nil
+}
+
+// marshalTuples serializes the signed messages of tuples
+func marshalTuples(tuples []messageTuple) ([][]byte, error) {
+	var out [][]byte
+	for k := range tuples {
+		bts, err := proto.Marshal(tuples[k].Signed)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, bts)
+	}
+	return out, nil
+}
+
+// unmarshalTuples rebuilds tuples from signed messages
+func (c *Consensus) unmarshalTuples(msgs [][]byte) ([]messageTuple, error) {
+	var tuples []messageTuple
+	for _, bts := range msgs {
+		sp, err := DecodeSignedMessage(bts)
+		if err != nil {
+			return nil, err
+		}
+		m, err := DecodeMessage(sp.Message)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.decodeState(m); err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, messageTuple{StateHash: c.stateHash(m.State), Message: m, Signed: sp, Weight: c.signerWeight(sp)})
+	}
+	return tuples, nil
+}
@@ -0,0 +1,127 @@
+package bdls
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Sperax/bdls/timer"
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalStateRoundTrip(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	consensus, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+
+	// collect some <roundchange> messages at round 1
+	for _, config := range configs[1:] {
+		_, signed, _ := createRoundChangeMessageSigner(t, 1, 1, State("state"), config.PrivateKey)
+		bts, err := proto.Marshal(signed)
+		assert.Nil(t, err)
+		assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	}
+	consensus.Propose(State("unconfirmed"))
+
+	snapshot, err := consensus.MarshalState()
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(snapshot, configs[0].PrivateKey.D.Bytes()))
+
+	standby, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+	assert.Nil(t, standby.UnmarshalState(snapshot))
+
+	assert.Equal(t, consensus.rounds.Len(), standby.rounds.Len())
+	assert.Equal(t, consensus.currentRound.RoundNumber, standby.currentRound.RoundNumber)
+	assert.Equal(t, consensus.currentRound.Stage, standby.currentRound.Stage)
+	assert.Equal(t, consensus.currentRound.NumRoundChanges(), standby.currentRound.NumRoundChanges())
+	assert.Equal(t, consensus.unconfirmed, standby.unconfirmed)
+	assert.True(t, consensus.lockTimeout.Equal(standby.lockTimeout))
+
+	// marshalling is deterministic
+	restored, err := standby.MarshalState()
+	assert.Nil(t, err)
+	assert.Equal(t, snapshot, restored)
+}
+
+func TestUnmarshalStateInvalid(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	consensus, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+	snapshot, err := consensus.MarshalState()
+	assert.Nil(t, err)
+
+	// another identity
+	other, err := NewConsensus(configs[1])
+	assert.Nil(t, err)
+	assert.Equal(t, ErrStateSnapshotIdentity, other.UnmarshalState(snapshot))
+
+	// corrupted snapshot
+	assert.NotNil(t, consensus.UnmarshalState(snapshot[:len(snapshot)/2]))
+}
+
+// the primary is replaced by a standby restored from it's snapshot in the middle
+// of a round, and the standby resumes to decide.
+func TestMarshalStateFailover(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	for _, config := range configs {
+		config.Scheduler = scheduler
+	}
+
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond)
+	assert.Nil(t, err)
+	defer network.StopAll()
+
+	for _, p := range network.Peers() {
+		data := make([]byte, 1024)
+		_, err := io.ReadFull(rand.Reader, data)
+		assert.Nil(t, err)
+		p.Propose(data)
+	}
+
+	// run until the primary has collected <roundchange> messages, and
+	// entered lock stage
+	primary := network.Peers()[0]
+	midRound := func() bool {
+		primary.Lock()
+		defer primary.Unlock()
+		height, _, _ := primary.c.CurrentState()
+		return height == 0 && primary.c.currentRound.Stage >= stageLock
+	}
+	for i := 0; i < 60000 && !midRound(); i++ {
+		scheduler.Advance(10 * time.Millisecond)
+	}
+	assert.True(t, midRound())
+
+	// failover to the standby with the snapshot of primary
+	primary.Lock()
+	snapshot, err := primary.c.MarshalState()
+	assert.Nil(t, err)
+	standby, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+	standby.SetLatency(100 * time.Millisecond)
+	assert.Nil(t, standby.UnmarshalState(snapshot))
+	assert.True(t, standby.currentRound.Stage >= stageLock)
+	for _, p := range network.Peers()[1:] {
+		standby.Join(p)
+	}
+	primary.c = standby
+	primary.Unlock()
+
+	decided := func() bool {
+		for _, p := range network.Peers() {
+			if height, _, _ := p.GetLatestState(); height < 1 {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; i < 60000 && !decided(); i++ {
+		scheduler.Advance(10 * time.Millisecond)
+	}
+	assert.True(t, decided())
+}
@@ -0,0 +1,302 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"reflect"
+	"sync"
+
+	proto "github.com/gogo/protobuf/proto"
+)
+
+const (
+	// DefaultSubscriberBufferSize is the channel buffer size for each subscriber,
+	// events will be dropped if a subscriber's buffer is full.
+	DefaultSubscriberBufferSize = 16
+)
+
+// DecideEvent is delivered to subscribers when a height is decided
+type DecideEvent struct {
+	Height uint64       // the decided height
+	Round  uint64       // the round in which the state is decided
+	State  State        // the decided state
+	Proof  *SignedProto // the <decide> message to prove the state
+}
+
+// EquivocationEvent is delivered to subscribers when the leader of a round
+// has been caught signing two different <lock> messages for the same height
+// and round, the signed messages are the evidence of this violation, and
+// can be verified independently by VerifyEquivocation.
+type EquivocationEvent struct {
+	Height uint64       // the height of conflicting messages
+	Round  uint64       // the round of conflicting messages
+	Leader Identity     // the identity of the leader signed both messages
+	First  *SignedProto // the first <lock> message accepted
+	Second *SignedProto // the conflicting <lock> message
+}
+
+// RejectionEvent is delivered to subscribers when a message fed to
+// ReceiveMessage has been rejected, the fields of the message are filled
+// as long as they can be decoded.
+type RejectionEvent struct {
+	Sender *ecdsa.PublicKey // the signer of the message, nil if unidentifiable
+	Type   MessageType      // the message type, MessageType_Nop if undecodable
+	Height uint64           // the height of the message
+	Round  uint64           // the round of the message
+	Err    error            // the root cause, usually a sentinel error in errors.go
+}
+
+// SendFailureEvent is delivered to subscribers when a message cannot be
+// sent to a peer, after all attempts allowed by Config.SendRetry, or at once
+// if the error is not temporary.
+type SendFailureEvent struct {
+	Peer     PeerInterface // the peer the message was sent to
+	Attempts int           // the number of attempts made
+	Err      error         // the error of the last attempt
+}
+
+// VersionMismatchEvent is delivered to subscribers when a participant has
+// sent VersionMismatchThreshold consecutive messages signed with a protocol
+// version different from Config.ProtocolVersion, it's delivered again only
+// after the participant has sent a message of my version.
+type VersionMismatchEvent struct {
+	Sender        *ecdsa.PublicKey // the participant sending the messages
+	LocalVersion  uint32           // the protocol version of mine
+	RemoteVersion uint32           // the protocol version of the latest message
+	Count         int              // the number of consecutive messages mismatched
+}
+
+// subscriberList is the channels subscribing one type of events, they're
+// kept as reflect values so the list is shared by all types of events.
+type subscriberList []reflect.Value
+
+// add appends the channel ch to the list
+func (l *subscriberList) add(ch interface{}) { *l = append(*l, reflect.ValueOf(ch)) }
+
+// remove removes the channel ch from the list and closes it, channels not
+// in the list are left untouched.
+func (l *subscriberList) remove(ch interface{}) {
+	for k := range *l {
+		if (*l)[k].Interface() == ch {
+			(*l)[k].Close()
+			copy((*l)[k:], (*l)[k+1:])
+			*l = (*l)[:len(*l)-1]
+			return
+		}
+	}
+}
+
+// notify delivers the event pointed by event to all channels without
+// blocking, channels with full buffers miss the event. The event is passed
+// by pointer to avoid copying it to an interface for each delivery.
+func (l subscriberList) notify(event interface{}) {
+	if len(l) == 0 {
+		return
+	}
+	v := reflect.ValueOf(event).Elem()
+	for _, ch := range l {
+		ch.TrySend(v)
+	}
+}
+
+// close closes all channels and empties the list
+func (l *subscriberList) close() {
+	for _, ch := range *l {
+		ch.Close()
+	}
+	*l = nil
+}
+
+// subscribers contains all subscribers of events, the subscribers
+// can be removed from other goroutines, so it's guarded by a mutex.
+type subscribers struct {
+	chans         subscriberList // of DecideEvent
+	equivocations subscriberList // of EquivocationEvent
+	rejections    subscriberList // of RejectionEvent
+	sendFailures  subscriberList // of SendFailureEvent
+	versions      subscriberList // of VersionMismatchEvent
+	closed        bool           // all channels have been closed by Consensus.Close
+	sync.Mutex
+}
+
+// subscribe adds the channel ch to the list, and returns the function to
+// unsubscribe, ch is closed at once if the channels have been closed.
+func (s *subscribers) subscribe(l *subscriberList, ch interface{}) func() {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		reflect.ValueOf(ch).Close()
+		return func() {}
+	}
+	l.add(ch)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.Lock()
+			defer s.Unlock()
+			// the channel has been closed if consensus has closed
+			l.remove(ch)
+		})
+	}
+}
+
+// notify delivers the event pointed by event to the list without blocking
+func (s *subscribers) notify(l *subscriberList, event interface{}) {
+	s.Lock()
+	defer s.Unlock()
+	l.notify(event)
+}
+
+// close closes the channels of all subscribers, and channels subscribed
+// later will be returned closed.
+func (s *subscribers) close() {
+	s.Lock()
+	defer s.Unlock()
+	s.chans.close()
+	s.equivocations.close()
+	s.rejections.close()
+	s.sendFailures.close()
+	s.versions.close()
+	s.closed = true
+}
+
+// isClosed returns whether the channels have been closed by Consensus.Close
+func (s *subscribers) isClosed() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.closed
+}
+
+// Subscribe returns a channel to receive DecideEvent for each height decided,
+// along with a function to unsubscribe and close the channel.
+//
+// Delivery is non-blocking, a slow subscriber will miss events when
+// it's buffer is full, rather than stall the consensus.
+//
+// The state is committed before the event is delivered, so by the time a
+// DecideEvent for height H is received, CurrentState returns height H, or
+// a higher height if more heights have been decided since.
+func (c *Consensus) Subscribe() (<-chan DecideEvent, func()) {
+	ch := make(chan DecideEvent, DefaultSubscriberBufferSize)
+	return ch, c.subscribers.subscribe(&c.subscribers.chans, ch)
+}
+
+// notifyDecide delivers a DecideEvent to all subscribers without blocking
+func (c *Consensus) notifyDecide(event DecideEvent) {
+	c.recordEvent(Event{Type: EventDecide, Height: event.Height, Round: event.Round, Decide: &event})
+	c.subscribers.notify(&c.subscribers.chans, &event)
+}
+
+// SubscribeEquivocation returns a channel to receive EquivocationEvent for
+// each conflicting <lock> message detected, along with a function to
+// unsubscribe and close the channel, delivery is non-blocking as Subscribe.
+func (c *Consensus) SubscribeEquivocation() (<-chan EquivocationEvent, func()) {
+	ch := make(chan EquivocationEvent, DefaultSubscriberBufferSize)
+	return ch, c.subscribers.subscribe(&c.subscribers.equivocations, ch)
+}
+
+// notifyEquivocation delivers an EquivocationEvent to all subscribers without blocking
+func (c *Consensus) notifyEquivocation(event EquivocationEvent) {
+	c.recordEvent(Event{Type: EventEquivocation, Height: event.Height, Round: event.Round, Equivocation: &event})
+	c.subscribers.notify(&c.subscribers.equivocations, &event)
+}
+
+// SubscribeRejection returns a channel to receive RejectionEvent for each
+// message rejected by ReceiveMessage, along with a function to unsubscribe
+// and close the channel, delivery is non-blocking as Subscribe.
+func (c *Consensus) SubscribeRejection() (<-chan RejectionEvent, func()) {
+	ch := make(chan RejectionEvent, DefaultSubscriberBufferSize)
+	return ch, c.subscribers.subscribe(&c.subscribers.rejections, ch)
+}
+
+// notifyRejection delivers a RejectionEvent of the rejected message bts to all
+// subscribers without blocking, the message is decoded only if subscribed, or
+// the event history is enabled.
+func (c *Consensus) notifyRejection(bts []byte, err error) {
+	c.subscribers.Lock()
+	defer c.subscribers.Unlock()
+	if len(c.subscribers.rejections) == 0 && c.events == nil {
+		return
+	}
+
+	// unwrap to the root cause
+	event := RejectionEvent{Err: err}
+	for cause := errors.Unwrap(err); cause != nil; cause = errors.Unwrap(cause) {
+		event.Err = cause
+	}
+
+	// best-effort decoding of the message
+	signed := new(SignedProto)
+	if bts != nil && proto.Unmarshal(bts, signed) == nil {
+		if pubkey := signed.PublicKey(c.curve); c.curve.IsOnCurve(pubkey.X, pubkey.Y) {
+			event.Sender = pubkey
+		}
+		if m, err := DecodeMessage(signed.Message); err == nil {
+			event.Type = m.Type
+			event.Height = m.Height
+			event.Round = m.Round
+		}
+	}
+	c.recordEvent(Event{Type: EventRejection, Height: event.Height, Round: event.Round, Rejection: &event})
+
+	c.subscribers.rejections.notify(&event)
+}
+
+// SubscribeSendFailure returns a channel to receive SendFailureEvent for each
+// message failed to send to a peer, along with a function to unsubscribe and
+// close the channel, delivery is non-blocking as Subscribe.
+func (c *Consensus) SubscribeSendFailure() (<-chan SendFailureEvent, func()) {
+	ch := make(chan SendFailureEvent, DefaultSubscriberBufferSize)
+	return ch, c.subscribers.subscribe(&c.subscribers.sendFailures, ch)
+}
+
+// notifySendFailure delivers a SendFailureEvent to all subscribers without
+// blocking.
+func (c *Consensus) notifySendFailure(event SendFailureEvent) {
+	c.subscribers.notify(&c.subscribers.sendFailures, &event)
+}
+
+// SubscribeVersionMismatch returns a channel to receive VersionMismatchEvent
+// for each participant detected sending messages of an incompatible protocol
+// version, along with a function to unsubscribe and close the channel,
+// delivery is non-blocking as Subscribe.
+func (c *Consensus) SubscribeVersionMismatch() (<-chan VersionMismatchEvent, func()) {
+	ch := make(chan VersionMismatchEvent, DefaultSubscriberBufferSize)
+	return ch, c.subscribers.subscribe(&c.subscribers.versions, ch)
+}
+
+// notifyVersionMismatch delivers a VersionMismatchEvent to all subscribers without blocking
+func (c *Consensus) notifyVersionMismatch(event VersionMismatchEvent) {
+	c.subscribers.notify(&c.subscribers.versions, &event)
+}
@@ -0,0 +1,226 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Sperax/bdls/timer"
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribeDecide(t *testing.T) {
+	m, sp, privateKey, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+
+	ch1, unsubscribe1 := consensus.Subscribe()
+	ch2, unsubscribe2 := consensus.Subscribe()
+	defer unsubscribe1()
+	defer unsubscribe2()
+
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	err = consensus.ReceiveMessage(bts, time.Now())
+	assert.Nil(t, err)
+
+	for _, ch := range []<-chan DecideEvent{ch1, ch2} {
+		select {
+		case event := <-ch:
+			assert.Equal(t, uint64(10), event.Height)
+			assert.Equal(t, uint64(10), event.Round)
+			assert.Equal(t, State(m.State), event.State)
+			proof, err := proto.Marshal(event.Proof)
+			assert.Nil(t, err)
+			assert.Equal(t, bts, proof)
+		default:
+			t.Fatal("subscriber did not receive decide event")
+		}
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	consensus := createConsensus(t, 0, 0, nil)
+	ch, unsubscribe := consensus.Subscribe()
+	unsubscribe()
+	unsubscribe() // idempotent
+
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(consensus.subscribers.chans))
+}
+
+func TestSubscribeNonBlocking(t *testing.T) {
+	consensus := createConsensus(t, 0, 0, nil)
+	ch, unsubscribe := consensus.Subscribe()
+	defer unsubscribe()
+
+	// nobody reads from ch, notification must not block
+	for i := 0; i < 2*DefaultSubscriberBufferSize; i++ {
+		consensus.notifyDecide(DecideEvent{Height: uint64(i)})
+	}
+	assert.Equal(t, DefaultSubscriberBufferSize, len(ch))
+}
+
+func TestSubscribeRejection(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+	consensus := createConsensus(t, 0, 0, []*ecdsa.PublicKey{&privateKey.PublicKey})
+	ch, unsubscribe := consensus.SubscribeRejection()
+	defer unsubscribe()
+
+	// message with bad signature
+	_, signed, _ := createRoundChangeMessageSigner(t, 1, 2, State("state"), privateKey)
+	signed.R[0] ^= 0xff
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	err = consensus.ReceiveMessage(bts, time.Now())
+	assert.NotNil(t, err)
+
+	select {
+	case event := <-ch:
+		assert.Equal(t, ErrMessageSignature, event.Err)
+		assert.NotNil(t, event.Sender)
+		assert.Equal(t, DefaultPubKeyToIdentity(&privateKey.PublicKey), DefaultPubKeyToIdentity(event.Sender))
+		assert.Equal(t, MessageType_RoundChange, event.Type)
+		assert.Equal(t, uint64(1), event.Height)
+		assert.Equal(t, uint64(2), event.Round)
+	default:
+		t.Fatal("subscriber did not receive rejection event")
+	}
+
+	// undecodable message
+	err = consensus.ReceiveMessage([]byte("garbage"), time.Now())
+	assert.NotNil(t, err)
+	select {
+	case event := <-ch:
+		assert.NotNil(t, event.Err)
+		assert.Nil(t, event.Sender)
+		assert.Equal(t, MessageType_Nop, event.Type)
+	default:
+		t.Fatal("subscriber did not receive rejection event")
+	}
+
+	// nobody reads from ch, notification must not block
+	for i := 0; i < 2*DefaultSubscriberBufferSize; i++ {
+		_ = consensus.ReceiveMessage(bts, time.Now())
+	}
+	assert.Equal(t, DefaultSubscriberBufferSize, len(ch))
+
+	unsubscribe()
+	assert.Equal(t, 0, len(consensus.subscribers.rejections))
+}
+
+func TestDecideEventOrdering(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	scheduler := timer.NewManualScheduler(configs[0].Epoch)
+	var peers []*IPCPeer
+	var violations int64
+	for i, config := range configs {
+		i := i
+		config.Scheduler = scheduler
+		config.OnDecide = func(height uint64, rounds uint64, duration time.Duration) {
+			// hooks run with the peer locked, call into consensus directly
+			if current, _, _ := peers[i].c.CurrentState(); current < height {
+				atomic.AddInt64(&violations, 1)
+			}
+		}
+	}
+
+	network, err := NewIPCNetwork(configs, 100*time.Millisecond)
+	assert.Nil(t, err)
+	defer network.StopAll()
+	peers = network.Peers()
+
+	// subscribers check the current height upon each event
+	const target = 3
+	var wg sync.WaitGroup
+	var unsubscribes []func()
+	for _, p := range peers {
+		p.Lock()
+		ch, unsubscribe := p.c.Subscribe()
+		p.Unlock()
+		unsubscribes = append(unsubscribes, unsubscribe)
+
+		wg.Add(1)
+		go func(p *IPCPeer) {
+			defer wg.Done()
+			for event := range ch {
+				height, _, state := p.GetLatestState()
+				if height < event.Height || (height == event.Height && !bytes.Equal(state, event.State)) {
+					atomic.AddInt64(&violations, 1)
+				}
+			}
+		}(p)
+	}
+
+	for height := uint64(1); height <= target; height++ {
+		data := make([]byte, 1024)
+		_, err := io.ReadFull(rand.Reader, data)
+		assert.Nil(t, err)
+		for _, p := range peers {
+			p.Propose(data)
+		}
+
+		for i := 0; i < 60000; i++ {
+			decided := true
+			for _, p := range peers {
+				if current, _, _ := p.GetLatestState(); current < height {
+					decided = false
+				}
+			}
+			if decided {
+				break
+			}
+			scheduler.Advance(10 * time.Millisecond)
+		}
+	}
+
+	for _, unsubscribe := range unsubscribes {
+		unsubscribe()
+	}
+	wg.Wait()
+
+	for _, p := range peers {
+		height, _, _ := p.GetLatestState()
+		assert.Equal(t, uint64(target), height)
+	}
+	assert.Equal(t, int64(0), atomic.LoadInt64(&violations))
+}
@@ -0,0 +1,261 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// TCPFrameHeaderSize is the size of the big-endian length prefix of a frame
+	// |FrameLength(4bytes)| Message(FrameLength) ... |
+	TCPFrameHeaderSize = 4
+
+	// DefaultTCPMaxFrameSize is the default ceiling of a frame(32MB)
+	DefaultTCPMaxFrameSize = 32 * 1024 * 1024
+
+	// DefaultTCPSendQueueSize is the default maximum number of messages
+	// queued to send, so a slow remote cannot grow memory without limit.
+	DefaultTCPSendQueueSize = 1024
+)
+
+// TCPPeer represents a peer over a stream connection, consensus messages
+// are framed with a 4-byte big-endian length prefix.
+//
+// As Consensus is not thread-safe, the read loop calls Consensus.ReceiveMessage
+// with Consensus.Locker held, the goroutine calling Consensus.Update and
+// other methods MUST hold the same lock.
+type TCPPeer struct {
+	c         *Consensus       // the consensus object to feed messages
+	locker    sync.Locker      // lock to protect consensus object
+	conn      net.Conn         // the underlying connection
+	publicKey *ecdsa.PublicKey // the public key of remote peer(optional)
+
+	maxFrameSize uint32 // maximum frame size allowed to receive or send
+	maxPending   int    // maximum number of messages queued to send
+
+	// outgoing messages and it's notification
+	pending   [][]byte
+	chPending chan struct{}
+
+	// read/write errors
+	chErrors chan error
+
+	die     chan struct{}
+	dieOnce sync.Once
+	sync.Mutex
+}
+
+// NewTCPPeer creates a TCPPeer over the connection and starts it's read and
+// send loop, messages received will be delivered to consensus with
+// Consensus.Locker held.
+func NewTCPPeer(conn net.Conn, c *Consensus) *TCPPeer {
+	p := new(TCPPeer)
+	p.c = c
+	p.locker = c.Locker()
+	p.conn = conn
+	p.maxFrameSize = DefaultTCPMaxFrameSize
+	p.maxPending = DefaultTCPSendQueueSize
+	// frames beyond consensus message size are useless to receive
+	if c.maxMessageSize > 0 && c.maxMessageSize < DefaultTCPMaxFrameSize {
+		p.maxFrameSize = uint32(c.maxMessageSize)
+	}
+	p.chPending = make(chan struct{}, 1)
+	p.chErrors = make(chan error, 1)
+	p.die = make(chan struct{})
+	go p.readLoop()
+	go p.sendLoop()
+	return p
+}
+
+// GetPublicKey implements PeerInterface, returns nil if the public key
+// of the remote peer has not been set.
+func (p *TCPPeer) GetPublicKey() *ecdsa.PublicKey {
+	p.Lock()
+	defer p.Unlock()
+	return p.publicKey
+}
+
+// SetPublicKey sets the known public key of the remote peer, this is
+// required for <commit> unicast to the leader.
+func (p *TCPPeer) SetPublicKey(key *ecdsa.PublicKey) {
+	p.Lock()
+	defer p.Unlock()
+	p.publicKey = key
+}
+
+// SetMaxFrameSize sets the maximum frame size to receive or send
+func (p *TCPPeer) SetMaxFrameSize(size uint32) {
+	p.Lock()
+	defer p.Unlock()
+	p.maxFrameSize = size
+}
+
+// SetSendQueueSize sets the maximum number of messages queued to send,
+// messages beyond are rejected by Send with ErrTCPSendQueueFull.
+func (p *TCPPeer) SetSendQueueSize(size int) {
+	p.Lock()
+	defer p.Unlock()
+	p.maxPending = size
+}
+
+// RemoteAddr implements PeerInterface, returns the remote address of the connection
+func (p *TCPPeer) RemoteAddr() net.Addr { return p.conn.RemoteAddr() }
+
+// Errors returns a channel to receive read/write errors, the first error
+// will terminate this peer.
+func (p *TCPPeer) Errors() <-chan error { return p.chErrors }
+
+// Send implements PeerInterface, the message is queued to be sent by send loop,
+// ErrTCPSendQueueFull is returned if the remote cannot keep up with the queue.
+func (p *TCPPeer) Send(msg []byte) error {
+	p.Lock()
+	defer p.Unlock()
+
+	select {
+	case <-p.die:
+		return ErrTCPPeerClosed
+	default:
+	}
+
+	if uint32(len(msg)) > p.maxFrameSize {
+		return ErrTCPFrameSizeExceeded
+	}
+
+	if len(p.pending) >= p.maxPending {
+		return ErrTCPSendQueueFull
+	}
+
+	p.pending = append(p.pending, msg)
+	select {
+	case p.chPending <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close terminates the connection to this peer, it's safe to call Close
+// multiple times.
+func (p *TCPPeer) Close() {
+	p.dieOnce.Do(func() {
+		close(p.die)
+		p.conn.Close()
+	})
+}
+
+// notifyError reports the error and terminates this peer
+func (p *TCPPeer) notifyError(err error) {
+	select {
+	case <-p.die: // errors caused by Close() are not reported
+	default:
+		select {
+		case p.chErrors <- err:
+		default:
+		}
+	}
+	p.Close()
+}
+
+// readLoop keeps reading frames from connection
+func (p *TCPPeer) readLoop() {
+	header := make([]byte, TCPFrameHeaderSize)
+	for {
+		// io.ReadFull handles partial reads
+		_, err := io.ReadFull(p.conn, header)
+		if err != nil {
+			p.notifyError(err)
+			return
+		}
+
+		p.Lock()
+		maxFrameSize := p.maxFrameSize
+		p.Unlock()
+
+		// check length before allocation
+		length := binary.BigEndian.Uint32(header)
+		if length > maxFrameSize {
+			p.notifyError(ErrTCPFrameSizeExceeded)
+			return
+		}
+
+		bts := make([]byte, length)
+		_, err = io.ReadFull(p.conn, bts)
+		if err != nil {
+			p.notifyError(err)
+			return
+		}
+
+		// NOTE: message errors are not connection errors, the message is
+		// just ignored.
+		p.locker.Lock()
+		_ = p.c.ReceiveMessage(bts, time.Now())
+		p.locker.Unlock()
+	}
+}
+
+// sendLoop keeps sending queued messages to connection
+func (p *TCPPeer) sendLoop() {
+	var pending [][]byte
+	header := make([]byte, TCPFrameHeaderSize)
+	for {
+		select {
+		case <-p.chPending:
+			p.Lock()
+			pending = p.pending
+			p.pending = nil
+			p.Unlock()
+
+			for _, bts := range pending {
+				binary.BigEndian.PutUint32(header, uint32(len(bts)))
+				// write length
+				_, err := p.conn.Write(header)
+				if err != nil {
+					p.notifyError(err)
+					return
+				}
+
+				// write message
+				_, err = p.conn.Write(bts)
+				if err != nil {
+					p.notifyError(err)
+					return
+				}
+			}
+		case <-p.die:
+			return
+		}
+	}
+}
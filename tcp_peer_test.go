@@ -0,0 +1,106 @@
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTCPPeerDelivery(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+
+	consensus := createConsensus(t, 0, 0, []*ecdsa.PublicKey{&privateKey.PublicKey})
+
+	c1, c2 := net.Pipe()
+	p1 := NewTCPPeer(c1, consensus)
+	p2 := NewTCPPeer(c2, createConsensus(t, 0, 0, nil))
+	defer p1.Close()
+	defer p2.Close()
+
+	state := make([]byte, 1024)
+	_, err = io.ReadFull(rand.Reader, state)
+	assert.Nil(t, err)
+	_, signed, _ := createRoundChangeMessageSigner(t, 1, 0, state, privateKey)
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+
+	// send from the other end
+	assert.Nil(t, p2.Send(bts))
+
+	assert.Eventually(t, func() bool {
+		consensus.Locker().Lock()
+		defer consensus.Locker().Unlock()
+		return consensus.HasProposed(state)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTCPPeerFrameSizeExceeded(t *testing.T) {
+	c1, c2 := net.Pipe()
+	p := NewTCPPeer(c1, createConsensus(t, 0, 0, nil))
+	defer p.Close()
+	p.SetMaxFrameSize(1024)
+
+	// oversized message is rejected while sending
+	assert.Equal(t, ErrTCPFrameSizeExceeded, p.Send(make([]byte, 1025)))
+
+	// oversized frame header is rejected before allocation
+	header := make([]byte, TCPFrameHeaderSize)
+	binary.BigEndian.PutUint32(header, 1025)
+	go c2.Write(header)
+
+	select {
+	case err := <-p.Errors():
+		assert.Equal(t, ErrTCPFrameSizeExceeded, err)
+	case <-time.After(time.Second):
+		t.Fatal("frame size exceeded not reported")
+	}
+}
+
+func TestTCPPeerRemoteClosed(t *testing.T) {
+	c1, c2 := net.Pipe()
+	p := NewTCPPeer(c1, createConsensus(t, 0, 0, nil))
+
+	// a partial frame then close
+	go func() {
+		c2.Write([]byte{0, 0})
+		c2.Close()
+	}()
+
+	select {
+	case err := <-p.Errors():
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	case <-time.After(time.Second):
+		t.Fatal("remote close not reported")
+	}
+
+	// the peer has stopped
+	assert.Equal(t, ErrTCPPeerClosed, p.Send([]byte{0}))
+	p.Close()
+}
+
+func TestTCPPeerSendQueueFull(t *testing.T) {
+	c1, c2 := net.Pipe()
+	p := NewTCPPeer(c1, createConsensus(t, 0, 0, nil))
+	defer p.Close()
+	p.SetSendQueueSize(2)
+
+	// nothing is read from the remote, the send loop blocks on writing
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = p.Send([]byte{byte(i)})
+	}
+	assert.Equal(t, ErrTCPSendQueueFull, err)
+
+	// accepted again once the remote catches up
+	go io.Copy(ioutil.Discard, c2)
+	assert.Eventually(t, func() bool { return p.Send([]byte{0}) == nil }, time.Second, 10*time.Millisecond)
+}
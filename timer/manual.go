@@ -0,0 +1,96 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package timer
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// ManualScheduler is a Scheduler with virtual clock, functions are executed
+// only when the clock is advanced by Advance(), it's for deterministic tests
+// and simulations running faster than wall clock.
+type ManualScheduler struct {
+	now   time.Time
+	tasks timedFuncHeap
+	seq   uint64 // sequence to keep FIFO order for identical deadlines
+	mu    sync.Mutex
+}
+
+// NewManualScheduler creates a ManualScheduler with the clock set to 'now'
+func NewManualScheduler(now time.Time) *ManualScheduler {
+	return &ManualScheduler{now: now}
+}
+
+// Put a function 'f' awaiting to be executed at 'deadline', functions are
+// never executed in Put, even if the deadline has passed.
+func (ms *ManualScheduler) Put(f func(), deadline time.Time) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.seq++
+	heap.Push(&ms.tasks, timedFunc{execute: f, ts: deadline, seq: ms.seq})
+}
+
+// Now returns the virtual clock
+func (ms *ManualScheduler) Now() time.Time {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.now
+}
+
+// Advance moves the clock forward by d, and executes all functions with
+// deadlines not later than the new clock in deadline order, the clock is
+// set to the deadline of each function while it's being executed, functions
+// put during execution are executed in the same Advance if they're due.
+func (ms *ManualScheduler) Advance(d time.Duration) {
+	ms.mu.Lock()
+	target := ms.now.Add(d)
+	for ms.tasks.Len() > 0 && !ms.tasks[0].ts.After(target) {
+		task := heap.Pop(&ms.tasks).(timedFunc)
+		if task.ts.After(ms.now) {
+			ms.now = task.ts
+		}
+		// execute without lock, to allow Put and Now in task
+		ms.mu.Unlock()
+		task.execute()
+		ms.mu.Lock()
+	}
+	ms.now = target
+	ms.mu.Unlock()
+}
+
+// Len returns the number of functions awaiting to be executed
+func (ms *ManualScheduler) Len() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.tasks.Len()
+}
@@ -0,0 +1,38 @@
+package timer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManualScheduler(t *testing.T) {
+	epoch := time.Now()
+	ms := NewManualScheduler(epoch)
+
+	var executed []int
+	var clocks []time.Time
+	ms.Put(func() { executed = append(executed, 2); clocks = append(clocks, ms.Now()) }, epoch.Add(2*time.Second))
+	ms.Put(func() { executed = append(executed, 1); clocks = append(clocks, ms.Now()) }, epoch.Add(time.Second))
+	ms.Put(func() { executed = append(executed, 3) }, epoch.Add(2*time.Second))
+	// functions are not executed in Put, even if overdue
+	ms.Put(func() { executed = append(executed, 0) }, epoch.Add(-time.Second))
+	assert.Equal(t, 0, len(executed))
+
+	ms.Advance(1500 * time.Millisecond)
+	assert.Equal(t, []int{0, 1}, executed)
+	assert.Equal(t, epoch.Add(1500*time.Millisecond), ms.Now())
+
+	// functions put in execution are executed if due
+	ms.Put(func() {
+		ms.Put(func() { executed = append(executed, 5) }, ms.Now().Add(time.Second))
+		executed = append(executed, 4)
+	}, epoch.Add(2*time.Second))
+
+	ms.Advance(1500 * time.Millisecond)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, executed)
+	assert.Equal(t, []time.Time{epoch.Add(time.Second), epoch.Add(2 * time.Second)}, clocks)
+	assert.Equal(t, epoch.Add(3*time.Second), ms.Now())
+	assert.Equal(t, 0, ms.Len())
+}
@@ -40,18 +40,34 @@ import (
 // SystemTimedSched is the library level timed-scheduler
 var SystemTimedSched *TimedSched = NewTimedSched(runtime.NumCPU())
 
+// Scheduler executes functions at their deadlines, the clock of the
+// scheduler is reported by Now().
+type Scheduler interface {
+	// Put a function 'f' awaiting to be executed at 'deadline'
+	Put(f func(), deadline time.Time)
+	// Now returns current time of the scheduler
+	Now() time.Time
+}
+
 type timedFunc struct {
 	execute func()
 	ts      time.Time
+	seq     uint64 // insertion order for identical ts, used by ManualScheduler
 }
 
 // a heap for sorted timed function
 type timedFuncHeap []timedFunc
 
 func (h timedFuncHeap) Len() int            { return len(h) }
-func (h timedFuncHeap) Less(i, j int) bool  { return h[i].ts.Before(h[j].ts) }
 func (h timedFuncHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
 func (h *timedFuncHeap) Push(x interface{}) { *h = append(*h, x.(timedFunc)) }
+func (h timedFuncHeap) Less(i, j int) bool {
+	if h[i].ts.Equal(h[j].ts) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].ts.Before(h[j].ts)
+}
+
 func (h *timedFuncHeap) Pop() interface{} {
 	old := *h
 	n := len(old)
@@ -163,7 +179,7 @@ func (ts *TimedSched) prepend() {
 // Put a function 'f' awaiting to be executed at 'deadline'
 func (ts *TimedSched) Put(f func(), deadline time.Time) {
 	ts.prependLock.Lock()
-	ts.prependTasks = append(ts.prependTasks, timedFunc{f, deadline})
+	ts.prependTasks = append(ts.prependTasks, timedFunc{execute: f, ts: deadline})
 	ts.prependLock.Unlock()
 
 	select {
@@ -172,5 +188,8 @@ func (ts *TimedSched) Put(f func(), deadline time.Time) {
 	}
 }
 
+// Now returns current system time
+func (ts *TimedSched) Now() time.Time { return time.Now() }
+
 // Close terminates this scheduler
 func (ts *TimedSched) Close() { ts.dieOnce.Do(func() { close(ts.die) }) }
@@ -0,0 +1,98 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+// names of spans started by consensus
+const (
+	// SpanReceiveMessage covers processing of a message, from the received or
+	// loopback messages, attributes: type, height, round, error
+	SpanReceiveMessage = "bdls.ReceiveMessage"
+	// SpanVerifyMessage covers decoding and signature verification of a message
+	SpanVerifyMessage = "bdls.VerifyMessage"
+	// SpanVerifyProofs covers verification of the proofs in <lock>, <select>
+	// and <decide> messages, attributes: proofs
+	SpanVerifyProofs = "bdls.VerifyProofs"
+	// SpanDecide covers the state transition to a decided height,
+	// attributes: height, round
+	SpanDecide = "bdls.Decide"
+)
+
+// spanMessagePrefix is the prefix of spans covering the handling of each
+// type of message after signature verification, such as "bdls.<lock>"
+const spanMessagePrefix = "bdls."
+
+// Span is a timed operation started by Tracer, users can adapt tracing
+// systems(OpenTelemetry, etc.) to this interface.
+type Span interface {
+	// SetAttribute annotates the span with a key-value pair
+	SetAttribute(key string, value interface{})
+	// End marks the end of the operation
+	End()
+}
+
+// Tracer starts spans around the phases of consensus, spans of the same
+// message are started and ended in nesting order within one goroutine.
+type Tracer interface {
+	StartSpan(name string) Span
+}
+
+// nopSpan does nothing, it's returned when Config.Tracer is nil
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(key string, value interface{}) {}
+func (nopSpan) End()                                       {}
+
+// startSpan starts a span with the tracer, attributes should only be set
+// when c.tracer is not nil to avoid boxing them into interfaces.
+func (c *Consensus) startSpan(name string) Span {
+	if c.tracer == nil {
+		return nopSpan{}
+	}
+	return c.tracer.StartSpan(name)
+}
+
+// startProofsSpan starts a span for verifying proofs
+func (c *Consensus) startProofsSpan(proofs []*SignedProto) Span {
+	if c.tracer == nil {
+		return nopSpan{}
+	}
+	span := c.tracer.StartSpan(SpanVerifyProofs)
+	span.SetAttribute("proofs", len(proofs))
+	return span
+}
+
+// startMessageSpan starts a span for handling the type of message
+func (c *Consensus) startMessageSpan(t MessageType) Span {
+	if c.tracer == nil {
+		return nopSpan{}
+	}
+	return c.tracer.StartSpan(spanMessagePrefix + "<" + t.String() + ">")
+}
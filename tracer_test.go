@@ -0,0 +1,77 @@
+package bdls
+
+import (
+	"testing"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordedSpan struct {
+	name       string
+	attributes map[string]interface{}
+	ended      bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) { s.attributes[key] = value }
+func (s *recordedSpan) End()                                       { s.ended = true }
+
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) StartSpan(name string) Span {
+	s := &recordedSpan{name: name, attributes: make(map[string]interface{})}
+	t.spans = append(t.spans, s)
+	return s
+}
+
+func (t *recordingTracer) names() (names []string) {
+	for _, s := range t.spans {
+		names = append(names, s.name)
+	}
+	return
+}
+
+func TestTracerDecide(t *testing.T) {
+	_, sp, privateKey, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+	consensus.SetLeader(&privateKey.PublicKey)
+	consensus.AddParticipant(&privateKey.PublicKey)
+	tracer := new(recordingTracer)
+	consensus.tracer = tracer
+
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+
+	assert.Equal(t, []string{SpanReceiveMessage, SpanVerifyMessage, "bdls.<decide>", SpanVerifyProofs, SpanDecide}, tracer.names())
+	for _, s := range tracer.spans {
+		assert.True(t, s.ended, s.name)
+	}
+
+	receive := tracer.spans[0]
+	assert.Equal(t, "decide", receive.attributes["type"])
+	assert.Equal(t, uint64(10), receive.attributes["height"])
+	assert.Equal(t, uint64(10), receive.attributes["round"])
+	assert.Nil(t, receive.attributes["error"])
+	assert.Equal(t, 20, tracer.spans[3].attributes["proofs"])
+	assert.Equal(t, uint64(10), tracer.spans[4].attributes["height"])
+}
+
+func TestTracerRejected(t *testing.T) {
+	_, sp, _ := createRoundChangeMessage(t, 10, 10)
+	consensus := createConsensus(t, 9, 10, nil)
+	tracer := new(recordingTracer)
+	consensus.tracer = tracer
+
+	bts, err := proto.Marshal(sp)
+	assert.Nil(t, err)
+	assert.NotNil(t, consensus.ReceiveMessage(bts, time.Now()))
+
+	// unknown participant, rejected in verification
+	assert.Equal(t, []string{SpanReceiveMessage, SpanVerifyMessage}, tracer.names())
+	assert.NotNil(t, tracer.spans[0].attributes["error"])
+	assert.True(t, tracer.spans[0].ended)
+}
@@ -0,0 +1,355 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// UDPFragmentHeaderSize is the size of the header of each datagram
+	// |MessageID(4bytes)|FragmentIndex(2bytes)|TotalFragments(2bytes)| Payload ... |
+	UDPFragmentHeaderSize = 8
+
+	// DefaultUDPFragmentSize is the default payload size of a datagram, to fit
+	// in the common MTU of 1500 bytes along with IP/UDP headers.
+	DefaultUDPFragmentSize = 1200
+
+	// DefaultUDPMaxFragments is the default maximum fragments of a message
+	DefaultUDPMaxFragments = 8192
+
+	// DefaultUDPReassemblyTimeout is the default duration to keep an incomplete
+	// message awaiting for it's missing fragments.
+	DefaultUDPReassemblyTimeout = 5 * time.Second
+
+	// DefaultUDPMaxReassembling is the default maximum number of incomplete
+	// messages kept at once, fragments of further messages are dropped, so a
+	// remote sender cannot exhaust memory with fragments of fresh ids.
+	DefaultUDPMaxReassembling = 64
+
+	// udpExpireInterval is the interval to discard incomplete messages
+	// exceeded reassembly timeout
+	udpExpireInterval = time.Second
+
+	// udpMaxDatagramSize is the size of buffer to receive datagrams
+	udpMaxDatagramSize = 65536
+)
+
+// udpReassembly is an incomplete message awaiting for fragments
+type udpReassembly struct {
+	fragments [][]byte
+	received  int
+	deadline  time.Time
+}
+
+// UDPPeer represents a peer over a datagram connection, messages larger than
+// fragment size are split into multiple datagrams, and reassembled on the
+// remote side before delivering to consensus. Messages with lost fragments
+// are discarded after reassembly timeout.
+//
+// The connection should be dedicated to the remote peer, datagrams from
+// other addresses are ignored.
+//
+// As Consensus is not thread-safe, the read loop calls Consensus.ReceiveMessage
+// with the given locker held, the same locker MUST be shared by all peers of
+// one consensus object and the goroutine calling Consensus.Update.
+type UDPPeer struct {
+	c          *Consensus       // the consensus object to feed messages
+	locker     sync.Locker      // lock to protect consensus object
+	conn       net.PacketConn   // the underlying connection
+	remoteAddr net.Addr         // the address of remote peer
+	publicKey  *ecdsa.PublicKey // the public key of remote peer(optional)
+
+	fragmentSize      int           // payload size of each datagram
+	maxFragments      int           // maximum fragments of a message
+	reassemblyTimeout time.Duration // timeout for incomplete messages
+	maxReassembling   int           // maximum incomplete messages kept
+
+	nextID     uint32                    // message id of next outgoing message
+	reassembly map[uint32]*udpReassembly // incomplete incoming messages
+
+	die     chan struct{}
+	dieOnce sync.Once
+	sync.Mutex
+}
+
+// NewUDPPeer creates a UDPPeer over the connection to remoteAddr and starts
+// it's read loop, messages received will be delivered to consensus with
+// locker held.
+func NewUDPPeer(conn net.PacketConn, c *Consensus, remoteAddr net.Addr, locker sync.Locker) *UDPPeer {
+	p := new(UDPPeer)
+	p.c = c
+	p.locker = locker
+	p.conn = conn
+	p.remoteAddr = remoteAddr
+	p.fragmentSize = DefaultUDPFragmentSize
+	p.maxFragments = DefaultUDPMaxFragments
+	p.reassemblyTimeout = DefaultUDPReassemblyTimeout
+	p.maxReassembling = DefaultUDPMaxReassembling
+	p.reassembly = make(map[uint32]*udpReassembly)
+	p.die = make(chan struct{})
+	go p.readLoop()
+	go p.expireLoop()
+	return p
+}
+
+// GetPublicKey implements PeerInterface, returns nil if the public key
+// of the remote peer has not been set.
+func (p *UDPPeer) GetPublicKey() *ecdsa.PublicKey {
+	p.Lock()
+	defer p.Unlock()
+	return p.publicKey
+}
+
+// SetPublicKey sets the known public key of the remote peer, this is
+// required for <commit> unicast to the leader.
+func (p *UDPPeer) SetPublicKey(key *ecdsa.PublicKey) {
+	p.Lock()
+	defer p.Unlock()
+	p.publicKey = key
+}
+
+// SetFragmentSize sets the payload size of each outgoing datagram, sizes
+// not larger than UDPFragmentHeaderSize are rejected with ErrUDPFragmentSize.
+func (p *UDPPeer) SetFragmentSize(size int) error {
+	if size <= UDPFragmentHeaderSize {
+		return ErrUDPFragmentSize
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.fragmentSize = size
+	return nil
+}
+
+// SetReassemblyTimeout sets the duration to keep an incomplete message
+func (p *UDPPeer) SetReassemblyTimeout(timeout time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+	p.reassemblyTimeout = timeout
+}
+
+// SetMaxReassembling sets the maximum number of incomplete messages kept
+func (p *UDPPeer) SetMaxReassembling(n int) {
+	p.Lock()
+	defer p.Unlock()
+	p.maxReassembling = n
+}
+
+// RemoteAddr implements PeerInterface, returns the address of remote peer
+func (p *UDPPeer) RemoteAddr() net.Addr { return p.remoteAddr }
+
+// Send implements PeerInterface, the message is fragmented and written
+// to the connection immediately.
+func (p *UDPPeer) Send(msg []byte) error {
+	p.Lock()
+	select {
+	case <-p.die:
+		p.Unlock()
+		return ErrUDPPeerClosed
+	default:
+	}
+
+	datagrams, err := p.fragment(p.nextID, msg)
+	if err != nil {
+		p.Unlock()
+		return err
+	}
+	p.nextID++
+	p.Unlock()
+
+	for _, datagram := range datagrams {
+		_, err := p.conn.WriteTo(datagram, p.remoteAddr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close terminates this peer and closes the connection, it's safe to call
+// Close multiple times.
+func (p *UDPPeer) Close() {
+	p.dieOnce.Do(func() {
+		close(p.die)
+		p.conn.Close()
+	})
+}
+
+// fragment splits msg into datagrams with header prepended
+func (p *UDPPeer) fragment(id uint32, msg []byte) ([][]byte, error) {
+	total := (len(msg) + p.fragmentSize - 1) / p.fragmentSize
+	if total == 0 { // empty message still takes a datagram
+		total = 1
+	}
+	if total > p.maxFragments || total > 0xFFFF {
+		return nil, ErrUDPMessageTooLarge
+	}
+
+	datagrams := make([][]byte, 0, total)
+	for i := 0; i < total; i++ {
+		begin := i * p.fragmentSize
+		end := begin + p.fragmentSize
+		if end > len(msg) {
+			end = len(msg)
+		}
+
+		datagram := make([]byte, UDPFragmentHeaderSize+end-begin)
+		binary.BigEndian.PutUint32(datagram, id)
+		binary.BigEndian.PutUint16(datagram[4:], uint16(i))
+		binary.BigEndian.PutUint16(datagram[6:], uint16(total))
+		copy(datagram[UDPFragmentHeaderSize:], msg[begin:end])
+		datagrams = append(datagrams, datagram)
+	}
+	return datagrams, nil
+}
+
+// reassemble accepts a datagram and returns the complete message if all
+// fragments have been received, incomplete messages exceeded reassembly
+// timeout are discarded.
+func (p *UDPPeer) reassemble(datagram []byte, now time.Time) []byte {
+	p.Lock()
+	defer p.Unlock()
+	p.expire(now)
+
+	if len(datagram) < UDPFragmentHeaderSize {
+		return nil
+	}
+
+	id := binary.BigEndian.Uint32(datagram)
+	index := int(binary.BigEndian.Uint16(datagram[4:]))
+	total := int(binary.BigEndian.Uint16(datagram[6:]))
+	if total == 0 || total > p.maxFragments || index >= total {
+		return nil
+	}
+
+	payload := make([]byte, len(datagram)-UDPFragmentHeaderSize)
+	copy(payload, datagram[UDPFragmentHeaderSize:])
+
+	// fast path for unfragmented messages
+	if total == 1 {
+		return payload
+	}
+
+	r, ok := p.reassembly[id]
+	if !ok {
+		if len(p.reassembly) >= p.maxReassembling {
+			return nil
+		}
+		r = &udpReassembly{fragments: make([][]byte, total), deadline: now.Add(p.reassemblyTimeout)}
+		p.reassembly[id] = r
+	}
+
+	// mismatched total or duplicated fragment
+	if len(r.fragments) != total || r.fragments[index] != nil {
+		return nil
+	}
+	r.fragments[index] = payload
+	r.received++
+
+	if r.received < total {
+		return nil
+	}
+
+	delete(p.reassembly, id)
+	var size int
+	for _, f := range r.fragments {
+		size += len(f)
+	}
+	msg := make([]byte, 0, size)
+	for _, f := range r.fragments {
+		msg = append(msg, f...)
+	}
+	return msg
+}
+
+// expire discards incomplete messages exceeded reassembly timeout, the
+// caller must hold the lock of this peer.
+func (p *UDPPeer) expire(now time.Time) {
+	for id, r := range p.reassembly {
+		if now.After(r.deadline) {
+			delete(p.reassembly, id)
+		}
+	}
+}
+
+// expireLoop discards incomplete messages periodically, as those from a
+// silent remote won't be discarded while reassembling the next datagram.
+func (p *UDPPeer) expireLoop() {
+	ticker := time.NewTicker(udpExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.Lock()
+			p.expire(now)
+			p.Unlock()
+		case <-p.die:
+			return
+		}
+	}
+}
+
+// numReassembling returns the number of incomplete messages
+func (p *UDPPeer) numReassembling() int {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.reassembly)
+}
+
+// readLoop keeps reading datagrams from connection
+func (p *UDPPeer) readLoop() {
+	buf := make([]byte, udpMaxDatagramSize)
+	for {
+		n, addr, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			p.Close()
+			return
+		}
+
+		if addr.String() != p.remoteAddr.String() {
+			continue
+		}
+
+		msg := p.reassemble(buf[:n], time.Now())
+		if msg == nil {
+			continue
+		}
+
+		// NOTE: message errors are ignored, as datagrams are unreliable
+		p.locker.Lock()
+		_ = p.c.ReceiveMessage(msg, time.Now())
+		p.locker.Unlock()
+	}
+}
@@ -0,0 +1,169 @@
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// createUDPPeerPair creates a UDPPeer at the first endpoint, and returns the
+// raw connection of the second endpoint to send datagrams to the peer.
+func createUDPPeerPair(t *testing.T, c *Consensus, locker sync.Locker) (*UDPPeer, net.PacketConn) {
+	c1, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	c2, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	return NewUDPPeer(c1, c, c2.LocalAddr(), locker), c2
+}
+
+func createUDPTestMessage(t *testing.T) (*Consensus, State, []byte) {
+	privateKey, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+	assert.Nil(t, err)
+	consensus := createConsensus(t, 0, 0, []*ecdsa.PublicKey{&privateKey.PublicKey})
+
+	state := make([]byte, 4096)
+	_, err = io.ReadFull(rand.Reader, state)
+	assert.Nil(t, err)
+	_, signed, _ := createRoundChangeMessageSigner(t, 1, 0, state, privateKey)
+	bts, err := proto.Marshal(signed)
+	assert.Nil(t, err)
+	return consensus, state, bts
+}
+
+func TestUDPPeerDelivery(t *testing.T) {
+	var mu sync.Mutex
+	consensus, state, bts := createUDPTestMessage(t)
+
+	p1, c2 := createUDPPeerPair(t, consensus, &mu)
+	p2 := NewUDPPeer(c2, createConsensus(t, 0, 0, nil), p1.conn.LocalAddr(), new(sync.Mutex))
+	defer p1.Close()
+	defer p2.Close()
+
+	// message is fragmented into multiple datagrams
+	assert.Nil(t, p2.Send(bts))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return consensus.HasProposed(state)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestUDPPeerOutOfOrder(t *testing.T) {
+	var mu sync.Mutex
+	consensus, state, bts := createUDPTestMessage(t)
+	p, c2 := createUDPPeerPair(t, consensus, &mu)
+	defer p.Close()
+	defer c2.Close()
+
+	datagrams, err := p.fragment(1, bts)
+	assert.Nil(t, err)
+	assert.True(t, len(datagrams) > 1)
+
+	// send in reversed order, along with a duplicated fragment
+	_, err = c2.WriteTo(datagrams[len(datagrams)-1], p.conn.LocalAddr())
+	assert.Nil(t, err)
+	for i := len(datagrams) - 1; i >= 0; i-- {
+		_, err := c2.WriteTo(datagrams[i], p.conn.LocalAddr())
+		assert.Nil(t, err)
+	}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return consensus.HasProposed(state)
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, p.numReassembling())
+}
+
+func TestUDPPeerDroppedFragment(t *testing.T) {
+	p := &UDPPeer{
+		fragmentSize:      DefaultUDPFragmentSize,
+		maxFragments:      DefaultUDPMaxFragments,
+		reassemblyTimeout: time.Second,
+		maxReassembling:   DefaultUDPMaxReassembling,
+		reassembly:        make(map[uint32]*udpReassembly),
+	}
+
+	msg := make([]byte, 3*DefaultUDPFragmentSize)
+	_, err := io.ReadFull(rand.Reader, msg)
+	assert.Nil(t, err)
+	datagrams, err := p.fragment(1, msg)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(datagrams))
+
+	// the 2nd fragment is lost
+	now := time.Now()
+	assert.Nil(t, p.reassemble(datagrams[0], now))
+	assert.Nil(t, p.reassemble(datagrams[2], now))
+	assert.Equal(t, 1, p.numReassembling())
+
+	// incomplete message is discarded after timeout
+	unfragmented, err := p.fragment(2, []byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, p.reassemble(unfragmented[0], now.Add(2*time.Second)))
+	assert.Equal(t, 0, p.numReassembling())
+
+	// the late fragment cannot complete the discarded message
+	assert.Nil(t, p.reassemble(datagrams[1], now.Add(2*time.Second)))
+	assert.Equal(t, 1, p.numReassembling())
+
+	// complete message within timeout
+	datagrams, err = p.fragment(3, msg)
+	assert.Nil(t, err)
+	assert.Nil(t, p.reassemble(datagrams[2], now))
+	assert.Nil(t, p.reassemble(datagrams[0], now))
+	assert.Equal(t, msg, p.reassemble(datagrams[1], now))
+}
+
+func TestUDPPeerMessageTooLarge(t *testing.T) {
+	p, c2 := createUDPPeerPair(t, createConsensus(t, 0, 0, nil), new(sync.Mutex))
+	defer c2.Close()
+	assert.Equal(t, ErrUDPFragmentSize, p.SetFragmentSize(0))
+	assert.Equal(t, ErrUDPFragmentSize, p.SetFragmentSize(UDPFragmentHeaderSize))
+	assert.Nil(t, p.SetFragmentSize(UDPFragmentHeaderSize+1))
+	assert.Equal(t, ErrUDPMessageTooLarge, p.Send(make([]byte, (UDPFragmentHeaderSize+1)*DefaultUDPMaxFragments+1)))
+
+	p.Close()
+	assert.Equal(t, ErrUDPPeerClosed, p.Send([]byte{0}))
+}
+
+func TestUDPPeerMaxReassembling(t *testing.T) {
+	p := &UDPPeer{
+		fragmentSize:      DefaultUDPFragmentSize,
+		maxFragments:      DefaultUDPMaxFragments,
+		reassemblyTimeout: time.Second,
+		maxReassembling:   2,
+		reassembly:        make(map[uint32]*udpReassembly),
+	}
+
+	// the first fragments of messages with fresh ids
+	msg := make([]byte, 2*DefaultUDPFragmentSize)
+	now := time.Now()
+	var pending [][][]byte
+	for id := uint32(0); id < 3; id++ {
+		datagrams, err := p.fragment(id, msg)
+		assert.Nil(t, err)
+		assert.Nil(t, p.reassemble(datagrams[0], now))
+		pending = append(pending, datagrams)
+	}
+	assert.Equal(t, 2, p.numReassembling())
+
+	// the message beyond is dropped, others can complete
+	assert.Nil(t, p.reassemble(pending[2][1], now))
+	assert.Equal(t, msg, p.reassemble(pending[0][1], now))
+	assert.Equal(t, 1, p.numReassembling())
+
+	// incomplete messages expire without further datagrams
+	p.Lock()
+	p.expire(now.Add(2 * time.Second))
+	p.Unlock()
+	assert.Equal(t, 0, p.numReassembling())
+}
@@ -0,0 +1,93 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"fmt"
+)
+
+const (
+	// VersionMismatchThreshold is the number of consecutive messages with a
+	// different protocol version from a participant to deliver a
+	// VersionMismatchEvent, a few messages are tolerated as they may be
+	// in-flight while the participant is restarting with a new version.
+	VersionMismatchThreshold = 3
+)
+
+// checkVersion checks the protocol version of a signed message against
+// mine, and tracks the participants sending messages of other versions.
+func (c *Consensus) checkVersion(signed *SignedProto) error {
+	if signed.Version != c.protocolVersion {
+		c.trackVersionMismatch(signed)
+		return fmt.Errorf("verifying message from %x with version %d: %w", signed.X, signed.Version, ErrMessageVersion)
+	}
+
+	// a participant is compatible again once it has sent a message of my version
+	if len(c.versionMismatches) > 0 {
+		identity := c.pubKeyToIdentity(signed.PublicKey(c.curve))
+		if _, ok := c.versionMismatches[identity]; ok && signed.VerifyWithHasher(c.curve, c.hasher) {
+			delete(c.versionMismatches, identity)
+		}
+	}
+	return nil
+}
+
+// trackVersionMismatch counts a message of different version from a
+// participant, and notifies subscribers once the count has reached
+// VersionMismatchThreshold. The version is signed along with the message,
+// so the signature is verified to prevent blaming other participants.
+func (c *Consensus) trackVersionMismatch(signed *SignedProto) {
+	pubkey := signed.PublicKey(c.curve)
+	identity := c.pubKeyToIdentity(pubkey)
+	knownParticipant := false
+	for k := range c.participants {
+		if identity == c.participants[k] {
+			knownParticipant = true
+		}
+	}
+	if !knownParticipant || !signed.VerifyWithHasher(c.curve, c.hasher) {
+		return
+	}
+
+	if c.versionMismatches == nil {
+		c.versionMismatches = make(map[Identity]int)
+	}
+	c.versionMismatches[identity]++
+	if count := c.versionMismatches[identity]; count == VersionMismatchThreshold {
+		c.logger.Warnf("participant %x has sent %d messages with version %d, expected %d", signed.X, count, signed.Version, c.protocolVersion)
+		c.notifyVersionMismatch(VersionMismatchEvent{
+			Sender:        pubkey,
+			LocalVersion:  c.protocolVersion,
+			RemoteVersion: signed.Version,
+			Count:         count,
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionMismatch(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	consensus := createConsensus(t, 0, 0, participants)
+	mismatches, unsubscribe := consensus.SubscribeVersionMismatch()
+	defer unsubscribe()
+
+	var seq int
+	send := func(version uint32) error {
+		seq++
+		m := &Message{Type: MessageType_RoundChange, Height: 1, State: State(fmt.Sprint(seq))}
+		sp := &SignedProto{Version: version}
+		assert.Nil(t, sp.SignWith(m, privateKeySigner{keys[1]}))
+		bts, err := proto.Marshal(sp)
+		assert.Nil(t, err)
+		return consensus.ReceiveMessage(bts, time.Now())
+	}
+
+	// reported once after the threshold
+	for i := 1; i <= VersionMismatchThreshold+1; i++ {
+		assert.True(t, errors.Is(send(ProtocolVersion+1), ErrMessageVersion))
+		if i < VersionMismatchThreshold {
+			assert.Equal(t, 0, len(mismatches))
+		}
+	}
+	assert.Equal(t, 1, len(mismatches))
+	event := <-mismatches
+	assert.Equal(t, keys[1].PublicKey.X, event.Sender.X)
+	assert.Equal(t, keys[1].PublicKey.Y, event.Sender.Y)
+	assert.Equal(t, uint32(ProtocolVersion), event.LocalVersion)
+	assert.Equal(t, uint32(ProtocolVersion+1), event.RemoteVersion)
+	assert.Equal(t, VersionMismatchThreshold, event.Count)
+
+	// a message of my version resets the count
+	assert.Nil(t, send(ProtocolVersion))
+	for i := 1; i < VersionMismatchThreshold; i++ {
+		assert.True(t, errors.Is(send(ProtocolVersion+1), ErrMessageVersion))
+	}
+	assert.Equal(t, 0, len(mismatches))
+	assert.True(t, errors.Is(send(ProtocolVersion+1), ErrMessageVersion))
+	assert.Equal(t, 1, len(mismatches))
+}
+
+func TestVersionMismatchUnauthenticated(t *testing.T) {
+	keys, participants := createDecideChainKeys(t, 4)
+	consensus := createConsensus(t, 0, 0, participants)
+	mismatches, unsubscribe := consensus.SubscribeVersionMismatch()
+	defer unsubscribe()
+
+	for i := 0; i < VersionMismatchThreshold; i++ {
+		// version changed after signing
+		m := &Message{Type: MessageType_RoundChange, Height: 1, State: State(fmt.Sprint(i))}
+		sp := new(SignedProto)
+		assert.Nil(t, sp.SignWith(m, privateKeySigner{keys[1]}))
+		sp.Version = ProtocolVersion + 1
+		bts, err := proto.Marshal(sp)
+		assert.Nil(t, err)
+		assert.True(t, errors.Is(consensus.ReceiveMessage(bts, time.Now()), ErrMessageVersion))
+
+		// not a participant
+		stranger, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+		assert.Nil(t, err)
+		sp = &SignedProto{Version: ProtocolVersion + 1}
+		assert.Nil(t, sp.SignWith(m, privateKeySigner{stranger}))
+		bts, err = proto.Marshal(sp)
+		assert.Nil(t, err)
+		assert.True(t, errors.Is(consensus.ReceiveMessage(bts, time.Now()), ErrMessageVersion))
+	}
+	assert.Equal(t, 0, len(mismatches))
+}
+
+func TestConfigProtocolVersion(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	configs[0].ProtocolVersion = ProtocolVersion + 1
+	consensus, err := NewConsensus(configs[0])
+	assert.Nil(t, err)
+
+	// signed with the configured version
+	var sent []*SignedProto
+	consensus.messageOutCallback = func(m *Message, signed *SignedProto) { sent = append(sent, signed) }
+	consensus.broadcast(&Message{Type: MessageType_RoundChange, Height: 1, State: State("state")}, time.Now())
+	assert.Equal(t, 1, len(sent))
+	assert.Equal(t, uint32(ProtocolVersion+1), sent[0].Version)
+	assert.True(t, sent[0].Verify(S256Curve))
+
+	// and accepted by peers of the same version only
+	bts, err := proto.Marshal(sent[0])
+	assert.Nil(t, err)
+	configs[1].ProtocolVersion = ProtocolVersion + 1
+	same, err := NewConsensus(configs[1])
+	assert.Nil(t, err)
+	assert.Nil(t, same.ReceiveMessage(bts, time.Now()))
+	other, err := NewConsensus(configs[2])
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(other.ReceiveMessage(bts, time.Now()), ErrMessageVersion))
+}
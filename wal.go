@@ -0,0 +1,198 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// WALRecordHeaderSize is the size of header of each record in FileWAL
+	// |Length(4bytes)|CRC32(4bytes)| Message(Length) ... |
+	WALRecordHeaderSize = 8
+)
+
+// WAL is a write-ahead log to persist accepted messages, for consensus to
+// rebuild it's states after restarting.
+type WAL interface {
+	// Append a message to the log
+	Append(msg []byte) error
+	// Replay calls f with each message in the order of appending
+	Replay(f func(msg []byte) error) error
+}
+
+// replayWAL feeds messages from wal to consensus, and sets wal for appending,
+// messages rejected are ignored as in ReceiveMessage, errors from reading wal
+// will be returned.
+func (c *Consensus) replayWAL(wal WAL, now time.Time) error {
+	c.replaying = true
+	err := wal.Replay(func(msg []byte) error {
+		if err := c.receive(msg, now); err != nil {
+			c.logger.Debugf("wal message rejected: %v", err)
+		}
+		return nil
+	})
+	c.replaying = false
+	if err != nil {
+		return err
+	}
+	c.wal = wal
+	return nil
+}
+
+// appendWAL persists an accepted message, errors are logged as the message
+// has already taken effect.
+func (c *Consensus) appendWAL(msg []byte) {
+	if c.wal == nil || c.replaying {
+		return
+	}
+
+	if err := c.wal.Append(msg); err != nil {
+		c.logger.Errorf("wal append: %v", err)
+	}
+}
+
+// FileWAL is a WAL backed by a file, each record is written with length
+// and checksum, and synced to disk when appending. A partially written
+// record at the tail, caused by crashing while appending, will be ignored
+// and truncated while replaying.
+//
+// NOTE: the log grows as consensus proceeds, it's caller's responsibility to
+// rotate the log file after a height has been decided, the messages of decided
+// heights are no longer required.
+type FileWAL struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+// NewFileWAL opens or creates a WAL at path
+func NewFileWAL(path string) (*FileWAL, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileWAL{file: f}, nil
+}
+
+// Append implements WAL, the record is synced to disk before returning
+func (w *FileWAL) Append(msg []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	record := make([]byte, WALRecordHeaderSize+len(msg))
+	binary.BigEndian.PutUint32(record, uint32(len(msg)))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(msg))
+	copy(record[WALRecordHeaderSize:], msg)
+
+	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(record); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Replay implements WAL, the log is truncated at the first incomplete or
+// corrupted record.
+func (w *FileWAL) Replay(f func(msg []byte) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var offset int64
+	reader := bufio.NewReader(w.file)
+	header := make([]byte, WALRecordHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return w.truncate(offset, err)
+		}
+
+		// a torn header may claim any length, the record cannot exceed
+		// the rest of the file
+		length := binary.BigEndian.Uint32(header)
+		if int64(length) > info.Size()-offset-int64(WALRecordHeaderSize) {
+			return w.truncate(offset, errWALLength)
+		}
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(reader, msg); err != nil {
+			return w.truncate(offset, err)
+		}
+
+		if crc32.ChecksumIEEE(msg) != binary.BigEndian.Uint32(header[4:]) {
+			return w.truncate(offset, errWALChecksum)
+		}
+
+		if err := f(msg); err != nil {
+			return err
+		}
+		offset += int64(WALRecordHeaderSize) + int64(length)
+	}
+}
+
+// errWALChecksum is an internal error for corrupted records
+var errWALChecksum = errors.New("wal record checksum mismatch")
+
+// errWALLength is an internal error for records longer than the file
+var errWALLength = errors.New("wal record length exceeds file size")
+
+// truncate discards the tail from offset, if the tail is caused by
+// partial write, otherwise returns the error.
+func (w *FileWAL) truncate(offset int64, err error) error {
+	if err != io.ErrUnexpectedEOF && err != errWALChecksum && err != errWALLength && err != io.EOF {
+		return err
+	}
+	return w.file.Truncate(offset)
+}
+
+// Close closes the underlying file
+func (w *FileWAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
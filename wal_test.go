@@ -0,0 +1,185 @@
+package bdls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createTempWAL(t *testing.T) (*FileWAL, string, func()) {
+	dir, err := ioutil.TempDir("", "bdls-wal")
+	assert.Nil(t, err)
+	path := filepath.Join(dir, "wal")
+	wal, err := NewFileWAL(path)
+	assert.Nil(t, err)
+	return wal, path, func() { wal.Close(); os.RemoveAll(dir) }
+}
+
+func replayAll(t *testing.T, wal WAL) [][]byte {
+	var msgs [][]byte
+	err := wal.Replay(func(msg []byte) error {
+		msgs = append(msgs, msg)
+		return nil
+	})
+	assert.Nil(t, err)
+	return msgs
+}
+
+func TestFileWALAppendReplay(t *testing.T) {
+	wal, path, cleanup := createTempWAL(t)
+	defer cleanup()
+
+	records := [][]byte{[]byte("hello"), {}, []byte("world")}
+	for _, r := range records {
+		assert.Nil(t, wal.Append(r))
+	}
+	assert.Equal(t, records, replayAll(t, wal))
+
+	// reopen
+	assert.Nil(t, wal.Close())
+	wal2, err := NewFileWAL(path)
+	assert.Nil(t, err)
+	defer wal2.Close()
+	assert.Equal(t, records, replayAll(t, wal2))
+
+	// appending after replay
+	assert.Nil(t, wal2.Append([]byte("again")))
+	assert.Equal(t, append(records, []byte("again")), replayAll(t, wal2))
+}
+
+func TestFileWALTornWrite(t *testing.T) {
+	wal, path, cleanup := createTempWAL(t)
+	defer cleanup()
+
+	assert.Nil(t, wal.Append([]byte("complete")))
+	assert.Nil(t, wal.Append([]byte("partial")))
+	assert.Nil(t, wal.Close())
+
+	// cut the last record in half
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(path, info.Size()-3))
+
+	wal, err = NewFileWAL(path)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("complete")}, replayAll(t, wal))
+
+	// the torn record has been discarded, new records follow the complete one
+	assert.Nil(t, wal.Append([]byte("next")))
+	assert.Equal(t, [][]byte{[]byte("complete"), []byte("next")}, replayAll(t, wal))
+	assert.Nil(t, wal.Close())
+}
+
+func TestFileWALCorruptedRecord(t *testing.T) {
+	wal, path, cleanup := createTempWAL(t)
+	defer cleanup()
+
+	assert.Nil(t, wal.Append([]byte("complete")))
+	assert.Nil(t, wal.Append([]byte("corrupted")))
+	assert.Nil(t, wal.Close())
+
+	// flip the last byte
+	bts, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	bts[len(bts)-1] ^= 0xff
+	assert.Nil(t, ioutil.WriteFile(path, bts, 0600))
+
+	wal, err = NewFileWAL(path)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("complete")}, replayAll(t, wal))
+	assert.Nil(t, wal.Close())
+}
+
+func TestFileWALCorruptedLength(t *testing.T) {
+	wal, path, cleanup := createTempWAL(t)
+	defer cleanup()
+
+	assert.Nil(t, wal.Append([]byte("complete")))
+	assert.Nil(t, wal.Append([]byte("corrupted")))
+	assert.Nil(t, wal.Close())
+
+	// the length of the last record claims far more than the file holds
+	bts, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	offset := WALRecordHeaderSize + len("complete")
+	binary.BigEndian.PutUint32(bts[offset:], 0xffffffff)
+	assert.Nil(t, ioutil.WriteFile(path, bts, 0600))
+
+	wal, err = NewFileWAL(path)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("complete")}, replayAll(t, wal))
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(offset), info.Size())
+	assert.Nil(t, wal.Close())
+}
+
+func TestWALRestartMidRound(t *testing.T) {
+	wal, path, cleanup := createTempWAL(t)
+	defer cleanup()
+
+	var keys []*ecdsa.PrivateKey
+	var participants []Identity
+	for i := 0; i < ConfigMinimumParticipants; i++ {
+		key, err := ecdsa.GenerateKey(S256Curve, rand.Reader)
+		assert.Nil(t, err)
+		keys = append(keys, key)
+		participants = append(participants, DefaultPubKeyToIdentity(&key.PublicKey))
+	}
+
+	newConfig := func(wal WAL) *Config {
+		config := new(Config)
+		config.Epoch = time.Now()
+		config.CurrentHeight = 0
+		config.PrivateKey = keys[0]
+		config.Participants = participants
+		config.StateCompare = func(a State, b State) int { return bytes.Compare(a, b) }
+		config.StateValidate = func(a State) bool { return true }
+		config.WAL = wal
+		return config
+	}
+
+	consensus, err := NewConsensus(newConfig(wal))
+	assert.Nil(t, err)
+
+	// <roundchange> messages from other participants for round 1
+	state := make([]byte, 1024)
+	_, err = io.ReadFull(rand.Reader, state)
+	assert.Nil(t, err)
+	for _, key := range keys[1:] {
+		_, signed, _ := createRoundChangeMessageSigner(t, 1, 1, state, key)
+		bts, err := signed.Marshal()
+		assert.Nil(t, err)
+		assert.Nil(t, consensus.ReceiveMessage(bts, time.Now()))
+	}
+	assert.Equal(t, uint64(1), consensus.currentRound.RoundNumber)
+	stage := consensus.currentRound.Stage
+	assert.True(t, consensus.HasProposed(state))
+
+	// kill the node and restart from the log
+	assert.Nil(t, wal.Close())
+	wal2, err := NewFileWAL(path)
+	assert.Nil(t, err)
+	defer wal2.Close()
+	n := len(replayAll(t, wal2))
+	assert.Equal(t, len(keys[1:]), n)
+
+	restarted, err := NewConsensus(newConfig(wal2))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), restarted.currentRound.RoundNumber)
+	assert.Equal(t, stage, restarted.currentRound.Stage)
+	assert.True(t, restarted.HasProposed(state))
+
+	// messages replayed must not be appended again
+	assert.Equal(t, n, len(replayAll(t, wal2)))
+}
@@ -0,0 +1,123 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+const (
+	// MaxTotalParticipantWeight is the maximum sum of weights of participants,
+	// callers with large stakes should scale them down to fit.
+	MaxTotalParticipantWeight = 1<<31 - 1
+)
+
+// verifyWeights checks every participant has a positive weight, and the total
+// weight of individual participants is within MaxTotalParticipantWeight.
+func verifyWeights(participants []Identity, weights map[Identity]uint64) error {
+	var total uint64
+	seen := make(map[Identity]bool)
+	for _, id := range participants {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		w := weights[id]
+		if w == 0 || w > MaxTotalParticipantWeight {
+			return ErrConfigParticipantWeights
+		}
+		total += w
+		if total > MaxTotalParticipantWeight {
+			return ErrConfigParticipantWeights
+		}
+	}
+	return nil
+}
+
+// normalizeWeights divides weights by their greatest common divisor, weights
+// out of range are dropped. nil is returned if all weights are equal, so the
+// quorum is 2t+1 of individual participants exactly as without weights.
+func normalizeWeights(weights map[Identity]uint64) map[Identity]int {
+	var g uint64
+	for _, w := range weights {
+		if w != 0 && w <= MaxTotalParticipantWeight {
+			g = gcd(g, w)
+		}
+	}
+
+	equal := true
+	normalized := make(map[Identity]int, len(weights))
+	for id, w := range weights {
+		if w != 0 && w <= MaxTotalParticipantWeight {
+			normalized[id] = int(w / g)
+			equal = equal && w == g
+		}
+	}
+	if equal {
+		return nil
+	}
+	return normalized
+}
+
+// gcd computes the greatest common divisor of a and b
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// weightOf returns the voting weight of a participant, which is 1 for all
+// participants if weights have not set.
+func (c *Consensus) weightOf(id Identity) int {
+	if c.weights == nil {
+		return 1
+	}
+	return c.weights[id]
+}
+
+// signerWeight returns the voting weight of the signer of a message
+func (c *Consensus) signerWeight(sp *SignedProto) int {
+	if c.weights == nil {
+		return 1
+	}
+	return c.weights[c.pubKeyToIdentity(sp.PublicKey(c.curve))]
+}
+
+// sumWeights sums the weights of individual participants
+func (c *Consensus) sumWeights(participants []Identity) int {
+	var total int
+	seen := make(map[Identity]bool)
+	for _, id := range participants {
+		if !seen[id] {
+			seen[id] = true
+			total += c.weightOf(id)
+		}
+	}
+	return total
+}
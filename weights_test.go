@@ -0,0 +1,207 @@
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeWeights(t *testing.T) {
+	var ids [3]Identity
+	for i := range ids {
+		ids[i][0] = byte(i)
+	}
+
+	// equal weights are counted as individual participants
+	assert.Nil(t, normalizeWeights(map[Identity]uint64{ids[0]: 5, ids[1]: 5, ids[2]: 5}))
+
+	normalized := normalizeWeights(map[Identity]uint64{ids[0]: 2, ids[1]: 4, ids[2]: 6})
+	assert.Equal(t, 1, normalized[ids[0]])
+	assert.Equal(t, 2, normalized[ids[1]])
+	assert.Equal(t, 3, normalized[ids[2]])
+
+	// weights out of range are dropped before taking the divisor
+	normalized = normalizeWeights(map[Identity]uint64{ids[0]: 2, ids[1]: 4, ids[2]: MaxTotalParticipantWeight + 2})
+	assert.Equal(t, 1, normalized[ids[0]])
+	assert.Equal(t, 2, normalized[ids[1]])
+	_, ok := normalized[ids[2]]
+	assert.False(t, ok)
+}
+
+func TestVerifyConfigParticipantWeights(t *testing.T) {
+	configs := createIPCNetworkConfigs(t, 4)
+	config := configs[0]
+
+	weights := make(map[Identity]uint64)
+	for _, id := range config.Participants {
+		weights[id] = 10
+	}
+	config.ParticipantWeights = weights
+	assert.Nil(t, VerifyConfig(config))
+
+	// zero weight
+	weights[config.Participants[3]] = 0
+	assert.Equal(t, ErrConfigParticipantWeights, VerifyConfig(config))
+
+	// missing weight
+	delete(weights, config.Participants[3])
+	assert.Equal(t, ErrConfigParticipantWeights, VerifyConfig(config))
+
+	// total weight overflow
+	weights[config.Participants[3]] = MaxTotalParticipantWeight
+	assert.Equal(t, ErrConfigParticipantWeights, VerifyConfig(config))
+}
+
+func TestWeightedQuorumEqualWeights(t *testing.T) {
+	for n := 4; n < 30; n++ {
+		configs := createIPCNetworkConfigs(t, n)
+		unweighted, err := NewConsensus(configs[0])
+		assert.Nil(t, err)
+
+		weights := make(map[Identity]uint64)
+		for _, id := range configs[1].Participants {
+			weights[id] = 7
+		}
+		configs[1].ParticipantWeights = weights
+		weighted, err := NewConsensus(configs[1])
+		assert.Nil(t, err)
+		assert.Nil(t, weighted.weights)
+		assert.Equal(t, unweighted.quorum(), weighted.quorum(), n)
+		assert.Equal(t, QuorumSize(n), weighted.quorum(), n)
+	}
+
+	// e.g. 5 participants need 3 votes rather than 4, and 6 need 3 rather than 5
+	for n, quorum := range map[int]int{4: 3, 5: 3, 6: 3, 7: 5} {
+		configs := createIPCNetworkConfigs(t, n)
+		weights := make(map[Identity]uint64)
+		for _, id := range configs[0].Participants {
+			weights[id] = 10
+		}
+		configs[0].ParticipantWeights = weights
+		consensus, err := NewConsensus(configs[0])
+		assert.Nil(t, err)
+		assert.Equal(t, quorum, consensus.quorum(), n)
+	}
+}
+
+// a high-stake signer of <commit> messages decides whether quorum is met
+func TestWeightedQuorumCommits(t *testing.T) {
+	m, _, _, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+
+	var msgs [][]byte
+	for _, proof := range m.Proof {
+		bts, err := proto.Marshal(proof)
+		assert.Nil(t, err)
+		msgs = append(msgs, bts)
+	}
+	ok, err := consensus.HasQuorum(msgs, m.State)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	setWeights := func(heavy *ecdsa.PublicKey, weight uint64) {
+		weights := make(map[Identity]uint64)
+		for _, id := range consensus.participants {
+			weights[id] = 1
+		}
+		weights[DefaultPubKeyToIdentity(heavy)] = weight
+		consensus.weights = normalizeWeights(weights)
+		consensus.totalWeight = consensus.sumWeights(consensus.participants)
+	}
+
+	// a high-stake participant who did not commit, prevents the quorum
+	setWeights(&consensus.privateKey.PublicKey, 100)
+	ok, err = consensus.HasQuorum(msgs, m.State)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// a high-stake participant who committed, makes the quorum alone
+	setWeights(proofKeys[0], 100)
+	ok, err = consensus.HasQuorum(msgs[:1], m.State)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+// a participant holding more than 2/3 of the total weight decides alone,
+// while holding a half of the total weight doesn't
+func TestWeightedQuorumDecide(t *testing.T) {
+	run := func(heavy uint64) uint64 {
+		configs := createIPCNetworkConfigs(t, 4)
+		config := configs[0]
+		if heavy > 0 {
+			weights := make(map[Identity]uint64)
+			for k, id := range config.Participants {
+				weights[id] = 1
+				if k == 0 {
+					weights[id] = heavy
+				}
+			}
+			config.ParticipantWeights = weights
+		}
+		consensus, err := NewConsensus(config)
+		assert.Nil(t, err)
+
+		data := make([]byte, 1024)
+		_, err = io.ReadFull(rand.Reader, data)
+		assert.Nil(t, err)
+		consensus.Propose(data)
+
+		now := config.Epoch
+		for i := 0; i < 1000; i++ {
+			now = now.Add(20 * time.Millisecond)
+			assert.Nil(t, consensus.Update(now))
+		}
+		height, _, _ := consensus.CurrentState()
+		return height
+	}
+
+	assert.Equal(t, uint64(0), run(0))
+	assert.Equal(t, uint64(1), run(7))
+	// W = 6, W mod 3 != 1, 2t+1 would have been 3
+	assert.Equal(t, uint64(0), run(3))
+}
+
+func TestWeightedQuorumHalfStake(t *testing.T) {
+	m, _, _, proofKeys := createDecideMessage(t, 20, 10, 10, 10, 10)
+	consensus := createConsensus(t, 9, 10, proofKeys)
+
+	var msgs [][]byte
+	for _, proof := range m.Proof {
+		bts, err := proto.Marshal(proof)
+		assert.Nil(t, err)
+		msgs = append(msgs, bts)
+	}
+
+	// a committed participant holds exactly a half of the total weight,
+	// 42 = 21 + 2 + 19*1 with 21 participants
+	assert.Equal(t, 21, countIdentities(consensus.participants))
+	weights := make(map[Identity]uint64)
+	for _, id := range consensus.participants {
+		weights[id] = 1
+	}
+	weights[DefaultPubKeyToIdentity(proofKeys[0])] = 21
+	weights[DefaultPubKeyToIdentity(proofKeys[1])] = 2
+	consensus.weights = normalizeWeights(weights)
+	consensus.totalWeight = consensus.sumWeights(consensus.participants)
+	assert.Equal(t, 42, consensus.totalWeight)
+	assert.Equal(t, 29, consensus.quorum())
+
+	ok, err := consensus.HasQuorum(msgs[:1], m.State)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 2/3 of the total weight is not enough
+	ok, err = consensus.HasQuorum(msgs[:7], m.State)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// more than 2/3 of the total weight
+	ok, err = consensus.HasQuorum(msgs[:8], m.State)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
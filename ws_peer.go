@@ -0,0 +1,239 @@
+// BSD 3-Clause License
+//
+// Copyright (c) 2020, Sperax
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bdls
+
+import (
+	"crypto/ecdsa"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// message types of WebSocket frames, the values are the opcodes defined in
+// RFC 6455, and the same as in gorilla/websocket.
+const (
+	WSTextMessage   = 1
+	WSBinaryMessage = 2
+	WSCloseMessage  = 8
+	WSPingMessage   = 9
+	WSPongMessage   = 10
+
+	// WSCloseNormalClosure is the status code of a clean close
+	WSCloseNormalClosure = 1000
+)
+
+const (
+	// DefaultWSPingInterval is the default interval to send pings
+	DefaultWSPingInterval = 30 * time.Second
+
+	// DefaultWSPongTimeout is the default duration to wait for any frame
+	// from remote before the connection is considered dead, it must be
+	// larger than the ping interval.
+	DefaultWSPongTimeout = 60 * time.Second
+
+	// wsControlTimeout is the deadline for writing control frames
+	wsControlTimeout = time.Second
+)
+
+// WSConn is the WebSocket connection required by WSPeer, the method set is
+// a subset of *websocket.Conn from gorilla/websocket, so it can be used
+// directly.
+type WSConn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+// WSPeer represents a peer over a WebSocket connection, usually for browser
+// facing observers, each consensus message is framed as a binary message.
+// Frames of other types are ignored.
+//
+// A peer in read-only mode only delivers incoming messages to consensus, and
+// Send does nothing, as in observer mode.
+//
+// As Consensus is not thread-safe, the read loop calls Consensus.ReceiveMessage
+// with the given locker held, the same locker MUST be shared by all peers of
+// one consensus object and the goroutine calling Consensus.Update.
+type WSPeer struct {
+	c         *Consensus       // the consensus object to feed messages
+	locker    sync.Locker      // lock to protect consensus object
+	conn      WSConn           // the underlying connection
+	readOnly  bool             // Send does nothing in read-only mode
+	publicKey *ecdsa.PublicKey // the public key of remote peer(optional)
+
+	pingInterval time.Duration // interval to send pings
+	pongTimeout  time.Duration // timeout to receive frames from remote
+
+	writeMu sync.Mutex // serialize writes of data frames
+	die     chan struct{}
+	dieOnce sync.Once
+	sync.Mutex
+}
+
+// NewWSPeer creates a WSPeer over the connection and starts it's read loop
+// and keepalive, messages received will be delivered to consensus with
+// locker held.
+func NewWSPeer(conn WSConn, c *Consensus, readOnly bool, locker sync.Locker) *WSPeer {
+	p := new(WSPeer)
+	p.c = c
+	p.locker = locker
+	p.conn = conn
+	p.readOnly = readOnly
+	p.pingInterval = DefaultWSPingInterval
+	p.pongTimeout = DefaultWSPongTimeout
+	p.die = make(chan struct{})
+
+	// any pong extends the read deadline
+	conn.SetPongHandler(func(string) error {
+		return p.extendReadDeadline()
+	})
+	_ = p.extendReadDeadline()
+
+	go p.readLoop()
+	go p.keepalive()
+	return p
+}
+
+// GetPublicKey implements PeerInterface, returns nil if the public key
+// of the remote peer has not been set.
+func (p *WSPeer) GetPublicKey() *ecdsa.PublicKey {
+	p.Lock()
+	defer p.Unlock()
+	return p.publicKey
+}
+
+// SetPublicKey sets the known public key of the remote peer
+func (p *WSPeer) SetPublicKey(key *ecdsa.PublicKey) {
+	p.Lock()
+	defer p.Unlock()
+	p.publicKey = key
+}
+
+// SetKeepalive sets the interval to send pings, and the timeout to receive
+// any frame from remote, the timeout must be larger than the interval.
+func (p *WSPeer) SetKeepalive(pingInterval time.Duration, pongTimeout time.Duration) {
+	p.Lock()
+	p.pingInterval = pingInterval
+	p.pongTimeout = pongTimeout
+	p.Unlock()
+	_ = p.extendReadDeadline()
+}
+
+// RemoteAddr implements PeerInterface, returns the address of remote peer
+func (p *WSPeer) RemoteAddr() net.Addr { return p.conn.RemoteAddr() }
+
+// Send implements PeerInterface, the message is written as a binary frame,
+// it's a no-op in read-only mode.
+func (p *WSPeer) Send(msg []byte) error {
+	if p.readOnly {
+		return nil
+	}
+
+	select {
+	case <-p.die:
+		return ErrWSPeerClosed
+	default:
+	}
+
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.conn.WriteMessage(WSBinaryMessage, msg)
+}
+
+// Close terminates this peer with a close frame to remote, and closes the
+// connection, it's safe to call Close multiple times.
+func (p *WSPeer) Close() {
+	p.dieOnce.Do(func() {
+		close(p.die)
+		payload := make([]byte, 2)
+		binary.BigEndian.PutUint16(payload, WSCloseNormalClosure)
+		_ = p.conn.WriteControl(WSCloseMessage, payload, time.Now().Add(wsControlTimeout))
+		p.conn.Close()
+	})
+}
+
+// extendReadDeadline sets the read deadline to pong timeout from now
+func (p *WSPeer) extendReadDeadline() error {
+	p.Lock()
+	timeout := p.pongTimeout
+	p.Unlock()
+	return p.conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// readLoop keeps reading frames from connection
+func (p *WSPeer) readLoop() {
+	defer p.Close()
+	for {
+		messageType, msg, err := p.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		// frames from remote prove it's alive
+		if err := p.extendReadDeadline(); err != nil {
+			return
+		}
+
+		if messageType != WSBinaryMessage {
+			continue
+		}
+
+		// NOTE: message errors are ignored, as messages may come from
+		// participants at different heights.
+		p.locker.Lock()
+		_ = p.c.ReceiveMessage(msg, time.Now())
+		p.locker.Unlock()
+	}
+}
+
+// keepalive sends pings periodically until the peer is closed
+func (p *WSPeer) keepalive() {
+	for {
+		p.Lock()
+		interval := p.pingInterval
+		p.Unlock()
+
+		select {
+		case <-time.After(interval):
+			if err := p.conn.WriteControl(WSPingMessage, nil, time.Now().Add(wsControlTimeout)); err != nil {
+				p.Close()
+				return
+			}
+		case <-p.die:
+			return
+		}
+	}
+}
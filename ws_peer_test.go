@@ -0,0 +1,199 @@
+package bdls
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// wsFrame is a frame written to fakeWSConn
+type wsFrame struct {
+	messageType int
+	data        []byte
+}
+
+// fakeWSConn is an in-memory WSConn, frames from remote are fed via incoming,
+// and frames written are captured in outgoing.
+type fakeWSConn struct {
+	incoming    chan wsFrame
+	outgoing    chan wsFrame
+	pongHandler func(string) error
+	deadline    time.Time
+	closed      chan struct{}
+	closeOnce   sync.Once
+	sync.Mutex
+}
+
+func newFakeWSConn() *fakeWSConn {
+	return &fakeWSConn{
+		incoming: make(chan wsFrame, 16),
+		outgoing: make(chan wsFrame, 16),
+		closed:   make(chan struct{}),
+	}
+}
+
+var errFakeWSConnClosed = errors.New("fake websocket connection closed")
+
+func (c *fakeWSConn) ReadMessage() (int, []byte, error) {
+	for {
+		select {
+		case f := <-c.incoming:
+			if f.messageType == WSPongMessage {
+				c.Lock()
+				h := c.pongHandler
+				c.Unlock()
+				if err := h(string(f.data)); err != nil {
+					return 0, nil, err
+				}
+				continue
+			}
+			return f.messageType, f.data, nil
+		case <-c.closed:
+			return 0, nil, errFakeWSConnClosed
+		}
+	}
+}
+
+func (c *fakeWSConn) write(messageType int, data []byte) error {
+	select {
+	case <-c.closed:
+		return errFakeWSConnClosed
+	case c.outgoing <- wsFrame{messageType, data}:
+		return nil
+	}
+}
+
+func (c *fakeWSConn) WriteMessage(messageType int, data []byte) error {
+	return c.write(messageType, data)
+}
+
+func (c *fakeWSConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return c.write(messageType, data)
+}
+
+func (c *fakeWSConn) SetReadDeadline(t time.Time) error {
+	c.Lock()
+	defer c.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *fakeWSConn) readDeadline() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.deadline
+}
+
+func (c *fakeWSConn) SetPongHandler(h func(string) error) {
+	c.Lock()
+	defer c.Unlock()
+	c.pongHandler = h
+}
+
+func (c *fakeWSConn) RemoteAddr() net.Addr { return fakeAddress("ws") }
+
+func (c *fakeWSConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func TestWSPeerDelivery(t *testing.T) {
+	var mu sync.Mutex
+	consensus, state, bts := createUDPTestMessage(t)
+	conn := newFakeWSConn()
+	p := NewWSPeer(conn, consensus, true, &mu)
+	defer p.Close()
+
+	// text frames are ignored
+	conn.incoming <- wsFrame{WSTextMessage, bts}
+	conn.incoming <- wsFrame{WSBinaryMessage, bts}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return consensus.HasProposed(state)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWSPeerReadOnly(t *testing.T) {
+	conn := newFakeWSConn()
+	p := NewWSPeer(conn, createConsensus(t, 0, 0, nil), true, new(sync.Mutex))
+	assert.Nil(t, p.Send([]byte("message")))
+	assert.Equal(t, 0, len(conn.outgoing))
+
+	// no-op even after closing
+	p.Close()
+	<-conn.outgoing // close frame
+	assert.Nil(t, p.Send([]byte("message")))
+}
+
+func TestWSPeerSend(t *testing.T) {
+	conn := newFakeWSConn()
+	p := NewWSPeer(conn, createConsensus(t, 0, 0, nil), false, new(sync.Mutex))
+	assert.Nil(t, p.Send([]byte("message")))
+	assert.Equal(t, wsFrame{WSBinaryMessage, []byte("message")}, <-conn.outgoing)
+
+	p.Close()
+	assert.Equal(t, ErrWSPeerClosed, p.Send([]byte("message")))
+}
+
+func TestWSPeerKeepalive(t *testing.T) {
+	conn := newFakeWSConn()
+	p := NewWSPeer(conn, createConsensus(t, 0, 0, nil), true, new(sync.Mutex))
+	defer p.Close()
+	p.SetKeepalive(20*time.Millisecond, time.Minute)
+
+	// pings are sent periodically
+	for i := 0; i < 3; i++ {
+		select {
+		case f := <-conn.outgoing:
+			assert.Equal(t, WSPingMessage, f.messageType)
+		case <-time.After(time.Second):
+			t.Fatal("ping has not been sent")
+		}
+	}
+
+	// pong extends read deadline
+	before := conn.readDeadline()
+	time.Sleep(10 * time.Millisecond)
+	conn.incoming <- wsFrame{WSPongMessage, nil}
+	assert.Eventually(t, func() bool {
+		return conn.readDeadline().After(before)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWSPeerClose(t *testing.T) {
+	conn := newFakeWSConn()
+	p := NewWSPeer(conn, createConsensus(t, 0, 0, nil), false, new(sync.Mutex))
+	p.Close()
+	p.Close()
+
+	// a clean close frame is sent before closing the connection
+	f := <-conn.outgoing
+	assert.Equal(t, WSCloseMessage, f.messageType)
+	assert.Equal(t, uint16(WSCloseNormalClosure), binary.BigEndian.Uint16(f.data))
+	select {
+	case <-conn.closed:
+	default:
+		t.Fatal("connection has not been closed")
+	}
+}
+
+func TestWSPeerRemoteClose(t *testing.T) {
+	conn := newFakeWSConn()
+	p := NewWSPeer(conn, createConsensus(t, 0, 0, nil), false, new(sync.Mutex))
+
+	// read errors terminate the peer
+	conn.Close()
+	select {
+	case <-p.die:
+	case <-time.After(time.Second):
+		t.Fatal("peer has not been closed")
+	}
+	assert.Equal(t, ErrWSPeerClosed, p.Send([]byte("message")))
+}